@@ -1,16 +1,25 @@
 package commandline
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"os"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/entity"
+	commonentity "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/utils"
 )
 
 const Version string = "0.0.1"
 
+const assStyleUsage = "Default style for --sub-format ASS, e.g. font=Noto Sans:size=48:primary=&H0000FFFF:bold=true:align=8. Keys: font, size, primary, outline_colour, back, bold, italic, outline, shadow, align, margin_l, margin_r, margin_v, res_x, res_y"
+
 // headerMap is a custom type that represents a map of headers.
 type headerMap map[string]string
 
@@ -104,6 +113,86 @@ func (s *speedFlag) Set(value string) error {
 	return nil
 }
 
+// bytesFlag accepts a hex string, a base64 string or a file path.
+type bytesFlag []byte
+
+func (b *bytesFlag) String() string {
+	return hex.EncodeToString(*b)
+}
+
+func (b *bytesFlag) Set(value string) error {
+	parsed, err := utils.ParseKeyBytes(value)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// muxOptionsFlag parses --mux-after-done into MuxOptions.
+type muxOptionsFlag struct {
+	opts **entity.MuxOptions
+}
+
+func (m *muxOptionsFlag) String() string {
+	if m.opts == nil || *m.opts == nil {
+		return ""
+	}
+	return (*m.opts).String()
+}
+
+func (m *muxOptionsFlag) Set(value string) error {
+	parsed, err := entity.ParseMuxOptions(value)
+	if err != nil {
+		return err
+	}
+	*m.opts = parsed
+	return nil
+}
+
+// subFormatFlag accepts one of the subtitle formats WebVttSub can write.
+type subFormatFlag struct {
+	format *string
+}
+
+func (s *subFormatFlag) String() string {
+	if s.format == nil {
+		return ""
+	}
+	return *s.format
+}
+
+func (s *subFormatFlag) Set(value string) error {
+	format := strings.ToUpper(strings.TrimSpace(value))
+	switch format {
+	case "SRT", "VTT", "ASS", "TTML":
+		*s.format = format
+		return nil
+	}
+	return fmt.Errorf("unsupported subtitle format: %s, expecting SRT, VTT, ASS or TTML", value)
+}
+
+// assStyleFlag parses --ass-style into an AssStyle.
+type assStyleFlag struct {
+	style **commonentity.AssStyle
+}
+
+func (a *assStyleFlag) String() string {
+	if a.style == nil || *a.style == nil {
+		return ""
+	}
+	return (*a.style).String()
+}
+
+func (a *assStyleFlag) Set(value string) error {
+	parsed, err := commonentity.ParseAssStyle(value)
+	if err != nil {
+		return err
+	}
+	*a.style = parsed
+	return nil
+}
+
 type Options struct {
 	Input                  string
 	TmpDir                 *string
@@ -117,6 +206,7 @@ type Options struct {
 	Headers                *headerMap
 	LogLevel               string
 	SubtitleFormat         string
+	AssStyle               *commonentity.AssStyle
 	AutoSelect             bool
 	SubOnly                bool
 	ThreadCount            int
@@ -130,6 +220,8 @@ type Options struct {
 	AutoSubtitleFix        bool
 	CheckSegementsCount    bool
 	WriteMetaJson          bool
+	MetaJsonKeys           bool
+	ProgressJson           string
 	AppendUrlParams        bool
 	MP4RealTimeDecryption  bool
 	UseShakaPackager       bool
@@ -143,13 +235,34 @@ type Options struct {
 	AdKeywords             *stringSlice
 	MaxSpeed               *speedFlag
 	UseSystemProxy         bool
+	CustomHLSMethod        string
+	CustomHLSKey           *bytesFlag
+	CustomHLSIV            *bytesFlag
+	MuxAfterDone           *entity.MuxOptions
+	MuxAttachments         *stringSlice
 }
 
+// CommandInvoker parses the process arguments, exiting the way the flag package
+// does on a bad or -h argument.
 func CommandInvoker() Options {
+	opts, err := ParseOptions(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	return opts
+}
+
+// ParseOptions parses the download arguments. Errors are reported on stderr
+// together with the usage, as flag does.
+func ParseOptions(args []string) (Options, error) {
 	opts := Options{
 		Headers:          new(headerMap),
 		Keys:             new(stringSlice),
 		AdKeywords:       new(stringSlice),
+		MuxAttachments:   new(stringSlice),
 		MaxSpeed:         new(speedFlag),
 		TmpDir:           new(string),
 		SaveDir:          new(string),
@@ -157,51 +270,71 @@ func CommandInvoker() Options {
 		SavePattern:      new(string),
 		UILanguage:       new(string),
 		UrlProcessorArgs: new(string),
+		CustomHLSKey:     new(bytesFlag),
+		CustomHLSIV:      new(bytesFlag),
+		SubtitleFormat:   "SRT",
 	}
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 
-	flag.StringVar(&opts.Input, "input", "", "Input URL or file")
-	flag.StringVar(opts.TmpDir, "tmp-dir", "", "Set directory for temporary files")
-	flag.StringVar(opts.SaveDir, "save-dir", "", "Set ouput directory")
-	flag.StringVar(opts.SavePattern, "save-pattern", "", "Set")
-	flag.StringVar(opts.UILanguage, "ui-language", "", "")
-	flag.StringVar(opts.UrlProcessorArgs, "urlprocessor-args", "", "")
-	flag.Var(opts.Keys, "key", "Pass decryption key(s) to mp4decrypt/shaka-packager. format:\r\n--key KID1:KEY1 --key KID2:KEY2")
-	flag.StringVar(&opts.KeyTextFile, "key-text-file", "", "")
-	flag.Var(&headersVar{opts.Headers}, "H", "Specify headers in the format key:value")
-	flag.Var(&headersVar{opts.Headers}, "header", "Specify headers in the format key:value")
-	flag.StringVar(&opts.LogLevel, "log-level", "INFO", "Set log level")
-	flag.StringVar(&opts.SubtitleFormat, "sub-format", "SRT", "")
-	flag.BoolVar(&opts.AutoSelect, "auto-select", false, "")
-	flag.BoolVar(&opts.SubOnly, "sub-only", false, "")
-	flag.IntVar(&opts.ThreadCount, "thread-count", runtime.GOMAXPROCS(0), "")
-	flag.IntVar(&opts.DownloadRetryCount, "download-retry-count", 3, "")
-	flag.BoolVar(&opts.SkipMerge, "skip-merge", false, "")
-	flag.BoolVar(&opts.SkipDownload, "skip-download", false, "")
-	flag.BoolVar(&opts.NoDateInfo, "no-date-info", false, "")
-	flag.BoolVar(&opts.BinaryMerge, "binary-merge", false, "")
-	flag.BoolVar(&opts.UseFFmpegConcatDemuxer, "use-ffmpeg-concat-demuxer", false, "")
-	flag.BoolVar(&opts.DelAfterDone, "del-after-done", true, "")
-	flag.BoolVar(&opts.AutoSubtitleFix, "auto-subtitle-fix", true, "")
-	flag.BoolVar(&opts.CheckSegementsCount, "check-segments-count", true, "")
-	flag.BoolVar(&opts.WriteMetaJson, "write-meta-json", true, "")
-
-	flag.BoolVar(&opts.AppendUrlParams, "append-url-params", false, "Description for append-url-params")
-	flag.BoolVar(&opts.MP4RealTimeDecryption, "mp4-real-time-decryption", false, "Description for mp4-real-time-decryption")
-	flag.BoolVar(&opts.UseShakaPackager, "use-shaka-packager", false, "Description for use-shaka-packager")
-	flag.BoolVar(&opts.ForceAnsiConsole, "force-ansi-console", false, "Description for force-ansi-console")
-	flag.BoolVar(&opts.NoAnsiColor, "no-ansi-color", false, "Description for no-ansi-color")
-	flag.StringVar(&opts.DecryptionBinaryPath, "decryption-binary-path", "", "Path for decryption-binary")
-	flag.StringVar(&opts.FFmpegBinaryPath, "ffmpeg-binary-path", "", "Path for FFmpeg-binary")
-	flag.StringVar(&opts.BaseUrl, "base-url", "", "Base URL for the operation")
-	flag.BoolVar(&opts.ConcurrentDownload, "concurrent-download", false, "Enable concurrent downloads")
-	flag.BoolVar(&opts.NoLog, "no-log", false, "Disable logging")
-	flag.Var(opts.AdKeywords, "ad-keyword", "Ad keywords (can specify multiple)")
-	flag.Var(opts.MaxSpeed, "R", "Max download speed (in bytes/sec)")
-	flag.Var(opts.MaxSpeed, "max-speed", "Max download speed (in bytes/sec)")
-	flag.BoolVar(&opts.UseSystemProxy, "use-system-proxy", true, "")
+	fs.StringVar(&opts.Input, "input", "", "Input URL or file")
+	fs.StringVar(opts.TmpDir, "tmp-dir", "", "Set directory for temporary files")
+	fs.StringVar(opts.SaveDir, "save-dir", "", "Set ouput directory")
+	fs.StringVar(opts.SaveName, "save-name", "", "Set the output name. Defaults to the manifest URL file name plus the start time")
+	fs.StringVar(opts.SavePattern, "save-pattern", "", "Name merged streams from a template, e.g. \"<SaveName>.<Language>.<Resolution>\". Variables: <SaveName>, <Id>, <GroupId>, <Codecs>, <Language>, <Resolution>, <Bandwidth>, <MediaType>, <Ext>, <Date:2006-01-02>")
+	fs.StringVar(opts.UILanguage, "ui-language", "", "")
+	fs.StringVar(opts.UrlProcessorArgs, "urlprocessor-args", "", "")
+	fs.Var(opts.Keys, "key", "Pass decryption key(s) to mp4decrypt/shaka-packager. format:\r\n--key KID1:KEY1 --key KID2:KEY2. Other values, e.g. TRACK_ID:KEY, are passed through unchanged")
+	fs.StringVar(&opts.KeyTextFile, "key-text-file", "", "Load KID:KEY lines from a file; the key matching each track's KID is chosen automatically")
+	fs.Var(&headersVar{opts.Headers}, "H", "Specify headers in the format key:value")
+	fs.Var(&headersVar{opts.Headers}, "header", "Specify headers in the format key:value")
+	fs.StringVar(&opts.LogLevel, "log-level", "INFO", "Set log level")
+	fs.Var(&subFormatFlag{&opts.SubtitleFormat}, "sub-format", "Subtitle output format: SRT, VTT, ASS or TTML")
+	fs.Var(&assStyleFlag{&opts.AssStyle}, "ass-style", assStyleUsage)
+	fs.BoolVar(&opts.AutoSelect, "auto-select", false, "")
+	fs.BoolVar(&opts.SubOnly, "sub-only", false, "")
+	fs.IntVar(&opts.ThreadCount, "thread-count", runtime.GOMAXPROCS(0), "")
+	fs.IntVar(&opts.DownloadRetryCount, "download-retry-count", 3, "")
+	fs.BoolVar(&opts.SkipMerge, "skip-merge", false, "")
+	fs.BoolVar(&opts.SkipDownload, "skip-download", false, "")
+	fs.BoolVar(&opts.NoDateInfo, "no-date-info", false, "Do not write creation time metadata")
+	fs.BoolVar(&opts.BinaryMerge, "binary-merge", false, "Merge segments by concatenation instead of with ffmpeg")
+	fs.BoolVar(&opts.UseFFmpegConcatDemuxer, "use-ffmpeg-concat-demuxer", false, "Merge with the ffmpeg concat demuxer instead of the concat protocol")
+	fs.BoolVar(&opts.DelAfterDone, "del-after-done", true, "Delete temporary and intermediate files when done")
+	fs.BoolVar(&opts.AutoSubtitleFix, "auto-subtitle-fix", true, "")
+	fs.BoolVar(&opts.CheckSegementsCount, "check-segments-count", true, "Fail when the downloaded segment count does not match the playlist")
+	fs.BoolVar(&opts.WriteMetaJson, "write-meta-json", true, "Write meta.json and meta_selected.json describing the parsed and selected streams")
+	fs.StringVar(&opts.ProgressJson, "progress-json", "", "Write progress events as JSON lines to this file, or to stdout with \"-\"")
+	fs.BoolVar(&opts.MetaJsonKeys, "meta-json-keys", false, "Keep segment decryption keys and the Authorization, Proxy-Authorization and Cookie headers in meta.json files instead of redacting them")
 
-	//Parse all flags
-	flag.Parse()
+	fs.BoolVar(&opts.AppendUrlParams, "append-url-params", false, "Description for append-url-params")
+	fs.BoolVar(&opts.MP4RealTimeDecryption, "mp4-real-time-decryption", false, "Decrypt fMP4 segments as soon as they are downloaded instead of after merging")
+	fs.BoolVar(&opts.UseShakaPackager, "use-shaka-packager", false, "Use shaka-packager instead of mp4decrypt to decrypt")
+	fs.BoolVar(&opts.ForceAnsiConsole, "force-ansi-console", false, "Description for force-ansi-console")
+	fs.BoolVar(&opts.NoAnsiColor, "no-ansi-color", false, "Description for no-ansi-color")
+	fs.StringVar(&opts.DecryptionBinaryPath, "decryption-binary-path", "", "Path to mp4decrypt/shaka-packager, or the directory containing it")
+	fs.StringVar(&opts.FFmpegBinaryPath, "ffmpeg-binary-path", "", "Path to ffmpeg, or the directory containing it")
+	fs.StringVar(&opts.BaseUrl, "base-url", "", "Base URL for the operation")
+	fs.BoolVar(&opts.ConcurrentDownload, "concurrent-download", false, "Enable concurrent downloads")
+	fs.BoolVar(&opts.NoLog, "no-log", false, "Disable logging")
+	fs.Var(opts.AdKeywords, "ad-keyword", "Ad keywords (can specify multiple)")
+	fs.Var(opts.MaxSpeed, "R", "Max download speed (in bytes/sec)")
+	fs.Var(opts.MaxSpeed, "max-speed", "Max download speed (in bytes/sec)")
+	fs.BoolVar(&opts.UseSystemProxy, "use-system-proxy", true, "")
+	fs.Var(&muxOptionsFlag{&opts.MuxAfterDone}, "M", "Mux the downloaded streams into one file, e.g. format=mkv:muxer=ffmpeg:keep=false:skip_sub=false. muxer=native writes MKV without ffmpeg")
+	fs.Var(&muxOptionsFlag{&opts.MuxAfterDone}, "mux-after-done", "Mux the downloaded streams into one file, e.g. format=mkv:muxer=ffmpeg:keep=false:skip_sub=false. muxer=native writes MKV without ffmpeg")
+	fs.Var(opts.MuxAttachments, "mux-attachment", "Attach a file, e.g. a font for ASS subtitles, when muxing to MKV with muxer=native (can specify multiple)")
+	fs.StringVar(&opts.CustomHLSMethod, "custom-hls-method", "", "Override the HLS encryption method (AES_128, AES_128_ECB, CHACHA20, ...)")
+	fs.Var(opts.CustomHLSKey, "custom-hls-key", "Override the HLS decryption key. Accepts HEX, Base64 or a file path")
+	fs.Var(opts.CustomHLSIV, "custom-hls-iv", "Override the HLS decryption IV/nonce. Accepts HEX, Base64 or a file path")
 
-	return opts
+	//Parse all flags
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if opts.CustomHLSMethod != "" && commonentity.ParseMethod(opts.CustomHLSMethod) == enums.UNKNOWN {
+		err := fmt.Errorf("unknown --custom-hls-method: %s", opts.CustomHLSMethod)
+		fmt.Fprintln(fs.Output(), err)
+		return opts, err
+	}
+	return opts, nil
 }
@@ -0,0 +1,131 @@
+package commandline
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/entity"
+)
+
+// offsetFlag accepts a Go duration ("-1.5s"), plain seconds ("2.5") or a
+// clock time ("00:01:02.500"), each optionally negative.
+type offsetFlag struct {
+	value *time.Duration
+}
+
+func (o *offsetFlag) String() string {
+	if o.value == nil {
+		return ""
+	}
+	return o.value.String()
+}
+
+func (o *offsetFlag) Set(value string) error {
+	d, err := parseOffset(value)
+	if err != nil {
+		return err
+	}
+	*o.value = d
+	return nil
+}
+
+func parseOffset(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+	if s, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(s * float64(time.Second)), nil
+	}
+	negative := strings.HasPrefix(value, "-")
+	clock, frac, _ := strings.Cut(strings.TrimPrefix(value, "-"), ".")
+	var seconds int64
+	for _, part := range strings.Split(clock, ":") {
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time offset: %s", value)
+		}
+		seconds = seconds*60 + v
+	}
+	d := time.Duration(seconds) * time.Second
+	if frac != "" {
+		f, err := strconv.ParseFloat("0."+frac, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time offset: %s", value)
+		}
+		d += time.Duration(f * float64(time.Second))
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+// frameRateFlag accepts "23.976" or "24000/1001".
+type frameRateFlag struct {
+	value *float64
+}
+
+func (f *frameRateFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f.value, 'f', -1, 64)
+}
+
+func (f *frameRateFlag) Set(value string) error {
+	num, den, isRatio := strings.Cut(strings.TrimSpace(value), "/")
+	rate, err := strconv.ParseFloat(num, 64)
+	if err == nil && isRatio {
+		var d float64
+		if d, err = strconv.ParseFloat(den, 64); err == nil {
+			rate /= d
+		}
+	}
+	if err != nil || !(rate > 0) || math.IsInf(rate, 1) {
+		return fmt.Errorf("invalid frame rate: %s", value)
+	}
+	*f.value = rate
+	return nil
+}
+
+// SubtitleCommand parses the arguments following `subtitle`, e.g.
+// `subtitle --shift -1.2s --drop "^\[.*\]$" --sub-format VTT in.srt`.
+func SubtitleCommand(args []string) (*entity.SubtitleOptions, error) {
+	opts := &entity.SubtitleOptions{Format: "SRT"}
+	drop := new(stringSlice)
+	fs := flag.NewFlagSet("subtitle", flag.ContinueOnError)
+	fs.StringVar(&opts.Output, "output", "", "Output file; defaults to the input name with the --sub-format extension")
+	fs.Var(&subFormatFlag{&opts.Format}, "sub-format", "Subtitle output format: SRT, VTT, ASS or TTML")
+	fs.Var(&assStyleFlag{&opts.AssStyle}, "ass-style", assStyleUsage)
+	fs.Var(&offsetFlag{&opts.Shift}, "shift", "Shift every cue, e.g. -1.5s, 2.5 or 00:00:01.200")
+	fs.Var(&frameRateFlag{&opts.FromFps}, "fps-from", "Frame rate the subtitles were timed for, e.g. 25")
+	fs.Var(&frameRateFlag{&opts.ToFps}, "fps-to", "Frame rate to retime to, e.g. 23.976 or 24000/1001")
+	fs.Var(drop, "drop", "Remove lines matching this regex, e.g. ^\\[.*\\]$ (can specify multiple)")
+	fs.Var(&offsetFlag{&opts.MergeShorter}, "merge-shorter", "Merge cues shorter than this into the cues that follow them")
+	opts.MergeGap = 200 * time.Millisecond
+	fs.Var(&offsetFlag{&opts.MergeGap}, "merge-gap", "Largest gap between cues merged by --merge-shorter")
+	fs.BoolVar(&opts.AutoFix, "auto-subtitle-fix", true, "Fix overlapping and zero-length cues")
+	// Errors are reported the way flag reports its own, so callers needn't print them.
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	var err error
+	if fs.NArg() != 1 {
+		err = fmt.Errorf("expecting one input file, got %d", fs.NArg())
+	} else if (opts.FromFps == 0) != (opts.ToFps == 0) {
+		err = fmt.Errorf("--fps-from and --fps-to must be given together")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, err
+	}
+	opts.Input = fs.Arg(0)
+	opts.Drop = *drop
+	return opts, nil
+}
@@ -0,0 +1,103 @@
+package commandline
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOffset(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"1.5s", 1500 * time.Millisecond},
+		{"-1.2s", -1200 * time.Millisecond},
+		{"1m30s", 90 * time.Second},
+		{"2.5", 2500 * time.Millisecond},
+		{"-0.25", -250 * time.Millisecond},
+		{"00:01:02.500", time.Minute + 2500*time.Millisecond},
+		{"-00:00:01.200", -1200 * time.Millisecond},
+		{"01:02", time.Minute + 2*time.Second},
+		{"1:00:00", time.Hour},
+		{" 3s ", 3 * time.Second},
+	}
+	for _, tt := range tests {
+		got, err := parseOffset(tt.value)
+		if err != nil {
+			t.Errorf("parseOffset(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseOffset(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{"", "abc", "00:xx:01", "00:00:01.x"} {
+		if _, err := parseOffset(value); err == nil {
+			t.Errorf("parseOffset(%q): got no error", value)
+		}
+	}
+}
+
+func TestFrameRateFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"25", 25},
+		{"23.976", 23.976},
+		{"24000/1001", 24000.0 / 1001},
+		{" 30000/1001 ", 30000.0 / 1001},
+	}
+	for _, tt := range tests {
+		var rate float64
+		if err := (&frameRateFlag{&rate}).Set(tt.value); err != nil || rate != tt.want {
+			t.Errorf("Set(%q) = %v, %v, want %v", tt.value, rate, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "0", "-25", "fast", "24000/0", "0/0", "NaN", "24000/x"} {
+		var rate float64
+		if err := (&frameRateFlag{&rate}).Set(value); err == nil {
+			t.Errorf("Set(%q): got no error, rate %v", value, rate)
+		}
+	}
+}
+
+func TestSubtitleCommand(t *testing.T) {
+	opts, err := SubtitleCommand([]string{"--shift", "-1.5s", "--fps-from", "25", "--fps-to", "24000/1001", "--drop", "^a", "--drop", "^b", "in.srt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Input != "in.srt" || opts.Shift != -1500*time.Millisecond || opts.FromFps != 25 || opts.ToFps != 24000.0/1001 || len(opts.Drop) != 2 {
+		t.Errorf("got %+v", opts)
+	}
+	if opts.Format != "SRT" || opts.MergeGap != 200*time.Millisecond || !opts.AutoFix || opts.AssStyle != nil {
+		t.Errorf("defaults: got %+v", opts)
+	}
+
+	opts, err = SubtitleCommand([]string{"--sub-format", "ass", "--ass-style", "font=Noto Sans:size=40:align=8", "in.vtt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Format != "ASS" || opts.AssStyle == nil || opts.AssStyle.FontName != "Noto Sans" || opts.AssStyle.FontSize != 40 || opts.AssStyle.Alignment != 8 {
+		t.Errorf("--ass-style: got %+v, style %+v", opts, opts.AssStyle)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"fps-from alone", []string{"--fps-from", "25", "in.srt"}, "--fps-from and --fps-to must be given together"},
+		{"fps-to alone", []string{"--fps-to", "25", "in.srt"}, "--fps-from and --fps-to must be given together"},
+		{"no input", nil, "expecting one input file, got 0"},
+		{"bad offset", []string{"--shift", "soon", "in.srt"}, "invalid time offset"},
+		{"bad ass style", []string{"--ass-style", "size=0", "in.srt"}, "must be positive"},
+	}
+	for _, tt := range tests {
+		_, err := SubtitleCommand(tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
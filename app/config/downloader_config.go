@@ -0,0 +1,14 @@
+package config
+
+import (
+	commandline "github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/command_line"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+)
+
+type DownloaderConfig struct {
+	MyOptions *commandline.Options
+	// DirPrefix is the temporary directory that holds this task's segments.
+	DirPrefix string
+	Headers   map[string]string
+	Logger    *log.Logger
+}
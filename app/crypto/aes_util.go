@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+)
+
+// AES128Decrypt decrypts an HLS AES-128 segment. CBC is used unless ecb is set,
+// and PKCS#7 padding is removed when present.
+func AES128Decrypt(data, key, iv []byte, ecb bool) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted data length %d is not a multiple of %d", len(data), aes.BlockSize)
+	}
+
+	out := make([]byte, len(data))
+	if ecb {
+		for i := 0; i < len(data); i += aes.BlockSize {
+			block.Decrypt(out[i:i+aes.BlockSize], data[i:i+aes.BlockSize])
+		}
+	} else {
+		if len(iv) != aes.BlockSize {
+			return nil, fmt.Errorf("iv must be %d bytes, got %d", aes.BlockSize, len(iv))
+		}
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	}
+
+	return unpadPKCS7(out), nil
+}
+
+func unpadPKCS7(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	pad := int(data[len(data)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(data) {
+		return data
+	}
+	for _, b := range data[len(data)-pad:] {
+		if int(b) != pad {
+			return data
+		}
+	}
+	return data[:len(data)-pad]
+}
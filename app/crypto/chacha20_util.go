@@ -0,0 +1,103 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const chachaBlockSize = 64
+
+// ChaCha20 is a minimal RFC 8439 ChaCha20 stream cipher.
+type ChaCha20 struct {
+	state   [16]uint32
+	block   [chachaBlockSize]byte
+	offset  int
+	counter uint32
+}
+
+// NewChaCha20 creates a cipher with a 32-byte key and a 12-byte nonce, starting at counter.
+func NewChaCha20(key, nonce []byte, counter uint32) (*ChaCha20, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("chacha20 key must be 32 bytes, got %d", len(key))
+	}
+	if len(nonce) != 12 {
+		return nil, fmt.Errorf("chacha20 nonce must be 12 bytes, got %d", len(nonce))
+	}
+
+	c := &ChaCha20{counter: counter, offset: chachaBlockSize}
+	c.state[0], c.state[1], c.state[2], c.state[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		c.state[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	for i := 0; i < 3; i++ {
+		c.state[13+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	return c, nil
+}
+
+// XORKeyStream XORs src with the key stream into dst. dst and src may overlap entirely.
+func (c *ChaCha20) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.offset == chachaBlockSize {
+			c.nextBlock()
+		}
+		dst[i] = src[i] ^ c.block[c.offset]
+		c.offset++
+	}
+}
+
+func (c *ChaCha20) nextBlock() {
+	x := c.state
+	x[12] = c.counter
+	for i := 0; i < 10; i++ {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+	for i := range x {
+		in := c.state[i]
+		if i == 12 {
+			in = c.counter
+		}
+		binary.LittleEndian.PutUint32(c.block[i*4:], x[i]+in)
+	}
+	c.counter++
+	c.offset = 0
+}
+
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+// DecryptPer1024Bytes decrypts a ChaCha20 segment where every 1024-byte chunk
+// is encrypted independently with the counter reset to zero. An 8-byte nonce
+// is left-padded with four zero bytes.
+func DecryptPer1024Bytes(data, key, nonce []byte) ([]byte, error) {
+	if len(nonce) == 8 {
+		nonce = append(make([]byte, 4), nonce...)
+	}
+
+	out := make([]byte, len(data))
+	for start := 0; start < len(data); start += 1024 {
+		end := min(start+1024, len(data))
+		c, err := NewChaCha20(key, nonce, 0)
+		if err != nil {
+			return nil, err
+		}
+		c.XORKeyStream(out[start:end], data[start:end])
+	}
+	return out, nil
+}
@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestChaCha20Rfc8439(t *testing.T) {
+	// RFC 8439 section 2.4.2
+	key := mustHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	nonce := mustHex(t, "000000000000004a00000000")
+	plain := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	want := mustHex(t, "6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b"+
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8"+
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736"+
+		"5af90bbf74a35be6b40b8eedf2785e42874d")
+
+	c, err := NewChaCha20(key, nonce, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(plain))
+	// in uneven pieces, so the key stream carries over between calls
+	c.XORKeyStream(got[:7], plain[:7])
+	c.XORKeyStream(got[7:70], plain[7:70])
+	c.XORKeyStream(got[70:], plain[70:])
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  %x\nwant %x", got, want)
+	}
+}
+
+func TestDecryptPer1024Bytes(t *testing.T) {
+	// RFC 8439 appendix A.1, test vector 1: the first block for a zero key and nonce
+	block0 := mustHex(t, "76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7"+
+		"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586")
+	key := make([]byte, 32)
+	for _, nonce := range [][]byte{make([]byte, 12), make([]byte, 8)} {
+		got, err := DecryptPer1024Bytes(make([]byte, 1024+64), key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every 1024-byte chunk starts the key stream over
+		if !bytes.Equal(got[:64], block0) || !bytes.Equal(got[1024:], block0) {
+			t.Errorf("%d-byte nonce: chunks do not start with the counter 0 block", len(nonce))
+		}
+	}
+	if _, err := DecryptPer1024Bytes([]byte{0}, key[:16], make([]byte, 12)); err == nil {
+		t.Error("got no error for a 16-byte key")
+	}
+}
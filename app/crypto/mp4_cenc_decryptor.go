@@ -0,0 +1,304 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// CencDecryptor decrypts fMP4 fragments natively using the init segment's tenc box.
+// It supports the 'cenc' (AES-CTR) and 'cbcs' (AES-CBC pattern) schemes.
+type CencDecryptor struct {
+	scheme string
+	tenc   *mp4.TencInfo
+	block  cipher.Block
+}
+
+// NewCencDecryptor picks the key for the init's default KID from keys (KID hex -> key hex).
+// When the init has no KID the only key given is used.
+func NewCencDecryptor(initInfo *mp4.ParsedMP4Info, keys map[string]string) (*CencDecryptor, error) {
+	if initInfo == nil || initInfo.Tenc == nil {
+		return nil, fmt.Errorf("init segment has no tenc box")
+	}
+	scheme := initInfo.Scheme
+	if scheme == "" {
+		scheme = "cenc"
+	}
+	if scheme != "cenc" && scheme != "cbcs" {
+		return nil, fmt.Errorf("unsupported protection scheme %q", scheme)
+	}
+
+	keyHex, ok := keys[initInfo.KID]
+	if !ok && len(keys) == 1 {
+		for _, k := range keys {
+			keyHex = k
+		}
+		ok = true
+	}
+	if !ok {
+		return nil, fmt.Errorf("no key for KID %s", initInfo.KID)
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &CencDecryptor{scheme: scheme, tenc: initInfo.Tenc, block: block}, nil
+}
+
+type sampleEncryption struct {
+	iv         []byte
+	subsamples [][2]int // clear bytes, protected bytes
+}
+
+// DecryptFragment returns a decrypted copy of one or more moof+mdat pairs.
+func (d *CencDecryptor) DecryptFragment(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	boxes, err := mp4.ReadBoxes(out)
+	if err != nil {
+		return nil, err
+	}
+	for _, moof := range boxes {
+		if moof.Type != "moof" {
+			continue
+		}
+		trafs, _ := mp4.ReadBoxes(moof.Payload)
+		for _, traf := range trafs {
+			if traf.Type != "traf" {
+				continue
+			}
+			if err := d.decryptTraf(out, moof.Offset, traf.Payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// ErrNoSampleEncryption is returned for a protected fragment without a senc
+// box, whose sample IVs can't be read natively.
+var ErrNoSampleEncryption = errors.New("fragment has no senc box")
+
+// piffSencUUID is the user type of PIFF's sample encryption box, used in place of senc.
+var piffSencUUID = []byte{0xa2, 0x39, 0x4f, 0x52, 0x5a, 0x9b, 0x4f, 0x14, 0xa2, 0x44, 0x6c, 0x42, 0x7c, 0x64, 0x8d, 0xf4}
+
+func (d *CencDecryptor) decryptTraf(file []byte, moofOffset int, traf []byte) error {
+	children, err := mp4.ReadBoxes(traf)
+	if err != nil {
+		return err
+	}
+
+	base := int64(moofOffset)
+	defaultSize := uint32(0)
+	var sizes []uint32
+	var dataOffset int64
+	var samples []sampleEncryption
+	for _, box := range children {
+		p := box.Payload
+		switch box.Type {
+		case "tfhd":
+			if len(p) < 8 {
+				return fmt.Errorf("tfhd truncated")
+			}
+			flags := binary.BigEndian.Uint32(p) & 0xffffff
+			pos := 8
+			if flags&0x01 != 0 {
+				if pos+8 > len(p) {
+					return fmt.Errorf("tfhd truncated")
+				}
+				base = int64(binary.BigEndian.Uint64(p[pos:]))
+				pos += 8
+			}
+			if flags&0x02 != 0 {
+				pos += 4
+			}
+			if flags&0x08 != 0 {
+				pos += 4
+			}
+			if flags&0x10 != 0 {
+				if pos+4 > len(p) {
+					return fmt.Errorf("tfhd truncated")
+				}
+				defaultSize = binary.BigEndian.Uint32(p[pos:])
+			}
+		case "trun":
+			if len(p) < 8 {
+				return fmt.Errorf("trun truncated")
+			}
+			flags := binary.BigEndian.Uint32(p) & 0xffffff
+			count := int(binary.BigEndian.Uint32(p[4:]))
+			pos := 8
+			if flags&0x01 != 0 {
+				if pos+4 > len(p) {
+					return fmt.Errorf("trun truncated")
+				}
+				dataOffset = int64(int32(binary.BigEndian.Uint32(p[pos:])))
+				pos += 4
+			}
+			if flags&0x04 != 0 {
+				pos += 4
+			}
+			// Each of the 0x100, 0x200, 0x400 and 0x800 fields takes 4 bytes per sample.
+			entrySize := 4 * bits.OnesCount32(flags&0xf00)
+			if count < 0 || entrySize > 0 && count > (len(p)-pos)/entrySize {
+				return fmt.Errorf("trun truncated")
+			}
+			for i := 0; i < count; i++ {
+				if flags&0x100 != 0 {
+					pos += 4
+				}
+				size := defaultSize
+				if flags&0x200 != 0 {
+					size = binary.BigEndian.Uint32(p[pos:])
+					pos += 4
+				}
+				if flags&0x400 != 0 {
+					pos += 4
+				}
+				if flags&0x800 != 0 {
+					pos += 4
+				}
+				sizes = append(sizes, size)
+			}
+		case "senc":
+			if samples, err = d.parseSenc(p); err != nil {
+				return err
+			}
+		case "uuid":
+			if len(p) >= 16 && bytes.Equal(p[:16], piffSencUUID) {
+				if samples, err = d.parseSenc(p[16:]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if samples == nil {
+		if len(sizes) > 0 && d.tenc.IsProtected {
+			return ErrNoSampleEncryption
+		}
+		return nil
+	}
+	if len(samples) != len(sizes) {
+		return fmt.Errorf("senc has %d samples but trun has %d", len(samples), len(sizes))
+	}
+
+	pos := base + dataOffset
+	for i, size := range sizes {
+		end := pos + int64(size)
+		if pos < 0 || end > int64(len(file)) {
+			return fmt.Errorf("sample %d is outside the fragment", i)
+		}
+		d.decryptSample(file[pos:end], samples[i])
+		pos = end
+	}
+	return nil
+}
+
+// parseSenc reads a senc box, or the payload of PIFF's equivalent after its user type.
+func (d *CencDecryptor) parseSenc(p []byte) ([]sampleEncryption, error) {
+	errTruncated := fmt.Errorf("senc truncated")
+	if len(p) < 8 {
+		return nil, errTruncated
+	}
+	flags := binary.BigEndian.Uint32(p) & 0xffffff
+	pos := 4
+	ivSize := d.tenc.PerSampleIVSize
+	if flags&0x01 != 0 {
+		// PIFF override: AlgorithmID (3), IV_size (1), KID (16)
+		if len(p) < pos+24 {
+			return nil, errTruncated
+		}
+		ivSize = int(p[pos+3])
+		pos += 20
+	}
+	count := int(binary.BigEndian.Uint32(p[pos:]))
+	pos += 4
+	// every sample needs at least its IV
+	if count < 0 || ivSize > 0 && count > (len(p)-pos)/ivSize {
+		return nil, errTruncated
+	}
+	samples := make([]sampleEncryption, count)
+	for i := range samples {
+		if pos+ivSize > len(p) {
+			return nil, errTruncated
+		}
+		if ivSize > 0 {
+			samples[i].iv = p[pos : pos+ivSize]
+		} else {
+			samples[i].iv = d.tenc.ConstantIV
+		}
+		pos += ivSize
+		if flags&0x02 != 0 {
+			if pos+2 > len(p) {
+				return nil, errTruncated
+			}
+			n := int(binary.BigEndian.Uint16(p[pos:]))
+			pos += 2
+			if n > (len(p)-pos)/6 {
+				return nil, errTruncated
+			}
+			for j := 0; j < n; j++ {
+				clearBytes := int(binary.BigEndian.Uint16(p[pos:]))
+				protectedBytes := int(binary.BigEndian.Uint32(p[pos+2:]))
+				samples[i].subsamples = append(samples[i].subsamples, [2]int{clearBytes, protectedBytes})
+				pos += 6
+			}
+		}
+	}
+	return samples, nil
+}
+
+func (d *CencDecryptor) decryptSample(sample []byte, enc sampleEncryption) {
+	iv := make([]byte, 16)
+	copy(iv, enc.iv)
+
+	subsamples := enc.subsamples
+	if len(subsamples) == 0 {
+		subsamples = [][2]int{{0, len(sample)}}
+	}
+
+	var ctr cipher.Stream
+	if d.scheme == "cenc" {
+		ctr = cipher.NewCTR(d.block, iv)
+	}
+	pos := 0
+	for _, sub := range subsamples {
+		pos += sub[0]
+		end := min(pos+sub[1], len(sample))
+		if pos >= end {
+			continue
+		}
+		if ctr != nil {
+			ctr.XORKeyStream(sample[pos:end], sample[pos:end])
+		} else {
+			d.decryptCbcs(sample[pos:end], iv)
+		}
+		pos = end
+	}
+}
+
+// decryptCbcs decrypts one protected range with the crypt:skip block pattern.
+// The IV is reset for every subsample and trailing partial blocks stay clear.
+func (d *CencDecryptor) decryptCbcs(data, iv []byte) {
+	crypt, skip := d.tenc.CryptByteBlock, d.tenc.SkipByteBlock
+	if crypt == 0 && skip == 0 {
+		crypt = 1
+	}
+	dec := cipher.NewCBCDecrypter(d.block, iv)
+	for pos := 0; pos+aes.BlockSize <= len(data); pos += (crypt + skip) * aes.BlockSize {
+		n := min(crypt*aes.BlockSize, (len(data)-pos)/aes.BlockSize*aes.BlockSize)
+		dec.CryptBlocks(data[pos:pos+n], data[pos:pos+n])
+	}
+}
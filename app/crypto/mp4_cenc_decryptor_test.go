@@ -0,0 +1,184 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+func testBox(boxType string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], boxType)
+	return append(b, payload...)
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// testFragment builds a moof+mdat with two 'cenc' samples, each with one
+// clear byte followed by protected bytes. encBox is "senc", "uuid" for
+// PIFF's box, or "" for none.
+func testFragment(t *testing.T, key, clear []byte, encBox string) []byte {
+	ivs := [][]byte{{1, 2, 3, 4, 5, 6, 7, 8}, {9, 10, 11, 12, 13, 14, 15, 16}}
+	sizes := []int{len(clear) / 2, len(clear) - len(clear)/2}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdat := append([]byte(nil), clear...)
+	senc := append(be32(0x02), be32(2)...)
+	pos := 0
+	for i, size := range sizes {
+		iv := make([]byte, 16)
+		copy(iv, ivs[i])
+		cipher.NewCTR(block, iv).XORKeyStream(mdat[pos+1:pos+size], mdat[pos+1:pos+size])
+		pos += size
+		senc = append(senc, ivs[i]...)
+		senc = append(senc, 0, 1)
+		senc = append(senc, 0, 1)
+		senc = append(senc, be32(uint32(size-1))...)
+	}
+
+	tfhd := append(be32(0x020000), be32(1)...)
+	trun := func(dataOffset uint32) []byte {
+		b := append(be32(0x201), be32(2)...)
+		b = append(b, be32(dataOffset)...)
+		for _, size := range sizes {
+			b = append(b, be32(uint32(size))...)
+		}
+		return b
+	}
+	var encryption []byte
+	switch encBox {
+	case "senc":
+		encryption = testBox("senc", senc)
+	case "uuid":
+		encryption = testBox("uuid", piffSencUUID, senc)
+	}
+	moofSize := len(testBox("moof", testBox("traf", testBox("tfhd", tfhd), testBox("trun", trun(0)), encryption)))
+	moof := testBox("moof", testBox("traf", testBox("tfhd", tfhd), testBox("trun", trun(uint32(moofSize+8))), encryption))
+	return append(moof, testBox("mdat", mdat)...)
+}
+
+func testDecryptor(t *testing.T, key []byte) *CencDecryptor {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &CencDecryptor{scheme: "cenc", tenc: &mp4.TencInfo{IsProtected: true, PerSampleIVSize: 8}, block: block}
+}
+
+func TestDecryptFragment(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	clear := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	d := testDecryptor(t, key)
+	for _, encBox := range []string{"senc", "uuid"} {
+		fragment := testFragment(t, key, clear, encBox)
+		out, err := d.DecryptFragment(fragment)
+		if err != nil {
+			t.Fatalf("%s: %v", encBox, err)
+		}
+		if !bytes.HasSuffix(out, clear) {
+			t.Errorf("%s: mdat not decrypted", encBox)
+		}
+	}
+}
+
+func TestDecryptFragmentCbcs(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	iv := bytes.Repeat([]byte{0x07}, 16)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one sample of two subsamples; each protected range restarts the IV and
+	// encrypts every other block, leaving a trailing partial block clear
+	subsamples := [][2]int{{1, 5*16 + 5}, {2, 3 * 16}}
+	clear := make([]byte, 0, 136)
+	for len(clear) < cap(clear) {
+		clear = append(clear, byte(len(clear)))
+	}
+	mdat := append([]byte(nil), clear...)
+	senc := append(be32(0x02), be32(1)...)
+	senc = append(senc, 0, byte(len(subsamples)))
+	pos := 0
+	for _, sub := range subsamples {
+		pos += sub[0]
+		enc := cipher.NewCBCEncrypter(block, iv)
+		for off := 0; off+16 <= sub[1]; off += 2 * 16 {
+			enc.CryptBlocks(mdat[pos+off:pos+off+16], mdat[pos+off:pos+off+16])
+		}
+		pos += sub[1]
+		senc = append(senc, byte(sub[0]>>8), byte(sub[0]))
+		senc = append(senc, be32(uint32(sub[1]))...)
+	}
+
+	tfhd := append(be32(0x020000), be32(1)...)
+	trun := func(dataOffset uint32) []byte {
+		return append(append(be32(0x201), be32(1)...), append(be32(dataOffset), be32(uint32(len(mdat)))...)...)
+	}
+	moofSize := len(testBox("moof", testBox("traf", testBox("tfhd", tfhd), testBox("trun", trun(0)), testBox("senc", senc))))
+	moof := testBox("moof", testBox("traf", testBox("tfhd", tfhd), testBox("trun", trun(uint32(moofSize+8))), testBox("senc", senc)))
+	fragment := append(moof, testBox("mdat", mdat)...)
+
+	d := &CencDecryptor{scheme: "cbcs", tenc: &mp4.TencInfo{IsProtected: true, ConstantIV: iv, CryptByteBlock: 1, SkipByteBlock: 1}, block: block}
+	out, err := d.DecryptFragment(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(out, clear) {
+		t.Errorf("mdat not decrypted:\ngot  % x\nwant % x", out[len(out)-len(clear):], clear)
+	}
+}
+
+func TestDecryptFragmentWithoutSenc(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	fragment := testFragment(t, key, []byte("0123456789abcdef"), "")
+	if _, err := testDecryptor(t, key).DecryptFragment(fragment); !errors.Is(err, ErrNoSampleEncryption) {
+		t.Errorf("got %v, want ErrNoSampleEncryption", err)
+	}
+}
+
+func TestDecryptFragmentTruncated(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	fragment := testFragment(t, key, []byte("0123456789abcdef"), "senc")
+	d := testDecryptor(t, key)
+	// Shrink the traf and every box inside it by cutting bytes off the end of
+	// the moof while keeping the sizes consistent, then corrupt single bytes.
+	moofSize := int(binary.BigEndian.Uint32(fragment))
+	for cut := 1; cut < moofSize-16; cut++ {
+		moof := append([]byte(nil), fragment[:moofSize-cut]...)
+		binary.BigEndian.PutUint32(moof, uint32(len(moof)))
+		fixInnerSizes(moof[8:], len(moof)-8)
+		d.DecryptFragment(moof)
+	}
+	for i := 0; i < moofSize; i++ {
+		corrupt := append([]byte(nil), fragment...)
+		corrupt[i] ^= 0xff
+		d.DecryptFragment(corrupt)
+	}
+}
+
+// fixInnerSizes clamps the size of the last box in data and recurses into it.
+func fixInnerSizes(data []byte, size int) {
+	for pos := 0; pos+8 <= size; {
+		boxSize := int(binary.BigEndian.Uint32(data[pos:]))
+		if pos+boxSize >= size {
+			binary.BigEndian.PutUint32(data[pos:], uint32(size-pos))
+			if string(data[pos+4:pos+8]) == "traf" {
+				fixInnerSizes(data[pos+8:], size-pos-8)
+			}
+			return
+		}
+		pos += boxSize
+	}
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+)
+
+// CustomKey is a method, key and IV given on the command line that override
+// what the manifest declared for each segment.
+type CustomKey struct {
+	Method *enums.EncryptMethod
+	Key    []byte
+	IV     []byte
+}
+
+// Apply overrides info with the values that are set. The key and IV only go to
+// encrypted segments, so clear segments stay clear unless Method is set too.
+func (c *CustomKey) Apply(info *entity.EncryptInfo) {
+	if c.Method != nil {
+		info.Method = *c.Method
+	}
+	if info.Method == enums.NONE {
+		return
+	}
+	if len(c.Key) > 0 {
+		info.Key = c.Key
+	}
+	if len(c.IV) > 0 {
+		info.IV = c.IV
+	}
+}
+
+// CanDecryptSegment reports whether the segment's method is decrypted natively per segment.
+func CanDecryptSegment(info *entity.EncryptInfo) bool {
+	switch info.Method {
+	case enums.AES_128, enums.AES_128_ECB, enums.CHACHA20:
+		return len(info.Key) > 0
+	}
+	return false
+}
+
+// DecryptSegment decrypts one downloaded segment according to its EncryptInfo.
+// Segments using a method that is not handled per segment are returned unchanged.
+func DecryptSegment(data []byte, segment *entity.MediaSegment) ([]byte, error) {
+	info := &segment.EncryptInfo
+	if !CanDecryptSegment(info) {
+		return data, nil
+	}
+
+	switch info.Method {
+	case enums.AES_128, enums.AES_128_ECB:
+		iv := info.IV
+		if len(iv) == 0 {
+			// HLS: without an explicit IV the media sequence number is used.
+			iv = make([]byte, 16)
+			binary.BigEndian.PutUint64(iv[8:], uint64(segment.Index))
+		}
+		return AES128Decrypt(data, info.Key, iv, info.Method == enums.AES_128_ECB)
+	case enums.CHACHA20:
+		if len(info.IV) == 0 {
+			return nil, fmt.Errorf("chacha20 segment %d has no nonce", segment.Index)
+		}
+		return DecryptPer1024Bytes(data, info.Key, info.IV)
+	}
+	return data, nil
+}
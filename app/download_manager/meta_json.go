@@ -0,0 +1,121 @@
+package downloadmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/jsoncontext"
+)
+
+// sensitiveHeaders carry credentials, which meta json files leave out like keys.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// WriteMetaJson saves every parsed stream to meta.json and the chosen ones to
+// meta_selected.json in the save directory, when --write-meta-json is on.
+// Segment keys and credential headers are left out unless --meta-json-keys is given.
+// StartDownload writes meta_selected.json again once the init segments have
+// added their DRM information.
+func (m *SimpleDownloadManager) WriteMetaJson(all, selected []entity.StreamSpec) error {
+	if err := m.writeMetaJsonFile("meta.json", all); err != nil {
+		return err
+	}
+	return m.writeMetaJsonFile("meta_selected.json", selected)
+}
+
+func (m *SimpleDownloadManager) writeMetaJsonFile(name string, specs []entity.StreamSpec) error {
+	opts := m.config.MyOptions
+	if !opts.WriteMetaJson {
+		return nil
+	}
+	if err := os.MkdirAll(*opts.SaveDir, os.ModePerm); err != nil {
+		return err
+	}
+	recorded := make([]entity.StreamSpec, len(specs))
+	for i, spec := range specs {
+		if spec.Headers == nil {
+			spec.Headers = m.config.Headers
+		}
+		if !opts.MetaJsonKeys {
+			spec = spec.WithoutKeys()
+			spec.Headers = withoutSensitiveHeaders(spec.Headers)
+		}
+		recorded[i] = spec
+	}
+	data, err := jsoncontext.NewJsonContext().MarshalStreamSpecs(recorded)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(*opts.SaveDir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	m.config.Logger.Debug("Wrote %s", path)
+	return nil
+}
+
+// withoutSensitiveHeaders returns a copy of headers without sensitiveHeaders.
+func withoutSensitiveHeaders(headers map[string]string) map[string]string {
+	kept := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if !slices.ContainsFunc(sensitiveHeaders, func(name string) bool { return strings.EqualFold(k, name) }) {
+			kept[k] = v
+		}
+	}
+	return kept
+}
+
+// IsMetaJson reports whether --input names a meta.json file rather than a manifest.
+func IsMetaJson(input string) bool {
+	if !strings.EqualFold(filepath.Ext(input), ".json") {
+		return false
+	}
+	info, err := os.Stat(input)
+	return err == nil && !info.IsDir()
+}
+
+// LoadMetaJson reads the streams recorded by WriteMetaJson, so they can be
+// downloaded without parsing the manifest again. Each stream keeps the headers
+// recorded with it, see streamHeaders.
+func LoadMetaJson(path string) ([]entity.StreamSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := jsoncontext.NewJsonContext().UnmarshalStreamSpecs(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid meta json %s: %w", filepath.Base(path), err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no streams in %s", filepath.Base(path))
+	}
+	return specs, nil
+}
+
+// missingKeys reports segments whose AES or ChaCha20 key was redacted from a
+// meta json and not given with --custom-hls-key either.
+func missingKeys(spec *entity.StreamSpec) bool {
+	if spec.Playlist == nil {
+		return false
+	}
+	segments := []entity.MediaSegment{}
+	if spec.Playlist.MediaInit != nil {
+		segments = append(segments, *spec.Playlist.MediaInit)
+	}
+	for _, part := range spec.Playlist.MediaParts {
+		segments = append(segments, part.MediaSegments...)
+	}
+	for _, seg := range segments {
+		switch seg.EncryptInfo.Method {
+		case enums.AES_128, enums.AES_128_ECB, enums.CHACHA20:
+			if len(seg.EncryptInfo.Key) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
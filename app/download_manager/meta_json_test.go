@@ -0,0 +1,284 @@
+package downloadmanager
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	commandline "github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/command_line"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/config"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/jsoncontext"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+func TestWriteMetaJson(t *testing.T) {
+	video := enums.VIDEO
+	encrypted := entity.StreamSpec{
+		MediaType: &video,
+		Url:       "https://example.com/video.m3u8",
+		Headers:   map[string]string{"Cookie": "session=1", "Referer": "https://example.com/"},
+		Playlist: &entity.Playlist{MediaParts: []entity.MediaPart{{MediaSegments: []entity.MediaSegment{
+			{Url: "https://example.com/0.ts", EncryptInfo: entity.EncryptInfo{Method: enums.AES_128, Key: []byte("0123456789abcdef")}},
+		}}}},
+	}
+	// a stream without recorded headers gets the download's
+	plain := entity.StreamSpec{MediaType: &video, Url: "https://example.com/other.m3u8"}
+	globalHeaders := map[string]string{"authorization": "Bearer secret", "User-Agent": "test"}
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantKey     bool
+		wantHeaders []map[string]string
+	}{
+		{
+			"redacted",
+			nil,
+			false,
+			[]map[string]string{{"Referer": "https://example.com/"}, {"User-Agent": "test"}},
+		},
+		{
+			"with keys",
+			[]string{"--meta-json-keys"},
+			true,
+			[]map[string]string{encrypted.Headers, globalHeaders},
+		},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		opts, err := commandline.ParseOptions(append([]string{"--no-log", "--save-dir", dir}, tt.args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manager, err := NewSimpleDownloadManager(&config.DownloaderConfig{
+			MyOptions: &opts,
+			Headers:   globalHeaders,
+			Logger:    &log.Logger{LogLevel: log.ERROR},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := manager.WriteMetaJson([]entity.StreamSpec{encrypted, plain}, []entity.StreamSpec{encrypted}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"meta.json", "meta_selected.json"} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			specs, err := jsoncontext.NewJsonContext().UnmarshalStreamSpecs(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name == "meta_selected.json" && len(specs) != 1 || name == "meta.json" && len(specs) != 2 {
+				t.Fatalf("%s %s: got %d streams", tt.name, name, len(specs))
+			}
+			for i, spec := range specs {
+				if !reflect.DeepEqual(spec.Headers, tt.wantHeaders[i]) {
+					t.Errorf("%s %s stream %d: headers %v, want %v", tt.name, name, i, spec.Headers, tt.wantHeaders[i])
+				}
+			}
+			key := specs[0].Playlist.MediaParts[0].MediaSegments[0].EncryptInfo.Key
+			if hasKey := len(key) > 0; hasKey != tt.wantKey {
+				t.Errorf("%s %s: key recorded %v, want %v", tt.name, name, hasKey, tt.wantKey)
+			}
+		}
+	}
+	if encrypted.Headers["Cookie"] == "" || len(encrypted.Playlist.MediaParts[0].MediaSegments[0].EncryptInfo.Key) == 0 {
+		t.Error("redacting changed the caller's streams")
+	}
+}
+
+func TestWriteMetaJsonDisabled(t *testing.T) {
+	dir := t.TempDir()
+	opts, err := commandline.ParseOptions([]string{"--no-log", "--save-dir", dir, "--write-meta-json=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewSimpleDownloadManager(&config.DownloaderConfig{MyOptions: &opts, Logger: &log.Logger{LogLevel: log.ERROR}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.WriteMetaJson([]entity.StreamSpec{{Url: "https://example.com/a.m3u8"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("wrote %d files", len(entries))
+	}
+}
+
+func TestMetaJsonStreamHeaders(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]http.Header)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		w.Write([]byte("segment"))
+	}))
+	defer server.Close()
+
+	video, audio := enums.VIDEO, enums.AUDIO
+	stream := func(mediaType *enums.MediaType, name string, headers map[string]string) entity.StreamSpec {
+		return entity.StreamSpec{
+			MediaType: mediaType,
+			Url:       server.URL + "/" + name + ".m3u8",
+			Headers:   headers,
+			Playlist: &entity.Playlist{MediaParts: []entity.MediaPart{{MediaSegments: []entity.MediaSegment{
+				{Url: server.URL + "/" + name + ".ts"},
+			}}}},
+		}
+	}
+	data, err := jsoncontext.NewJsonContext().MarshalStreamSpecs([]entity.StreamSpec{
+		stream(&video, "video", map[string]string{"Referer": "https://video.example/", "X-Token": "v"}),
+		stream(&audio, "audio", map[string]string{"Referer": "https://audio.example/"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "meta_selected.json")
+	if err := os.WriteFile(metaPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := LoadMetaJson(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := commandline.ParseOptions([]string{
+		"-H", "X-Token: cli", "--skip-merge", "--no-log", "--write-meta-json=false",
+		"--tmp-dir", dir, "--save-dir", dir, "--save-name", "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewSimpleDownloadManager(&config.DownloaderConfig{
+		MyOptions: &opts,
+		DirPrefix: filepath.Join(dir, "test"),
+		Headers:   *opts.Headers,
+		Logger:    &log.Logger{LogLevel: log.ERROR},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.StartDownload(specs); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, referer, token string
+	}{
+		{"/video.ts", "https://video.example/", "cli"},
+		{"/audio.ts", "https://audio.example/", "cli"},
+	}
+	for _, tt := range tests {
+		h := seen[tt.path]
+		if h == nil {
+			t.Errorf("%s was not requested", tt.path)
+			continue
+		}
+		if got := h.Get("Referer"); got != tt.referer {
+			t.Errorf("%s: Referer %q, want %q", tt.path, got, tt.referer)
+		}
+		if got := h.Get("X-Token"); got != tt.token {
+			t.Errorf("%s: X-Token %q, want %q", tt.path, got, tt.token)
+		}
+	}
+}
+
+func TestMetaJsonMissingKeys(t *testing.T) {
+	video := enums.VIDEO
+	// as recorded without --meta-json-keys
+	spec := entity.StreamSpec{
+		MediaType: &video,
+		Url:       "https://example.com/video.m3u8",
+		Playlist: &entity.Playlist{MediaParts: []entity.MediaPart{{MediaSegments: []entity.MediaSegment{
+			{Url: "https://example.com/0.ts", EncryptInfo: entity.EncryptInfo{Method: enums.AES_128}},
+		}}}},
+	}
+	dir := t.TempDir()
+	opts, err := commandline.ParseOptions([]string{"--no-log", "--tmp-dir", dir, "--save-dir", dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewSimpleDownloadManager(&config.DownloaderConfig{MyOptions: &opts, DirPrefix: dir, Logger: &log.Logger{LogLevel: log.ERROR}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.StartDownload([]entity.StreamSpec{spec})
+	if err == nil || !strings.Contains(err.Error(), "--custom-hls-key") {
+		t.Errorf("got %v, want an error pointing at --custom-hls-key", err)
+	}
+}
+
+func TestMetaJsonRecordsDrmInfo(t *testing.T) {
+	// an init segment whose moov carries a Widevine pssh with one KID
+	kid := "00112233445566778899aabbccddeeff"
+	box := func(boxType string, payload []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(payload))), append([]byte(boxType), payload...)...)
+	}
+	systemID, _ := hex.DecodeString(mp4.WidevineSystemID)
+	kidBytes, _ := hex.DecodeString(kid)
+	data := append([]byte{0x12, 16}, kidBytes...)
+	pssh := append(append([]byte{0, 0, 0, 0}, systemID...), binary.BigEndian.AppendUint32(nil, uint32(len(data)))...)
+	init := box("moov", box("pssh", append(pssh, data...)))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/init.mp4" {
+			w.Write(init)
+			return
+		}
+		w.Write([]byte("segment"))
+	}))
+	defer server.Close()
+
+	video := enums.VIDEO
+	spec := entity.StreamSpec{
+		MediaType: &video,
+		Url:       server.URL + "/video.mpd",
+		Playlist: &entity.Playlist{
+			MediaInit:  &entity.MediaSegment{Url: server.URL + "/init.mp4"},
+			MediaParts: []entity.MediaPart{{MediaSegments: []entity.MediaSegment{{Url: server.URL + "/0.m4s"}}}},
+		},
+	}
+	dir := t.TempDir()
+	opts, err := commandline.ParseOptions([]string{"--skip-merge", "--no-log", "--tmp-dir", dir, "--save-dir", dir, "--save-name", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewSimpleDownloadManager(&config.DownloaderConfig{MyOptions: &opts, DirPrefix: filepath.Join(dir, "test"), Logger: &log.Logger{LogLevel: log.ERROR}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs := []entity.StreamSpec{spec}
+	if err := manager.WriteMetaJson(specs, specs); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.StartDownload(specs); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := LoadMetaJson(filepath.Join(dir, "meta_selected.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drm := recorded[0].DrmInfo
+	if drm == nil || len(drm.Pssh) != 1 {
+		t.Fatalf("got DrmInfo %+v, want the init segment's pssh", drm)
+	}
+	if got := drm.Pssh[0]; got.System != "Widevine" || !reflect.DeepEqual(got.KIDs, []string{kid}) {
+		t.Errorf("got pssh %+v", got)
+	}
+}
@@ -0,0 +1,99 @@
+package downloadmanager
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// StreamEventInfo describes a stream in --progress-json events.
+func StreamEventInfo(index int, spec *entity.StreamSpec) map[string]interface{} {
+	info := map[string]interface{}{
+		"stream":   index,
+		"segments": *spec.GetSegmentsCount(),
+		"url":      spec.Url,
+	}
+	if spec.MediaType != nil {
+		info["media_type"] = spec.MediaType.String()
+	}
+	for key, value := range map[string]*string{
+		"group_id": spec.GroupId, "language": spec.Language, "name": spec.Name,
+		"codecs": spec.Codecs, "resolution": spec.Resolution,
+	} {
+		if value != nil && *value != "" {
+			info[key] = *value
+		}
+	}
+	if spec.Bandwidth != nil {
+		info["bandwidth"] = *spec.Bandwidth
+	}
+	return info
+}
+
+// SummaryEventInfo describes the result of a run in the final --progress-json
+// event: exit_status is 0 or 1, and error is only set on failure.
+func SummaryEventInfo(outputs []string, err error) map[string]interface{} {
+	summary := map[string]interface{}{"exit_status": 0, "outputs": outputs}
+	if err != nil {
+		summary["exit_status"] = 1
+		summary["error"] = err.Error()
+	}
+	return summary
+}
+
+func (m *SimpleDownloadManager) emit(event string, fields map[string]interface{}) {
+	m.config.Logger.Progress.Emit(event, fields)
+}
+
+// phase reports the start ("start") or end ("done") of a merge, decrypt or mux step.
+func (m *SimpleDownloadManager) phase(phase, state string, task *streamTask) {
+	fields := map[string]interface{}{"phase": phase, "state": state}
+	if task != nil {
+		fields["stream"] = task.Index
+	}
+	m.emit("phase", fields)
+}
+
+// saved records a finished output file for Outputs.
+func (m *SimpleDownloadManager) saved(path string) {
+	m.config.Logger.Info("Saved to %s", path)
+	m.savedPaths = append(m.savedPaths, path)
+}
+
+// Outputs lists the files this run produced that are still on disk, e.g. after
+// muxing deleted its inputs.
+func (m *SimpleDownloadManager) Outputs() []string {
+	var outputs []string
+	for _, path := range m.savedPaths {
+		if _, err := os.Stat(path); err == nil {
+			outputs = append(outputs, path)
+		}
+	}
+	return outputs
+}
+
+// reportSpeed emits a "speed" event every second until stop is closed.
+func (m *SimpleDownloadManager) reportSpeed(task *streamTask, downloaded *atomic.Int64, stop <-chan struct{}) {
+	if m.config.Logger.Progress == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last, lastTime := int64(0), time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			total := downloaded.Load()
+			m.emit("speed", map[string]interface{}{
+				"stream":           task.Index,
+				"bytes_per_second": int64(float64(total-last) / now.Sub(lastTime).Seconds()),
+				"downloaded_bytes": total,
+			})
+			last, lastTime = total, now
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package downloadmanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	commandline "github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/command_line"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/config"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+)
+
+// decodeEvents parses --progress-json output, checking that every line has an event and a time.
+func decodeEvents(t *testing.T, data []byte) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %d: %v: %s", len(events)+1, err, scanner.Text())
+		}
+		if _, ok := event["event"].(string); !ok {
+			t.Errorf("line %d: no event key: %s", len(events)+1, scanner.Text())
+		}
+		if _, ok := event["time"].(string); !ok {
+			t.Errorf("line %d: no time key: %s", len(events)+1, scanner.Text())
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestStreamEventInfo(t *testing.T) {
+	video, lang, empty, bandwidth := enums.VIDEO, "en", "", 2000000
+	spec := &entity.StreamSpec{
+		MediaType: &video,
+		Language:  &lang,
+		Name:      &empty,
+		Bandwidth: &bandwidth,
+		Url:       "https://example.com/video.m3u8",
+		Playlist: &entity.Playlist{MediaParts: []entity.MediaPart{
+			{MediaSegments: []entity.MediaSegment{{Index: 0}, {Index: 1}}},
+			{MediaSegments: []entity.MediaSegment{{Index: 2}}},
+		}},
+	}
+	want := map[string]interface{}{
+		"stream": 3, "segments": 3, "url": "https://example.com/video.m3u8",
+		"media_type": "VIDEO", "language": "en", "bandwidth": 2000000,
+	}
+	if got := StreamEventInfo(3, spec); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSummaryEvent(t *testing.T) {
+	var buf bytes.Buffer
+	progress := log.NewProgressReporter(&buf)
+	progress.Emit("summary", SummaryEventInfo([]string{"out/show.mp4", "out/show.en.srt"}, nil))
+	progress.Emit("summary", SummaryEventInfo(nil, errors.New("segment 3 failed")))
+
+	events := decodeEvents(t, buf.Bytes())
+	want := []map[string]interface{}{
+		{"event": "summary", "exit_status": 0.0, "outputs": []interface{}{"out/show.mp4", "out/show.en.srt"}},
+		{"event": "summary", "exit_status": 1.0, "outputs": nil, "error": "segment 3 failed"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i := range want {
+		delete(events[i], "time")
+		if !reflect.DeepEqual(events[i], want[i]) {
+			t.Errorf("event %d: got %v, want %v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestDownloadProgressEvents(t *testing.T) {
+	// a TS null packet, which MergeTS accepts as a segment
+	nullPacket := append([]byte{0x47, 0x1F, 0xFF, 0x10}, bytes.Repeat([]byte{0xFF}, 184)...)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the second segment fails once before succeeding
+		if r.URL.Path == "/1.ts" && requests.Add(1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(nullPacket)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	opts, err := commandline.ParseOptions([]string{
+		"--binary-merge", "--no-log", "--thread-count", "1", "--download-retry-count", "2",
+		"--tmp-dir", dir, "--save-dir", dir, "--save-name", "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := enums.VIDEO
+	spec := entity.StreamSpec{
+		MediaType: &video,
+		Url:       server.URL + "/video.m3u8",
+		Playlist: &entity.Playlist{MediaParts: []entity.MediaPart{{MediaSegments: []entity.MediaSegment{
+			{Index: 0, Url: server.URL + "/0.ts"},
+			{Index: 1, Url: server.URL + "/1.ts"},
+		}}}},
+	}
+	var buf bytes.Buffer
+	manager, err := NewSimpleDownloadManager(&config.DownloaderConfig{
+		MyOptions: &opts,
+		DirPrefix: filepath.Join(dir, "test"),
+		Headers:   map[string]string{},
+		Logger:    &log.Logger{LogLevel: log.ERROR, Progress: log.NewProgressReporter(&buf)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.StartDownload([]entity.StreamSpec{spec}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	var order []string
+	for _, event := range decodeEvents(t, buf.Bytes()) {
+		name := event["event"].(string)
+		counts[name]++
+		switch name {
+		case "track_selected":
+			if event["stream"] != 0.0 || event["segments"] != 2.0 || event["media_type"] != "VIDEO" {
+				t.Errorf("track_selected: got %v", event)
+			}
+			order = append(order, name)
+		case "segment_retry":
+			if event["index"] != 1.0 || event["attempt"] != 1.0 || event["error"] == nil {
+				t.Errorf("segment_retry: got %v", event)
+			}
+		case "segment_finished":
+			if event["stream"] != 0.0 || !(event["bytes"].(float64) > 0) {
+				t.Errorf("segment_finished: got %v", event)
+			}
+		case "phase":
+			order = append(order, event["phase"].(string)+" "+event["state"].(string))
+		}
+	}
+	want := map[string]int{"track_selected": 1, "segment_started": 2, "segment_retry": 1, "segment_finished": 2, "phase": 2}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("%s: got %d events, want %d (all: %v)", name, counts[name], n, counts)
+		}
+	}
+	if counts["segment_failed"] != 0 {
+		t.Errorf("got %d segment_failed events", counts["segment_failed"])
+	}
+	if wantOrder := []string{"track_selected", "merge start", "merge done"}; !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("got %v, want %v", order, wantOrder)
+	}
+
+	outputs := manager.Outputs()
+	if len(outputs) != 1 || filepath.Base(outputs[0]) != "test.ts" {
+		t.Fatalf("outputs: got %v", outputs)
+	}
+	if data, err := os.ReadFile(outputs[0]); err != nil || len(data) != 2*len(nullPacket) {
+		t.Errorf("%s: got %d bytes, %v, want both segments", outputs[0], len(data), err)
+	}
+}
@@ -0,0 +1,523 @@
+package downloadmanager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/config"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/crypto"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/downloader"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/util"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// streamTask is the per-StreamSpec state shared by the download stages.
+type streamTask struct {
+	Index    int
+	Spec     *entity.StreamSpec
+	Dir      string
+	InitPath string
+	Keys     []util.KeyEntry
+	// Downloader fetches the stream's segments with its own headers.
+	Downloader *downloader.SimpleDownloader
+	// MergeInitPath is the init segment to merge with; it differs from InitPath
+	// once real-time decryption has written a clear copy.
+	MergeInitPath string
+	// Parts holds the downloaded segment files of each MediaPart, in Index order.
+	Parts     [][]string
+	Decrypted bool
+	// NoRealTime is set once real-time decryption turned out not to work for the stream.
+	NoRealTime bool
+	OutputPath string
+	// CaptionPaths are the closed captions extracted from a video stream, keyed by channel.
+	CaptionPaths map[string]string
+}
+
+// clearDir holds the decrypted segments, apart from the encrypted ones in Dir,
+// so a resumed task never mixes the two whichever way it decrypts.
+func (t *streamTask) clearDir() string {
+	return filepath.Join(t.Dir, "dec")
+}
+
+func (t *streamTask) segmentPaths() []string {
+	var paths []string
+	for _, part := range t.Parts {
+		paths = append(paths, part...)
+	}
+	return paths
+}
+
+type SimpleDownloadManager struct {
+	config     *config.DownloaderConfig
+	downloader *downloader.SimpleDownloader
+	keyDB      *util.KeyDatabase
+	// customKey holds --custom-hls-method, --custom-hls-key and --custom-hls-iv, if given.
+	customKey *crypto.CustomKey
+	decryptor *util.Mp4Decryptor
+	ffmpeg    *util.FFmpegMerger
+	saveName  string
+	// basePts is the 90kHz PTS of the first video segment, or -1 until known.
+	basePts int64
+	// fmp4Baseline is the start time in seconds all fMP4 streams are shifted
+	// back by, set by the first one merged, or -1 until then.
+	fmp4Baseline float64
+	startTime    time.Time
+	// outputs are the paths handed out by outputPath, to keep streams from colliding.
+	outputs map[string]bool
+	// savedPaths are the finished files, see Outputs.
+	savedPaths []string
+}
+
+func NewSimpleDownloadManager(cfg *config.DownloaderConfig) (*SimpleDownloadManager, error) {
+	opts := cfg.MyOptions
+	keyDB := util.NewKeyDatabase()
+	for _, key := range *opts.Keys {
+		keyDB.AddKeyArg(key)
+	}
+	if opts.KeyTextFile != "" {
+		if err := keyDB.LoadKeyTextFile(opts.KeyTextFile); err != nil {
+			return nil, fmt.Errorf("failed to load key text file: %w", err)
+		}
+		cfg.Logger.Info("Loaded %d keys from %s", keyDB.Len(), opts.KeyTextFile)
+	}
+	if opts.UseShakaPackager {
+		// shaka-packager has no form for mp4decrypt's TRACK_ID:KEY
+		for _, key := range keyDB.All() {
+			if key.Raw != "" {
+				return nil, fmt.Errorf("--use-shaka-packager needs --key values in KID:KEY form, got %q", key.Raw)
+			}
+		}
+	}
+
+	var customKey *crypto.CustomKey
+	if opts.CustomHLSMethod != "" || len(*opts.CustomHLSKey) > 0 || len(*opts.CustomHLSIV) > 0 {
+		customKey = &crypto.CustomKey{Key: *opts.CustomHLSKey, IV: *opts.CustomHLSIV}
+		if opts.CustomHLSMethod != "" {
+			method := entity.ParseMethod(opts.CustomHLSMethod)
+			customKey.Method = &method
+		}
+	}
+
+	return &SimpleDownloadManager{
+		config: cfg,
+		downloader: &downloader.SimpleDownloader{
+			Headers:    cfg.Headers,
+			RetryCount: opts.DownloadRetryCount,
+			Logger:     cfg.Logger,
+			Progress:   cfg.Logger.Progress,
+		},
+		keyDB:        keyDB,
+		customKey:    customKey,
+		saveName:     util.SanitizeFileName(*opts.SaveName),
+		basePts:      -1,
+		fmp4Baseline: -1,
+		startTime:    time.Now(),
+		outputs:      make(map[string]bool),
+	}, nil
+}
+
+// StartDownload downloads every selected stream. Init segments are fetched and
+// keys resolved for all streams first, so a missing key fails before any media is downloaded.
+func (m *SimpleDownloadManager) StartDownload(specs []entity.StreamSpec) error {
+	tasks := make([]*streamTask, 0, len(specs))
+	protected := make([]*entity.StreamSpec, 0)
+	for i := range specs {
+		task := &streamTask{
+			Index: i,
+			Spec:  &specs[i],
+			Dir:   filepath.Join(m.config.DirPrefix, streamDirName(i, &specs[i])),
+		}
+		m.applyCustomKey(task.Spec)
+		if missingKeys(task.Spec) {
+			return fmt.Errorf("%s has encrypted segments without keys, pass --custom-hls-key or record them with --meta-json-keys", task.Spec.ToShortShortString())
+		}
+		task.Downloader = m.streamDownloader(task)
+		if err := m.prepareStream(task); err != nil {
+			return err
+		}
+		if task.Spec.DrmInfo != nil {
+			protected = append(protected, task.Spec)
+		}
+		m.emit("track_selected", StreamEventInfo(i, task.Spec))
+		tasks = append(tasks, task)
+	}
+
+	if len(protected) > 0 {
+		m.config.Logger.Info("Protection info:")
+		console := log.NewCustomAnsiConsole(m.config.MyOptions.ForceAnsiConsole, m.config.MyOptions.NoAnsiColor)
+		console.Markup(util.FormatKeysReport(protected))
+		// record the KIDs and PSSH boxes read from the init segments
+		if err := m.writeMetaJsonFile("meta_selected.json", specs); err != nil {
+			m.config.Logger.Warn("Failed to write meta json: %v", err)
+		}
+	}
+	for _, task := range tasks {
+		if err := m.resolveKeys(task); err != nil {
+			return err
+		}
+	}
+
+	// Subtitles go last so their timestamp maps can be resolved against the video.
+	order := make([]*streamTask, 0, len(tasks))
+	for _, task := range tasks {
+		if streamMediaType(task.Spec) != enums.SUBTITLES {
+			order = append(order, task)
+		}
+	}
+	for _, task := range tasks {
+		if streamMediaType(task.Spec) == enums.SUBTITLES {
+			order = append(order, task)
+		}
+	}
+	for _, task := range order {
+		if err := m.downloadStream(task); err != nil {
+			return err
+		}
+		if m.config.MyOptions.SkipMerge {
+			if err := m.decryptStream(task); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.mergeStream(task, len(tasks)); err != nil {
+			return err
+		}
+	}
+
+	if m.config.MyOptions.SkipMerge {
+		return nil
+	}
+	chapters := m.writeChapters(tasks)
+	if m.config.MyOptions.MuxAfterDone != nil {
+		return m.muxOutputs(tasks, chapters)
+	}
+	return nil
+}
+
+// streamDownloader returns a downloader sending the stream's recorded headers,
+// overridden by those given on the command line.
+func (m *SimpleDownloadManager) streamDownloader(task *streamTask) *downloader.SimpleDownloader {
+	dl := *m.downloader
+	dl.Stream = task.Index
+	dl.Headers = make(map[string]string, len(task.Spec.Headers)+len(m.config.Headers))
+	for k, v := range task.Spec.Headers {
+		dl.Headers[k] = v
+	}
+	for k, v := range m.config.Headers {
+		dl.Headers[k] = v
+	}
+	return &dl
+}
+
+// applyCustomKey hands the --custom-hls-* overrides to every segment of spec.
+func (m *SimpleDownloadManager) applyCustomKey(spec *entity.StreamSpec) {
+	if m.customKey == nil || spec.Playlist == nil {
+		return
+	}
+	if spec.Playlist.MediaInit != nil {
+		m.customKey.Apply(&spec.Playlist.MediaInit.EncryptInfo)
+	}
+	for p := range spec.Playlist.MediaParts {
+		segments := spec.Playlist.MediaParts[p].MediaSegments
+		for s := range segments {
+			m.customKey.Apply(&segments[s].EncryptInfo)
+		}
+	}
+}
+
+// prepareStream downloads the init segment and records the stream's protection info.
+func (m *SimpleDownloadManager) prepareStream(task *streamTask) error {
+	spec := task.Spec
+	if err := os.MkdirAll(task.Dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	var initInfo *mp4.ParsedMP4Info
+	if spec.Playlist != nil && spec.Playlist.MediaInit != nil {
+		task.InitPath = filepath.Join(task.Dir, "_init.mp4")
+		task.MergeInitPath = task.InitPath
+		if _, err := task.Downloader.DownloadSegment(spec.Playlist.MediaInit, task.InitPath); err != nil {
+			return fmt.Errorf("failed to download init segment of %s: %w", spec.ToShortShortString(), err)
+		}
+		data, err := os.ReadFile(task.InitPath)
+		if err != nil {
+			return err
+		}
+		initInfo = mp4.ReadInit(data)
+	}
+	spec.DrmInfo = util.InspectDrm(spec, initInfo)
+	return nil
+}
+
+func (m *SimpleDownloadManager) resolveKeys(task *streamTask) error {
+	spec := task.Spec
+	if !isProtected(spec) {
+		return nil
+	}
+	var kids []string
+	if spec.DrmInfo != nil {
+		kids = spec.DrmInfo.KIDs
+	}
+	if m.keyDB.Len() == 0 {
+		return fmt.Errorf("%s is encrypted (KID %v) but no key was given, use --key or --key-text-file", spec.ToShortShortString(), kids)
+	}
+	keys, err := m.keyDB.ResolveKeys(kids)
+	if err != nil {
+		return fmt.Errorf("%s: %w", spec.ToShortShortString(), err)
+	}
+	for _, key := range keys {
+		if key.Label != "" {
+			m.config.Logger.Info("Using key %s (%s)", key.KID, key.Label)
+		} else {
+			m.config.Logger.Info("Using key %s", key.KID)
+		}
+	}
+	task.Keys = keys
+	return nil
+}
+
+func (m *SimpleDownloadManager) downloadStream(task *streamTask) error {
+	spec := task.Spec
+	if spec.Playlist == nil {
+		return nil
+	}
+	opts := m.config.MyOptions
+	m.config.Logger.Info("Start downloading %s", spec.ToShortShortString())
+
+	dl, segmentDir := task.Downloader, task.Dir
+	if opts.MP4RealTimeDecryption && len(task.Keys) > 0 && !task.NoRealTime {
+		realTime, err := m.realTimeDownloader(task)
+		if err != nil {
+			m.config.Logger.Warn("Real-time decryption unavailable, decrypting after download: %v", err)
+		} else {
+			dl, segmentDir = realTime, task.clearDir()
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []int64
+	var noSenc atomic.Bool
+	sem := make(chan struct{}, max(opts.ThreadCount, 1))
+	ext := segmentExt(spec)
+	task.Parts = make([][]string, len(spec.Playlist.MediaParts))
+	var downloaded atomic.Int64
+	stopSpeed := make(chan struct{})
+	go m.reportSpeed(task, &downloaded, stopSpeed)
+
+	for p := range spec.Playlist.MediaParts {
+		segments := spec.Playlist.MediaParts[p].MediaSegments
+		sort.SliceStable(segments, func(i, j int) bool { return segments[i].Index < segments[j].Index })
+		for s := range segments {
+			segment := &segments[s]
+			savePath := filepath.Join(segmentDir, fmt.Sprintf("%05d%s", segment.Index, ext))
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				event := map[string]interface{}{"stream": task.Index, "index": segment.Index, "url": segment.Url}
+				m.emit("segment_started", event)
+				result, err := dl.DownloadSegment(segment, savePath)
+				if errors.Is(err, crypto.ErrNoSampleEncryption) {
+					// downloaded again below
+					noSenc.Store(true)
+					return
+				}
+				if err != nil {
+					m.config.Logger.Error("Segment %d failed: %v", segment.Index, err)
+					event["error"] = err.Error()
+					m.emit("segment_failed", event)
+					mu.Lock()
+					failed = append(failed, segment.Index)
+					mu.Unlock()
+					return
+				}
+				downloaded.Add(result.ActualContentLength)
+				event["bytes"] = result.ActualContentLength
+				m.emit("segment_finished", event)
+			}()
+			task.Parts[p] = append(task.Parts[p], savePath)
+		}
+	}
+	wg.Wait()
+	close(stopSpeed)
+
+	if noSenc.Load() {
+		m.config.Logger.Warn("%s has no senc boxes, decrypting after download instead of in real time", spec.ToShortShortString())
+		// The segments written so far are clear; start over with the encrypted ones.
+		for _, path := range task.segmentPaths() {
+			os.Remove(path)
+		}
+		os.Remove(task.MergeInitPath)
+		task.MergeInitPath = task.InitPath
+		task.Decrypted = false
+		task.NoRealTime = true
+		return m.downloadStream(task)
+	}
+
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool { return failed[i] < failed[j] })
+		msg := fmt.Sprintf("%d of %d segments of %s failed to download: %v", len(failed), len(task.segmentPaths()), spec.ToShortShortString(), failed)
+		if opts.CheckSegementsCount {
+			return fmt.Errorf("%s", msg)
+		}
+		m.config.Logger.Warn("%s", msg)
+	}
+	task.Parts = existingParts(task.Parts)
+	return nil
+}
+
+// existingParts drops segments that were never written.
+func existingParts(parts [][]string) [][]string {
+	result := make([][]string, 0, len(parts))
+	for _, part := range parts {
+		kept := make([]string, 0, len(part))
+		for _, path := range part {
+			if _, err := os.Stat(path); err == nil {
+				kept = append(kept, path)
+			}
+		}
+		result = append(result, kept)
+	}
+	return result
+}
+
+// decryptStream runs mp4decrypt/shaka-packager over each downloaded segment of a
+// protected stream that was not already decrypted in real time. Clear segments
+// go to clearDir, where a segment already there, whether from an earlier run
+// or from real-time decryption, is not decrypted again.
+func (m *SimpleDownloadManager) decryptStream(task *streamTask) error {
+	if task.Decrypted || len(task.Keys) == 0 {
+		return nil
+	}
+	decryptor, err := m.mp4Decryptor()
+	if err != nil {
+		return err
+	}
+
+	decDir := task.clearDir()
+	if err := os.MkdirAll(decDir, os.ModePerm); err != nil {
+		return err
+	}
+	m.config.Logger.Info("Decrypting %s using %s", task.Spec.ToShortShortString(), decryptor.BinaryPath)
+	m.phase("decrypt", "start", task)
+	defer m.phase("decrypt", "done", task)
+	for _, part := range task.Parts {
+		for i, path := range part {
+			dest := filepath.Join(decDir, filepath.Base(path))
+			if info, err := os.Stat(dest); err != nil || info.Size() == 0 {
+				if err := decryptor.Decrypt(task.Keys, path, dest, task.InitPath); err != nil {
+					return fmt.Errorf("failed to decrypt %s: %w", path, err)
+				}
+			}
+			part[i] = dest
+		}
+	}
+
+	if task.InitPath != "" {
+		initData, err := os.ReadFile(task.InitPath)
+		if err != nil {
+			return err
+		}
+		task.MergeInitPath = filepath.Join(task.Dir, "_init.dec.mp4")
+		if err := os.WriteFile(task.MergeInitPath, mp4.RemoveProtection(initData), 0644); err != nil {
+			return err
+		}
+	}
+	task.Decrypted = true
+	return nil
+}
+
+func (m *SimpleDownloadManager) mp4Decryptor() (*util.Mp4Decryptor, error) {
+	if m.decryptor == nil {
+		opts := m.config.MyOptions
+		decryptor, err := util.NewMp4Decryptor(opts.UseShakaPackager, opts.DecryptionBinaryPath, *opts.TmpDir, m.config.Logger)
+		if err != nil {
+			return nil, err
+		}
+		m.decryptor = decryptor
+	}
+	return m.decryptor, nil
+}
+
+// realTimeDownloader returns a downloader that decrypts each fMP4 segment as soon
+// as it arrives, and writes a clear init segment next to the original one so the
+// segments downloaded so far can already be played. Its segments go to clearDir.
+func (m *SimpleDownloadManager) realTimeDownloader(task *streamTask) (*downloader.SimpleDownloader, error) {
+	if task.InitPath == "" {
+		return nil, fmt.Errorf("%s has no init segment", task.Spec.ToShortShortString())
+	}
+	initData, err := os.ReadFile(task.InitPath)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string, len(task.Keys))
+	for _, key := range task.Keys {
+		keys[key.KID] = key.Key
+	}
+	decryptor, err := crypto.NewCencDecryptor(mp4.ReadInit(initData), keys)
+	if err != nil {
+		return nil, err
+	}
+
+	clearInitPath := filepath.Join(task.Dir, "_init.dec.mp4")
+	if err := os.WriteFile(clearInitPath, mp4.RemoveProtection(initData), 0644); err != nil {
+		return nil, err
+	}
+	task.MergeInitPath = clearInitPath
+	task.Decrypted = true
+
+	dl := *task.Downloader
+	dl.PostProcessor = func(segment *entity.MediaSegment, data []byte) ([]byte, error) {
+		return decryptor.DecryptFragment(data)
+	}
+	return &dl, nil
+}
+
+func streamDirName(index int, spec *entity.StreamSpec) string {
+	mediaType := "video"
+	if spec.MediaType != nil {
+		mediaType = spec.MediaType.String()
+	}
+	return fmt.Sprintf("%d_%s", index, mediaType)
+}
+
+func segmentExt(spec *entity.StreamSpec) string {
+	if spec.Extension != nil && *spec.Extension != "" {
+		return "." + *spec.Extension
+	}
+	if spec.Playlist != nil && spec.Playlist.MediaInit != nil {
+		return ".m4s"
+	}
+	return ".ts"
+}
+
+// isProtected reports whether a stream needs a content key, either because the
+// manifest declares a DRM method or because the init segment carries a tenc box.
+func isProtected(spec *entity.StreamSpec) bool {
+	if spec.DrmInfo != nil && len(spec.DrmInfo.KIDs) > 0 {
+		return true
+	}
+	if spec.Playlist == nil {
+		return false
+	}
+	for _, part := range spec.Playlist.MediaParts {
+		for _, segment := range part.MediaSegments {
+			switch segment.EncryptInfo.Method {
+			case enums.CENC, enums.SAMPLE_AES, enums.SAMPLE_AES_CTR:
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package downloadmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/remux"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/util"
+	commonentity "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+)
+
+// mergeStream joins a stream's segments into task.OutputPath. Protected fMP4 that
+// was not decrypted in real time is merged first and then decrypted in one pass.
+func (m *SimpleDownloadManager) mergeStream(task *streamTask, streamCount int) error {
+	opts := m.config.MyOptions
+	segments := task.segmentPaths()
+	if len(segments) == 0 {
+		m.config.Logger.Warn("Nothing to merge for %s", task.Spec.ToShortShortString())
+		return nil
+	}
+	if err := m.checkSegmentsCount(task, len(segments)); err != nil {
+		return err
+	}
+
+	isFMP4 := task.MergeInitPath != ""
+	isSubtitle := task.Spec.MediaType != nil && *task.Spec.MediaType == enums.SUBTITLES
+	var ffmpeg *util.FFmpegMerger
+	nativeRemux := false
+	if !opts.BinaryMerge && !isFMP4 && !isSubtitle {
+		var err error
+		if ffmpeg, err = m.ffmpegMerger(); err != nil {
+			m.config.Logger.Warn("%v, remuxing to MP4 natively", err)
+			nativeRemux = true
+		}
+	}
+
+	var subtitle *commonentity.WebVttSub
+	if isSubtitle {
+		var err error
+		if isFMP4 {
+			subtitle, err = readMP4Subtitles(task.MergeInitPath, segments)
+		} else {
+			subtitle, err = readTextSubtitles(segments, m.basePts, opts.AutoSubtitleFix)
+		}
+		if err != nil {
+			m.config.Logger.Warn("%v, merging subtitle segments as they are", err)
+		}
+	}
+
+	ext := mergeExt(task, isFMP4)
+	if subtitle != nil {
+		ext = commonentity.SubtitleExt(opts.SubtitleFormat)
+	} else if ffmpeg != nil || nativeRemux {
+		ext = ".mp4"
+		if task.Spec.MediaType != nil && *task.Spec.MediaType == enums.AUDIO {
+			ext = ".m4a"
+		}
+	}
+	task.OutputPath = m.outputPath(task, streamCount, ext)
+	needDecrypt := !task.Decrypted && len(task.Keys) > 0
+	mergedPath := task.OutputPath
+	if needDecrypt {
+		ext := filepath.Ext(task.OutputPath)
+		mergedPath = strings.TrimSuffix(task.OutputPath, ext) + ".enc" + ext
+	}
+
+	// Captions and the subtitle base timestamp are read from the segments,
+	// which merging may delete.
+	if streamMediaType(task.Spec) == enums.VIDEO && m.basePts < 0 {
+		m.basePts = m.videoBasePts(task)
+	}
+	captions := m.extractCaptions(task, segments)
+
+	m.phase("merge", "start", task)
+	var err error
+	if subtitle != nil {
+		m.config.Logger.Info("Merging %d subtitle segments...", len(segments))
+		err = writeSubtitle(subtitle, opts.SubtitleFormat, opts.AssStyle, mergedPath)
+	} else if ffmpeg != nil {
+		m.config.Logger.Info("Merging %d segments with ffmpeg...", len(segments))
+		err = m.mergeByFFmpeg(ffmpeg, task, segments, mergedPath)
+	} else if nativeRemux {
+		m.config.Logger.Info("Remuxing %d segments...", len(segments))
+		err = remux.RemuxTSToMP4(task.Parts, mergedPath)
+	} else if isFMP4 {
+		m.config.Logger.Info("Binary merging %d segments...", len(segments))
+		m.fmp4Baseline, err = util.MergeFMP4(task.MergeInitPath, segments, mergedPath, m.fmp4Baseline)
+	} else {
+		m.config.Logger.Info("Binary merging %d segments...", len(segments))
+		err = util.MergeTS(task.Parts, mergedPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to merge %s: %w", task.Spec.ToShortShortString(), err)
+	}
+	m.phase("merge", "done", task)
+
+	if needDecrypt {
+		decryptor, err := m.mp4Decryptor()
+		if err != nil {
+			return err
+		}
+		m.config.Logger.Info("Decrypting %s using %s", filepath.Base(mergedPath), decryptor.BinaryPath)
+		m.phase("decrypt", "start", task)
+		if err := decryptor.Decrypt(task.Keys, mergedPath, task.OutputPath, ""); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", mergedPath, err)
+		}
+		m.phase("decrypt", "done", task)
+		os.Remove(mergedPath)
+	}
+
+	if opts.DelAfterDone {
+		os.RemoveAll(task.Dir)
+	}
+	m.saved(task.OutputPath)
+	m.writeCaptions(task, captions)
+	return nil
+}
+
+func (m *SimpleDownloadManager) mergeByFFmpeg(ffmpeg *util.FFmpegMerger, task *streamTask, segments []string, output string) error {
+	opts := m.config.MyOptions
+	total := time.Duration(*task.Spec.Playlist.GetTotalDuration() * float64(time.Second))
+	console := log.NewCustomAnsiConsole(opts.ForceAnsiConsole, opts.NoAnsiColor)
+	ffmpeg.OnProgress = func(outTime time.Duration, speed string) {
+		console.Markup(fmt.Sprintf("\rMerging %s / %s (%s)", formatDuration(outTime), formatDuration(total), speed))
+	}
+	defer console.MarkupLine("")
+
+	return ffmpeg.Merge(segments, output, util.FFmpegMergeOptions{
+		UseConcatDemuxer: opts.UseFFmpegConcatDemuxer,
+		NoDateInfo:       opts.NoDateInfo,
+		WorkDir:          task.Dir,
+	})
+}
+
+func (m *SimpleDownloadManager) ffmpegMerger() (*util.FFmpegMerger, error) {
+	if m.ffmpeg == nil {
+		opts := m.config.MyOptions
+		ffmpeg, err := util.NewFFmpegMerger(opts.FFmpegBinaryPath, *opts.TmpDir, m.config.Logger)
+		if err != nil {
+			return nil, err
+		}
+		m.ffmpeg = ffmpeg
+	}
+	return m.ffmpeg, nil
+}
+
+func formatDuration(d time.Duration) string {
+	s := int(d.Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// checkSegmentsCount compares the merged segments with the playlist, failing
+// when --check-segments-count is on rather than producing a shorter file.
+func (m *SimpleDownloadManager) checkSegmentsCount(task *streamTask, actual int) error {
+	expected := *task.Spec.GetSegmentsCount()
+	if actual == expected {
+		return nil
+	}
+	msg := fmt.Sprintf("%s: expected %d segments, found %d", task.Spec.ToShortShortString(), expected, actual)
+	if m.config.MyOptions.CheckSegementsCount {
+		return fmt.Errorf("segment count check failed, %s", msg)
+	}
+	m.config.Logger.Warn("%s", msg)
+	return nil
+}
+
+// outputPath names a merged stream from --save-pattern, or the save name plus
+// the stream's directory name when there are several streams. A path already
+// on disk or given to another stream gets an index suffix.
+func (m *SimpleDownloadManager) outputPath(task *streamTask, streamCount int, ext string) string {
+	pattern := *m.config.MyOptions.SavePattern
+	name := m.saveName
+	switch {
+	case pattern != "":
+		name = util.FormatSavePattern(pattern, util.SavePatternVars{
+			SaveName: m.saveName,
+			Id:       task.Index,
+			Spec:     task.Spec,
+			Ext:      strings.TrimPrefix(ext, "."),
+			Time:     m.startTime,
+		})
+		if strings.Contains(pattern, "<Ext>") {
+			ext = ""
+		}
+	case streamCount > 1:
+		name = fmt.Sprintf("%s.%s", name, streamDirName(task.Index, task.Spec))
+	}
+	path := util.UniquePath(filepath.Join(*m.config.MyOptions.SaveDir, name+ext), func(p string) bool { return m.outputs[p] })
+	m.outputs[path] = true
+	return path
+}
+
+func mergeExt(task *streamTask, isFMP4 bool) string {
+	spec := task.Spec
+	switch {
+	case spec.MediaType != nil && *spec.MediaType == enums.SUBTITLES:
+		return segmentExt(spec)
+	case isFMP4 && spec.MediaType != nil && *spec.MediaType == enums.AUDIO:
+		return ".m4a"
+	case isFMP4:
+		return ".mp4"
+	}
+	return ".ts"
+}
@@ -0,0 +1,209 @@
+package downloadmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/remux"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/util"
+	commonentity "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+)
+
+// muxOutputs combines the merged streams into one file for --mux-after-done,
+// embedding chapters when there are any.
+func (m *SimpleDownloadManager) muxOutputs(tasks []*streamTask, chapters *chapterFiles) error {
+	opts := m.config.MyOptions
+	muxOpts := opts.MuxAfterDone
+	files := collectOutputFiles(tasks, muxOpts.SkipSubtitle || muxOpts.MuxFormat == "ts")
+	if len(files) == 0 {
+		return nil
+	}
+
+	output := util.UniquePath(filepath.Join(*opts.SaveDir, fmt.Sprintf("%s.MUX.%s", m.saveName, muxOpts.MuxFormat)), nil)
+	m.config.Logger.Info("Muxing %d streams into %s", len(files), output)
+	m.phase("mux", "start", nil)
+
+	native := muxOpts.Muxer == "native"
+	var ffmpeg *util.FFmpegMerger
+	if !native {
+		binPath := opts.FFmpegBinaryPath
+		if muxOpts.BinPath != "" {
+			binPath = muxOpts.BinPath
+		}
+		var err error
+		ffmpeg, err = util.NewFFmpegMerger(binPath, *opts.TmpDir, m.config.Logger)
+		if err != nil && muxOpts.MuxFormat != "mkv" {
+			return fmt.Errorf("cannot mux: %w", err)
+		}
+		if err != nil {
+			m.config.Logger.Warn("%v, muxing to MKV natively", err)
+			native = true
+		}
+	}
+
+	var err error
+	if native {
+		mkvOpts := remux.MkvMuxOptions{NoDateInfo: opts.NoDateInfo, TmpDir: *opts.TmpDir, Attachments: *opts.MuxAttachments}
+		if chapters != nil {
+			for _, c := range chapters.Chapters {
+				mkvOpts.Chapters = append(mkvOpts.Chapters, remux.MkvChapter{Start: c.Start, End: c.End, Title: c.Title})
+			}
+		}
+		err = remux.MuxToMkv(files, output, mkvOpts)
+	} else {
+		files = m.dropTtml(files)
+		if len(*opts.MuxAttachments) > 0 {
+			m.config.Logger.Warn("Attachments are only written by the native MKV muxer, skipping %d", len(*opts.MuxAttachments))
+		}
+		ffmetadata := ""
+		if chapters != nil && muxOpts.MuxFormat != "ts" {
+			ffmetadata = m.writeFFMetadata(chapters.Chapters)
+			defer os.Remove(ffmetadata)
+		}
+		err = ffmpeg.Mux(files, output, muxOpts.MuxFormat, ffmetadata, opts.NoDateInfo)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.DelAfterDone && !muxOpts.KeepFiles {
+		for _, f := range files {
+			os.Remove(f.FilePath)
+		}
+	}
+	m.phase("mux", "done", nil)
+	m.saved(output)
+	return nil
+}
+
+// dropTtml leaves out TTML subtitles, which ffmpeg cannot read.
+func (m *SimpleDownloadManager) dropTtml(files []entity.OutputFile) []entity.OutputFile {
+	kept := files[:0]
+	for _, f := range files {
+		if strings.EqualFold(filepath.Ext(f.FilePath), ".ttml") {
+			m.config.Logger.Warn("ffmpeg cannot mux TTML, skipping %s", filepath.Base(f.FilePath))
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// chapterFiles are the chapters of the download and where they were written.
+type chapterFiles struct {
+	Chapters []util.Chapter
+	OgmPath  string
+}
+
+// writeChapters derives chapters from the first video stream (or the first
+// stream when there is no video) and saves them next to the output as OGM
+// chapter text. It returns nil when there are none.
+func (m *SimpleDownloadManager) writeChapters(tasks []*streamTask) *chapterFiles {
+	var source *streamTask
+	for _, task := range tasks {
+		if task.OutputPath == "" {
+			continue
+		}
+		if source == nil || streamMediaType(task.Spec) == enums.VIDEO && streamMediaType(source.Spec) != enums.VIDEO {
+			source = task
+		}
+	}
+	if source == nil {
+		return nil
+	}
+	chapters := util.BuildChapters(source.Spec)
+	if len(chapters) == 0 {
+		return nil
+	}
+
+	saveDir := *m.config.MyOptions.SaveDir
+	files := &chapterFiles{
+		Chapters: chapters,
+		OgmPath:  util.UniquePath(filepath.Join(saveDir, m.saveName+".chapters.txt"), nil),
+	}
+	if err := util.WriteOgmChapters(chapters, files.OgmPath); err != nil {
+		m.config.Logger.Warn("Failed to write chapters: %v", err)
+	}
+	m.config.Logger.Info("Found %d chapters", len(chapters))
+	return files
+}
+
+// writeFFMetadata writes chapters for ffmpeg into the temp dir, returning ""
+// when that fails so the mux goes ahead without them. The caller removes the file.
+func (m *SimpleDownloadManager) writeFFMetadata(chapters []util.Chapter) string {
+	tmpDir := *m.config.MyOptions.TmpDir
+	path := util.UniquePath(filepath.Join(tmpDir, m.saveName+".ffmetadata.txt"), nil)
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		m.config.Logger.Warn("Failed to write chapters: %v", err)
+		return ""
+	}
+	if err := util.WriteFFMetadata(chapters, path); err != nil {
+		m.config.Logger.Warn("Failed to write chapters: %v", err)
+		return ""
+	}
+	return path
+}
+
+// collectOutputFiles orders the merged streams video, audio, subtitles, then any
+// closed captions extracted from video, and carries their manifest metadata across.
+func collectOutputFiles(tasks []*streamTask, skipSubtitle bool) []entity.OutputFile {
+	var files []entity.OutputFile
+	for _, mediaType := range []enums.MediaType{enums.VIDEO, enums.AUDIO, enums.SUBTITLES} {
+		for _, task := range tasks {
+			spec := task.Spec
+			if task.OutputPath == "" || streamMediaType(spec) != mediaType {
+				continue
+			}
+			if mediaType == enums.SUBTITLES && skipSubtitle {
+				continue
+			}
+			files = append(files, entity.OutputFile{
+				Index:     task.Index,
+				FilePath:  task.OutputPath,
+				MediaType: mediaType,
+				LangCode:  safeString(spec.Language),
+				Title:     safeString(spec.Name),
+				Default:   spec.Default != nil && *spec.Default == enums.YES,
+				Forced:    spec.Forced != nil && *spec.Forced == enums.YES || spec.Role != nil && *spec.Role == enums.ForcedSubtitle,
+				Role:      spec.Role,
+			})
+		}
+	}
+	if !skipSubtitle {
+		for _, task := range tasks {
+			channels := make([]string, 0, len(task.CaptionPaths))
+			for channel := range task.CaptionPaths {
+				channels = append(channels, channel)
+			}
+			sort.Strings(channels)
+			for _, channel := range channels {
+				files = append(files, entity.OutputFile{
+					Index:     task.Index,
+					FilePath:  task.CaptionPaths[channel],
+					MediaType: enums.SUBTITLES,
+					Title:     channel,
+				})
+			}
+		}
+	}
+	return files
+}
+
+func streamMediaType(spec *commonentity.StreamSpec) enums.MediaType {
+	if spec.MediaType == nil {
+		return enums.VIDEO
+	}
+	return *spec.MediaType
+}
+
+func safeString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,154 @@
+package downloadmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/remux"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// readTextSubtitles parses WebVTT, TTML or SRT segments and joins their cues.
+// WebVTT segments are placed on the video timeline starting at basePts (see
+// entity.MergeWebVttSegments).
+func readTextSubtitles(segments []string, basePts int64, autoFix bool) (*entity.WebVttSub, error) {
+	texts := make([]string, 0, len(segments))
+	isVtt := true
+	for _, path := range segments {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text := strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\ufeff")
+		isVtt = isVtt && strings.HasPrefix(strings.TrimSpace(text), "WEBVTT")
+		texts = append(texts, text)
+	}
+	if isVtt {
+		return entity.MergeWebVttSegments(texts, basePts, autoFix)
+	}
+
+	merged := &entity.WebVttSub{}
+	for i, text := range texts {
+		sub, err := entity.ParseSubtitle(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(segments[i]), err)
+		}
+		merged.AddCuesFromOne(sub)
+	}
+	if autoFix {
+		merged.FixTimestamps()
+	}
+	return merged, nil
+}
+
+// videoBasePts reads the PTS of a video stream's first segment, which HLS
+// subtitle timestamp maps refer to. It is -1 when it can't be read.
+func (m *SimpleDownloadManager) videoBasePts(task *streamTask) int64 {
+	segments := task.segmentPaths()
+	if len(segments) == 0 {
+		return -1
+	}
+	var pts int64
+	var err error
+	if task.MergeInitPath != "" {
+		pts, err = firstFragmentPts(task.MergeInitPath, segments[0])
+	} else {
+		pts, err = remux.FirstVideoPTS(segments[0])
+	}
+	if err != nil {
+		m.config.Logger.Debug("Cannot read the first video timestamp: %v", err)
+		return -1
+	}
+	return pts
+}
+
+// firstFragmentPts is the presentation time of the first video sample of an
+// fMP4 segment, in 90kHz units.
+func firstFragmentPts(init, segment string) (int64, error) {
+	initData, err := os.ReadFile(init)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		return 0, err
+	}
+	reader, err := mp4.NewFragmentReader(initData, func(t *mp4.TrackInfo) bool { return t.Handler == "vide" })
+	if err != nil {
+		return 0, err
+	}
+	samples, err := reader.Samples(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(samples) == 0 {
+		return 0, fmt.Errorf("no video samples in %s", filepath.Base(segment))
+	}
+	first := samples[0].DTS + samples[0].CTS
+	return first * 90000 / int64(reader.Track.Timescale), nil
+}
+
+// readMP4Subtitles extracts wvtt or stpp cues from fMP4 subtitle segments.
+func readMP4Subtitles(init string, segments []string) (*entity.WebVttSub, error) {
+	initData, err := os.ReadFile(init)
+	if err != nil {
+		return nil, err
+	}
+	fragments := make([][]byte, 0, len(segments))
+	for _, path := range segments {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, data)
+	}
+	return mp4.ExtractSubtitles(initData, fragments)
+}
+
+// extractCaptions decodes CEA-608/708 captions embedded in a clear video stream.
+func (m *SimpleDownloadManager) extractCaptions(task *streamTask, segments []string) map[string]*entity.WebVttSub {
+	if streamMediaType(task.Spec) != enums.VIDEO || len(task.Keys) > 0 && !task.Decrypted {
+		return nil
+	}
+	var captions map[string]*entity.WebVttSub
+	var err error
+	if task.MergeInitPath != "" {
+		captions, err = remux.ExtractCaptionsFromFMP4(task.MergeInitPath, segments)
+	} else {
+		captions, err = remux.ExtractCaptionsFromTS(task.Parts)
+	}
+	if err != nil {
+		m.config.Logger.Debug("No closed captions extracted: %v", err)
+		return nil
+	}
+	return captions
+}
+
+// writeCaptions saves each caption channel next to the video in --sub-format.
+func (m *SimpleDownloadManager) writeCaptions(task *streamTask, captions map[string]*entity.WebVttSub) {
+	if len(captions) == 0 {
+		return
+	}
+	format := m.config.MyOptions.SubtitleFormat
+	base := strings.TrimSuffix(task.OutputPath, filepath.Ext(task.OutputPath))
+	task.CaptionPaths = make(map[string]string)
+	for channel, sub := range captions {
+		path := fmt.Sprintf("%s.%s%s", base, channel, entity.SubtitleExt(format))
+		if err := writeSubtitle(sub, format, m.config.MyOptions.AssStyle, path); err != nil {
+			m.config.Logger.Warn("Failed to write %s captions: %v", channel, err)
+			continue
+		}
+		task.CaptionPaths[channel] = path
+		m.config.Logger.Info("Extracted %s closed captions to %s", channel, path)
+		m.savedPaths = append(m.savedPaths, path)
+	}
+}
+
+// writeSubtitle saves sub in --sub-format, styled by --ass-style for ASS.
+func writeSubtitle(sub *entity.WebVttSub, format string, assStyle *entity.AssStyle, output string) error {
+	return os.WriteFile(output, []byte(sub.ToFormat(format, assStyle)), 0644)
+}
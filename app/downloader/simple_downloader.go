@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/crypto"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/utils"
+)
+
+type DownloadResult struct {
+	ActualContentLength int64
+	RespContentLength   int64
+	ActualFilePath      string
+}
+
+// SimpleDownloader downloads and decrypts single segments. It holds no
+// per-segment state, so one instance can be shared by concurrent workers.
+type SimpleDownloader struct {
+	Headers    map[string]string
+	RetryCount int
+	Logger     *log.Logger
+	// Progress receives a "segment_retry" event for every retry, tagged with Stream.
+	Progress *log.ProgressReporter
+	// Stream is the index of the stream this downloader fetches segments for.
+	Stream int
+	// PostProcessor, when set, runs on every segment after HLS decryption and
+	// before it is written, e.g. for real-time MP4 decryption.
+	PostProcessor func(segment *entity.MediaSegment, data []byte) ([]byte, error)
+}
+
+// DownloadSegment saves segment to savePath. An existing file at savePath is
+// treated as already finished, which is what makes resuming a task possible.
+func (d *SimpleDownloader) DownloadSegment(segment *entity.MediaSegment, savePath string) (*DownloadResult, error) {
+	if info, err := os.Stat(savePath); err == nil && info.Size() > 0 {
+		return &DownloadResult{ActualContentLength: info.Size(), ActualFilePath: savePath}, nil
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= d.RetryCount; attempt++ {
+		if attempt > 0 {
+			d.Logger.Warn("Retry %d/%d: %s (%v)", attempt, d.RetryCount, segment.Url, lastErr)
+			d.Progress.Emit("segment_retry", map[string]interface{}{
+				"stream": d.Stream, "index": segment.Index, "url": segment.Url, "attempt": attempt, "error": lastErr.Error(),
+			})
+		}
+		result, err := d.download(segment, savePath)
+		if err == nil {
+			return result, nil
+		}
+		var postErr *postProcessError
+		if errors.As(err, &postErr) {
+			// the same data would fail the same way again
+			return nil, postErr.err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// postProcessError marks a PostProcessor failure, which is not retried.
+type postProcessError struct {
+	err error
+}
+
+func (e *postProcessError) Error() string {
+	return e.err.Error()
+}
+
+func (d *SimpleDownloader) download(segment *entity.MediaSegment, savePath string) (*DownloadResult, error) {
+	req, err := utils.NewRequest(segment.Url, d.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if segment.StartRange != nil {
+		rangeValue := fmt.Sprintf("bytes=%d-", *segment.StartRange)
+		if stop := segment.CalculateStopRange(); stop != nil {
+			rangeValue += fmt.Sprint(*stop)
+		}
+		req.Header.Set("Range", rangeValue)
+	}
+
+	resp, err := utils.AppHttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("GET %s: %s", segment.Url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if segment.ExpectLength != nil && int64(len(data)) != *segment.ExpectLength {
+		return nil, fmt.Errorf("segment %d: expected %d bytes, got %d", segment.Index, *segment.ExpectLength, len(data))
+	}
+
+	respLength := int64(len(data))
+	data, err = crypto.DecryptSegment(data, segment)
+	if err != nil {
+		return nil, err
+	}
+	if d.PostProcessor != nil {
+		if data, err = d.PostProcessor(segment, data); err != nil {
+			return nil, &postProcessError{err}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(savePath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	// Write to a temp file first so an interrupted task never leaves a
+	// half-written segment that a resumed run would mistake for a finished one.
+	tmpPath := savePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, savePath); err != nil {
+		return nil, err
+	}
+
+	return &DownloadResult{
+		ActualContentLength: int64(len(data)),
+		RespContentLength:   respLength,
+		ActualFilePath:      savePath,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MuxOptions is parsed from --mux-after-done, e.g. "format=mkv:muxer=ffmpeg:keep=false".
+// Muxer is "ffmpeg" or "native"; the native muxer only produces mkv.
+type MuxOptions struct {
+	MuxFormat    string
+	Muxer        string
+	BinPath      string
+	KeepFiles    bool
+	SkipSubtitle bool
+}
+
+func NewMuxOptions() *MuxOptions {
+	return &MuxOptions{MuxFormat: "mp4", Muxer: "ffmpeg"}
+}
+
+// ParseMuxOptions reads colon-separated key=value pairs. A bare value is taken as the format.
+func ParseMuxOptions(value string) (*MuxOptions, error) {
+	opts := NewMuxOptions()
+	for _, pair := range strings.Split(value, ":") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			key, val = "format", pair
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "format":
+			opts.MuxFormat = strings.ToLower(val)
+		case "muxer":
+			opts.Muxer = strings.ToLower(val)
+		case "bin_path":
+			opts.BinPath = val
+		case "keep":
+			opts.KeepFiles, err = strconv.ParseBool(val)
+		case "skip_sub":
+			opts.SkipSubtitle, err = strconv.ParseBool(val)
+		default:
+			return nil, fmt.Errorf("unknown mux option: %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", key, val)
+		}
+	}
+	switch opts.MuxFormat {
+	case "mp4", "mkv", "ts":
+	default:
+		return nil, fmt.Errorf("unsupported mux format: %s", opts.MuxFormat)
+	}
+	switch opts.Muxer {
+	case "ffmpeg":
+	case "native":
+		if opts.MuxFormat != "mkv" {
+			return nil, fmt.Errorf("the native muxer only writes mkv, not %s", opts.MuxFormat)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported muxer: %s", opts.Muxer)
+	}
+	return opts, nil
+}
+
+func (m *MuxOptions) String() string {
+	return fmt.Sprintf("format=%s:muxer=%s:keep=%t:skip_sub=%t", m.MuxFormat, m.Muxer, m.KeepFiles, m.SkipSubtitle)
+}
@@ -0,0 +1,15 @@
+package entity
+
+import "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+
+// OutputFile is one merged stream waiting to be muxed.
+type OutputFile struct {
+	Index     int
+	FilePath  string
+	MediaType enums.MediaType
+	LangCode  string
+	Title     string
+	Default   bool
+	Forced    bool
+	Role      *enums.RoleType
+}
@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"time"
+
+	commonentity "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// SubtitleOptions drives the `subtitle` subcommand. Edits are applied in field
+// order: drop, retime, shift, then merge.
+type SubtitleOptions struct {
+	Input  string
+	Output string
+	// Format is SRT, VTT, ASS or TTML.
+	Format string
+	// AssStyle styles ASS output; nil uses commonentity.NewAssStyle().
+	AssStyle *commonentity.AssStyle
+	// Drop holds regexes; matching lines, and cues left empty, are removed.
+	Drop []string
+	// FromFps and ToFps rescale timing when both are set.
+	FromFps float64
+	ToFps   float64
+	Shift   time.Duration
+	// MergeShorter joins cues shorter than this with followers within MergeGap.
+	MergeShorter time.Duration
+	MergeGap     time.Duration
+	AutoFix      bool
+}
@@ -0,0 +1,144 @@
+package remux
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// audioConfig describes an AAC or AC-3 track.
+type audioConfig struct {
+	ac3          bool
+	sampleRate   int
+	channels     int
+	samplesPerFr int
+	// AAC
+	objectType  int
+	freqIndex   int
+	channelConf int
+	// AC-3
+	fscod, bsid, bsmod, acmod, lfeon, bitRateCode int
+}
+
+// splitADTS returns the raw AAC frames in an ADTS stream and fills cfg from the first header.
+func (cfg *audioConfig) splitADTS(data []byte) [][]byte {
+	var frames [][]byte
+	for pos := 0; pos+7 <= len(data); {
+		if data[pos] != 0xff || data[pos+1]&0xf0 != 0xf0 {
+			pos++
+			continue
+		}
+		headerLen := 7
+		if data[pos+1]&0x01 == 0 {
+			headerLen = 9
+		}
+		frameLen := int(data[pos+3]&0x03)<<11 | int(data[pos+4])<<3 | int(data[pos+5]>>5)
+		if frameLen < headerLen || pos+frameLen > len(data) {
+			break
+		}
+		if cfg.sampleRate == 0 {
+			cfg.objectType = int(data[pos+2]>>6) + 1
+			cfg.freqIndex = int(data[pos+2] >> 2 & 0x0f)
+			cfg.channelConf = int(data[pos+2]&0x01)<<2 | int(data[pos+3]>>6)
+			if cfg.freqIndex < len(aacSampleRates) {
+				cfg.sampleRate = aacSampleRates[cfg.freqIndex]
+			}
+			cfg.channels = cfg.channelConf
+			if cfg.channels == 7 {
+				cfg.channels = 8
+			}
+			cfg.samplesPerFr = 1024
+		}
+		frames = append(frames, data[pos+headerLen:pos+frameLen])
+		pos += frameLen
+	}
+	return frames
+}
+
+var ac3SampleRates = []int{48000, 44100, 32000}
+
+// ac3FrameWords is the syncframe size in 16-bit words per frmsizecod, for each fscod.
+var ac3FrameWords = [3][38]int{
+	{64, 64, 80, 80, 96, 96, 112, 112, 128, 128, 160, 160, 192, 192, 224, 224, 256, 256, 320, 320, 384, 384, 448, 448, 512, 512, 640, 640, 768, 768, 896, 896, 1024, 1024, 1152, 1152, 1280, 1280},
+	{69, 70, 87, 88, 104, 105, 121, 122, 139, 140, 174, 175, 208, 209, 243, 244, 278, 279, 348, 349, 417, 418, 487, 488, 557, 558, 696, 697, 835, 836, 975, 976, 1114, 1115, 1253, 1254, 1393, 1394},
+	{96, 96, 120, 120, 144, 144, 168, 168, 192, 192, 240, 240, 288, 288, 336, 336, 384, 384, 480, 480, 576, 576, 672, 672, 768, 768, 960, 960, 1152, 1152, 1344, 1344, 1536, 1536, 1728, 1728, 1920, 1920},
+}
+
+var ac3Channels = []int{2, 1, 2, 3, 3, 4, 4, 5}
+
+// splitAC3 returns the AC-3 syncframes in data and fills cfg from the first one.
+func (cfg *audioConfig) splitAC3(data []byte) [][]byte {
+	var frames [][]byte
+	for pos := 0; pos+7 <= len(data); {
+		if data[pos] != 0x0b || data[pos+1] != 0x77 {
+			pos++
+			continue
+		}
+		fscod := int(data[pos+4] >> 6)
+		frmsizecod := int(data[pos+4] & 0x3f)
+		if fscod > 2 || frmsizecod > 37 {
+			pos++
+			continue
+		}
+		size := ac3FrameWords[fscod][frmsizecod] * 2
+		if pos+size > len(data) {
+			break
+		}
+		if cfg.sampleRate == 0 {
+			r := newBitReader(data[pos+5 : pos+8])
+			cfg.ac3 = true
+			cfg.fscod = fscod
+			cfg.bitRateCode = frmsizecod >> 1
+			cfg.bsid = int(r.readBits(5))
+			cfg.bsmod = int(r.readBits(3))
+			cfg.acmod = int(r.readBits(3))
+			if cfg.acmod&1 != 0 && cfg.acmod != 1 {
+				r.skipBits(2)
+			}
+			if cfg.acmod&4 != 0 {
+				r.skipBits(2)
+			}
+			if cfg.acmod == 2 {
+				r.skipBits(2)
+			}
+			cfg.lfeon = int(r.readBit())
+			cfg.sampleRate = ac3SampleRates[fscod]
+			cfg.channels = ac3Channels[cfg.acmod] + cfg.lfeon
+			cfg.samplesPerFr = 1536
+		}
+		frames = append(frames, data[pos:pos+size])
+		pos += size
+	}
+	return frames
+}
+
+// esds builds the ES descriptor box payload for AAC.
+func (cfg *audioConfig) esds() []byte {
+	asc := []byte{
+		byte(cfg.objectType<<3 | cfg.freqIndex>>1),
+		byte(cfg.freqIndex&1<<7 | cfg.channelConf<<3),
+	}
+	descriptor := func(tag byte, payload []byte) []byte {
+		return append([]byte{tag, 0x80, 0x80, 0x80, byte(len(payload))}, payload...)
+	}
+	var dcd bytes.Buffer
+	dcd.Write([]byte{0x40, 0x15, 0, 0, 0})
+	binary.Write(&dcd, binary.BigEndian, uint32(0))
+	binary.Write(&dcd, binary.BigEndian, uint32(0))
+	dcd.Write(descriptor(0x05, asc))
+
+	var es bytes.Buffer
+	es.Write([]byte{0, 1, 0})
+	es.Write(descriptor(0x04, dcd.Bytes()))
+	es.Write(descriptor(0x06, []byte{0x02}))
+
+	return append([]byte{0, 0, 0, 0}, descriptor(0x03, es.Bytes())...)
+}
+
+// dac3 builds the AC3SpecificBox payload.
+func (cfg *audioConfig) dac3() []byte {
+	v := uint32(cfg.fscod)<<22 | uint32(cfg.bsid)<<17 | uint32(cfg.bsmod)<<14 |
+		uint32(cfg.acmod)<<11 | uint32(cfg.lfeon)<<10 | uint32(cfg.bitRateCode)<<5
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+}
@@ -0,0 +1,76 @@
+package remux
+
+import "errors"
+
+var errBitsExhausted = errors.New("bit reader exhausted")
+
+// bitReader reads MSB-first bits, including Exp-Golomb codes, from an RBSP.
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (r *bitReader) readBit() uint32 {
+	if r.pos >= len(r.data)*8 {
+		r.err = errBitsExhausted
+		return 0
+	}
+	bit := (r.data[r.pos/8] >> (7 - r.pos%8)) & 1
+	r.pos++
+	return uint32(bit)
+}
+
+func (r *bitReader) readBits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.readBit()
+	}
+	return v
+}
+
+func (r *bitReader) skipBits(n int) {
+	r.pos += n
+	if r.pos > len(r.data)*8 {
+		r.err = errBitsExhausted
+	}
+}
+
+func (r *bitReader) readUE() uint32 {
+	zeros := 0
+	for r.readBit() == 0 && r.err == nil && zeros < 32 {
+		zeros++
+	}
+	return (1<<zeros - 1) + r.readBits(zeros)
+}
+
+func (r *bitReader) readSE() int32 {
+	v := r.readUE()
+	if v&1 == 1 {
+		return int32((v + 1) / 2)
+	}
+	return -int32(v / 2)
+}
+
+// unescapeRBSP removes emulation prevention bytes (00 00 03 -> 00 00).
+func unescapeRBSP(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
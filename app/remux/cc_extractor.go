@@ -0,0 +1,297 @@
+package remux
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// ccPacket is the cc_data of one picture, at that picture's presentation time.
+type ccPacket struct {
+	pts      time.Duration
+	triplets []byte
+}
+
+// captionTrack turns snapshots of what is on screen into cues.
+type captionTrack struct {
+	cues    []entity.SubCue
+	current *entity.SubCue
+}
+
+// update records that text is on screen from t on. An unchanged text keeps the current cue.
+func (c *captionTrack) update(text string, t time.Duration) {
+	if c.current != nil {
+		if c.current.Payload == text {
+			return
+		}
+		if t > c.current.StartTime {
+			c.current.EndTime = t
+			c.cues = append(c.cues, *c.current)
+		}
+		c.current = nil
+	}
+	if text != "" {
+		c.current = &entity.SubCue{StartTime: t, Payload: text}
+	}
+}
+
+func (c *captionTrack) finish(t time.Duration) *entity.WebVttSub {
+	c.update("", t)
+	if len(c.cues) == 0 {
+		return nil
+	}
+	return &entity.WebVttSub{Cues: c.cues}
+}
+
+// ExtractCaptionsFromTS decodes the CEA-608 (CC1-CC4) and CEA-708 service 1
+// captions carried in the SEI of H.264/HEVC video. parts are ordered lists of
+// TS segments; as in TSRemuxer, each part continues the timeline where the
+// previous one's video ended, and timestamps within a part are unwrapped
+// across the 33-bit PTS rollover. The result is keyed by channel name and
+// holds only channels with captions.
+func ExtractCaptionsFromTS(parts [][]string) (map[string]*entity.WebVttSub, error) {
+	var packets []ccPacket
+	var partStart time.Duration
+	for _, part := range parts {
+		// The demuxer unwraps PTS and DTS, so first, end and lastDTS are continuous.
+		first, end, lastDTS := noTimestamp, noTimestamp, noTimestamp
+		frameDuration := int64(3003)
+		var partPackets []ccPacket
+		demuxer := NewTSDemuxer(func(pes *PES) {
+			if pes.PTS == noTimestamp || pes.StreamType != StreamTypeH264 && pes.StreamType != StreamTypeH265 {
+				return
+			}
+			if first == noTimestamp || pes.PTS < first {
+				first = pes.PTS
+			}
+			if lastDTS != noTimestamp && pes.DTS > lastDTS {
+				frameDuration = pes.DTS - lastDTS
+			}
+			lastDTS = pes.DTS
+			end = max(end, pes.PTS+frameDuration)
+			hevc := pes.StreamType == StreamTypeH265
+			var triplets []byte
+			for _, nal := range splitAnnexB(pes.Data) {
+				triplets = append(triplets, seiCCData(nal, hevc)...)
+			}
+			if len(triplets) > 0 {
+				partPackets = append(partPackets, ccPacket{pts: time.Duration(pes.PTS), triplets: triplets})
+			}
+		})
+		for _, path := range part {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := demuxer.Feed(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		demuxer.Flush()
+		if first == noTimestamp {
+			continue
+		}
+		for _, p := range partPackets {
+			p.pts = partStart + ticksToDuration(int64(p.pts)-first)
+			packets = append(packets, p)
+		}
+		partStart += ticksToDuration(end - first)
+	}
+	return decodeCaptions(packets), nil
+}
+
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / 90000
+}
+
+// ExtractCaptionsFromFMP4 is ExtractCaptionsFromTS for fMP4 video fragments.
+func ExtractCaptionsFromFMP4(init string, segments []string) (map[string]*entity.WebVttSub, error) {
+	initData, err := os.ReadFile(init)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := mp4.NewFragmentReader(initData, func(t *mp4.TrackInfo) bool {
+		switch t.Format {
+		case "avc1", "avc3", "hvc1", "hev1":
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no H.264/HEVC track in init segment")
+	}
+	track := reader.Track
+	hevc := track.Format == "hvc1" || track.Format == "hev1"
+	lengthSize := 4
+	if !hevc && len(track.Config) > 4 {
+		lengthSize = int(track.Config[4]&3) + 1
+	} else if hevc && len(track.Config) > 21 {
+		lengthSize = int(track.Config[21]&3) + 1
+	}
+
+	var packets []ccPacket
+	first := int64(-1)
+	for _, path := range segments {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		samples, err := reader.Samples(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, sample := range samples {
+			pts := sample.DTS + sample.CTS
+			if first < 0 || pts < first {
+				first = pts
+			}
+			var triplets []byte
+			for _, nal := range splitLengthPrefixed(data[sample.Offset:sample.Offset+int64(sample.Size)], lengthSize) {
+				triplets = append(triplets, seiCCData(nal, hevc)...)
+			}
+			if len(triplets) > 0 {
+				packets = append(packets, ccPacket{pts: time.Duration(pts), triplets: triplets})
+			}
+		}
+	}
+	for i := range packets {
+		packets[i].pts = mp4.TicksToDuration(int64(packets[i].pts)-first, track.Timescale)
+	}
+	return decodeCaptions(packets), nil
+}
+
+func splitLengthPrefixed(data []byte, lengthSize int) [][]byte {
+	var nals [][]byte
+	for pos := 0; pos+lengthSize <= len(data); {
+		size := 0
+		for i := 0; i < lengthSize; i++ {
+			size = size<<8 | int(data[pos+i])
+		}
+		pos += lengthSize
+		if size <= 0 || pos+size > len(data) {
+			break
+		}
+		nals = append(nals, data[pos:pos+size])
+		pos += size
+	}
+	return nals
+}
+
+// seiCCData returns the cc_data triplets of ATSC A/53 user data in an SEI NAL unit.
+func seiCCData(nal []byte, hevc bool) []byte {
+	header := 1
+	if hevc {
+		if len(nal) < 2 || (nal[0]>>1)&0x3F != 39 && (nal[0]>>1)&0x3F != 40 {
+			return nil
+		}
+		header = 2
+	} else if len(nal) < 1 || nal[0]&0x1F != 6 {
+		return nil
+	}
+	rbsp := unescapeRBSP(nal[header:])
+
+	var triplets []byte
+	for pos := 0; pos < len(rbsp) && rbsp[pos] != 0x80; {
+		payloadType, payloadSize := 0, 0
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			payloadType += 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			break
+		}
+		payloadType += int(rbsp[pos])
+		pos++
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			payloadSize += 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			break
+		}
+		payloadSize += int(rbsp[pos])
+		pos++
+		if pos+payloadSize > len(rbsp) {
+			break
+		}
+		if payloadType == 4 {
+			triplets = append(triplets, a53CCData(rbsp[pos:pos+payloadSize])...)
+		}
+		pos += payloadSize
+	}
+	return triplets
+}
+
+// a53CCData reads user_data_registered_itu_t_t35 carrying GA94 cc_data.
+func a53CCData(p []byte) []byte {
+	if len(p) < 10 || p[0] != 0xB5 || p[1] != 0x00 || p[2] != 0x31 || string(p[3:7]) != "GA94" || p[7] != 0x03 {
+		return nil
+	}
+	if p[8]&0x40 == 0 {
+		return nil
+	}
+	count := int(p[8] & 0x1F)
+	data := p[10:]
+	if len(data) < count*3 {
+		count = len(data) / 3
+	}
+	return data[:count*3]
+}
+
+// decodeCaptions runs the cc_data through the 608 and 708 decoders in presentation order.
+func decodeCaptions(packets []ccPacket) map[string]*entity.WebVttSub {
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].pts < packets[j].pts })
+	d608 := newCEA608Decoder()
+	d708 := newCEA708Decoder()
+	var last time.Duration
+	for _, p := range packets {
+		last = p.pts
+		for i := 0; i+3 <= len(p.triplets); i += 3 {
+			flags, b1, b2 := p.triplets[i], p.triplets[i+1], p.triplets[i+2]
+			valid := flags&0x04 != 0
+			switch ccType := flags & 0x03; {
+			case ccType <= 1 && valid:
+				d608.decode(int(ccType), b1, b2, p.pts)
+			case ccType == 3:
+				d708.startPacket(p.pts)
+				if valid {
+					d708.add(b1, b2)
+				}
+			case ccType == 2 && valid:
+				d708.add(b1, b2)
+			}
+		}
+	}
+	// Keep the last caption on screen for a moment past the final picture.
+	end := last + 2*time.Second
+	d708.startPacket(end)
+
+	result := make(map[string]*entity.WebVttSub)
+	for i, ch := range d608.channels {
+		ch.checkpoint(end)
+		if sub := ch.track.finish(end); sub != nil {
+			result[fmt.Sprintf("CC%d", i+1)] = sub
+		}
+	}
+	d708.service.checkpoint(end)
+	if sub := d708.service.track.finish(end); sub != nil {
+		result["SERVICE1"] = sub
+	}
+	return result
+}
+
+// screenText joins non-empty rows, trimming the padding around them.
+func screenText(rows []string) string {
+	var lines []string
+	for _, row := range rows {
+		if row = strings.TrimSpace(row); row != "" {
+			lines = append(lines, row)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
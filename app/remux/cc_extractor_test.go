@@ -0,0 +1,150 @@
+package remux
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// tsStream is one elementary stream announced in the PMT written by tsWriter.
+type tsStream struct {
+	pid        uint16
+	streamType byte
+}
+
+// tsWriter builds MPEG-TS segments: a PAT, a PMT on PID 0x1000 and PES packets.
+type tsWriter struct {
+	data       []byte
+	continuity map[uint16]byte
+}
+
+func newTSWriter(streams ...tsStream) *tsWriter {
+	w := &tsWriter{continuity: make(map[uint16]byte)}
+	pat := []byte{0x00, 0xB0, 13, 0, 1, 0xC1, 0, 0, 0, 1, 0xF0, 0x00, 0, 0, 0, 0}
+	w.packets(0, append([]byte{0}, pat...))
+	pmt := []byte{0x02, 0xB0, 0, 0, 1, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0x00}
+	for _, s := range streams {
+		pmt = append(pmt, s.streamType, 0xE0|byte(s.pid>>8), byte(s.pid), 0xF0, 0x00)
+	}
+	pmt = append(pmt, 0, 0, 0, 0) // CRC32, not checked
+	pmt[2] = byte(len(pmt) - 3)
+	w.packets(0x1000, append([]byte{0}, pmt...))
+	return w
+}
+
+// packets splits payload into TS packets, padding the last one with adaptation field stuffing.
+func (w *tsWriter) packets(pid uint16, payload []byte) {
+	for first := true; first || len(payload) > 0; first = false {
+		packet := make([]byte, 4, tsPacketSize)
+		packet[0] = 0x47
+		binary.BigEndian.PutUint16(packet[1:], pid)
+		if first {
+			packet[1] |= 0x40
+		}
+		packet[3] = 0x10 | w.continuity[pid]&0x0F
+		w.continuity[pid]++
+		n := min(len(payload), tsPacketSize-4)
+		if stuffing := tsPacketSize - 4 - n; stuffing > 0 {
+			packet[3] |= 0x20
+			packet = append(packet, byte(stuffing-1))
+			if stuffing > 1 {
+				packet = append(packet, 0x00)
+				for i := 2; i < stuffing; i++ {
+					packet = append(packet, 0xFF)
+				}
+			}
+		}
+		packet = append(packet, payload[:n]...)
+		payload = payload[n:]
+		w.data = append(w.data, packet...)
+	}
+}
+
+// pes writes one PES packet with a PTS and DTS in 90kHz ticks; both are wrapped to 33 bits.
+func (w *tsWriter) pes(pid uint16, streamID byte, pts, dts int64, data []byte) {
+	header := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0xC0, 10}
+	header = append(header, tsTimestamp(0x3, pts)...)
+	header = append(header, tsTimestamp(0x1, dts)...)
+	if length := len(header) - 6 + len(data); streamID != 0xE0 && length <= 0xFFFF {
+		binary.BigEndian.PutUint16(header[4:], uint16(length))
+	}
+	w.packets(pid, append(header, data...))
+}
+
+func tsTimestamp(prefix byte, ts int64) []byte {
+	ts %= ptsWrap
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0E | 1,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 1,
+		byte(ts >> 7),
+		byte(ts<<1) | 1,
+	}
+}
+
+func (w *tsWriter) save(t *testing.T, path string) string {
+	t.Helper()
+	if err := os.WriteFile(path, w.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ccSEI returns an Annex B H.264 SEI NAL unit carrying the triplets as A/53 cc_data.
+func ccSEI(triplets []byte) []byte {
+	payload := append([]byte{0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0x40 | byte(len(triplets)/3), 0xFF}, triplets...)
+	nal := append([]byte{0, 0, 0, 1, 0x06, 4, byte(len(payload))}, payload...)
+	return append(nal, 0x80)
+}
+
+func TestExtractCaptionsFromTS(t *testing.T) {
+	dir := t.TempDir()
+	const frame = 45000 // half a second
+	// writeVideo writes one picture per entry, starting at pts; frames without captions get none.
+	writeVideo := func(name string, pts int64, pictures ...[][2]byte) string {
+		w := newTSWriter(tsStream{0x100, StreamTypeH264})
+		for i, pairs := range pictures {
+			data := []byte{0, 0, 0, 1, 0x09, 0xF0} // access unit delimiter
+			if len(pairs) > 0 {
+				data = append(data, ccSEI(cc608(0, 0, pairs...).triplets)...)
+			}
+			ts := pts + int64(i)*frame
+			w.pes(0x100, 0xE0, ts, ts, data)
+		}
+		return w.save(t, filepath.Join(dir, name))
+	}
+
+	parts := [][]string{
+		{
+			// the PTS rolls over to zero on the third picture
+			writeVideo("a0.ts", ptsWrap-2*frame, ccSeq(ccRCL, ccRow15, "Hi"), ccSeq(ccEOC)),
+			writeVideo("a1.ts", 0, nil, ccSeq(ccEDM)),
+		},
+		{
+			// a new part restarts the timestamps
+			writeVideo("b0.ts", 900000, ccSeq(ccRDC, ccRow15, "Yo"), ccSeq(ccEDM)),
+		},
+	}
+	subs, err := ExtractCaptionsFromTS(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	want := []entity.SubCue{
+		{StartTime: sec(0.5), EndTime: sec(1.5), Payload: "Hi"},
+		// the first part's video ends one frame after its last picture
+		{StartTime: sec(2), EndTime: sec(2.5), Payload: "Yo"},
+	}
+	var got []entity.SubCue
+	if sub := subs["CC1"]; sub != nil {
+		got = sub.Cues
+	}
+	if !reflect.DeepEqual(got, want) || len(subs) != 1 {
+		t.Errorf("got %+v (%d channels), want %+v", got, len(subs), want)
+	}
+}
@@ -0,0 +1,264 @@
+package remux
+
+import "time"
+
+// CEA-608 caption modes.
+const (
+	cc608PopOn = iota
+	cc608RollUp
+	cc608PaintOn
+	cc608Text // text service data, ignored
+)
+
+const (
+	cc608Rows    = 15
+	cc608Columns = 32
+)
+
+// pacRows maps the first byte of a preamble address code (channel bit
+// cleared) to its two rows, picked by bit 0x20 of the second byte.
+var pacRows = map[byte][2]int{
+	0x11: {0, 1}, 0x12: {2, 3}, 0x15: {4, 5}, 0x16: {6, 7}, 0x17: {8, 9},
+	0x10: {10, 10}, 0x13: {11, 12}, 0x14: {13, 14},
+}
+
+// Characters that differ from ASCII in the basic 608 set.
+var cc608Basic = map[byte]rune{
+	0x2A: 'á', 0x5C: 'é', 0x5E: 'í', 0x5F: 'ó', 0x60: 'ú',
+	0x7B: 'ç', 0x7C: '÷', 0x7D: 'Ñ', 0x7E: 'ñ', 0x7F: '█',
+}
+
+// Special characters, second byte 0x30-0x3F after 0x11.
+var cc608Special = []rune("®°½¿™¢£♪à èâêîôû")
+
+// Extended characters, second byte 0x20-0x3F after 0x12 (Spanish/French) and 0x13 (Portuguese/German/Danish).
+var cc608Extended = map[byte][]rune{
+	0x12: []rune("ÁÉÓÚÜü‘¡*'—©℠•“”ÀÂÇÈÊËëÎÏïÔÙùÛ«»"),
+	0x13: []rune("ÃãÍÌìÒòÕõ{}\\^_|~ÄäÖöß¥¤│ÅåØø┌┐└┘"),
+}
+
+// cea608Channel is one data channel (CC1-CC4) with its two caption memories.
+type cea608Channel struct {
+	mode         int
+	displayed    [cc608Rows][cc608Columns]rune
+	nonDisplayed [cc608Rows][cc608Columns]rune
+	row, col     int
+	rollRows     int
+	track        captionTrack
+	// dirty is set when text was written straight to the screen; dirtySince
+	// is when the first of it appeared.
+	dirty      bool
+	dirtySince time.Duration
+}
+
+type cea608Decoder struct {
+	channels [4]*cea608Channel
+	active   [2]int
+	lastCtrl [2][2]byte
+}
+
+func newCEA608Decoder() *cea608Decoder {
+	d := &cea608Decoder{active: [2]int{0, 2}}
+	for i := range d.channels {
+		d.channels[i] = &cea608Channel{row: cc608Rows - 1, rollRows: 2}
+	}
+	return d
+}
+
+// decode handles one byte pair of field 0 (CC1/CC2) or field 1 (CC3/CC4).
+func (d *cea608Decoder) decode(field int, b1, b2 byte, t time.Duration) {
+	b1, b2 = b1&0x7F, b2&0x7F
+	if b1 == 0 && b2 == 0 {
+		return
+	}
+	if b1 >= 0x10 && b1 <= 0x1F {
+		// Control codes are sent twice; act on the first only.
+		if d.lastCtrl[field] == [2]byte{b1, b2} {
+			d.lastCtrl[field] = [2]byte{}
+			return
+		}
+		d.lastCtrl[field] = [2]byte{b1, b2}
+		channel := field * 2
+		if b1&0x08 != 0 {
+			channel++
+		}
+		d.active[field] = channel
+		d.channels[channel].control(b1&^0x08, b2, t)
+		return
+	}
+	d.lastCtrl[field] = [2]byte{}
+	if b1 < 0x10 {
+		// XDS data on field 2
+		return
+	}
+	ch := d.channels[d.active[field]]
+	ch.writeChar(cc608Char(b1), t)
+	if b2 >= 0x20 {
+		ch.writeChar(cc608Char(b2), t)
+	}
+}
+
+func cc608Char(b byte) rune {
+	if r, ok := cc608Basic[b]; ok {
+		return r
+	}
+	return rune(b)
+}
+
+func (c *cea608Channel) target() *[cc608Rows][cc608Columns]rune {
+	if c.mode == cc608PopOn {
+		return &c.nonDisplayed
+	}
+	return &c.displayed
+}
+
+func (c *cea608Channel) writeChar(r rune, t time.Duration) {
+	if c.mode == cc608Text {
+		return
+	}
+	target := c.target()
+	if c.col >= cc608Columns {
+		c.col = cc608Columns - 1
+	}
+	target[c.row][c.col] = r
+	c.col++
+	c.touch(t)
+}
+
+// touch notes a change to the displayed memory.
+func (c *cea608Channel) touch(t time.Duration) {
+	if c.mode == cc608PopOn || c.dirty {
+		return
+	}
+	c.dirty = true
+	c.dirtySince = t
+}
+
+// checkpoint publishes what is on screen. Text typed straight to the screen
+// is dated from when it started to appear.
+func (c *cea608Channel) checkpoint(t time.Duration) {
+	if c.dirty {
+		t = c.dirtySince
+		c.dirty = false
+	}
+	c.track.update(c.screen(), t)
+}
+
+func (c *cea608Channel) screen() string {
+	rows := make([]string, 0, cc608Rows)
+	for _, row := range c.displayed {
+		var line []rune
+		for _, r := range row {
+			if r == 0 {
+				r = ' '
+			}
+			line = append(line, r)
+		}
+		rows = append(rows, string(line))
+	}
+	return screenText(rows)
+}
+
+func (c *cea608Channel) control(b1, b2 byte, t time.Duration) {
+	switch {
+	case (b1 == 0x14 || b1 == 0x15) && b2 >= 0x20 && b2 <= 0x2F:
+		c.command(b2, t)
+	case b1 == 0x17 && b2 >= 0x21 && b2 <= 0x23:
+		c.col = min(c.col+int(b2-0x20), cc608Columns-1)
+	case b1 == 0x11 && b2 >= 0x20 && b2 <= 0x2F:
+		// mid-row style change, shown as a space
+		c.writeChar(' ', t)
+	case b1 == 0x11 && b2 >= 0x30 && b2 <= 0x3F:
+		c.writeChar(cc608Special[b2-0x30], t)
+	case (b1 == 0x12 || b1 == 0x13) && b2 >= 0x20 && b2 <= 0x3F:
+		// Extended characters replace the standard fallback sent before them.
+		if c.col > 0 {
+			c.col--
+		}
+		c.writeChar(cc608Extended[b1][b2-0x20], t)
+	case b2 >= 0x40 && b2 <= 0x7F:
+		c.preamble(b1, b2)
+	}
+}
+
+// preamble moves the cursor to a preamble address code's row and indent.
+func (c *cea608Channel) preamble(b1, b2 byte) {
+	rows, ok := pacRows[b1&0x17]
+	if !ok {
+		return
+	}
+	row := rows[0]
+	if b2&0x20 != 0 {
+		row = rows[1]
+	}
+	if c.mode == cc608RollUp && row != c.row {
+		// The roll-up window moves with its base row.
+		var moved [cc608Rows][cc608Columns]rune
+		for i := 0; i < c.rollRows; i++ {
+			from, to := c.row-i, row-i
+			if from >= 0 && to >= 0 {
+				moved[to] = c.displayed[from]
+			}
+		}
+		c.displayed = moved
+	}
+	c.row = row
+	c.col = 0
+	if b2&0x10 != 0 {
+		c.col = int((b2&0x0E)>>1) * 4
+	}
+}
+
+func (c *cea608Channel) command(code byte, t time.Duration) {
+	switch code {
+	case 0x20: // RCL
+		c.mode = cc608PopOn
+	case 0x21: // BS
+		if c.col > 0 {
+			c.col--
+			c.target()[c.row][c.col] = 0
+			c.touch(t)
+		}
+	case 0x24: // DER
+		for i := c.col; i < cc608Columns; i++ {
+			c.target()[c.row][i] = 0
+		}
+		c.touch(t)
+	case 0x25, 0x26, 0x27: // RU2-RU4
+		if c.mode != cc608RollUp {
+			c.checkpoint(t)
+			c.displayed = [cc608Rows][cc608Columns]rune{}
+			c.nonDisplayed = [cc608Rows][cc608Columns]rune{}
+			c.track.update("", t)
+		}
+		c.mode = cc608RollUp
+		c.rollRows = int(code-0x25) + 2
+		c.col = 0
+	case 0x29: // RDC
+		c.mode = cc608PaintOn
+	case 0x2A, 0x2B: // TR, RTD
+		c.mode = cc608Text
+	case 0x2C: // EDM
+		c.checkpoint(t)
+		c.displayed = [cc608Rows][cc608Columns]rune{}
+		c.track.update("", t)
+	case 0x2D: // CR, only meaningful in roll-up
+		if c.mode != cc608RollUp {
+			return
+		}
+		c.checkpoint(t)
+		for r := max(c.row-c.rollRows+1, 0); r < c.row; r++ {
+			c.displayed[r] = c.displayed[r+1]
+		}
+		c.displayed[c.row] = [cc608Columns]rune{}
+		c.col = 0
+		c.checkpoint(t)
+	case 0x2E: // ENM
+		c.nonDisplayed = [cc608Rows][cc608Columns]rune{}
+	case 0x2F: // EOC
+		c.checkpoint(t)
+		c.displayed, c.nonDisplayed = c.nonDisplayed, c.displayed
+		c.mode = cc608PopOn
+		c.track.update(c.screen(), t)
+	}
+}
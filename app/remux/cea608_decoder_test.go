@@ -0,0 +1,195 @@
+package remux
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// cc608 builds the cc_data of one picture for a 608 field. Control codes are
+// sent twice, the way encoders send them; text pairs once.
+func cc608(seconds float64, field byte, pairs ...[2]byte) ccPacket {
+	var triplets []byte
+	for _, p := range pairs {
+		n := 1
+		if p[0] >= 0x10 && p[0] <= 0x1F {
+			n = 2
+		}
+		for i := 0; i < n; i++ {
+			triplets = append(triplets, 0xFC|field, p[0], p[1])
+		}
+	}
+	return ccPacket{pts: time.Duration(seconds * float64(time.Second)), triplets: triplets}
+}
+
+var (
+	ccRCL     = [2]byte{0x14, 0x20}
+	ccBS      = [2]byte{0x14, 0x21}
+	ccRU2     = [2]byte{0x14, 0x25}
+	ccRDC     = [2]byte{0x14, 0x29}
+	ccEDM     = [2]byte{0x14, 0x2C}
+	ccCR      = [2]byte{0x14, 0x2D}
+	ccENM     = [2]byte{0x14, 0x2E}
+	ccEOC     = [2]byte{0x14, 0x2F}
+	ccRow15   = [2]byte{0x14, 0x60} // preamble: row 15, column 0
+	ccRow14   = [2]byte{0x14, 0x40} // preamble: row 14, column 0
+	ccIndent8 = [2]byte{0x14, 0x74} // preamble: row 15, column 8
+)
+
+func ccText(s string) [][2]byte {
+	var pairs [][2]byte
+	for i := 0; i < len(s); i += 2 {
+		p := [2]byte{s[i], 0}
+		if i+1 < len(s) {
+			p[1] = s[i+1]
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+func ccSeq(pairs ...any) [][2]byte {
+	var out [][2]byte
+	for _, p := range pairs {
+		switch p := p.(type) {
+		case [2]byte:
+			out = append(out, p)
+		case string:
+			out = append(out, ccText(p)...)
+		}
+	}
+	return out
+}
+
+func TestDecodeCaptions608(t *testing.T) {
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	tests := []struct {
+		name    string
+		packets []ccPacket
+		want    map[string][]entity.SubCue
+	}{
+		{
+			"pop-on",
+			[]ccPacket{
+				cc608(0, 0, ccSeq(ccRCL, ccENM, ccRow14, "Hello", ccRow15, "world")...),
+				cc608(1, 0, ccEOC),
+				cc608(3, 0, ccEDM),
+			},
+			map[string][]entity.SubCue{"CC1": {{StartTime: sec(1), EndTime: sec(3), Payload: "Hello\nworld"}}},
+		},
+		{
+			"pop-on replaced by the next caption",
+			[]ccPacket{
+				cc608(0, 0, ccSeq(ccRCL, ccRow15, "One")...),
+				cc608(1, 0, ccEOC),
+				cc608(1.5, 0, ccSeq(ccRCL, ccENM, ccRow15, "Two")...),
+				cc608(2, 0, ccEOC),
+			},
+			map[string][]entity.SubCue{"CC1": {
+				{StartTime: sec(1), EndTime: sec(2), Payload: "One"},
+				// the last caption stays up for two seconds past the final picture
+				{StartTime: sec(2), EndTime: sec(4), Payload: "Two"},
+			}},
+		},
+		{
+			"roll-up",
+			[]ccPacket{
+				cc608(0, 0, ccRU2, ccRow15),
+				cc608(1, 0, ccText("AB")...),
+				cc608(2, 0, ccCR),
+				cc608(3, 0, ccText("CD")...),
+			},
+			map[string][]entity.SubCue{"CC1": {
+				{StartTime: sec(1), EndTime: sec(3), Payload: "AB"},
+				{StartTime: sec(3), EndTime: sec(5), Payload: "AB\nCD"},
+			}},
+		},
+		{
+			"paint-on",
+			[]ccPacket{
+				cc608(0, 0, ccRDC, ccRow15),
+				cc608(1, 0, ccText("Hi")...),
+				cc608(2, 0, ccEDM),
+			},
+			map[string][]entity.SubCue{"CC1": {{StartTime: sec(1), EndTime: sec(2), Payload: "Hi"}}},
+		},
+		{
+			"doubled control codes act once",
+			[]ccPacket{
+				cc608(0, 0, ccSeq(ccRCL, ccRow15, "Hello", ccBS, "p!")...),
+				cc608(1, 0, ccEOC),
+				cc608(2, 0, ccEDM),
+			},
+			map[string][]entity.SubCue{"CC1": {{StartTime: sec(1), EndTime: sec(2), Payload: "Hellp!"}}},
+		},
+		{
+			"indent and special characters",
+			[]ccPacket{
+				// 0x5C is é in the basic set; 0x11 0x37 is ♪; 0x12 0x21 replaces the E before it with É
+				cc608(0, 0, ccSeq(ccRCL, ccIndent8, "Caf\\", [2]byte{0x11, 0x37}, "E", [2]byte{0x12, 0x21})...),
+				cc608(1, 0, ccEOC),
+				cc608(2, 0, ccEDM),
+			},
+			map[string][]entity.SubCue{"CC1": {{StartTime: sec(1), EndTime: sec(2), Payload: "Café♪É"}}},
+		},
+		{
+			"channels",
+			[]ccPacket{
+				// 0x1C is the CC2 form of 0x14; field 1 carries CC3
+				cc608(0, 0, ccSeq([2]byte{0x1C, 0x29}, [2]byte{0x1C, 0x60}, "two")...),
+				cc608(0, 1, ccSeq(ccRDC, ccRow15, "three")...),
+				cc608(1, 0, [2]byte{0x1C, 0x2C}),
+				cc608(1, 1, ccEDM),
+			},
+			map[string][]entity.SubCue{
+				"CC2": {{StartTime: sec(0), EndTime: sec(1), Payload: "two"}},
+				"CC3": {{StartTime: sec(0), EndTime: sec(1), Payload: "three"}},
+			},
+		},
+		{
+			"invalid and padding pairs are skipped",
+			[]ccPacket{
+				cc608(0, 0, ccSeq(ccRDC, ccRow15, "ok")...),
+				{pts: sec(0.5), triplets: []byte{0xF8, 'n', 'o', 0xFC, 0x80, 0x80}},
+				cc608(1, 0, ccEDM),
+			},
+			map[string][]entity.SubCue{"CC1": {{StartTime: sec(0), EndTime: sec(1), Payload: "ok"}}},
+		},
+	}
+	for _, tt := range tests {
+		subs := decodeCaptions(tt.packets)
+		got := make(map[string][]entity.SubCue)
+		for name, sub := range subs {
+			got[name] = sub.Cues
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSEICCData(t *testing.T) {
+	triplets := []byte{0xFC, 0x94, 0x20, 0xFC, 0x94, 0x20}
+	payload := append([]byte{0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0x40 | 2, 0xFF}, triplets...)
+	h264 := append([]byte{0x06, 4, byte(len(payload))}, payload...)
+	h264 = append(h264, 0x80)
+	tests := []struct {
+		name string
+		nal  []byte
+		hevc bool
+		want []byte
+	}{
+		{"H.264", h264, false, triplets},
+		{"HEVC prefix SEI", append([]byte{39 << 1, 1}, h264[1:]...), true, triplets},
+		{"not an SEI", append([]byte{0x05}, h264[1:]...), false, nil},
+		{"truncated payload", h264[:8], false, nil},
+		{"other user data", append([]byte{0x06, 4, 4}, 0xB5, 0, 0x2F, 0), false, nil},
+	}
+	for _, tt := range tests {
+		if got := seiCCData(tt.nal, tt.hevc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package remux
+
+import "time"
+
+// G2 characters reachable through EXT1 that have a printable equivalent.
+var cc708G2 = map[byte]rune{
+	0x20: ' ', 0x21: ' ', 0x25: '…', 0x2A: 'Š', 0x2C: 'Œ', 0x30: '█',
+	0x31: '‘', 0x32: '’', 0x33: '“', 0x34: '”', 0x35: '•', 0x39: '™',
+	0x3A: 'š', 0x3C: 'œ', 0x3D: '℠', 0x3F: 'Ÿ',
+}
+
+type cea708Window struct {
+	defined bool
+	visible bool
+	rows    []string
+	row     int
+}
+
+func (w *cea708Window) clear() {
+	w.rows = []string{""}
+	w.row = 0
+}
+
+func (w *cea708Window) ensureRow() {
+	for len(w.rows) <= w.row {
+		w.rows = append(w.rows, "")
+	}
+}
+
+func (w *cea708Window) write(r rune) {
+	w.ensureRow()
+	w.rows[w.row] += string(r)
+}
+
+// cea708Service decodes the text and window commands of one caption service.
+// Pen and window styling is skipped; only what is visible matters.
+type cea708Service struct {
+	windows    [8]cea708Window
+	current    int
+	track      captionTrack
+	dirty      bool
+	dirtySince time.Duration
+}
+
+// cea708Decoder reassembles DTVCC packets and feeds service 1 blocks to its decoder.
+type cea708Decoder struct {
+	packet  []byte
+	time    time.Duration
+	service *cea708Service
+}
+
+func newCEA708Decoder() *cea708Decoder {
+	s := &cea708Service{}
+	for i := range s.windows {
+		s.windows[i].clear()
+	}
+	return &cea708Decoder{service: s}
+}
+
+// startPacket processes the packet being assembled and starts the next one at t.
+func (d *cea708Decoder) startPacket(t time.Duration) {
+	d.process()
+	d.packet = d.packet[:0]
+	d.time = t
+}
+
+func (d *cea708Decoder) add(b1, b2 byte) {
+	d.packet = append(d.packet, b1, b2)
+	if size := d.packetSize(); size > 0 && len(d.packet) >= size {
+		d.process()
+		d.packet = d.packet[:0]
+	}
+}
+
+func (d *cea708Decoder) packetSize() int {
+	if len(d.packet) == 0 {
+		return 0
+	}
+	if code := int(d.packet[0] & 0x3F); code != 0 {
+		return code * 2
+	}
+	return 128
+}
+
+func (d *cea708Decoder) process() {
+	if len(d.packet) < 2 {
+		return
+	}
+	data := d.packet[1:min(len(d.packet), d.packetSize())]
+	for pos := 0; pos < len(data); {
+		service := int(data[pos] >> 5)
+		size := int(data[pos] & 0x1F)
+		pos++
+		if service == 7 && pos < len(data) {
+			service = int(data[pos] & 0x3F)
+			pos++
+		}
+		if service == 0 || pos+size > len(data) {
+			break
+		}
+		if service == 1 {
+			d.service.decode(data[pos:pos+size], d.time)
+		}
+		pos += size
+	}
+}
+
+func (s *cea708Service) window() *cea708Window {
+	return &s.windows[s.current]
+}
+
+func (s *cea708Service) text(r rune, t time.Duration) {
+	w := s.window()
+	w.write(r)
+	if w.visible && !s.dirty {
+		s.dirty = true
+		s.dirtySince = t
+	}
+}
+
+func (s *cea708Service) checkpoint(t time.Duration) {
+	if s.dirty {
+		t = s.dirtySince
+		s.dirty = false
+	}
+	var rows []string
+	for _, w := range s.windows {
+		if w.visible {
+			rows = append(rows, w.rows...)
+		}
+	}
+	s.track.update(screenText(rows), t)
+}
+
+// forWindows applies fn to every window set in a window bitmap.
+func (s *cea708Service) forWindows(bitmap byte, fn func(w *cea708Window)) {
+	for i := range s.windows {
+		if bitmap&(1<<i) != 0 {
+			fn(&s.windows[i])
+		}
+	}
+}
+
+func (s *cea708Service) decode(data []byte, t time.Duration) {
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		param := func(n int) []byte {
+			if i+n >= len(data) {
+				i = len(data)
+				return make([]byte, n)
+			}
+			p := data[i+1 : i+1+n]
+			i += n
+			return p
+		}
+		switch {
+		case c == 0x08: // BS
+			w := s.window()
+			if w.row < len(w.rows) && w.rows[w.row] != "" {
+				r := []rune(w.rows[w.row])
+				w.rows[w.row] = string(r[:len(r)-1])
+			}
+		case c == 0x0C: // FF
+			s.checkpoint(t)
+			s.window().clear()
+			s.checkpoint(t)
+		case c == 0x0D: // CR
+			s.checkpoint(t)
+			w := s.window()
+			w.row++
+			w.ensureRow()
+			w.rows[w.row] = ""
+		case c == 0x0E: // HCR
+			w := s.window()
+			if w.row < len(w.rows) {
+				w.rows[w.row] = ""
+			}
+		case c == 0x10: // EXT1
+			ext := param(1)[0]
+			switch {
+			case ext < 0x08:
+			case ext < 0x10:
+				param(1)
+			case ext < 0x18:
+				param(2)
+			case ext < 0x20:
+				param(3)
+			case ext < 0x80:
+				if r, ok := cc708G2[ext]; ok {
+					s.text(r, t)
+				}
+			case ext < 0x88:
+				param(4)
+			case ext < 0x90:
+				param(5)
+			case ext < 0xA0:
+				n := int(param(1)[0] & 0x3F)
+				param(n)
+			}
+		case c >= 0x11 && c <= 0x17:
+			param(1)
+		case c >= 0x18 && c <= 0x1F:
+			param(2)
+		case c < 0x20:
+		case c < 0x7F:
+			s.text(rune(c), t)
+		case c == 0x7F:
+			s.text('♪', t)
+		case c <= 0x87: // CWx
+			s.current = int(c & 0x07)
+		case c == 0x88: // CLW
+			s.checkpoint(t)
+			s.forWindows(param(1)[0], (*cea708Window).clear)
+			s.checkpoint(t)
+		case c == 0x89: // DSW
+			s.checkpoint(t)
+			s.forWindows(param(1)[0], func(w *cea708Window) { w.visible = true })
+			s.checkpoint(t)
+		case c == 0x8A: // HDW
+			s.checkpoint(t)
+			s.forWindows(param(1)[0], func(w *cea708Window) { w.visible = false })
+			s.checkpoint(t)
+		case c == 0x8B: // TGW
+			s.checkpoint(t)
+			s.forWindows(param(1)[0], func(w *cea708Window) { w.visible = !w.visible })
+			s.checkpoint(t)
+		case c == 0x8C: // DLW
+			s.checkpoint(t)
+			s.forWindows(param(1)[0], func(w *cea708Window) {
+				w.clear()
+				w.defined, w.visible = false, false
+			})
+			s.checkpoint(t)
+		case c == 0x8D: // DLY
+			param(1)
+		case c == 0x8F: // RST
+			s.checkpoint(t)
+			for w := range s.windows {
+				s.windows[w] = cea708Window{}
+				s.windows[w].clear()
+			}
+			s.checkpoint(t)
+		case c == 0x90: // SPA
+			param(2)
+		case c == 0x91: // SPC
+			param(3)
+		case c == 0x92: // SPL
+			p := param(2)
+			w := s.window()
+			w.row = int(p[0] & 0x0F)
+			w.ensureRow()
+		case c == 0x97: // SWA
+			param(4)
+		case c >= 0x98 && c <= 0x9F: // DFx
+			p := param(6)
+			s.checkpoint(t)
+			s.current = int(c & 0x07)
+			w := s.window()
+			if !w.defined {
+				w.clear()
+				w.defined = true
+			}
+			w.visible = p[0]&0x20 != 0
+			s.checkpoint(t)
+		case c >= 0xA0:
+			s.text(rune(c), t)
+		}
+	}
+}
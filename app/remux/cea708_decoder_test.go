@@ -0,0 +1,112 @@
+package remux
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// cc708 builds the cc_data of one picture carrying a DTVCC packet with one
+// service block per entry of blocks, keyed by service number.
+func cc708(seconds float64, blocks ...any) ccPacket {
+	packet := []byte{0}
+	for i := 0; i+1 < len(blocks); i += 2 {
+		service, data := blocks[i].(int), blocks[i+1].([]byte)
+		packet = append(packet, byte(service<<5|len(data)))
+		packet = append(packet, data...)
+	}
+	if len(packet)%2 != 0 {
+		packet = append(packet, 0)
+	}
+	packet[0] = byte(len(packet) / 2)
+	var triplets []byte
+	for i := 0; i < len(packet); i += 2 {
+		flags := byte(0xFE)
+		if i == 0 {
+			flags = 0xFF
+		}
+		triplets = append(triplets, flags, packet[i], packet[i+1])
+	}
+	return ccPacket{pts: time.Duration(seconds * float64(time.Second)), triplets: triplets}
+}
+
+func TestDecodeCaptions708(t *testing.T) {
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	hidden := []byte{0x98, 0x00, 0, 0, 0, 0, 0}  // DF0, not visible
+	visible := []byte{0x99, 0x20, 0, 0, 0, 0, 0} // DF1, visible
+	join := func(parts ...any) []byte {
+		var out []byte
+		for _, p := range parts {
+			switch p := p.(type) {
+			case []byte:
+				out = append(out, p...)
+			case string:
+				out = append(out, p...)
+			case byte:
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		packets []ccPacket
+		want    []entity.SubCue
+	}{
+		{
+			"hidden window shown, cleared and written again",
+			[]ccPacket{
+				cc708(0, 1, join(hidden, "Hello", byte(0x0D), "world")),
+				cc708(1, 1, []byte{0x89, 0x01}), // DSW window 0
+				cc708(3, 1, []byte{0x88, 0x01}), // CLW window 0
+				cc708(4, 1, join("Again")),
+			},
+			[]entity.SubCue{
+				{StartTime: sec(1), EndTime: sec(3), Payload: "Hello\nworld"},
+				// the last caption stays up for two seconds past the final picture
+				{StartTime: sec(4), EndTime: sec(6), Payload: "Again"},
+			},
+		},
+		{
+			"visible window, backspace, EXT1 and music note",
+			[]ccPacket{
+				cc708(0, 1, join(visible, "Hi!", byte(0x08), byte(0x10), byte(0x25), byte(0x7F))),
+				cc708(2, 1, []byte{0x8A, 0x02}), // HDW window 1
+			},
+			[]entity.SubCue{{StartTime: sec(0), EndTime: sec(2), Payload: "Hi…♪"}},
+		},
+		{
+			"only service 1 is decoded",
+			[]ccPacket{
+				cc708(0, 2, join(visible, "Other"), 1, join(visible, "Mine")),
+				cc708(1, 1, []byte{0x8C, 0x02}), // DLW window 1
+			},
+			[]entity.SubCue{{StartTime: sec(0), EndTime: sec(1), Payload: "Mine"}},
+		},
+		{
+			"windows are switched with CWx",
+			[]ccPacket{
+				cc708(0, 1, join(visible, "One", hidden, "Zero")),
+				cc708(1, 1, join(byte(0x80), "!", byte(0x89), byte(0x01))), // CW0, then DSW window 0
+				cc708(2, 1, []byte{0x8F}),                                  // RST
+			},
+			[]entity.SubCue{
+				{StartTime: sec(0), EndTime: sec(1), Payload: "One"},
+				// visible windows are joined in window order
+				{StartTime: sec(1), EndTime: sec(2), Payload: "Zero!\nOne"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		subs := decodeCaptions(tt.packets)
+		var got []entity.SubCue
+		if sub := subs["SERVICE1"]; sub != nil {
+			got = sub.Cues
+		}
+		if !reflect.DeepEqual(got, tt.want) || len(subs) > 1 {
+			t.Errorf("%s:\ngot  %+v (%d channels)\nwant %+v", tt.name, got, len(subs), tt.want)
+		}
+	}
+}
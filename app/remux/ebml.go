@@ -0,0 +1,169 @@
+package remux
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Matroska element IDs, written with their length marker bits included.
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment      = 0x18538067
+	idSeekHead     = 0x114D9B74
+	idSeek         = 0x4DBB
+	idSeekID       = 0x53AB
+	idSeekPosition = 0x53AC
+	idVoid         = 0xEC
+
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idDuration       = 0x4489
+	idMuxingApp      = 0x4D80
+	idWritingApp     = 0x5741
+	idDateUTC        = 0x4461
+	idTitle          = 0x7BA9
+	idSegmentUID     = 0x73A4
+
+	idTracks            = 0x1654AE6B
+	idTrackEntry        = 0xAE
+	idTrackNumber       = 0xD7
+	idTrackUID          = 0x73C5
+	idTrackType         = 0x83
+	idFlagDefault       = 0x88
+	idFlagForced        = 0x55AA
+	idFlagLacing        = 0x9C
+	idDefaultDuration   = 0x23E383
+	idName              = 0x536E
+	idLanguage          = 0x22B59C
+	idCodecID           = 0x86
+	idCodecPrivate      = 0x63A2
+	idCodecDelay        = 0x56AA
+	idSeekPreRoll       = 0x56BB
+	idVideo             = 0xE0
+	idPixelWidth        = 0xB0
+	idPixelHeight       = 0xBA
+	idAudio             = 0xE1
+	idSamplingFrequency = 0xB5
+	idChannels          = 0x9F
+
+	idCluster       = 0x1F43B675
+	idTimestamp     = 0xE7
+	idSimpleBlock   = 0xA3
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+	idBlockDuration = 0x9B
+
+	idCues               = 0x1C53BB6B
+	idCuePoint           = 0xBB
+	idCueTime            = 0xB3
+	idCueTrackPositions  = 0xB7
+	idCueTrack           = 0xF7
+	idCueClusterPosition = 0xF1
+
+	idChapters         = 0x1043A770
+	idEditionEntry     = 0x45B9
+	idEditionUID       = 0x45BC
+	idChapterAtom      = 0xB6
+	idChapterUID       = 0x73C4
+	idChapterTimeStart = 0x91
+	idChapterTimeEnd   = 0x92
+	idChapterDisplay   = 0x80
+	idChapString       = 0x85
+	idChapLanguage     = 0x437C
+
+	idAttachments   = 0x1941A469
+	idAttachedFile  = 0x61A7
+	idFileName      = 0x466E
+	idFileMediaType = 0x4660
+	idFileData      = 0x465C
+	idFileUID       = 0x46AE
+)
+
+// unknownSize is the 8-byte "size unknown" marker, also used as a placeholder
+// for sizes patched in once the element is complete.
+var unknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+func ebmlID(id uint32) []byte {
+	switch {
+	case id >= 0x1000000:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x10000:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x100:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+// ebmlSize encodes a data size as the shortest variable-length integer.
+func ebmlSize(size uint64) []byte {
+	n := 1
+	for n < 8 && size >= (uint64(1)<<(7*n))-1 {
+		n++
+	}
+	return ebmlSizeN(size, n)
+}
+
+func ebmlSizeN(size uint64, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(size)
+		size >>= 8
+	}
+	b[0] |= 0x80 >> (n - 1)
+	return b
+}
+
+func ebmlElement(id uint32, payloads ...[]byte) []byte {
+	size := 0
+	for _, p := range payloads {
+		size += len(p)
+	}
+	b := append(ebmlID(id), ebmlSize(uint64(size))...)
+	for _, p := range payloads {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func ebmlUint(id uint32, v uint64) []byte {
+	n := 1
+	for n < 8 && v>>(8*n) != 0 {
+		n++
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return ebmlElement(id, b[8-n:])
+}
+
+func ebmlInt(id uint32, v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return ebmlElement(id, b)
+}
+
+func ebmlFloat(id uint32, v float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	return ebmlElement(id, b)
+}
+
+func ebmlString(id uint32, s string) []byte {
+	return ebmlElement(id, []byte(s))
+}
+
+// ebmlVoid returns a Void element occupying exactly total bytes (total >= 2).
+func ebmlVoid(total int) []byte {
+	if total < 9 {
+		return append([]byte{idVoid, 0x80 | byte(total-2)}, make([]byte, total-2)...)
+	}
+	return append(append([]byte{idVoid}, ebmlSizeN(uint64(total-9), 8)...), make([]byte, total-9)...)
+}
@@ -0,0 +1,379 @@
+package remux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/util"
+	commonentity "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// MkvMuxOptions carries the container-level extras for MuxToMkv.
+type MkvMuxOptions struct {
+	NoDateInfo  bool
+	Chapters    []MkvChapter
+	Attachments []string
+	// TmpDir holds intermediate MP4 files remuxed from TS inputs.
+	TmpDir string
+}
+
+// mkvSource yields the frames of one input track in decode order.
+type mkvSource struct {
+	track  *MkvTrack
+	file   *os.File
+	frames []mkvPendingFrame
+	next   int
+	// tmpPath is an intermediate file removed once muxing is done.
+	tmpPath string
+}
+
+type mkvPendingFrame struct {
+	decode    time.Duration
+	timestamp time.Duration
+	duration  time.Duration
+	keyframe  bool
+	offset    int64
+	size      uint32
+	data      []byte
+}
+
+// MuxToMkv writes files into a Matroska file without ffmpeg. Video and audio
+// inputs are MP4 (progressive or fragmented) or MPEG-TS; subtitles are WebVTT,
+// SRT or ASS.
+func MuxToMkv(files []entity.OutputFile, output string, opts MkvMuxOptions) error {
+	w, err := NewMkvWriter(output)
+	if err != nil {
+		return err
+	}
+	w.NoDateInfo = opts.NoDateInfo
+	w.Chapters = opts.Chapters
+	for _, path := range opts.Attachments {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			w.f.Close()
+			return err
+		}
+		w.Attachments = append(w.Attachments, MkvAttachment{Name: filepath.Base(path), MimeType: attachmentMimeType(path), Data: data})
+	}
+
+	var sources []*mkvSource
+	defer func() {
+		for _, s := range sources {
+			s.close()
+		}
+	}()
+	for _, f := range files {
+		source, err := openMkvSource(f, opts.TmpDir)
+		if err != nil {
+			w.f.Close()
+			return fmt.Errorf("%s: %w", f.FilePath, err)
+		}
+		source.track.Language = util.ToISO639_2(f.LangCode)
+		source.track.Name = f.Title
+		source.track.Default = f.Default
+		source.track.Forced = f.Forced
+		w.AddTrack(source.track)
+		sources = append(sources, source)
+	}
+
+	for {
+		var next *mkvSource
+		for _, s := range sources {
+			if s.next < len(s.frames) && (next == nil || s.frames[s.next].decode < next.frames[next.next].decode) {
+				next = s
+			}
+		}
+		if next == nil {
+			break
+		}
+		pending := next.frames[next.next]
+		next.frames[next.next] = mkvPendingFrame{}
+		next.next++
+		data := pending.data
+		if data == nil {
+			data = make([]byte, pending.size)
+			if _, err := next.file.ReadAt(data, pending.offset); err != nil {
+				w.f.Close()
+				return err
+			}
+		}
+		if err := w.WriteFrame(MkvFrame{
+			Track:     next.track,
+			Timestamp: pending.timestamp,
+			Duration:  pending.duration,
+			Keyframe:  pending.keyframe,
+			Data:      data,
+		}); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func openMkvSource(f entity.OutputFile, tmpDir string) (*mkvSource, error) {
+	switch strings.ToLower(filepath.Ext(f.FilePath)) {
+	case ".vtt", ".srt", ".ass", ".ssa", ".ttml":
+		return openSubtitleSource(f.FilePath)
+	case ".ts":
+		remuxed := filepath.Join(tmpDir, strings.TrimSuffix(filepath.Base(f.FilePath), ".ts")+".mkvsrc.mp4")
+		if err := RemuxTSToMP4([][]string{{f.FilePath}}, remuxed); err != nil {
+			os.Remove(remuxed)
+			return nil, err
+		}
+		source, err := openMP4Source(remuxed)
+		if err != nil {
+			os.Remove(remuxed)
+			return nil, err
+		}
+		source.tmpPath = remuxed
+		return source, nil
+	}
+	return openMP4Source(f.FilePath)
+}
+
+func (s *mkvSource) close() {
+	if s.file != nil {
+		s.file.Close()
+	}
+	if s.tmpPath != "" {
+		os.Remove(s.tmpPath)
+	}
+}
+
+func openMP4Source(path string) (*mkvSource, error) {
+	tracks, err := mp4.ReadTracks(path)
+	if err != nil {
+		return nil, err
+	}
+	var info *mp4.TrackInfo
+	for _, t := range tracks {
+		if (t.Handler == "vide" || t.Handler == "soun") && len(t.Samples) > 0 {
+			info = t
+			break
+		}
+	}
+	if info == nil {
+		return nil, fmt.Errorf("no audio or video track")
+	}
+	if info.Timescale == 0 {
+		return nil, fmt.Errorf("track %d has no timescale", info.ID)
+	}
+	track, err := mkvTrackFromMP4(info)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	toTime := func(v int64) time.Duration {
+		return mp4.TicksToDuration(v, info.Timescale)
+	}
+	source := &mkvSource{track: track, file: file, frames: make([]mkvPendingFrame, len(info.Samples))}
+	for i, sample := range info.Samples {
+		var duration int64
+		switch {
+		case i+1 < len(info.Samples):
+			duration = info.Samples[i+1].DTS - sample.DTS
+		case i > 0:
+			duration = sample.DTS - info.Samples[i-1].DTS
+		}
+		source.frames[i] = mkvPendingFrame{
+			decode:    toTime(sample.DTS - info.MediaTime),
+			timestamp: toTime(sample.DTS + sample.CTS - info.MediaTime),
+			duration:  toTime(duration),
+			keyframe:  sample.Sync,
+			offset:    sample.Offset,
+			size:      sample.Size,
+		}
+	}
+	if track.Type == MkvTrackAudio && len(source.frames) > 1 {
+		track.DefaultDuration = source.frames[0].duration
+	}
+	return source, nil
+}
+
+func mkvTrackFromMP4(info *mp4.TrackInfo) (*MkvTrack, error) {
+	track := &MkvTrack{Type: MkvTrackVideo, Width: info.Width, Height: info.Height}
+	if info.Handler == "soun" {
+		track = &MkvTrack{Type: MkvTrackAudio, SampleRate: float64(info.SampleRate), Channels: info.Channels}
+	}
+	switch info.Format {
+	case "avc1", "avc3":
+		track.CodecID, track.CodecPrivate = "V_MPEG4/ISO/AVC", info.Config
+	case "hvc1", "hev1":
+		track.CodecID, track.CodecPrivate = "V_MPEGH/ISO/HEVC", info.Config
+	case "av01":
+		track.CodecID, track.CodecPrivate = "V_AV1", info.Config
+	case "mp4a":
+		track.CodecID, track.CodecPrivate = "A_AAC", info.Config
+	case "Opus":
+		track.CodecID = "A_OPUS"
+		track.CodecPrivate = opusHead(info.Config)
+		if len(info.Config) >= 4 {
+			track.CodecDelay = time.Duration(binary.BigEndian.Uint16(info.Config[2:])) * time.Second / 48000
+		}
+		track.SeekPreRoll = 80 * time.Millisecond
+		track.SampleRate = 48000
+	case "ac-3":
+		track.CodecID = "A_AC3"
+	case "ec-3":
+		track.CodecID = "A_EAC3"
+	default:
+		return nil, fmt.Errorf("codec %q is not supported by the native muxer", info.Format)
+	}
+	return track, nil
+}
+
+// opusHead converts an MP4 dOps payload (big-endian) into the Ogg-style
+// OpusHead Matroska expects as CodecPrivate (little-endian).
+func opusHead(dOps []byte) []byte {
+	if len(dOps) < 11 {
+		return nil
+	}
+	head := []byte("OpusHead")
+	head = append(head, 1, dOps[1])
+	head = binary.LittleEndian.AppendUint16(head, binary.BigEndian.Uint16(dOps[2:]))
+	head = binary.LittleEndian.AppendUint32(head, binary.BigEndian.Uint32(dOps[4:]))
+	head = binary.LittleEndian.AppendUint16(head, binary.BigEndian.Uint16(dOps[8:]))
+	return append(head, dOps[10:]...)
+}
+
+func attachmentMimeType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ttf":
+		return "font/ttf"
+	case ".otf":
+		return "font/otf"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+var assTimeRegex = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})[.:](\d{2})$`)
+
+func openSubtitleSource(path string) (*mkvSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\ufeff")
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".ass" || ext == ".ssa" {
+		return assSource(text)
+	}
+
+	track := &MkvTrack{Type: MkvTrackSubtitle, CodecID: "S_TEXT/WEBVTT"}
+	var sub *commonentity.WebVttSub
+	switch ext {
+	case ".srt":
+		track.CodecID = "S_TEXT/UTF8"
+		sub, err = commonentity.ParseSrt(text)
+	case ".ttml":
+		sub, err = commonentity.ParseTtml(text)
+	default:
+		sub, err = commonentity.Parse(text+"\n\n", 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	source := &mkvSource{track: track}
+	for _, cue := range sub.Cues {
+		data := cue.Payload
+		if ext == ".srt" {
+			data = cue.SrtText()
+		}
+		source.frames = append(source.frames, mkvPendingFrame{
+			decode:    cue.StartTime,
+			timestamp: cue.StartTime,
+			duration:  cue.EndTime - cue.StartTime,
+			keyframe:  true,
+			data:      []byte(data),
+		})
+	}
+	return source, nil
+}
+
+// assSource keeps everything up to the [Events] Format line as CodecPrivate and
+// turns each Dialogue line into a block in Matroska's ASS field order.
+func assSource(text string) (*mkvSource, error) {
+	track := &MkvTrack{Type: MkvTrackSubtitle, CodecID: "S_TEXT/ASS"}
+	source := &mkvSource{track: track}
+	var header strings.Builder
+	inEvents := false
+	readOrder := 0
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 1<<16), 1<<24)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inEvents = strings.EqualFold(trimmed, "[Events]")
+		}
+		if !inEvents || !strings.HasPrefix(trimmed, "Dialogue:") {
+			if !inEvents || strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "Format:") {
+				header.WriteString(line)
+				header.WriteString("\n")
+			}
+			continue
+		}
+		// Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+		fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(trimmed, "Dialogue:")), ",", 10)
+		if len(fields) < 10 {
+			continue
+		}
+		start, ok1 := assTime(fields[1])
+		end, ok2 := assTime(fields[2])
+		if !ok1 || !ok2 {
+			continue
+		}
+		block := fmt.Sprintf("%d,%s,%s", readOrder, fields[0], strings.Join(fields[3:], ","))
+		readOrder++
+		source.frames = append(source.frames, mkvPendingFrame{
+			decode:    start,
+			timestamp: start,
+			duration:  end - start,
+			keyframe:  true,
+			data:      []byte(block),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	track.CodecPrivate = []byte(header.String())
+	// Blocks must be in timestamp order; Dialogue lines need not be.
+	sortPendingFrames(source.frames)
+	return source, nil
+}
+
+func assTime(s string) (time.Duration, bool) {
+	m := assTimeRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	var h, min, sec, cs int
+	fmt.Sscan(m[1], &h)
+	fmt.Sscan(m[2], &min)
+	fmt.Sscan(m[3], &sec)
+	fmt.Sscan(m[4], &cs)
+	return time.Duration(h)*time.Hour + time.Duration(min)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(cs)*10*time.Millisecond, true
+}
+
+func sortPendingFrames(frames []mkvPendingFrame) {
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].decode < frames[j].decode })
+}
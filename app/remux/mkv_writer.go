@@ -0,0 +1,418 @@
+package remux
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Matroska track types.
+const (
+	MkvTrackVideo    = 1
+	MkvTrackAudio    = 2
+	MkvTrackSubtitle = 0x11
+)
+
+const (
+	mkvTimestampScale = time.Millisecond
+	mkvClusterSpan    = 5 * time.Second
+	mkvSeekHeadSpace  = 160
+)
+
+// MkvTrack describes one TrackEntry. CodecID uses the Matroska names,
+// e.g. V_MPEG4/ISO/AVC, A_AAC, S_TEXT/WEBVTT.
+type MkvTrack struct {
+	Type            int
+	CodecID         string
+	CodecPrivate    []byte
+	Language        string
+	Name            string
+	Default         bool
+	Forced          bool
+	DefaultDuration time.Duration
+	CodecDelay      time.Duration
+	SeekPreRoll     time.Duration
+
+	Width, Height int
+	SampleRate    float64
+	Channels      int
+
+	number uint64
+}
+
+// MkvFrame is one block. Frames must be written in decode order; Timestamp is
+// the presentation time. Duration is only stored for subtitle tracks.
+type MkvFrame struct {
+	Track     *MkvTrack
+	Timestamp time.Duration
+	Duration  time.Duration
+	Keyframe  bool
+	Data      []byte
+}
+
+// MkvChapter is a flat chapter entry; End may be zero.
+type MkvChapter struct {
+	Start, End time.Duration
+	Title      string
+	Language   string
+}
+
+// MkvAttachment is a file carried in the Attachments element, typically a font.
+type MkvAttachment struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+type mkvCue struct {
+	time     time.Duration
+	track    uint64
+	position int64
+}
+
+// MkvWriter streams clusters to disk and writes Cues, Chapters and
+// Attachments at the end, pointing the SeekHead at them so the file is seekable.
+type MkvWriter struct {
+	Title       string
+	NoDateInfo  bool
+	Chapters    []MkvChapter
+	Attachments []MkvAttachment
+
+	f            *os.File
+	tracks       []*MkvTrack
+	segmentStart int64
+	seekHeadPos  int64
+	durationPos  int64
+	infoPos      int64
+	tracksPos    int64
+	started      bool
+
+	cluster      []byte
+	clusterTime  time.Duration
+	clusterOpen  bool
+	clusterVideo bool
+	clusterPos   int64
+	hasVideo     bool
+	cues         []mkvCue
+	end          time.Duration
+}
+
+func NewMkvWriter(output string) (*MkvWriter, error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return nil, err
+	}
+	return &MkvWriter{f: f}, nil
+}
+
+// AddTrack registers a track; all tracks must be added before the first frame.
+func (w *MkvWriter) AddTrack(track *MkvTrack) {
+	track.number = uint64(len(w.tracks) + 1)
+	w.tracks = append(w.tracks, track)
+}
+
+func (w *MkvWriter) offset() int64 {
+	pos, _ := w.f.Seek(0, io.SeekCurrent)
+	return pos - w.segmentStart
+}
+
+func (w *MkvWriter) writeHeader() error {
+	header := ebmlElement(idEBML,
+		ebmlUint(idEBMLVersion, 1),
+		ebmlUint(idEBMLReadVersion, 1),
+		ebmlUint(idEBMLMaxIDLength, 4),
+		ebmlUint(idEBMLMaxSizeLength, 8),
+		ebmlString(idDocType, "matroska"),
+		ebmlUint(idDocTypeVersion, 4),
+		ebmlUint(idDocTypeReadVersion, 2),
+	)
+	header = append(header, ebmlID(idSegment)...)
+	header = append(header, unknownSize...)
+	if _, err := w.f.Write(header); err != nil {
+		return err
+	}
+	w.segmentStart = int64(len(header))
+	w.seekHeadPos = 0
+	if _, err := w.f.Write(ebmlVoid(mkvSeekHeadSpace)); err != nil {
+		return err
+	}
+
+	uid := make([]byte, 16)
+	rand.Read(uid)
+	info := [][]byte{
+		ebmlElement(idSegmentUID, uid),
+		ebmlUint(idTimestampScale, uint64(mkvTimestampScale)),
+		ebmlString(idMuxingApp, "N_m3u8DL-RE"),
+		ebmlString(idWritingApp, "N_m3u8DL-RE"),
+	}
+	if w.Title != "" {
+		info = append(info, ebmlString(idTitle, w.Title))
+	}
+	if !w.NoDateInfo {
+		epoch := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+		info = append(info, ebmlInt(idDateUTC, time.Since(epoch).Nanoseconds()))
+	}
+	// Duration goes last so its position is easy to find for patching.
+	durationElem := ebmlFloat(idDuration, 0)
+	info = append(info, durationElem)
+	infoElem := ebmlElement(idInfo, info...)
+	w.infoPos = w.offset()
+	w.durationPos = w.segmentStart + w.infoPos + int64(len(infoElem)-8)
+	if _, err := w.f.Write(infoElem); err != nil {
+		return err
+	}
+
+	var entries [][]byte
+	for _, t := range w.tracks {
+		entries = append(entries, t.entry())
+		w.hasVideo = w.hasVideo || t.Type == MkvTrackVideo
+	}
+	w.tracksPos = w.offset()
+	_, err := w.f.Write(ebmlElement(idTracks, entries...))
+	return err
+}
+
+func (t *MkvTrack) entry() []byte {
+	fields := [][]byte{
+		ebmlUint(idTrackNumber, t.number),
+		ebmlUint(idTrackUID, randomUID()),
+		ebmlUint(idTrackType, uint64(t.Type)),
+		ebmlUint(idFlagDefault, boolUint(t.Default)),
+		ebmlUint(idFlagForced, boolUint(t.Forced)),
+		ebmlUint(idFlagLacing, 0),
+		ebmlString(idCodecID, t.CodecID),
+	}
+	lang := t.Language
+	if lang == "" {
+		lang = "und"
+	}
+	fields = append(fields, ebmlString(idLanguage, lang))
+	if t.Name != "" {
+		fields = append(fields, ebmlString(idName, t.Name))
+	}
+	if len(t.CodecPrivate) > 0 {
+		fields = append(fields, ebmlElement(idCodecPrivate, t.CodecPrivate))
+	}
+	if t.DefaultDuration > 0 {
+		fields = append(fields, ebmlUint(idDefaultDuration, uint64(t.DefaultDuration)))
+	}
+	if t.CodecDelay > 0 {
+		fields = append(fields, ebmlUint(idCodecDelay, uint64(t.CodecDelay)))
+	}
+	if t.SeekPreRoll > 0 {
+		fields = append(fields, ebmlUint(idSeekPreRoll, uint64(t.SeekPreRoll)))
+	}
+	switch t.Type {
+	case MkvTrackVideo:
+		fields = append(fields, ebmlElement(idVideo,
+			ebmlUint(idPixelWidth, uint64(t.Width)),
+			ebmlUint(idPixelHeight, uint64(t.Height)),
+		))
+	case MkvTrackAudio:
+		fields = append(fields, ebmlElement(idAudio,
+			ebmlFloat(idSamplingFrequency, t.SampleRate),
+			ebmlUint(idChannels, uint64(t.Channels)),
+		))
+	}
+	return ebmlElement(idTrackEntry, fields...)
+}
+
+func boolUint(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteFrame appends one block, starting a new cluster on a video keyframe
+// once the current one spans mkvClusterSpan, or when the relative timestamp
+// would overflow.
+func (w *MkvWriter) WriteFrame(frame MkvFrame) error {
+	if !w.started {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+		w.started = true
+	}
+	isVideo := frame.Track.Type == MkvTrackVideo
+	rel := (frame.Timestamp - w.clusterTime) / mkvTimestampScale
+	split := !w.clusterOpen || rel > 32000 || rel < -32000 ||
+		frame.Timestamp-w.clusterTime >= mkvClusterSpan && (frame.Keyframe && isVideo || !w.clusterVideo)
+	if split {
+		if err := w.flushCluster(); err != nil {
+			return err
+		}
+		w.clusterOpen = true
+		w.clusterTime = frame.Timestamp.Truncate(mkvTimestampScale)
+		w.clusterVideo = false
+		w.cluster = ebmlUint(idTimestamp, uint64(w.clusterTime/mkvTimestampScale))
+		w.clusterPos = w.offset()
+		rel = (frame.Timestamp - w.clusterTime) / mkvTimestampScale
+		if !w.hasVideo {
+			w.cues = append(w.cues, mkvCue{time: w.clusterTime, track: frame.Track.number, position: w.clusterPos})
+		}
+	}
+	if isVideo {
+		// With video present, only keyframes are useful seek points.
+		if !w.clusterVideo && frame.Keyframe {
+			w.cues = append(w.cues, mkvCue{time: frame.Timestamp.Truncate(mkvTimestampScale), track: frame.Track.number, position: w.clusterPos})
+		}
+		w.clusterVideo = true
+	}
+
+	header := append(ebmlSizeN(frame.Track.number, 1), byte(uint16(int16(rel))>>8), byte(int16(rel)))
+	if frame.Track.Type == MkvTrackSubtitle {
+		block := ebmlElement(idBlock, append(header, 0), frame.Data)
+		w.cluster = append(w.cluster, ebmlElement(idBlockGroup, block,
+			ebmlUint(idBlockDuration, uint64(frame.Duration/mkvTimestampScale)))...)
+	} else {
+		flags := byte(0)
+		if frame.Keyframe {
+			flags = 0x80
+		}
+		w.cluster = append(w.cluster, ebmlElement(idSimpleBlock, append(header, flags), frame.Data)...)
+	}
+	if end := frame.Timestamp + frame.Duration; end > w.end {
+		w.end = end
+	}
+	return nil
+}
+
+func (w *MkvWriter) flushCluster() error {
+	if !w.clusterOpen {
+		return nil
+	}
+	w.clusterOpen = false
+	_, err := w.f.Write(ebmlElement(idCluster, w.cluster))
+	w.cluster = nil
+	return err
+}
+
+// Close writes the trailing elements, patches the SeekHead, Duration and
+// Segment size, and closes the file.
+func (w *MkvWriter) Close() error {
+	defer w.f.Close()
+	if !w.started {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	if err := w.flushCluster(); err != nil {
+		return err
+	}
+
+	type seekEntry struct {
+		id  uint32
+		pos int64
+	}
+	seeks := []seekEntry{{idInfo, w.infoPos}, {idTracks, w.tracksPos}}
+
+	if len(w.Chapters) > 0 {
+		seeks = append(seeks, seekEntry{idChapters, w.offset()})
+		if _, err := w.f.Write(w.chaptersElement()); err != nil {
+			return err
+		}
+	}
+	if len(w.Attachments) > 0 {
+		seeks = append(seeks, seekEntry{idAttachments, w.offset()})
+		if _, err := w.f.Write(w.attachmentsElement()); err != nil {
+			return err
+		}
+	}
+	if len(w.cues) > 0 {
+		seeks = append(seeks, seekEntry{idCues, w.offset()})
+		if _, err := w.f.Write(w.cuesElement()); err != nil {
+			return err
+		}
+	}
+	segmentSize := w.offset()
+
+	var entries [][]byte
+	for _, s := range seeks {
+		entries = append(entries, ebmlElement(idSeek,
+			ebmlElement(idSeekID, ebmlID(s.id)),
+			ebmlUint(idSeekPosition, uint64(s.pos)),
+		))
+	}
+	seekHead := ebmlElement(idSeekHead, entries...)
+	if len(seekHead)+2 > mkvSeekHeadSpace {
+		return fmt.Errorf("seek head does not fit in %d bytes", mkvSeekHeadSpace)
+	}
+	seekHead = append(seekHead, ebmlVoid(mkvSeekHeadSpace-len(seekHead))...)
+	if _, err := w.f.WriteAt(seekHead, w.segmentStart+w.seekHeadPos); err != nil {
+		return err
+	}
+
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(float64(w.end)/float64(mkvTimestampScale)))
+	if _, err := w.f.WriteAt(duration, w.durationPos); err != nil {
+		return err
+	}
+	if _, err := w.f.WriteAt(ebmlSizeN(uint64(segmentSize), 8), w.segmentStart-8); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *MkvWriter) cuesElement() []byte {
+	var points [][]byte
+	for _, c := range w.cues {
+		points = append(points, ebmlElement(idCuePoint,
+			ebmlUint(idCueTime, uint64(c.time/mkvTimestampScale)),
+			ebmlElement(idCueTrackPositions,
+				ebmlUint(idCueTrack, c.track),
+				ebmlUint(idCueClusterPosition, uint64(c.position)),
+			),
+		))
+	}
+	return ebmlElement(idCues, points...)
+}
+
+func (w *MkvWriter) chaptersElement() []byte {
+	chapters := append([]MkvChapter(nil), w.Chapters...)
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Start < chapters[j].Start })
+	atoms := [][]byte{ebmlUint(idEditionUID, randomUID())}
+	for _, c := range chapters {
+		lang := c.Language
+		if lang == "" {
+			lang = "und"
+		}
+		fields := [][]byte{
+			ebmlUint(idChapterUID, randomUID()),
+			ebmlUint(idChapterTimeStart, uint64(c.Start)),
+		}
+		if c.End > c.Start {
+			fields = append(fields, ebmlUint(idChapterTimeEnd, uint64(c.End)))
+		}
+		fields = append(fields, ebmlElement(idChapterDisplay,
+			ebmlString(idChapString, c.Title),
+			ebmlString(idChapLanguage, lang),
+		))
+		atoms = append(atoms, ebmlElement(idChapterAtom, fields...))
+	}
+	return ebmlElement(idChapters, ebmlElement(idEditionEntry, atoms...))
+}
+
+func (w *MkvWriter) attachmentsElement() []byte {
+	var files [][]byte
+	for _, a := range w.Attachments {
+		files = append(files, ebmlElement(idAttachedFile,
+			ebmlString(idFileName, a.Name),
+			ebmlString(idFileMediaType, a.MimeType),
+			ebmlElement(idFileData, a.Data),
+			ebmlUint(idFileUID, randomUID()),
+		))
+	}
+	return ebmlElement(idAttachments, files...)
+}
+
+func randomUID() uint64 {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return binary.BigEndian.Uint64(b)>>1 | 1
+}
@@ -0,0 +1,246 @@
+package remux
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const movieTimescale = 1000
+
+type mp4Sample struct {
+	offset   uint64
+	size     uint32
+	dts      int64 // track timescale
+	cts      int32
+	duration uint32
+	sync     bool
+}
+
+// mp4Track is what the writer needs to build a trak box.
+type mp4Track struct {
+	id          uint32
+	handler     string // "vide" or "soun"
+	timescale   uint32
+	sampleEntry []byte
+	width       int
+	height      int
+	samples     []mp4Sample
+	// startOffset is the presentation delay of the first sample, in track timescale.
+	startOffset int64
+	// mediaTime is the composition time of the first sample, for the edit list.
+	mediaTime int64
+}
+
+func box(boxType string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	b := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	copy(b[4:], boxType)
+	for _, p := range payloads {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func fullBox(boxType string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(boxType, append([][]byte{header}, payloads...)...)
+}
+
+func be(values ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	return b.Bytes()
+}
+
+var unityMatrix = be(
+	uint32(0x00010000), uint32(0), uint32(0),
+	uint32(0), uint32(0x00010000), uint32(0),
+	uint32(0), uint32(0), uint32(0x40000000),
+)
+
+func ftypBox() []byte {
+	return box("ftyp", []byte("isom"), be(uint32(512)), []byte("isomiso2avc1mp41"))
+}
+
+func (t *mp4Track) duration() int64 {
+	if len(t.samples) == 0 {
+		return 0
+	}
+	last := t.samples[len(t.samples)-1]
+	return last.dts + int64(last.duration)
+}
+
+func toMovieTime(v int64, timescale uint32) uint64 {
+	return uint64(v * movieTimescale / int64(timescale))
+}
+
+func moovBox(tracks []*mp4Track) []byte {
+	var movieDuration uint64
+	for _, t := range tracks {
+		movieDuration = max(movieDuration, toMovieTime(t.duration()+t.startOffset, t.timescale))
+	}
+	mvhd := fullBox("mvhd", 1, 0,
+		be(uint64(0), uint64(0), uint32(movieTimescale), movieDuration,
+			uint32(0x00010000), uint16(0x0100), uint16(0), uint64(0)),
+		unityMatrix,
+		make([]byte, 24),
+		be(uint32(len(tracks)+1)))
+
+	payloads := [][]byte{mvhd}
+	for _, t := range tracks {
+		payloads = append(payloads, t.trakBox())
+	}
+	return box("moov", payloads...)
+}
+
+func (t *mp4Track) trakBox() []byte {
+	mediaDuration := t.duration()
+	volume := uint16(0)
+	if t.handler == "soun" {
+		volume = 0x0100
+	}
+	tkhd := fullBox("tkhd", 1, 3,
+		be(uint64(0), uint64(0), t.id, uint32(0), toMovieTime(mediaDuration+t.startOffset, t.timescale),
+			uint64(0), uint16(0), uint16(0), volume, uint16(0)),
+		unityMatrix,
+		be(uint32(t.width<<16), uint32(t.height<<16)))
+
+	var elst []byte
+	entries := [][]byte{}
+	if t.startOffset > 0 {
+		entries = append(entries, be(toMovieTime(t.startOffset, t.timescale), int64(-1), uint32(0x00010000)))
+	}
+	entries = append(entries, be(toMovieTime(mediaDuration, t.timescale), t.mediaTime, uint32(0x00010000)))
+	elst = fullBox("elst", 1, 0, append([][]byte{be(uint32(len(entries)))}, entries...)...)
+
+	mdhd := fullBox("mdhd", 1, 0, be(uint64(0), uint64(0), t.timescale, uint64(mediaDuration), uint16(0x55c4), uint16(0)))
+	name := "VideoHandler"
+	mediaHeader := fullBox("vmhd", 0, 1, make([]byte, 8))
+	if t.handler == "soun" {
+		name = "SoundHandler"
+		mediaHeader = fullBox("smhd", 0, 0, make([]byte, 4))
+	}
+	hdlr := fullBox("hdlr", 0, 0, be(uint32(0)), []byte(t.handler), make([]byte, 12), []byte(name+"\x00"))
+	dinf := box("dinf", fullBox("dref", 0, 0, be(uint32(1)), fullBox("url ", 0, 1)))
+
+	minf := box("minf", mediaHeader, dinf, t.stblBox())
+	return box("trak", tkhd, box("edts", elst), box("mdia", mdhd, hdlr, minf))
+}
+
+func (t *mp4Track) stblBox() []byte {
+	stsd := fullBox("stsd", 0, 0, be(uint32(1)), t.sampleEntry)
+
+	// stts: run-length encoded durations
+	var stts [][]byte
+	var ctts [][]byte
+	var sttsRuns, cttsRuns uint32
+	var stss []uint32
+	hasCtts := false
+	for i := 0; i < len(t.samples); {
+		j := i
+		for j < len(t.samples) && t.samples[j].duration == t.samples[i].duration {
+			j++
+		}
+		stts = append(stts, be(uint32(j-i), t.samples[i].duration))
+		sttsRuns++
+		i = j
+	}
+	for i := 0; i < len(t.samples); {
+		j := i
+		for j < len(t.samples) && t.samples[j].cts == t.samples[i].cts {
+			j++
+		}
+		if t.samples[i].cts != 0 {
+			hasCtts = true
+		}
+		ctts = append(ctts, be(uint32(j-i), t.samples[i].cts))
+		cttsRuns++
+		i = j
+	}
+	allSync := true
+	sizes := make([]byte, 0, len(t.samples)*4)
+	for i, s := range t.samples {
+		if s.sync {
+			stss = append(stss, uint32(i+1))
+		} else {
+			allSync = false
+		}
+		sizes = append(sizes, be(s.size)...)
+	}
+
+	// chunks: runs of samples stored back to back
+	var chunkOffsets []uint64
+	var stsc [][]byte
+	var stscRuns uint32
+	lastPerChunk := -1
+	for i := 0; i < len(t.samples); {
+		j := i + 1
+		for j < len(t.samples) && t.samples[j].offset == t.samples[j-1].offset+uint64(t.samples[j-1].size) {
+			j++
+		}
+		chunkOffsets = append(chunkOffsets, t.samples[i].offset)
+		if j-i != lastPerChunk {
+			stsc = append(stsc, be(uint32(len(chunkOffsets)), uint32(j-i), uint32(1)))
+			stscRuns++
+			lastPerChunk = j - i
+		}
+		i = j
+	}
+
+	boxes := [][]byte{
+		stsd,
+		fullBox("stts", 0, 0, append([][]byte{be(sttsRuns)}, stts...)...),
+	}
+	if hasCtts {
+		boxes = append(boxes, fullBox("ctts", 1, 0, append([][]byte{be(cttsRuns)}, ctts...)...))
+	}
+	if !allSync {
+		boxes = append(boxes, fullBox("stss", 0, 0, be(uint32(len(stss))), be(stss)))
+	}
+	boxes = append(boxes,
+		fullBox("stsc", 0, 0, append([][]byte{be(stscRuns)}, stsc...)...),
+		fullBox("stsz", 0, 0, be(uint32(0), uint32(len(t.samples))), sizes),
+		chunkOffsetBox(chunkOffsets))
+	return box("stbl", boxes...)
+}
+
+func chunkOffsetBox(offsets []uint64) []byte {
+	large := len(offsets) > 0 && offsets[len(offsets)-1] > 0xffffffff
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(len(offsets)))
+	for _, o := range offsets {
+		if large {
+			binary.Write(&b, binary.BigEndian, o)
+		} else {
+			binary.Write(&b, binary.BigEndian, uint32(o))
+		}
+	}
+	if large {
+		return fullBox("co64", 0, 0, b.Bytes())
+	}
+	return fullBox("stco", 0, 0, b.Bytes())
+}
+
+func visualSampleEntry(format string, width, height int, config []byte) []byte {
+	return box(format,
+		make([]byte, 6), be(uint16(1)),
+		make([]byte, 16),
+		be(uint16(width), uint16(height), uint32(0x00480000), uint32(0x00480000), uint32(0), uint16(1)),
+		make([]byte, 32),
+		be(uint16(0x0018), int16(-1)),
+		config)
+}
+
+func audioSampleEntry(format string, channels, sampleRate int, config []byte) []byte {
+	return box(format,
+		make([]byte, 6), be(uint16(1)),
+		make([]byte, 8),
+		be(uint16(channels), uint16(16), uint16(0), uint16(0), uint32(sampleRate<<16)),
+		config)
+}
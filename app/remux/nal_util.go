@@ -0,0 +1,324 @@
+package remux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// splitAnnexB returns the NAL units of an Annex B byte stream without start codes.
+func splitAnnexB(data []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			nals = append(nals, trimTrailingZeros(data[start:i]))
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(data) {
+		nals = append(nals, data[start:])
+	}
+	return nals
+}
+
+func trimTrailingZeros(nal []byte) []byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	return nal
+}
+
+// videoConfig collects the parameter sets and picture size of a video track.
+type videoConfig struct {
+	hevc   bool
+	vps    []byte
+	sps    []byte
+	pps    []byte
+	width  int
+	height int
+	// HEVC only
+	ptl            []byte
+	chromaFormat   int
+	bitDepthLuma   int
+	bitDepthChroma int
+	subLayers      int
+	temporalNested bool
+}
+
+// toSample converts an access unit to length-prefixed NAL units, storing
+// parameter sets in cfg instead of the sample. keyframe reports IDR/IRAP pictures.
+func (cfg *videoConfig) toSample(au []byte) (sample []byte, keyframe bool, err error) {
+	var buf bytes.Buffer
+	for _, nal := range splitAnnexB(au) {
+		if len(nal) == 0 {
+			continue
+		}
+		if cfg.hevc {
+			switch t := nal[0] >> 1 & 0x3f; {
+			case t == 32:
+				cfg.vps = append([]byte(nil), nal...)
+				continue
+			case t == 33:
+				if cfg.sps == nil {
+					if err := cfg.parseHEVCSPS(nal); err != nil {
+						return nil, false, err
+					}
+				}
+				cfg.sps = append([]byte(nil), nal...)
+				continue
+			case t == 34:
+				cfg.pps = append([]byte(nil), nal...)
+				continue
+			case t == 35:
+				continue
+			case t >= 16 && t <= 21:
+				keyframe = true
+			}
+		} else {
+			switch nal[0] & 0x1f {
+			case 7:
+				if cfg.sps == nil {
+					if err := cfg.parseH264SPS(nal); err != nil {
+						return nil, false, err
+					}
+				}
+				cfg.sps = append([]byte(nil), nal...)
+				continue
+			case 8:
+				cfg.pps = append([]byte(nil), nal...)
+				continue
+			case 9:
+				continue
+			case 5:
+				keyframe = true
+			}
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(nal)))
+		buf.Write(nal)
+	}
+	return buf.Bytes(), keyframe, nil
+}
+
+func (cfg *videoConfig) parseH264SPS(nal []byte) error {
+	// header, profile_idc, constraint flags and level_idc, which avcC copies
+	if len(nal) < 4 {
+		return fmt.Errorf("H.264 SPS of %d bytes is too short", len(nal))
+	}
+	r := newBitReader(unescapeRBSP(nal[1:]))
+	profile := r.readBits(8)
+	r.skipBits(16)
+	r.readUE()
+	chromaFormat := uint32(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.readUE()
+		if chromaFormat == 3 {
+			r.skipBits(1)
+		}
+		r.readUE()
+		r.readUE()
+		r.skipBits(1)
+		if r.readBit() == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.readBit() == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipScalingList(r, size)
+				}
+			}
+		}
+	}
+	r.readUE()
+	switch r.readUE() {
+	case 0:
+		r.readUE()
+	case 1:
+		r.skipBits(1)
+		r.readSE()
+		r.readSE()
+		for n := r.readUE(); n > 0 && r.err == nil; n-- {
+			r.readSE()
+		}
+	}
+	r.readUE()
+	r.skipBits(1)
+	widthMbs := r.readUE() + 1
+	heightMaps := r.readUE() + 1
+	frameMbsOnly := r.readBit()
+	if frameMbsOnly == 0 {
+		r.skipBits(1)
+	}
+	r.skipBits(1)
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	if r.readBit() == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.readUE(), r.readUE(), r.readUE(), r.readUE()
+	}
+	if r.err != nil {
+		return nil
+	}
+	cropX, cropY := uint32(1), 2-frameMbsOnly
+	if chromaFormat == 1 || chromaFormat == 2 {
+		cropX = 2
+	}
+	if chromaFormat == 1 {
+		cropY *= 2
+	}
+	cfg.width = int(widthMbs*16 - cropX*(cropLeft+cropRight))
+	cfg.height = int((2-frameMbsOnly)*heightMaps*16 - cropY*(cropTop+cropBottom))
+	return nil
+}
+
+func skipScalingList(r *bitReader, size int) {
+	last, next := int32(8), int32(8)
+	for j := 0; j < size; j++ {
+		if next != 0 {
+			next = (last + r.readSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+func (cfg *videoConfig) parseHEVCSPS(nal []byte) error {
+	// two header bytes and at least the first byte of the SPS
+	if len(nal) < 3 {
+		return fmt.Errorf("HEVC SPS of %d bytes is too short", len(nal))
+	}
+	rbsp := unescapeRBSP(nal[2:])
+	r := newBitReader(rbsp)
+	r.skipBits(4)
+	maxSubLayersMinus1 := int(r.readBits(3))
+	cfg.subLayers = maxSubLayersMinus1 + 1
+	cfg.temporalNested = r.readBit() == 1
+	ptlStart := r.pos / 8
+	r.skipBits(96)
+	if r.err != nil || ptlStart+12 > len(rbsp) {
+		return nil
+	}
+	cfg.ptl = append([]byte(nil), rbsp[ptlStart:ptlStart+12]...)
+
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = r.readBit() == 1
+		levelPresent[i] = r.readBit() == 1
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.skipBits(2 * (8 - maxSubLayersMinus1))
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			r.skipBits(88)
+		}
+		if levelPresent[i] {
+			r.skipBits(8)
+		}
+	}
+	r.readUE()
+	cfg.chromaFormat = int(r.readUE())
+	if cfg.chromaFormat == 3 {
+		r.skipBits(1)
+	}
+	width, height := r.readUE(), r.readUE()
+	if r.readBit() == 1 {
+		subW, subH := uint32(1), uint32(1)
+		if cfg.chromaFormat == 1 || cfg.chromaFormat == 2 {
+			subW = 2
+		}
+		if cfg.chromaFormat == 1 {
+			subH = 2
+		}
+		left, right, top, bottom := r.readUE(), r.readUE(), r.readUE(), r.readUE()
+		width -= subW * (left + right)
+		height -= subH * (top + bottom)
+	}
+	cfg.bitDepthLuma = int(r.readUE()) + 8
+	cfg.bitDepthChroma = int(r.readUE()) + 8
+	if r.err == nil {
+		cfg.width, cfg.height = int(width), int(height)
+	}
+	return nil
+}
+
+// avcC builds the AVCDecoderConfigurationRecord.
+func (cfg *videoConfig) avcC() ([]byte, error) {
+	sps, pps := cfg.sps, cfg.pps
+	if len(sps) < 4 {
+		return nil, fmt.Errorf("H.264 SPS of %d bytes is too short", len(sps))
+	}
+	var b bytes.Buffer
+	b.Write([]byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1})
+	binary.Write(&b, binary.BigEndian, uint16(len(sps)))
+	b.Write(sps)
+	b.WriteByte(1)
+	binary.Write(&b, binary.BigEndian, uint16(len(pps)))
+	b.Write(pps)
+	switch sps[1] {
+	case 100, 110, 122, 244:
+		r := newBitReader(unescapeRBSP(sps[1:]))
+		r.skipBits(24)
+		r.readUE()
+		chroma := r.readUE()
+		if chroma == 3 {
+			r.skipBits(1)
+		}
+		lumaMinus8, chromaMinus8 := r.readUE(), r.readUE()
+		b.Write([]byte{0xfc | byte(chroma), 0xf8 | byte(lumaMinus8), 0xf8 | byte(chromaMinus8), 0})
+	}
+	return b.Bytes(), nil
+}
+
+// hvcC builds the HEVCDecoderConfigurationRecord.
+func (cfg *videoConfig) hvcC() []byte {
+	var b bytes.Buffer
+	b.WriteByte(1)
+	ptl := cfg.ptl
+	if len(ptl) < 12 {
+		ptl = make([]byte, 12)
+	}
+	b.Write(ptl)
+	b.Write([]byte{0xf0, 0x00, 0xfc})
+	b.WriteByte(0xfc | byte(cfg.chromaFormat))
+	b.WriteByte(0xf8 | byte(max(cfg.bitDepthLuma-8, 0)))
+	b.WriteByte(0xf8 | byte(max(cfg.bitDepthChroma-8, 0)))
+	b.Write([]byte{0, 0})
+	nested := byte(0)
+	if cfg.temporalNested {
+		nested = 1
+	}
+	b.WriteByte(byte(cfg.subLayers&7)<<3 | nested<<2 | 3)
+
+	arrays := []struct {
+		nalType byte
+		nal     []byte
+	}{{32, cfg.vps}, {33, cfg.sps}, {34, cfg.pps}}
+	count := 0
+	for _, a := range arrays {
+		if a.nal != nil {
+			count++
+		}
+	}
+	b.WriteByte(byte(count))
+	for _, a := range arrays {
+		if a.nal == nil {
+			continue
+		}
+		b.WriteByte(0x80 | a.nalType)
+		binary.Write(&b, binary.BigEndian, uint16(1))
+		binary.Write(&b, binary.BigEndian, uint16(len(a.nal)))
+		b.Write(a.nal)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,23 @@
+package remux
+
+import "testing"
+
+func TestToSampleShortSPS(t *testing.T) {
+	tests := []struct {
+		hevc bool
+		au   []byte
+	}{
+		{false, []byte{0, 0, 1, 0x67, 100}},
+		{true, []byte{0, 0, 1, 0x42}},
+		{true, []byte{0, 0, 1, 0x42, 0x01}},
+	}
+	for _, tt := range tests {
+		cfg := &videoConfig{hevc: tt.hevc}
+		if _, _, err := cfg.toSample(tt.au); err == nil {
+			t.Errorf("% x: expected an error for a truncated SPS", tt.au)
+		}
+	}
+	if _, err := (&videoConfig{sps: []byte{0x67, 100}}).avcC(); err == nil {
+		t.Errorf("avcC: expected an error for a truncated SPS")
+	}
+}
@@ -0,0 +1,218 @@
+package remux
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MPEG-TS stream_type values we can remux.
+const (
+	StreamTypeAAC  byte = 0x0F
+	StreamTypeH264 byte = 0x1B
+	StreamTypeH265 byte = 0x24
+	StreamTypeAC3  byte = 0x81
+)
+
+const (
+	tsPacketSize = 188
+	noTimestamp  = int64(-1)
+	ptsWrap      = int64(1) << 33
+)
+
+// PES is one reassembled packet. PTS and DTS are 90kHz, unwrapped past the
+// 33-bit rollover, and noTimestamp when absent.
+type PES struct {
+	PID        uint16
+	StreamType byte
+	PTS        int64
+	DTS        int64
+	Data       []byte
+}
+
+// TSDemuxer reassembles PES packets for the elementary streams announced in the PMT.
+type TSDemuxer struct {
+	OnPES func(pes *PES)
+
+	pmtPIDs map[uint16]bool
+	streams map[uint16]byte
+	pending map[uint16][]byte
+	lastTs  map[uint16]int64
+}
+
+func NewTSDemuxer(onPES func(pes *PES)) *TSDemuxer {
+	return &TSDemuxer{
+		OnPES:   onPES,
+		pmtPIDs: make(map[uint16]bool),
+		streams: make(map[uint16]byte),
+		pending: make(map[uint16][]byte),
+		lastTs:  make(map[uint16]int64),
+	}
+}
+
+// Feed demuxes a chunk of whole TS packets, skipping anything before the first sync byte.
+func (d *TSDemuxer) Feed(data []byte) error {
+	start := -1
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0x47 && (i+tsPacketSize >= len(data) || data[i+tsPacketSize] == 0x47) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return fmt.Errorf("no MPEG-TS sync byte found")
+	}
+	for pos := start; pos+tsPacketSize <= len(data); pos += tsPacketSize {
+		d.readPacket(data[pos : pos+tsPacketSize])
+	}
+	return nil
+}
+
+// Flush emits the PES packets still being assembled.
+func (d *TSDemuxer) Flush() {
+	for pid := range d.pending {
+		d.emit(pid)
+	}
+}
+
+func (d *TSDemuxer) readPacket(packet []byte) {
+	if packet[0] != 0x47 {
+		return
+	}
+	pid := binary.BigEndian.Uint16(packet[1:3]) & 0x1fff
+	unitStart := packet[1]&0x40 != 0
+	afc := packet[3] >> 4 & 0x3
+	if afc&0x1 == 0 {
+		return
+	}
+	payload := packet[4:]
+	if afc&0x2 != 0 {
+		if len(payload) == 0 || int(payload[0])+1 > len(payload) {
+			return
+		}
+		payload = payload[1+int(payload[0]):]
+	}
+
+	switch {
+	case pid == 0:
+		d.readPAT(psiSection(payload, unitStart))
+	case d.pmtPIDs[pid]:
+		d.readPMT(psiSection(payload, unitStart))
+	default:
+		if _, ok := d.streams[pid]; !ok {
+			return
+		}
+		if unitStart {
+			d.emit(pid)
+			d.pending[pid] = append([]byte(nil), payload...)
+		} else if buf, ok := d.pending[pid]; ok {
+			d.pending[pid] = append(buf, payload...)
+		}
+	}
+}
+
+func psiSection(payload []byte, unitStart bool) []byte {
+	if !unitStart || len(payload) == 0 || int(payload[0])+1 >= len(payload) {
+		return nil
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 3 {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0fff)
+	if 3+length > len(section) || length < 4 {
+		return nil
+	}
+	return section[:3+length-4] // drop CRC32
+}
+
+func (d *TSDemuxer) readPAT(section []byte) {
+	for i := 8; i+4 <= len(section); i += 4 {
+		if binary.BigEndian.Uint16(section[i:]) != 0 {
+			d.pmtPIDs[binary.BigEndian.Uint16(section[i+2:])&0x1fff] = true
+		}
+	}
+}
+
+func (d *TSDemuxer) readPMT(section []byte) {
+	if len(section) < 12 {
+		return
+	}
+	infoLength := int(binary.BigEndian.Uint16(section[10:12]) & 0x0fff)
+	for i := 12 + infoLength; i+5 <= len(section); {
+		streamType := section[i]
+		pid := binary.BigEndian.Uint16(section[i+1:]) & 0x1fff
+		esInfoLength := int(binary.BigEndian.Uint16(section[i+3:]) & 0x0fff)
+		if streamType == 0x06 && i+5+esInfoLength <= len(section) && hasDescriptor(section[i+5:i+5+esInfoLength], 0x6A) {
+			streamType = StreamTypeAC3
+		}
+		switch streamType {
+		case StreamTypeAAC, StreamTypeH264, StreamTypeH265, StreamTypeAC3:
+			d.streams[pid] = streamType
+		}
+		i += 5 + esInfoLength
+	}
+}
+
+func hasDescriptor(descriptors []byte, tag byte) bool {
+	for i := 0; i+2 <= len(descriptors); i += 2 + int(descriptors[i+1]) {
+		if descriptors[i] == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *TSDemuxer) emit(pid uint16) {
+	buf, ok := d.pending[pid]
+	delete(d.pending, pid)
+	if !ok || len(buf) < 9 || buf[0] != 0 || buf[1] != 0 || buf[2] != 1 {
+		return
+	}
+	flags := buf[7]
+	headerEnd := 9 + int(buf[8])
+	if headerEnd > len(buf) {
+		return
+	}
+	pes := &PES{PID: pid, StreamType: d.streams[pid], PTS: noTimestamp, DTS: noTimestamp, Data: buf[headerEnd:]}
+	if flags&0x80 != 0 && len(buf) >= 14 {
+		pes.PTS = readTimestamp(buf[9:])
+	}
+	if flags&0x40 != 0 && len(buf) >= 19 {
+		pes.DTS = readTimestamp(buf[14:])
+	}
+	if pes.PTS != noTimestamp {
+		if pes.DTS == noTimestamp {
+			pes.DTS = pes.PTS
+		}
+		pes.DTS = d.unwrap(pid, pes.DTS, true)
+		pes.PTS = d.unwrap(pid, pes.PTS, false)
+	}
+	if d.OnPES != nil {
+		d.OnPES(pes)
+	}
+}
+
+// unwrap places a 33-bit timestamp on the continuous timeline closest to the
+// last DTS seen on the same PID.
+func (d *TSDemuxer) unwrap(pid uint16, ts int64, update bool) int64 {
+	last, ok := d.lastTs[pid]
+	if ok {
+		ts += (last / ptsWrap) * ptsWrap
+		for ts < last-ptsWrap/2 {
+			ts += ptsWrap
+		}
+		for ts > last+ptsWrap/2 {
+			ts -= ptsWrap
+		}
+	}
+	if update {
+		d.lastTs[pid] = ts
+	}
+	return ts
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 |
+		int64(b[1])<<22 | int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 | int64(b[4]>>1)
+}
@@ -0,0 +1,268 @@
+package remux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type trackState struct {
+	track      *mp4Track
+	streamType byte
+	video      *videoConfig
+	audio      *audioConfig
+	// first presentation time on the virtual 90kHz timeline
+	firstPTS int64
+	// end of the last sample on the virtual 90kHz timeline
+	endVTS int64
+}
+
+// TSRemuxer converts MPEG-TS segments (H.264/H.265 video, AAC/AC-3 audio) into
+// a single progressive MP4 without ffmpeg.
+//
+// Each MediaPart starts a new timeline in the source, so every part is shifted
+// to begin where the previous one ended. Within a part timestamps are unwrapped
+// across the 33-bit PTS rollover.
+type TSRemuxer struct {
+	out       *bufio.Writer
+	offset    uint64
+	tracks    map[uint16]*trackState
+	order     []*trackState
+	partBase  int64
+	partStart int64
+	err       error
+}
+
+// RemuxTSToMP4 remuxes parts, each an ordered list of TS segment files, into output.
+func RemuxTSToMP4(parts [][]string, output string) error {
+	if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := &TSRemuxer{out: bufio.NewWriterSize(file, 1<<20), tracks: make(map[uint16]*trackState)}
+	ftyp := ftypBox()
+	r.write(ftyp)
+	// mdat with a 64-bit size, patched once all samples are written
+	r.write([]byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 0})
+
+	for _, part := range parts {
+		r.partBase = noTimestamp
+		r.partStart = 0
+		for _, t := range r.order {
+			r.partStart = max(r.partStart, t.endVTS)
+		}
+		demuxer := NewTSDemuxer(r.onPES)
+		for _, path := range part {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := demuxer.Feed(data); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		demuxer.Flush()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if err := r.out.Flush(); err != nil {
+		return err
+	}
+
+	tracks, err := r.finalizeTracks()
+	if err != nil {
+		return err
+	}
+	if len(tracks) == 0 {
+		return fmt.Errorf("no supported audio or video stream found")
+	}
+	mdatSize := make([]byte, 8)
+	binary.BigEndian.PutUint64(mdatSize, r.offset-uint64(len(ftyp)))
+	if _, err := file.WriteAt(mdatSize, int64(len(ftyp))+8); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	_, err = file.Write(moovBox(tracks))
+	return err
+}
+
+func (r *TSRemuxer) write(data []byte) uint64 {
+	offset := r.offset
+	if r.err == nil {
+		_, r.err = r.out.Write(data)
+	}
+	r.offset += uint64(len(data))
+	return offset
+}
+
+func (r *TSRemuxer) trackFor(pes *PES) *trackState {
+	if t, ok := r.tracks[pes.PID]; ok {
+		return t
+	}
+	t := &trackState{
+		streamType: pes.StreamType,
+		track:      &mp4Track{id: uint32(len(r.order) + 1)},
+		firstPTS:   noTimestamp,
+	}
+	switch pes.StreamType {
+	case StreamTypeH264, StreamTypeH265:
+		t.video = &videoConfig{hevc: pes.StreamType == StreamTypeH265}
+		t.track.handler = "vide"
+		t.track.timescale = 90000
+	default:
+		t.audio = &audioConfig{}
+		t.track.handler = "soun"
+	}
+	r.tracks[pes.PID] = t
+	r.order = append(r.order, t)
+	return t
+}
+
+func (r *TSRemuxer) onPES(pes *PES) {
+	t := r.trackFor(pes)
+	if pes.DTS != noTimestamp && r.partBase == noTimestamp {
+		r.partBase = pes.DTS
+	}
+
+	vdts, vpts := t.endVTS, t.endVTS
+	if pes.DTS != noTimestamp {
+		vdts = pes.DTS - r.partBase + r.partStart
+		vpts = pes.PTS - r.partBase + r.partStart
+	}
+	if t.firstPTS == noTimestamp || vpts < t.firstPTS {
+		t.firstPTS = vpts
+	}
+
+	if t.video != nil {
+		data, keyframe, err := t.video.toSample(pes.Data)
+		if err != nil {
+			if r.err == nil {
+				r.err = fmt.Errorf("PID %d: %w", pes.PID, err)
+			}
+			return
+		}
+		if len(data) == 0 {
+			return
+		}
+		dts := max(vdts, t.lastDTS()+1)
+		frameDuration := int64(1)
+		if n := len(t.track.samples); n > 0 {
+			frameDuration = dts - t.track.samples[n-1].dts
+		}
+		t.track.samples = append(t.track.samples, mp4Sample{
+			offset: r.write(data),
+			size:   uint32(len(data)),
+			dts:    dts,
+			cts:    int32(vpts - dts),
+			sync:   keyframe,
+		})
+		t.endVTS = max(t.endVTS, dts+frameDuration)
+		return
+	}
+
+	var frames [][]byte
+	if pes.StreamType == StreamTypeAC3 {
+		frames = t.audio.splitAC3(pes.Data)
+	} else {
+		frames = t.audio.splitADTS(pes.Data)
+	}
+	if len(frames) == 0 || t.audio.sampleRate == 0 {
+		return
+	}
+	rate := int64(t.audio.sampleRate)
+	t.track.timescale = uint32(rate)
+	spf := int64(t.audio.samplesPerFr)
+	dts := vdts * rate / 90000
+	for _, frame := range frames {
+		dts = max(dts, t.lastDTS()+1)
+		t.track.samples = append(t.track.samples, mp4Sample{
+			offset: r.write(frame),
+			size:   uint32(len(frame)),
+			dts:    dts,
+			sync:   true,
+		})
+		dts += spf
+	}
+	t.endVTS = max(t.endVTS, dts*90000/rate)
+}
+
+func (t *trackState) lastDTS() int64 {
+	if len(t.track.samples) == 0 {
+		return -1 << 62
+	}
+	return t.track.samples[len(t.track.samples)-1].dts
+}
+
+// finalizeTracks fills in durations, sample entries and edit-list offsets.
+func (r *TSRemuxer) finalizeTracks() ([]*mp4Track, error) {
+	var tracks []*mp4Track
+	globalStart := int64(-1)
+	for _, t := range r.order {
+		if len(t.track.samples) > 0 && (globalStart < 0 || t.firstPTS < globalStart) {
+			globalStart = t.firstPTS
+		}
+	}
+
+	for _, t := range r.order {
+		tr := t.track
+		if len(tr.samples) == 0 {
+			continue
+		}
+		switch {
+		case t.video != nil && t.video.sps != nil && t.video.pps != nil:
+			tr.width, tr.height = t.video.width, t.video.height
+			if t.video.hevc {
+				tr.sampleEntry = visualSampleEntry("hvc1", tr.width, tr.height, box("hvcC", t.video.hvcC()))
+			} else {
+				avcC, err := t.video.avcC()
+				if err != nil {
+					return nil, err
+				}
+				tr.sampleEntry = visualSampleEntry("avc1", tr.width, tr.height, box("avcC", avcC))
+			}
+		case t.audio != nil && t.audio.ac3:
+			tr.sampleEntry = audioSampleEntry("ac-3", t.audio.channels, t.audio.sampleRate, box("dac3", t.audio.dac3()))
+		case t.audio != nil:
+			tr.sampleEntry = audioSampleEntry("mp4a", t.audio.channels, t.audio.sampleRate, box("esds", t.audio.esds()))
+		default:
+			continue
+		}
+
+		// Rebase decode times to zero and derive durations from the next sample.
+		base := tr.samples[0].dts
+		nominal := int64(1)
+		if t.audio != nil {
+			nominal = int64(t.audio.samplesPerFr)
+		}
+		minCompose := int64(-1)
+		for i := range tr.samples {
+			tr.samples[i].dts -= base
+			if i > 0 {
+				d := tr.samples[i].dts - tr.samples[i-1].dts
+				tr.samples[i-1].duration = uint32(d)
+				nominal = d
+			}
+			ct := tr.samples[i].dts + int64(tr.samples[i].cts)
+			if minCompose < 0 || ct < minCompose {
+				minCompose = ct
+			}
+		}
+		tr.samples[len(tr.samples)-1].duration = uint32(nominal)
+		tr.mediaTime = max(minCompose, 0)
+		tr.startOffset = (t.firstPTS - globalStart) * int64(tr.timescale) / 90000
+
+		tracks = append(tracks, tr)
+	}
+	return tracks, nil
+}
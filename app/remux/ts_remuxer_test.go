@@ -0,0 +1,245 @@
+package remux
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testSPS is a baseline H.264 SPS for 320x240 progressive video.
+func testSPS() []byte {
+	var out []byte
+	n := 0
+	put := func(v uint32, count int) {
+		for i := count - 1; i >= 0; i-- {
+			if n%8 == 0 {
+				out = append(out, 0)
+			}
+			if v>>i&1 == 1 {
+				out[len(out)-1] |= 0x80 >> (n % 8)
+			}
+			n++
+		}
+	}
+	ue := func(v uint32) {
+		v++
+		put(0, bits.Len32(v)-1)
+		put(v, bits.Len32(v))
+	}
+	put(66, 8)   // profile_idc
+	put(0xC0, 8) // constraint flags
+	put(30, 8)   // level_idc
+	ue(0)        // seq_parameter_set_id
+	ue(0)        // log2_max_frame_num_minus4
+	ue(2)        // pic_order_cnt_type
+	ue(1)        // max_num_ref_frames
+	put(0, 1)    // gaps_in_frame_num_value_allowed_flag
+	ue(19)       // pic_width_in_mbs_minus1
+	ue(14)       // pic_height_in_map_units_minus1
+	put(1, 1)    // frame_mbs_only_flag
+	put(1, 1)    // direct_8x8_inference_flag
+	put(0, 1)    // frame_cropping_flag
+	put(0, 1)    // vui_parameters_present_flag
+	put(1, 1)    // rbsp_stop_one_bit
+	return append([]byte{0x67}, out...)
+}
+
+// testAU builds an Annex B access unit; keyframes carry the parameter sets and an IDR slice.
+func testAU(keyframe bool, n byte) []byte {
+	au := []byte{0, 0, 0, 1, 0x09, 0xF0}
+	if keyframe {
+		au = append(au, 0, 0, 0, 1)
+		au = append(au, testSPS()...)
+		au = append(au, 0, 0, 0, 1, 0x68, 0xCE, 0x38, 0x80)
+		return append(au, 0, 0, 0, 1, 0x65, 0x88, 0x80, n)
+	}
+	return append(au, 0, 0, 0, 1, 0x41, 0x9A, n)
+}
+
+// testADTS builds 48kHz stereo AAC-LC ADTS frames with payloads of the given sizes.
+func testADTS(sizes ...int) []byte {
+	var out []byte
+	for i, size := range sizes {
+		frameLen := 7 + size
+		out = append(out, 0xFF, 0xF1, 1<<6|3<<2, 2<<6|byte(frameLen>>11)&0x03, byte(frameLen>>3), byte(frameLen&7)<<5|0x1F, 0xFC)
+		for j := 0; j < size; j++ {
+			out = append(out, byte(i))
+		}
+	}
+	return out
+}
+
+// childBoxes returns the payloads of the boxes of boxType directly inside data.
+func childBoxes(data []byte, boxType string) [][]byte {
+	var found [][]byte
+	for pos := 0; pos+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		header := 8
+		if size == 1 && pos+16 <= len(data) {
+			size, header = int(binary.BigEndian.Uint64(data[pos+8:])), 16
+		}
+		if size < header || pos+size > len(data) {
+			break
+		}
+		if string(data[pos+4:pos+8]) == boxType {
+			found = append(found, data[pos+header:pos+size])
+		}
+		pos += size
+	}
+	return found
+}
+
+// childBox follows a path of box types, taking the first match at each level.
+func childBox(t *testing.T, data []byte, path ...string) []byte {
+	t.Helper()
+	for _, boxType := range path {
+		boxes := childBoxes(data, boxType)
+		if len(boxes) == 0 {
+			t.Fatalf("no %s box in %v", boxType, path)
+		}
+		data = boxes[0]
+	}
+	return data
+}
+
+// tableEntries reads the uint32 fields of a full box table after its entry count.
+func tableEntries(payload []byte) []uint32 {
+	var values []uint32
+	for pos := 8; pos+4 <= len(payload); pos += 4 {
+		values = append(values, binary.BigEndian.Uint32(payload[pos:]))
+	}
+	return values
+}
+
+type editEntry struct {
+	duration  uint64
+	mediaTime int64
+}
+
+func editList(payload []byte) []editEntry {
+	var entries []editEntry
+	for pos := 8; pos+20 <= len(payload); pos += 20 {
+		entries = append(entries, editEntry{binary.BigEndian.Uint64(payload[pos:]), int64(binary.BigEndian.Uint64(payload[pos+8:]))})
+	}
+	return entries
+}
+
+func TestRemuxTSToMP4(t *testing.T) {
+	dir := t.TempDir()
+	const video, audio = 0x100, 0x101
+	segment := func(name string, write func(w *tsWriter)) string {
+		w := newTSWriter(tsStream{video, StreamTypeH264}, tsStream{audio, StreamTypeAAC})
+		write(w)
+		return w.save(t, filepath.Join(dir, name))
+	}
+	parts := [][]string{
+		{
+			segment("a0.ts", func(w *tsWriter) {
+				// I P B in decode order, 3000 ticks apart
+				w.pes(video, 0xE0, 903000, 900000, testAU(true, 0))
+				// audio starts one frame after the video is presented
+				w.pes(audio, 0xC0, 906000, 906000, testADTS(10, 11))
+				w.pes(video, 0xE0, 909000, 903000, testAU(false, 1))
+			}),
+			segment("a1.ts", func(w *tsWriter) {
+				w.pes(video, 0xE0, 906000, 906000, testAU(false, 2))
+			}),
+		},
+		{
+			// a new timeline, shifted to where the first part's longest track ended
+			segment("b0.ts", func(w *tsWriter) {
+				w.pes(video, 0xE0, 5003000, 5000000, testAU(true, 3))
+				w.pes(audio, 0xC0, 5003000, 5003000, testADTS(12))
+				w.pes(video, 0xE0, 5006000, 5003000, testAU(false, 4))
+			}),
+		},
+	}
+	output := filepath.Join(dir, "out", "merged.mp4")
+	if err := RemuxTSToMP4(parts, output); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traks := childBoxes(childBox(t, data, "moov"), "trak")
+	if len(traks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(traks))
+	}
+
+	// The audio ends its part 9840 ticks in: 3200 + 2*1024 samples at 48kHz.
+	// Video of the second part starts there, 3840 ticks after the B-frame.
+	tests := []struct {
+		name      string
+		trak      []byte
+		timescale uint32
+		stts      []uint32
+		ctts      []uint32
+		stss      []uint32
+		stsz      []uint32
+		edits     []editEntry
+	}{
+		{
+			"video", traks[0], 90000,
+			[]uint32{2, 3000, 1, 3840, 2, 3000},
+			[]uint32{1, 3000, 1, 6000, 1, 0, 2, 3000},
+			[]uint32{1, 4},
+			[]uint32{8, 7, 7, 8, 7},
+			// the composition offset of the first picture is cut
+			[]editEntry{{176, 3000}},
+		},
+		{
+			"audio", traks[1], 48000,
+			// the gap between the parts stays in the last first-part duration
+			[]uint32{1, 1024, 2, 2624},
+			nil,
+			nil,
+			[]uint32{10, 11, 12},
+			// presented 3000 ticks after the video, i.e. 1600 samples or 33ms
+			[]editEntry{{33, -1}, {130, 0}},
+		},
+	}
+	for _, tt := range tests {
+		mdia := childBox(t, tt.trak, "mdia")
+		if ts := binary.BigEndian.Uint32(childBox(t, mdia, "mdhd")[20:]); ts != tt.timescale {
+			t.Errorf("%s: timescale %d, want %d", tt.name, ts, tt.timescale)
+		}
+		stbl := childBox(t, mdia, "minf", "stbl")
+		if got := tableEntries(childBox(t, stbl, "stts")); !reflect.DeepEqual(got, tt.stts) {
+			t.Errorf("%s: stts %v, want %v", tt.name, got, tt.stts)
+		}
+		var ctts, stss []uint32
+		if boxes := childBoxes(stbl, "ctts"); len(boxes) > 0 {
+			ctts = tableEntries(boxes[0])
+		}
+		if boxes := childBoxes(stbl, "stss"); len(boxes) > 0 {
+			stss = tableEntries(boxes[0])
+		}
+		if !reflect.DeepEqual(ctts, tt.ctts) {
+			t.Errorf("%s: ctts %v, want %v", tt.name, ctts, tt.ctts)
+		}
+		if !reflect.DeepEqual(stss, tt.stss) {
+			t.Errorf("%s: stss %v, want %v", tt.name, stss, tt.stss)
+		}
+		// stsz has a sample size and a count before the table
+		if got := tableEntries(childBox(t, stbl, "stsz")[4:]); !reflect.DeepEqual(got, tt.stsz) {
+			t.Errorf("%s: stsz %v, want %v", tt.name, got, tt.stsz)
+		}
+		if got := editList(childBox(t, tt.trak, "edts", "elst")); !reflect.DeepEqual(got, tt.edits) {
+			t.Errorf("%s: elst %+v, want %+v", tt.name, got, tt.edits)
+		}
+	}
+
+	tkhd := childBox(t, traks[0], "tkhd")
+	if w, h := binary.BigEndian.Uint32(tkhd[len(tkhd)-8:])>>16, binary.BigEndian.Uint32(tkhd[len(tkhd)-4:])>>16; w != 320 || h != 240 {
+		t.Errorf("video size %dx%d, want 320x240", w, h)
+	}
+	// the first video sample is the length-prefixed IDR slice, without the parameter sets
+	offset := tableEntries(childBox(t, traks[0], "mdia", "minf", "stbl", "stco"))[0]
+	if got, want := data[offset:offset+8], []byte{0, 0, 0, 4, 0x65, 0x88, 0x80, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first video sample % x, want % x", got, want)
+	}
+}
@@ -0,0 +1,32 @@
+package remux
+
+import (
+	"fmt"
+	"os"
+)
+
+// FirstVideoPTS returns the lowest 90kHz PTS of the video in a TS segment,
+// which is what WebVTT X-TIMESTAMP-MAP values are relative to.
+func FirstVideoPTS(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	first := noTimestamp
+	demuxer := NewTSDemuxer(func(pes *PES) {
+		if pes.PTS == noTimestamp || pes.StreamType != StreamTypeH264 && pes.StreamType != StreamTypeH265 {
+			return
+		}
+		if first == noTimestamp || pes.PTS < first {
+			first = pes.PTS
+		}
+	})
+	if err := demuxer.Feed(data); err != nil {
+		return 0, err
+	}
+	demuxer.Flush()
+	if first == noTimestamp {
+		return 0, fmt.Errorf("no video timestamps in %s", path)
+	}
+	return first % ptsWrap, nil
+}
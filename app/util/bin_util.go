@@ -0,0 +1,43 @@
+package util
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// FindExecutable looks for the first of names in dirs, then next to our own
+// executable, then on PATH. An entry in dirs may also be the binary itself.
+func FindExecutable(names []string, dirs ...string) string {
+	if exePath, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exePath))
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			return dir
+		}
+		for _, name := range names {
+			candidate := filepath.Join(dir, withExeSuffix(name))
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+	}
+	for _, name := range names {
+		if path, err := exec.LookPath(withExeSuffix(name)); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func withExeSuffix(name string) string {
+	if runtime.GOOS == "windows" && filepath.Ext(name) != ".exe" {
+		return name + ".exe"
+	}
+	return name
+}
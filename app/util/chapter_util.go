@@ -0,0 +1,164 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// Chapter is one chapter marker on the output timeline.
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// Chapter sources in order of preference when two markers land together.
+const (
+	chapterFromPart = iota
+	chapterFromSegmentTitle
+	chapterFromDateRange
+)
+
+// chapterMergeWindow is how close two markers must be to count as the same chapter.
+const chapterMergeWindow = time.Second
+
+type chapterMark struct {
+	start    time.Duration
+	title    string
+	priority int
+}
+
+// BuildChapters derives chapters from a stream's playlist: MediaPart boundaries
+// (named after the DASH Period when known), EXTINF titles and EXT-X-DATERANGE
+// tags. It returns nil when the playlist has nothing better than one untitled chapter.
+func BuildChapters(spec *entity.StreamSpec) []Chapter {
+	if spec == nil || spec.Playlist == nil {
+		return nil
+	}
+	playlist := spec.Playlist
+	total := time.Duration(*playlist.GetTotalDuration() * float64(time.Second))
+
+	var marks []chapterMark
+	var offset time.Duration
+	lastTitle := ""
+	for i, part := range playlist.MediaParts {
+		title := fmt.Sprintf("Part %d", i+1)
+		if part.PeriodId != nil && *part.PeriodId != "" {
+			title = *part.PeriodId
+		} else if len(playlist.MediaParts) == 1 && spec.PeriodId != nil && *spec.PeriodId != "" {
+			title = *spec.PeriodId
+		}
+		if len(playlist.MediaParts) > 1 || title != "Part 1" {
+			marks = append(marks, chapterMark{start: offset, title: title, priority: chapterFromPart})
+		}
+		for _, seg := range part.MediaSegments {
+			if seg.Title != nil {
+				if t := strings.TrimSpace(*seg.Title); t != "" && t != lastTitle {
+					marks = append(marks, chapterMark{start: offset, title: t, priority: chapterFromSegmentTitle})
+					lastTitle = t
+				}
+			}
+			offset += time.Duration(seg.Duration * float64(time.Second))
+		}
+	}
+	for _, dr := range playlist.DateRanges {
+		if start, ok := dateRangeOffset(playlist, dr.StartDate); ok {
+			marks = append(marks, chapterMark{start: start, title: dr.Title(), priority: chapterFromDateRange})
+		}
+	}
+	if len(marks) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(marks, func(i, j int) bool { return marks[i].start < marks[j].start })
+	var merged []chapterMark
+	for _, m := range marks {
+		if m.start < 0 || m.start >= total && total > 0 {
+			continue
+		}
+		if n := len(merged); n > 0 && m.start-merged[n-1].start < chapterMergeWindow {
+			if m.priority > merged[n-1].priority {
+				merged[n-1].title = m.title
+				merged[n-1].priority = m.priority
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	// Chapters must cover the timeline from zero.
+	if merged[0].start >= chapterMergeWindow {
+		merged = append([]chapterMark{{start: 0, title: "Intro"}}, merged...)
+	} else {
+		merged[0].start = 0
+	}
+
+	chapters := make([]Chapter, len(merged))
+	for i, m := range merged {
+		end := total
+		if i+1 < len(merged) {
+			end = merged[i+1].start
+		}
+		chapters[i] = Chapter{Start: m.start, End: end, Title: m.title}
+	}
+	return chapters
+}
+
+// dateRangeOffset maps a wall-clock date onto the playlist timeline using
+// EXT-X-PROGRAM-DATE-TIME of the nearest preceding segment.
+func dateRangeOffset(playlist *entity.Playlist, date time.Time) (time.Duration, bool) {
+	var offset time.Duration
+	found := false
+	var result time.Duration
+	for _, part := range playlist.MediaParts {
+		for _, seg := range part.MediaSegments {
+			if seg.DateTime != nil && !seg.DateTime.After(date) {
+				result = offset + date.Sub(*seg.DateTime)
+				found = true
+			}
+			offset += time.Duration(seg.Duration * float64(time.Second))
+		}
+	}
+	return result, found
+}
+
+// WriteFFMetadata writes chapters in ffmpeg's FFMETADATA1 format.
+func WriteFFMetadata(chapters []Chapter, path string) error {
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	for _, c := range chapters {
+		fmt.Fprintf(&sb, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			c.Start.Milliseconds(), c.End.Milliseconds(), escapeFFMetadata(c.Title))
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+func escapeFFMetadata(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// WriteOgmChapters writes chapters in the OGM text format (CHAPTER01=..., CHAPTER01NAME=...).
+func WriteOgmChapters(chapters []Chapter, path string) error {
+	var sb strings.Builder
+	for i, c := range chapters {
+		ms := c.Start.Milliseconds()
+		fmt.Fprintf(&sb, "CHAPTER%02d=%02d:%02d:%02d.%03d\n", i+1, ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+		fmt.Fprintf(&sb, "CHAPTER%02dNAME=%s\n", i+1, strings.ReplaceAll(c.Title, "\n", " "))
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// testSegments returns count segments of seconds each; titles, when given, name them in order.
+func testSegments(count int, seconds float64, titles ...string) []entity.MediaSegment {
+	segments := make([]entity.MediaSegment, count)
+	for i := range segments {
+		segments[i].Duration = seconds
+		if i < len(titles) && titles[i] != "" {
+			segments[i].Title = &titles[i]
+		}
+	}
+	return segments
+}
+
+func TestBuildChapters(t *testing.T) {
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	period := "Episode"
+	programStart := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	withDate := testSegments(6, 10)
+	withDate[0].DateTime = &programStart
+
+	tests := []struct {
+		name  string
+		parts []entity.MediaPart
+		dates []entity.DateRange
+		want  []Chapter
+	}{
+		{
+			"one part",
+			[]entity.MediaPart{{MediaSegments: testSegments(3, 10)}},
+			nil,
+			nil,
+		},
+		{
+			"parts",
+			[]entity.MediaPart{{MediaSegments: testSegments(3, 10)}, {MediaSegments: testSegments(2, 10), PeriodId: &period}},
+			nil,
+			[]Chapter{{0, sec(30), "Part 1"}, {sec(30), sec(50), "Episode"}},
+		},
+		{
+			"segment titles, repeats ignored",
+			[]entity.MediaPart{{MediaSegments: testSegments(4, 10, "", "Ad", "Ad", "Show")}},
+			nil,
+			[]Chapter{{0, sec(10), "Intro"}, {sec(10), sec(30), "Ad"}, {sec(30), sec(40), "Show"}},
+		},
+		{
+			"segment title wins over the part at the same place",
+			[]entity.MediaPart{{MediaSegments: testSegments(2, 10, "Opening")}, {MediaSegments: testSegments(2, 10, "Credits")}},
+			nil,
+			[]Chapter{{0, sec(20), "Opening"}, {sec(20), sec(40), "Credits"}},
+		},
+		{
+			"date ranges",
+			[]entity.MediaPart{{MediaSegments: withDate}},
+			[]entity.DateRange{
+				{Id: "ad-1", StartDate: programStart.Add(20500 * time.Millisecond)},
+				{Id: "x", StartDate: programStart.Add(40 * time.Second), Attributes: map[string]string{"X-TITLE": "Second half"}},
+				// before the first dated segment and after the end
+				{Id: "early", StartDate: programStart.Add(-time.Minute)},
+				{Id: "late", StartDate: programStart.Add(time.Hour)},
+			},
+			[]Chapter{{0, sec(20.5), "Intro"}, {sec(20.5), sec(40), "ad-1"}, {sec(40), sec(60), "Second half"}},
+		},
+	}
+	for _, tt := range tests {
+		spec := &entity.StreamSpec{Playlist: &entity.Playlist{MediaParts: tt.parts, DateRanges: tt.dates}}
+		if got := BuildChapters(spec); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteFFMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chapters.txt")
+	chapters := []Chapter{
+		{Start: 0, End: 1500 * time.Millisecond, Title: "Plain"},
+		{Start: 1500 * time.Millisecond, End: time.Hour, Title: "a=b; #1 \\ two\nlines"},
+	}
+	if err := WriteFFMetadata(chapters, path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ";FFMETADATA1\n" +
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=1500\ntitle=Plain\n" +
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=1500\nEND=3600000\n" +
+		`title=a\=b\; \#1 \\ two\` + "\nlines\n"
+	if string(got) != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestWriteOgmChapters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chapters.txt")
+	chapters := []Chapter{
+		{Start: 0, Title: "Intro"},
+		{Start: 61*time.Minute + 2*time.Second + 3*time.Millisecond, Title: "Two\nlines"},
+	}
+	if err := WriteOgmChapters(chapters, path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "CHAPTER01=00:00:00.000\nCHAPTER01NAME=Intro\n" +
+		"CHAPTER02=01:01:02.003\nCHAPTER02NAME=Two lines\n"
+	if string(got) != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// InspectDrm combines the manifest's protection data already on spec.DrmInfo with
+// what the init segment declares, decoding any manifest PSSH that is still raw.
+// It returns nil for streams that carry no protection information at all.
+func InspectDrm(spec *entity.StreamSpec, initInfo *mp4.ParsedMP4Info) *entity.DrmInfo {
+	drm := &entity.DrmInfo{}
+	if spec.DrmInfo != nil {
+		drm.Scheme = spec.DrmInfo.Scheme
+		for _, kid := range spec.DrmInfo.KIDs {
+			drm.AddKID(kid)
+		}
+		for _, pssh := range spec.DrmInfo.Pssh {
+			if pssh.SystemID == "" && pssh.Data != "" {
+				if decoded, err := mp4.ParsePsshBase64(pssh.Data); err == nil {
+					pssh = *decoded
+				}
+			}
+			drm.Pssh = appendPssh(drm.Pssh, pssh)
+		}
+	}
+
+	if initInfo != nil {
+		if initInfo.Scheme != "" {
+			drm.Scheme = initInfo.Scheme
+		}
+		drm.AddKID(initInfo.KID)
+		for _, pssh := range initInfo.PSSH {
+			drm.Pssh = appendPssh(drm.Pssh, pssh)
+		}
+	}
+
+	if spec.Playlist != nil {
+		if spec.Playlist.MediaInit != nil {
+			drm.AddKID(spec.Playlist.MediaInit.EncryptInfo.KID)
+		}
+		for _, part := range spec.Playlist.MediaParts {
+			for _, segment := range part.MediaSegments {
+				drm.AddKID(segment.EncryptInfo.KID)
+			}
+		}
+	}
+
+	if drm.Scheme == "" && len(drm.KIDs) == 0 && len(drm.Pssh) == 0 {
+		return nil
+	}
+	return drm
+}
+
+func appendPssh(list []entity.PsshInfo, pssh entity.PsshInfo) []entity.PsshInfo {
+	for _, p := range list {
+		if p.Data == pssh.Data {
+			return list
+		}
+	}
+	return append(list, pssh)
+}
+
+// FormatKeysReport renders one block per protected stream listing its KIDs and PSSH boxes.
+func FormatKeysReport(specs []*entity.StreamSpec) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STREAM\tSCHEME\tKID\tSYSTEM\tPROVIDER\tCONTENT ID")
+	for _, spec := range specs {
+		drm := spec.DrmInfo
+		if drm == nil {
+			continue
+		}
+		name := spec.ToShortShortString()
+		kids := strings.Join(drm.KIDs, ",")
+		if len(drm.Pssh) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\t\t\n", name, drm.Scheme, kids)
+			continue
+		}
+		for i, pssh := range drm.Pssh {
+			psshKids := kids
+			if len(pssh.KIDs) > 0 {
+				psshKids = strings.Join(pssh.KIDs, ",")
+			}
+			if i > 0 {
+				name = ""
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, drm.Scheme, psshKids, pssh.System, pssh.Provider, pssh.ContentID)
+		}
+	}
+	w.Flush()
+	return sb.String()
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+func TestInspectDrm(t *testing.T) {
+	video := enums.VIDEO
+	spec := &entity.StreamSpec{
+		MediaType: &video,
+		DrmInfo:   &entity.DrmInfo{Scheme: "cenc", KIDs: []string{"aa"}, Pssh: []entity.PsshInfo{{System: "PlayReady", Data: "pr"}}},
+		Playlist: &entity.Playlist{MediaParts: []entity.MediaPart{{MediaSegments: []entity.MediaSegment{
+			{EncryptInfo: entity.EncryptInfo{KID: "cc"}},
+		}}}},
+	}
+	initInfo := &mp4.ParsedMP4Info{
+		Scheme: "cbcs",
+		KID:    "bb",
+		PSSH:   []entity.PsshInfo{{System: "Widevine", Data: "wv"}, {System: "PlayReady", Data: "pr"}},
+	}
+	drm := InspectDrm(spec, initInfo)
+	if drm == nil {
+		t.Fatal("got nil")
+	}
+	if drm.Scheme != "cbcs" {
+		t.Errorf("scheme %q, want the init segment's", drm.Scheme)
+	}
+	if got := strings.Join(drm.KIDs, ","); got != "aa,bb,cc" {
+		t.Errorf("KIDs %s, want aa,bb,cc", got)
+	}
+	if len(drm.Pssh) != 2 {
+		t.Errorf("got %d pssh boxes, want the repeated one once", len(drm.Pssh))
+	}
+	if drm := InspectDrm(&entity.StreamSpec{MediaType: &video}, &mp4.ParsedMP4Info{}); drm != nil {
+		t.Errorf("clear stream: got %+v", drm)
+	}
+}
+
+func TestFormatKeysReport(t *testing.T) {
+	video, audio := enums.VIDEO, enums.AUDIO
+	withPssh := &entity.StreamSpec{MediaType: &video, DrmInfo: &entity.DrmInfo{
+		Scheme: "cenc",
+		KIDs:   []string{"kid1", "kid2"},
+		Pssh: []entity.PsshInfo{
+			{System: "Widevine", KIDs: []string{"kid1"}, Provider: "provider", ContentID: "movie"},
+			{System: "PlayReady", Provider: "https://pr.example/"},
+		},
+	}}
+	kidOnly := &entity.StreamSpec{MediaType: &audio, DrmInfo: &entity.DrmInfo{Scheme: "cbcs", KIDs: []string{"kid3"}}}
+	clear := &entity.StreamSpec{MediaType: &audio}
+
+	lines := strings.Split(strings.TrimSuffix(FormatKeysReport([]*entity.StreamSpec{withPssh, clear, kidOnly}), "\n"), "\n")
+	want := []string{
+		`^STREAM +SCHEME +KID +SYSTEM +PROVIDER +CONTENT ID$`,
+		`^` + regexp.QuoteMeta(withPssh.ToShortShortString()) + ` +cenc +kid1 +Widevine +provider +movie$`,
+		// the PSSH without its own KIDs lists the stream's; the name is not repeated
+		`^ +cenc +kid1,kid2 +PlayReady +https://pr\.example/$`,
+		`^` + regexp.QuoteMeta(kidOnly.ToShortShortString()) + ` +cbcs +kid3$`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), strings.Join(lines, "\n"))
+	}
+	for i, pattern := range want {
+		if !regexp.MustCompile(pattern).MatchString(strings.TrimRight(lines[i], " ")) {
+			t.Errorf("line %d: %q does not match %s", i, lines[i], pattern)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+)
+
+var ErrFFmpegNotFound = errors.New("ffmpeg not found")
+
+// maxConcatArgLength keeps a concat: URL well below the Windows command-line limit.
+const maxConcatArgLength = 8000
+
+type FFmpegMergeOptions struct {
+	UseConcatDemuxer bool
+	NoDateInfo       bool
+	// WorkDir holds the concat list and any intermediate files.
+	WorkDir string
+}
+
+// FFmpegMerger remuxes segments with ffmpeg, reporting progress through OnProgress.
+type FFmpegMerger struct {
+	BinaryPath string
+	Logger     *log.Logger
+	OnProgress func(outTime time.Duration, speed string)
+}
+
+// NewFFmpegMerger locates ffmpeg in binaryPath (a file or a directory), tmpDir,
+// next to this program or on PATH.
+func NewFFmpegMerger(binaryPath, tmpDir string, logger *log.Logger) (*FFmpegMerger, error) {
+	path := FindExecutable([]string{"ffmpeg"}, binaryPath, tmpDir)
+	if path == "" {
+		return nil, ErrFFmpegNotFound
+	}
+	return &FFmpegMerger{BinaryPath: path, Logger: logger}, nil
+}
+
+// Merge concatenates files into an MP4 output; other containers come from
+// --mux-after-done. Without the concat demuxer, the concat: protocol is used
+// and the input list is merged in chunks that fit on a command line.
+func (f *FFmpegMerger) Merge(files []string, output string, opts FFmpegMergeOptions) error {
+	var input []string
+	if opts.UseConcatDemuxer {
+		listPath := filepath.Join(opts.WorkDir, "concat_list.txt")
+		if err := os.WriteFile(listPath, []byte(BuildConcatList(files)), 0644); err != nil {
+			return err
+		}
+		defer os.Remove(listPath)
+		input = []string{"-f", "concat", "-safe", "0", "-i", listPath}
+	} else {
+		for round := 0; ; round++ {
+			chunks := SplitConcatChunks(files, maxConcatArgLength)
+			if len(chunks) == 1 {
+				break
+			}
+			var intermediates []string
+			for i, chunk := range chunks {
+				path := filepath.Join(opts.WorkDir, fmt.Sprintf("_concat_%d_%d.ts", round, i))
+				args := BuildFFmpegMergeArgs([]string{"-i", "concat:" + strings.Join(chunk, "|")}, path, "ts", true)
+				if err := f.run(args); err != nil {
+					return err
+				}
+				defer os.Remove(path)
+				intermediates = append(intermediates, path)
+			}
+			files = intermediates
+		}
+		input = []string{"-i", "concat:" + strings.Join(files, "|")}
+	}
+
+	return f.run(BuildFFmpegMergeArgs(input, output, "mp4", opts.NoDateInfo))
+}
+
+// BuildFFmpegMergeArgs returns the ffmpeg arguments for a stream-copy remux of
+// input. format is "mp4", or "ts" for the intermediate files of a chunked merge.
+func BuildFFmpegMergeArgs(input []string, output, format string, noDateInfo bool) []string {
+	args := []string{"-loglevel", "warning", "-nostats", "-progress", "pipe:1", "-y"}
+	args = append(args, input...)
+	args = append(args, "-map", "0:v?", "-map", "0:a?", "-c", "copy")
+
+	if format == "ts" {
+		args = append(args, "-f", "mpegts")
+	} else {
+		args = append(args, "-bsf:a", "aac_adtstoasc", "-movflags", "+faststart", "-f", "mp4")
+	}
+	if !noDateInfo {
+		args = append(args, "-metadata", "creation_time="+time.Now().UTC().Format(time.RFC3339))
+	}
+	return append(args, output)
+}
+
+// BuildConcatList renders files as an ffmpeg concat demuxer list.
+func BuildConcatList(files []string) string {
+	var sb strings.Builder
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		fmt.Fprintf(&sb, "file '%s'\n", strings.ReplaceAll(file, "'", `'\''`))
+	}
+	return sb.String()
+}
+
+// SplitConcatChunks groups files so that each "concat:a|b|..." stays under maxLength.
+func SplitConcatChunks(files []string, maxLength int) [][]string {
+	var chunks [][]string
+	var current []string
+	length := len("concat:")
+	for _, file := range files {
+		if len(current) > 0 && length+len(file)+1 > maxLength {
+			chunks = append(chunks, current)
+			current, length = nil, len("concat:")
+		}
+		current = append(current, file)
+		length += len(file) + 1
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+func (f *FFmpegMerger) run(args []string) error {
+	f.Logger.Debug("%s %s", f.BinaryPath, strings.Join(args, " "))
+	cmd := exec.Command(f.BinaryPath, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// -progress writes key=value blocks, each ending with a progress= line.
+	var outTime time.Duration
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// out_time_ms is misnamed by ffmpeg and is in microseconds too.
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				outTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			if f.OnProgress != nil {
+				f.OnProgress(outTime, value)
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		f.Logger.Warn("ffmpeg: %s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFFmpegMergeArgs(t *testing.T) {
+	input := []string{"-i", "concat:a.ts|b.ts"}
+	prefix := []string{"-loglevel", "warning", "-nostats", "-progress", "pipe:1", "-y", "-i", "concat:a.ts|b.ts", "-map", "0:v?", "-map", "0:a?", "-c", "copy"}
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"mp4", []string{"-bsf:a", "aac_adtstoasc", "-movflags", "+faststart", "-f", "mp4", "out"}},
+		{"ts", []string{"-f", "mpegts", "out"}},
+	}
+	for _, tt := range tests {
+		got := BuildFFmpegMergeArgs(input, "out", tt.format, true)
+		if want := append(append([]string{}, prefix...), tt.want...); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.format, got, want)
+		}
+	}
+
+	args := BuildFFmpegMergeArgs(input, "out", "mp4", false)
+	if n := len(args); n < 3 || args[n-3] != "-metadata" || !strings.HasPrefix(args[n-2], "creation_time=") {
+		t.Errorf("creation_time missing: %q", args)
+	}
+}
+
+func TestSplitConcatChunks(t *testing.T) {
+	files := []string{"aaaa", "bbbb", "cccc", "dddd"}
+	tests := []struct {
+		maxLength int
+		want      [][]string
+	}{
+		// "concat:" is 7 bytes and every file takes its length plus a separator
+		{100, [][]string{files}},
+		{17, [][]string{{"aaaa", "bbbb"}, {"cccc", "dddd"}}},
+		{12, [][]string{{"aaaa"}, {"bbbb"}, {"cccc"}, {"dddd"}}},
+		// a file longer than the limit still gets a chunk of its own
+		{5, [][]string{{"aaaa"}, {"bbbb"}, {"cccc"}, {"dddd"}}},
+	}
+	for _, tt := range tests {
+		if got := SplitConcatChunks(files, tt.maxLength); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("maxLength %d: got %q, want %q", tt.maxLength, got, tt.want)
+		}
+	}
+	if got := SplitConcatChunks(nil, 100); len(got) != 0 {
+		t.Errorf("no files: got %q", got)
+	}
+}
+
+func TestBuildConcatList(t *testing.T) {
+	dir := t.TempDir()
+	got := BuildConcatList([]string{filepath.Join(dir, "a.ts"), filepath.Join(dir, "it's.ts")})
+	want := fmt.Sprintf("file '%s'\nfile '%s'\n", filepath.Join(dir, "a.ts"), filepath.Join(dir, `it'\''s.ts`))
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// fakeFFmpeg writes a script that records each invocation's arguments, one
+// per line, creates the output file and reports progress like ffmpeg does.
+func fakeFFmpeg(t *testing.T) (merger *FFmpegMerger, logPath string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	logPath = filepath.Join(dir, "calls.log")
+	script := `#!/bin/sh
+for arg; do printf '%s\n' "$arg"; done >> "` + logPath + `"
+echo "--" >> "` + logPath + `"
+for last; do :; done
+: > "$last"
+echo out_time_us=1500000
+echo speed=2.5x
+echo progress=end
+`
+	binPath := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(binPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &FFmpegMerger{BinaryPath: binPath}, logPath
+}
+
+// fakeFFmpegCalls returns the arguments of every recorded invocation.
+func fakeFFmpegCalls(t *testing.T, logPath string) [][]string {
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls [][]string
+	var current []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "--" {
+			calls = append(calls, current)
+			current = nil
+			continue
+		}
+		current = append(current, line)
+	}
+	return calls
+}
+
+func argAfter(args []string, flag string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestFFmpegMergerConcatProtocol(t *testing.T) {
+	merger, logPath := fakeFFmpeg(t)
+	workDir := t.TempDir()
+	var progress []string
+	merger.OnProgress = func(outTime time.Duration, speed string) {
+		progress = append(progress, fmt.Sprintf("%s@%s", outTime, speed))
+	}
+
+	// long enough names to need more than one concat: chunk
+	var files []string
+	for i := 0; i < 100; i++ {
+		files = append(files, filepath.Join(workDir, fmt.Sprintf("%s_%05d.ts", strings.Repeat("s", 100), i)))
+	}
+	output := filepath.Join(workDir, "out.mp4")
+	if err := merger.Merge(files, output, FFmpegMergeOptions{NoDateInfo: true, WorkDir: workDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fakeFFmpegCalls(t, logPath)
+	chunks := SplitConcatChunks(files, maxConcatArgLength)
+	if len(chunks) < 2 || len(calls) != len(chunks)+1 {
+		t.Fatalf("got %d calls for %d chunks", len(calls), len(chunks))
+	}
+	var intermediates []string
+	for i, chunk := range chunks {
+		args := calls[i]
+		if got, want := argAfter(args, "-i"), "concat:"+strings.Join(chunk, "|"); got != want {
+			t.Errorf("call %d: input %q, want %q", i, got, want)
+		}
+		if len(argAfter(args, "-i")) > maxConcatArgLength {
+			t.Errorf("call %d: concat argument longer than %d", i, maxConcatArgLength)
+		}
+		if got := argAfter(args, "-f"); got != "mpegts" {
+			t.Errorf("call %d: format %q, want mpegts", i, got)
+		}
+		intermediates = append(intermediates, args[len(args)-1])
+	}
+	final := calls[len(calls)-1]
+	if got, want := argAfter(final, "-i"), "concat:"+strings.Join(intermediates, "|"); got != want {
+		t.Errorf("final input %q, want %q", got, want)
+	}
+	if final[len(final)-1] != output || argAfter(final, "-f") != "mp4" {
+		t.Errorf("final call %q", final)
+	}
+	for _, path := range intermediates {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("intermediate %s was not removed", path)
+		}
+	}
+	if len(progress) != len(calls) || progress[0] != "1.5s@2.5x" {
+		t.Errorf("progress %q", progress)
+	}
+}
+
+func TestFFmpegMergerConcatDemuxer(t *testing.T) {
+	merger, logPath := fakeFFmpeg(t)
+	workDir := t.TempDir()
+	output := filepath.Join(workDir, "out.m4a")
+	files := []string{filepath.Join(workDir, "0.ts"), filepath.Join(workDir, "1.ts")}
+	if err := merger.Merge(files, output, FFmpegMergeOptions{UseConcatDemuxer: true, NoDateInfo: true, WorkDir: workDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fakeFFmpegCalls(t, logPath)
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	listPath := filepath.Join(workDir, "concat_list.txt")
+	want := BuildFFmpegMergeArgs([]string{"-f", "concat", "-safe", "0", "-i", listPath}, output, "mp4", true)
+	if !reflect.DeepEqual(calls[0], want) {
+		t.Errorf("got  %q\nwant %q", calls[0], want)
+	}
+	if _, err := os.Stat(listPath); !os.IsNotExist(err) {
+		t.Errorf("concat list was not removed")
+	}
+}
+
+func TestFFmpegMergerFailure(t *testing.T) {
+	merger, _ := fakeFFmpeg(t)
+	if err := os.WriteFile(merger.BinaryPath, []byte("#!/bin/sh\necho 'Invalid data found' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := merger.Merge([]string{"a.ts"}, filepath.Join(t.TempDir(), "out.mp4"), FFmpegMergeOptions{WorkDir: t.TempDir()})
+	if err == nil || !strings.Contains(err.Error(), "Invalid data found") {
+		t.Errorf("got %v, want the ffmpeg error output", err)
+	}
+}
@@ -0,0 +1,585 @@
+package util
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// fmp4TrackState follows one track's timeline across fragments.
+type fmp4TrackState struct {
+	id        uint32
+	timescale uint32
+	handler   string
+	trex      [3]uint32 // default duration, size, flags
+	started   bool
+	shift     int64 // added to every tfdt
+	next      int64 // expected tfdt of the next fragment, after shifting
+	tfra      []tfraEntry
+}
+
+type tfraEntry struct {
+	time       int64
+	moofOffset int64
+	trafNumber uint32
+}
+
+// sidxRef is one sidx reference, covering one or more moof+mdat pairs.
+type sidxRef struct {
+	size     uint32
+	duration uint32
+	sap      bool
+}
+
+// FMP4Merger joins fMP4 fragments into one file behind a single moov. It
+// renumbers mfhd, shifts baseMediaDecodeTime back by a baseline shared by all
+// tracks (and all streams of a download, see MergeFMP4) so they keep their
+// offsets, keeps gaps but closes jumps back, drops repeated
+// ftyp/moov/styp/sidx boxes, and indexes the result with a sidx after the moov
+// and an mfra at the end.
+type FMP4Merger struct {
+	init     []byte
+	tracks   map[uint32]*fmp4TrackState
+	order    []*fmp4TrackState
+	refTrack *fmp4TrackState
+	baseline float64 // seconds subtracted from every track, set by the first fragment unless given
+	seq      uint32
+	pos      int64
+	refs     []sidxRef
+	ept      int64
+}
+
+func newFMP4Merger(init []byte, baseline float64) (*FMP4Merger, error) {
+	m := &FMP4Merger{init: init, tracks: make(map[uint32]*fmp4TrackState), baseline: baseline}
+	boxes, err := mp4.ReadBoxes(init)
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+	for _, b := range boxes {
+		if b.Type != "moov" {
+			continue
+		}
+		for _, trak := range mp4.FindBoxes(b.Payload, "trak") {
+			t := &fmp4TrackState{timescale: 1}
+			for _, c := range mp4.FindBoxes(trak.Payload, "tkhd") {
+				id, ok := versionedField(c.Payload, 12, 20)
+				if !ok {
+					return nil, errFMP4Truncated("tkhd")
+				}
+				t.id = id
+			}
+			for _, c := range mp4.FindBoxes(trak.Payload, "mdhd") {
+				timescale, ok := versionedField(c.Payload, 12, 20)
+				if !ok {
+					return nil, errFMP4Truncated("mdhd")
+				}
+				t.timescale = timescale
+			}
+			for _, c := range mp4.FindBoxes(trak.Payload, "hdlr") {
+				if len(c.Payload) < 12 {
+					return nil, errFMP4Truncated("hdlr")
+				}
+				t.handler = string(c.Payload[8:12])
+			}
+			m.tracks[t.id] = t
+			m.order = append(m.order, t)
+		}
+		for _, x := range mp4.FindBoxes(b.Payload, "trex") {
+			if len(x.Payload) < 24 {
+				return nil, errFMP4Truncated("trex")
+			}
+			if t := m.tracks[binary.BigEndian.Uint32(x.Payload[4:])]; t != nil {
+				t.trex = [3]uint32{
+					binary.BigEndian.Uint32(x.Payload[12:]),
+					binary.BigEndian.Uint32(x.Payload[16:]),
+					binary.BigEndian.Uint32(x.Payload[20:]),
+				}
+			}
+		}
+	}
+	if len(m.order) == 0 {
+		return nil, fmt.Errorf("init segment has no tracks")
+	}
+	m.refTrack = m.order[0]
+	for _, t := range m.order {
+		if t.handler == "vide" {
+			m.refTrack = t
+			break
+		}
+	}
+	return m, nil
+}
+
+// reset clears the timeline so the same fragments can be processed again. The
+// baseline stays, as the same first fragment would set it again.
+func (m *FMP4Merger) reset(start int64) {
+	m.seq, m.pos, m.refs, m.ept = 0, start, nil, 0
+	for _, t := range m.order {
+		t.started, t.shift, t.next, t.tfra = false, 0, 0, nil
+	}
+}
+
+// MergeFMP4 writes the init segment followed by every fragment, fixing the
+// timeline on the way. Without an init segment the files are concatenated as they are.
+//
+// baseline is the start time in seconds that becomes zero, or negative to use
+// the first fragment's. The baseline used is returned so the other streams of
+// the download can be merged against it and stay in sync.
+func MergeFMP4(init string, fragments []string, output string, baseline float64) (float64, error) {
+	if init == "" {
+		return baseline, CombineMultipleFilesIntoSingleFile(fragments, output)
+	}
+	initData, err := os.ReadFile(init)
+	if err != nil {
+		return baseline, err
+	}
+	m, err := newFMP4Merger(initData, baseline)
+	if err != nil {
+		return baseline, fmt.Errorf("%s: %w", init, err)
+	}
+	if err := m.merge(fragments, output); err != nil {
+		return baseline, err
+	}
+	return m.baseline, nil
+}
+
+// merge writes the header, a sidx and the rewritten fragments to output.
+func (m *FMP4Merger) merge(fragments []string, output string) error {
+	header := m.header()
+	// The first pass only measures, so the sidx can go in front of the fragments.
+	m.reset(0)
+	for _, file := range fragments {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if _, err := m.rewrite(data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	sidx := m.sidxBox()
+
+	if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriterSize(out, 1<<20)
+	w.Write(header)
+	w.Write(sidx)
+	m.reset(int64(len(header) + len(sidx)))
+	for _, file := range fragments {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rewritten, err := m.rewrite(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if _, err := w.Write(rewritten); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(m.mfraBox()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// header returns the init segment with anything after the moov dropped.
+func (m *FMP4Merger) header() []byte {
+	boxes, _ := mp4.ReadBoxes(m.init)
+	var out []byte
+	for _, b := range boxes {
+		switch b.Type {
+		case "ftyp", "moov", "free", "pdin", "uuid":
+			out = append(out, b.Raw...)
+		}
+	}
+	return out
+}
+
+// rewrite returns the fragment's boxes as they go into the output at m.pos.
+func (m *FMP4Merger) rewrite(data []byte) ([]byte, error) {
+	boxes, err := mp4.ReadBoxes(data)
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+	var out []byte
+	for _, b := range boxes {
+		switch b.Type {
+		case "ftyp", "styp", "moov", "sidx", "mfra":
+			continue
+		case "moof":
+			moof, hasRef, duration, sap, err := m.rewriteMoof(b)
+			if err != nil {
+				return nil, err
+			}
+			if hasRef || len(m.refs) == 0 {
+				m.refs = append(m.refs, sidxRef{sap: sap})
+			}
+			ref := &m.refs[len(m.refs)-1]
+			ref.duration += duration
+			ref.size += uint32(len(moof))
+			out = append(out, moof...)
+			m.pos += int64(len(moof))
+		default:
+			if len(m.refs) > 0 {
+				m.refs[len(m.refs)-1].size += uint32(len(b.Raw))
+			}
+			out = append(out, b.Raw...)
+			m.pos += int64(len(b.Raw))
+		}
+	}
+	return out, nil
+}
+
+// rewriteMoof renumbers the moof and shifts each traf's decode time. It
+// reports whether the moof carries the sidx reference track, that track's
+// duration in it, and whether it starts with a sync sample. Boxes too short for
+// the fields their flags announce are reported as errors.
+func (m *FMP4Merger) rewriteMoof(moof mp4.Box) (out []byte, hasRef bool, refDuration uint32, sap bool, err error) {
+	children, _ := mp4.ReadBoxes(moof.Payload)
+	m.seq++
+
+	type trafParts struct {
+		state       *fmp4TrackState
+		boxes       []mp4.Box
+		tfdt        int64
+		duration    int64
+		firstCTS    int64
+		sync        bool
+		growth      int // bytes added by upgrading tfdt to version 1
+		explicitOff bool
+	}
+	var trafs []*trafParts
+	growth := 0
+	for _, c := range children {
+		if c.Type != "traf" {
+			continue
+		}
+		tp := &trafParts{}
+		tp.boxes, _ = mp4.ReadBoxes(c.Payload)
+		var tfhdFlags, defDuration, defFlags uint32
+		for _, b := range tp.boxes {
+			p := b.Payload
+			switch b.Type {
+			case "tfhd":
+				if len(p) < 8 {
+					return nil, false, 0, false, errFMP4Truncated("tfhd")
+				}
+				tfhdFlags = binary.BigEndian.Uint32(p) & 0xffffff
+				// base_data_offset, sample_description_index, then the defaults
+				if len(p) < 8+4*bits.OnesCount32(tfhdFlags&0x3b)+4*int(tfhdFlags&0x01) {
+					return nil, false, 0, false, errFMP4Truncated("tfhd")
+				}
+				tp.state = m.tracks[binary.BigEndian.Uint32(p[4:])]
+				tp.explicitOff = tfhdFlags&0x01 != 0
+				if tp.state != nil {
+					defDuration, defFlags = tp.state.trex[0], tp.state.trex[2]
+				}
+				pos := 8
+				if tfhdFlags&0x01 != 0 {
+					pos += 8
+				}
+				if tfhdFlags&0x02 != 0 {
+					pos += 4
+				}
+				if tfhdFlags&0x08 != 0 {
+					defDuration = binary.BigEndian.Uint32(p[pos:])
+					pos += 4
+				}
+				if tfhdFlags&0x10 != 0 {
+					pos += 4
+				}
+				if tfhdFlags&0x20 != 0 {
+					defFlags = binary.BigEndian.Uint32(p[pos:])
+				}
+			case "tfdt":
+				switch {
+				case len(p) >= 12 && p[0] == 1:
+					tp.tfdt = int64(binary.BigEndian.Uint64(p[4:]))
+				case len(p) >= 8 && p[0] != 1:
+					tp.tfdt = int64(binary.BigEndian.Uint32(p[4:]))
+				default:
+					return nil, false, 0, false, errFMP4Truncated("tfdt")
+				}
+			case "trun":
+				d, cts, sync, err := trunTiming(p, defDuration, defFlags, tp.duration == 0)
+				if err != nil {
+					return nil, false, 0, false, err
+				}
+				if tp.duration == 0 {
+					tp.firstCTS, tp.sync = cts, sync
+				}
+				tp.duration += d
+			}
+		}
+		if tp.state == nil {
+			trafs = append(trafs, tp)
+			continue
+		}
+
+		t := tp.state
+		if m.baseline < 0 {
+			m.baseline = float64(tp.tfdt) / float64(t.timescale)
+		}
+		if !t.started {
+			t.shift = -int64(m.baseline * float64(t.timescale))
+			t.started = true
+			// A track starting before the baseline can only start at zero.
+			if tp.tfdt+t.shift < 0 {
+				t.shift = -tp.tfdt
+			}
+		} else if tp.tfdt+t.shift < t.next {
+			// A new period or a restarted encoder going back in time: carry on
+			// from where the last fragment ended. Gaps forward are kept.
+			t.shift = t.next - tp.tfdt
+		}
+		tp.tfdt += t.shift
+		t.next = tp.tfdt + tp.duration
+		if tp.sync || t.handler != "vide" {
+			t.tfra = append(t.tfra, tfraEntry{time: tp.tfdt + tp.firstCTS, moofOffset: m.pos, trafNumber: uint32(len(trafs) + 1)})
+		}
+		if tp.tfdt > 0xFFFFFFFF {
+			for _, b := range tp.boxes {
+				if b.Type == "tfdt" && b.Payload[0] == 0 {
+					tp.growth = 4
+				}
+			}
+		}
+		growth += tp.growth
+		if t == m.refTrack {
+			hasRef = true
+			refDuration = uint32(tp.duration)
+			sap = tp.sync
+			if len(m.refs) == 0 {
+				m.ept = tp.tfdt + tp.firstCTS
+			}
+		}
+		trafs = append(trafs, tp)
+	}
+
+	// Rebuild: mfhd, then each traf with patched tfhd/tfdt/trun/saio.
+	var payload []byte
+	trafIndex := 0
+	growthBefore := 0
+	for _, c := range children {
+		switch c.Type {
+		case "mfhd":
+			payload = append(payload, fmp4FullBox("mfhd", 0, 0, be32(m.seq))...)
+		case "traf":
+			tp := trafs[trafIndex]
+			trafIndex++
+			growthBefore += tp.growth
+			var traf []byte
+			for _, b := range tp.boxes {
+				p := append([]byte(nil), b.Payload...)
+				switch b.Type {
+				case "tfhd":
+					if tp.explicitOff {
+						base := int64(binary.BigEndian.Uint64(p[8:]))
+						// Offsets in the source were relative to where the moof sat in its file.
+						binary.BigEndian.PutUint64(p[8:], uint64(m.pos+base-int64(moof.Offset)+int64(growth)))
+					}
+				case "tfdt":
+					if tp.state != nil {
+						if tp.growth > 0 || p[0] == 1 {
+							p = append([]byte{1, p[1], p[2], p[3]}, make([]byte, 8)...)
+							binary.BigEndian.PutUint64(p[4:], uint64(tp.tfdt))
+						} else {
+							binary.BigEndian.PutUint32(p[4:], uint32(tp.tfdt))
+						}
+					}
+				case "trun":
+					if !tp.explicitOff && binary.BigEndian.Uint32(p)&0x01 != 0 {
+						off := int32(binary.BigEndian.Uint32(p[8:]))
+						binary.BigEndian.PutUint32(p[8:], uint32(off+int32(growth)))
+					}
+				case "saio":
+					// Auxiliary info (senc) sits inside the moof, after any grown tfdt.
+					if !tp.explicitOff {
+						if err := shiftSaio(p, int64(growthBefore)); err != nil {
+							return nil, false, 0, false, err
+						}
+					}
+				}
+				traf = append(traf, fmp4Box(b.Type, p)...)
+			}
+			payload = append(payload, fmp4Box("traf", traf)...)
+		default:
+			payload = append(payload, c.Raw...)
+		}
+	}
+	return fmp4Box("moof", payload), hasRef, refDuration, sap, nil
+}
+
+// trunTiming sums a trun's sample durations and reads the first sample's
+// composition offset and sync flag.
+func trunTiming(p []byte, defDuration, defFlags uint32, first bool) (duration, firstCTS int64, sync bool, err error) {
+	if len(p) < 8 {
+		return 0, 0, false, errFMP4Truncated("trun")
+	}
+	flags := binary.BigEndian.Uint32(p) & 0xffffff
+	count := int(binary.BigEndian.Uint32(p[4:]))
+	pos := 8 + 4*bits.OnesCount32(flags&0x05)
+	entrySize := 4 * bits.OnesCount32(flags&0xf00)
+	if len(p) < pos || entrySize > 0 && count > (len(p)-pos)/entrySize {
+		return 0, 0, false, errFMP4Truncated("trun")
+	}
+	pos = 8
+	if flags&0x01 != 0 {
+		pos += 4
+	}
+	firstFlags, hasFirst := defFlags, flags&0x04 != 0
+	if hasFirst {
+		firstFlags = binary.BigEndian.Uint32(p[pos:])
+		pos += 4
+	}
+	for i := 0; i < count; i++ {
+		d, sampleFlags := defDuration, defFlags
+		if i == 0 && hasFirst {
+			sampleFlags = firstFlags
+		}
+		if flags&0x100 != 0 {
+			d = binary.BigEndian.Uint32(p[pos:])
+			pos += 4
+		}
+		if flags&0x200 != 0 {
+			pos += 4
+		}
+		if flags&0x400 != 0 {
+			sampleFlags = binary.BigEndian.Uint32(p[pos:])
+			pos += 4
+		}
+		if flags&0x800 != 0 {
+			if i == 0 {
+				firstCTS = int64(int32(binary.BigEndian.Uint32(p[pos:])))
+			}
+			pos += 4
+		}
+		if i == 0 {
+			sync = sampleFlags&0x00010000 == 0
+		}
+		duration += int64(d)
+	}
+	return duration, firstCTS, sync, nil
+}
+
+func shiftSaio(p []byte, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	if len(p) < 4 {
+		return errFMP4Truncated("saio")
+	}
+	flags := binary.BigEndian.Uint32(p) & 0xffffff
+	pos := 4
+	if flags&0x01 != 0 {
+		pos += 8
+	}
+	entrySize := 4
+	if p[0] == 1 {
+		entrySize = 8
+	}
+	if len(p) < pos+4 {
+		return errFMP4Truncated("saio")
+	}
+	count := int(binary.BigEndian.Uint32(p[pos:]))
+	pos += 4
+	if count > (len(p)-pos)/entrySize {
+		return errFMP4Truncated("saio")
+	}
+	for i := 0; i < count; i++ {
+		if p[0] == 1 {
+			binary.BigEndian.PutUint64(p[pos:], uint64(int64(binary.BigEndian.Uint64(p[pos:]))+delta))
+			pos += 8
+		} else {
+			binary.BigEndian.PutUint32(p[pos:], uint32(int64(binary.BigEndian.Uint32(p[pos:]))+delta))
+			pos += 4
+		}
+	}
+	return nil
+}
+
+// sidxBox indexes every moof carrying the reference track, placed right after the moov.
+func (m *FMP4Merger) sidxBox() []byte {
+	payload := be32(m.refTrack.id)
+	payload = append(payload, be32(m.refTrack.timescale)...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(max(m.ept, 0)))
+	payload = binary.BigEndian.AppendUint64(payload, 0) // first_offset: fragments follow directly
+	payload = append(payload, 0, 0, byte(len(m.refs)>>8), byte(len(m.refs)))
+	for _, r := range m.refs {
+		payload = append(payload, be32(r.size&0x7fffffff)...)
+		payload = append(payload, be32(r.duration)...)
+		sap := uint32(0)
+		if r.sap {
+			sap = 0x90000000 // starts_with_SAP, SAP type 1
+		}
+		payload = append(payload, be32(sap)...)
+	}
+	return fmp4FullBox("sidx", 1, 0, payload)
+}
+
+// mfraBox lists a random access point per fragment and track, closed by mfro.
+func (m *FMP4Merger) mfraBox() []byte {
+	var payload []byte
+	for _, t := range m.order {
+		if len(t.tfra) == 0 {
+			continue
+		}
+		// 1-byte traf/trun/sample numbers
+		entries := append(be32(t.id), be32(0)...)
+		entries = append(entries, be32(uint32(len(t.tfra)))...)
+		for _, e := range t.tfra {
+			entries = binary.BigEndian.AppendUint64(entries, uint64(e.time))
+			entries = binary.BigEndian.AppendUint64(entries, uint64(e.moofOffset))
+			entries = append(entries, byte(e.trafNumber), 1, 1)
+		}
+		payload = append(payload, fmp4FullBox("tfra", 1, 0, entries)...)
+	}
+	size := 8 + len(payload) + 16
+	payload = append(payload, fmp4FullBox("mfro", 0, 0, be32(uint32(size)))...)
+	return fmp4Box("mfra", payload)
+}
+
+func fmp4Box(boxType string, payload []byte) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], boxType)
+	return append(b, payload...)
+}
+
+func fmp4FullBox(boxType string, version byte, flags uint32, payload []byte) []byte {
+	return fmp4Box(boxType, append([]byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}, payload...))
+}
+
+func errFMP4Truncated(boxType string) error {
+	return fmt.Errorf("%s box is truncated", boxType)
+}
+
+// versionedField reads the 32-bit field at v0Offset of a version 0 full box,
+// or at v1Offset of a version 1 box whose times are 64-bit.
+func versionedField(p []byte, v0Offset, v1Offset int) (uint32, bool) {
+	pos := v0Offset
+	if len(p) > 0 && p[0] == 1 {
+		pos = v1Offset
+	}
+	if len(p) < pos+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(p[pos:]), true
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
@@ -0,0 +1,191 @@
+package util
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/parser/mp4"
+)
+
+// testInit builds an init segment with one track of the given handler and
+// timescale, whose samples default to duration ticks.
+func testInit(handler string, timescale, duration uint32) []byte {
+	tkhd := make([]byte, 80)
+	binary.BigEndian.PutUint32(tkhd[8:], 1)
+	mdhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mdhd[8:], timescale)
+	hdlr := append(make([]byte, 4), handler...)
+	trex := append(be32(1), be32(1)...)
+	trex = append(trex, be32(duration)...)
+	trex = append(trex, make([]byte, 8)...)
+	trak := fmp4Box("trak", append(fmp4FullBox("tkhd", 0, 0, tkhd),
+		fmp4Box("mdia", append(fmp4FullBox("mdhd", 0, 0, mdhd), fmp4FullBox("hdlr", 0, 0, hdlr)...))...))
+	moov := fmp4Box("moov", append(trak, fmp4Box("mvex", fmp4FullBox("trex", 0, 0, trex))...))
+	return append(fmp4Box("ftyp", []byte("isom")), moov...)
+}
+
+// testFMP4Fragment builds a moof+mdat of one sample starting at tfdt.
+func testFMP4Fragment(tfdt uint32) []byte {
+	traf := append(fmp4FullBox("tfhd", 0, 0x020000, be32(1)), fmp4FullBox("tfdt", 0, 0, be32(tfdt))...)
+	traf = append(traf, fmp4FullBox("trun", 0, 0, be32(1))...)
+	moof := fmp4Box("moof", append(fmp4FullBox("mfhd", 0, 0, be32(1)), fmp4Box("traf", traf)...))
+	return append(moof, fmp4Box("mdat", []byte{0})...)
+}
+
+// mergeTestStream merges fragments starting at each tfdt and returns the
+// tfdts written, along with the baseline used.
+func mergeTestStream(t *testing.T, init []byte, tfdts []uint32, baseline float64) ([]int64, float64) {
+	dir := t.TempDir()
+	initPath := filepath.Join(dir, "_init.mp4")
+	if err := os.WriteFile(initPath, init, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var fragments []string
+	for i, tfdt := range tfdts {
+		path := filepath.Join(dir, string(rune('a'+i))+".m4s")
+		if err := os.WriteFile(path, testFMP4Fragment(tfdt), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fragments = append(fragments, path)
+	}
+	output := filepath.Join(dir, "out.mp4")
+	baseline, err := MergeFMP4(initPath, fragments, output, baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, tfdt := range mp4.FindBoxes(data, "tfdt") {
+		got = append(got, int64(binary.BigEndian.Uint32(tfdt.Payload[4:])))
+	}
+	return got, baseline
+}
+
+func TestMergeFMP4Timeline(t *testing.T) {
+	video := testInit("vide", 1000, 2000)
+	tests := []struct {
+		name  string
+		tfdts []uint32
+		want  []int64
+	}{
+		{"continuous", []uint32{10000, 12000, 14000}, []int64{0, 2000, 4000}},
+		{"gap kept", []uint32{10000, 12000, 20000}, []int64{0, 2000, 10000}},
+		{"jump back closed", []uint32{10000, 12000, 3000, 5000}, []int64{0, 2000, 4000, 6000}},
+	}
+	for _, tt := range tests {
+		got, baseline := mergeTestStream(t, video, tt.tfdts, -1)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		if baseline != 10 {
+			t.Errorf("%s: baseline %v, want 10", tt.name, baseline)
+		}
+	}
+}
+
+func TestMergeFMP4SharedBaseline(t *testing.T) {
+	_, baseline := mergeTestStream(t, testInit("vide", 1000, 2000), []uint32{10000, 12000}, -1)
+	// audio starts half a second after the video and keeps that offset
+	got, audioBaseline := mergeTestStream(t, testInit("soun", 48000, 96000), []uint32{504000, 600000}, baseline)
+	if want := []int64{24000, 120000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if audioBaseline != baseline {
+		t.Errorf("baseline changed from %v to %v", baseline, audioBaseline)
+	}
+	// a stream starting before the baseline starts at zero
+	if got, _ := mergeTestStream(t, testInit("soun", 48000, 96000), []uint32{470400, 566400}, baseline); !reflect.DeepEqual(got, []int64{0, 96000}) {
+		t.Errorf("early stream: got %v", got)
+	}
+}
+
+func TestMergeFMP4Truncated(t *testing.T) {
+	// a trun announcing three sample durations but carrying none
+	dir := t.TempDir()
+	initPath, fragment := filepath.Join(dir, "_init.mp4"), filepath.Join(dir, "a.m4s")
+	traf := append(fmp4FullBox("tfhd", 0, 0x020000, be32(1)), fmp4FullBox("tfdt", 0, 0, be32(0))...)
+	traf = append(traf, fmp4FullBox("trun", 0, 0x100, be32(3))...)
+	moof := fmp4Box("moof", append(fmp4FullBox("mfhd", 0, 0, be32(1)), fmp4Box("traf", traf)...))
+	if err := os.WriteFile(initPath, testInit("vide", 1000, 2000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fragment, append(moof, fmp4Box("mdat", nil)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MergeFMP4(initPath, []string{fragment}, filepath.Join(dir, "out.mp4"), -1); err == nil {
+		t.Error("got no error for a truncated trun")
+	}
+}
+
+func TestMergeFMP4TruncatedBoxes(t *testing.T) {
+	// each box holds just the fields the merger reads, so any cut loses one
+	tkhd, mdhd := make([]byte, 16), make([]byte, 16)
+	binary.BigEndian.PutUint32(tkhd[12:], 1)
+	binary.BigEndian.PutUint32(mdhd[12:], 1000)
+	hdlr := append(make([]byte, 8), "vide"...)
+	trex := append(make([]byte, 4), be32(1)...)
+	trex = append(trex, make([]byte, 16)...)
+	initBoxes := map[string][]byte{"tkhd": tkhd, "mdhd": mdhd, "hdlr": hdlr, "trex": trex}
+	buildInit := func(payloads map[string][]byte) []byte {
+		box := func(boxType string) []byte { return fmp4Box(boxType, payloads[boxType]) }
+		trak := fmp4Box("trak", append(box("tkhd"), fmp4Box("mdia", append(box("mdhd"), box("hdlr")...))...))
+		return fmp4Box("moov", append(trak, fmp4Box("mvex", box("trex"))...))
+	}
+
+	// every optional tfhd and trun field present, two samples
+	tfhd := append([]byte{0, 0, 0, 0x3b}, be32(1)...)
+	tfhd = append(tfhd, make([]byte, 24)...)
+	tfdt := append([]byte{1, 0, 0, 0}, make([]byte, 8)...)
+	trun := append([]byte{0, 0, 0x0f, 0x05}, be32(2)...)
+	trun = append(trun, make([]byte, 8+2*16)...)
+	fragmentBoxes := map[string][]byte{"tfhd": tfhd, "tfdt": tfdt, "trun": trun}
+	buildFragment := func(payloads map[string][]byte) []byte {
+		traf := append(fmp4Box("tfhd", payloads["tfhd"]), fmp4Box("tfdt", payloads["tfdt"])...)
+		traf = append(traf, fmp4Box("trun", payloads["trun"])...)
+		return fmp4Box("moof", append(fmp4FullBox("mfhd", 0, 0, be32(1)), fmp4Box("traf", traf)...))
+	}
+
+	m, err := newFMP4Merger(buildInit(initBoxes), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.rewrite(buildFragment(fragmentBoxes)); err != nil {
+		t.Fatal(err)
+	}
+	cut := func(boxes map[string][]byte, boxType string, n int) map[string][]byte {
+		out := make(map[string][]byte, len(boxes))
+		for k, v := range boxes {
+			out[k] = v
+		}
+		out[boxType] = boxes[boxType][:n]
+		return out
+	}
+	for boxType, payload := range initBoxes {
+		for n := 0; n < len(payload); n++ {
+			if _, err := newFMP4Merger(buildInit(cut(initBoxes, boxType, n)), -1); err == nil {
+				t.Errorf("%s cut to %d bytes: got no error", boxType, n)
+			}
+		}
+	}
+	for boxType, payload := range fragmentBoxes {
+		for n := 0; n < len(payload); n++ {
+			if _, err := m.rewrite(buildFragment(cut(fragmentBoxes, boxType, n))); err == nil {
+				t.Errorf("%s cut to %d bytes: got no error", boxType, n)
+			}
+		}
+	}
+
+	saio := append([]byte{1, 0, 0, 0}, be32(2)...)
+	saio = append(saio, make([]byte, 16)...)
+	for n := 0; n < len(saio); n++ {
+		if err := shiftSaio(append([]byte(nil), saio[:n]...), 4); err == nil {
+			t.Errorf("saio cut to %d bytes: got no error", n)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/utils"
+)
+
+type KeyEntry struct {
+	KID   string
+	Key   string
+	Label string
+	// Raw is a --key value that is not a KID:KEY hex pair, such as mp4decrypt's
+	// TRACK_ID:KEY. It is handed to the decryptor unchanged.
+	Raw string
+}
+
+// String returns the KID:KEY form expected by mp4decrypt and shaka-packager.
+func (k KeyEntry) String() string {
+	if k.Raw != "" {
+		return k.Raw
+	}
+	return k.KID + ":" + k.Key
+}
+
+// KeyDatabase maps KIDs to content keys, loaded from --key and --key-text-file.
+type KeyDatabase struct {
+	entries map[string]KeyEntry
+	order   []string
+	// raw are the --key values passed through by AddKeyArg.
+	raw []KeyEntry
+}
+
+func NewKeyDatabase() *KeyDatabase {
+	return &KeyDatabase{entries: make(map[string]KeyEntry)}
+}
+
+// LoadKeyTextFile reads KID:KEY lines. Anything after the pair is kept as a label,
+// a trailing "# ..." is a comment, and blank lines or lines starting with # or // are skipped.
+func (db *KeyDatabase) LoadKeyTextFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if idx := strings.Index(line, " #"); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		if err := db.AddKey(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// AddKey adds one "KID:KEY [label]" value. The first key seen for a KID wins.
+func (db *KeyDatabase) AddKey(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil
+	}
+	kid, key, ok := strings.Cut(fields[0], ":")
+	kid, key = utils.NormalizeKID(kid), strings.ToLower(strings.TrimSpace(key))
+	if !ok || !isHex(kid, 32) || !isHex(key, 32) {
+		return fmt.Errorf("invalid key %q, expecting KID:KEY in hex", fields[0])
+	}
+	if _, exists := db.entries[kid]; exists {
+		return nil
+	}
+	db.entries[kid] = KeyEntry{KID: kid, Key: key, Label: strings.Join(fields[1:], " ")}
+	db.order = append(db.order, kid)
+	return nil
+}
+
+// AddKeyArg adds a --key value. Values that are not a KID:KEY hex pair can't
+// be matched to a track and are given to the decryptor as they are.
+func (db *KeyDatabase) AddKeyArg(value string) {
+	if err := db.AddKey(value); err == nil {
+		return
+	}
+	value = strings.TrimSpace(value)
+	kid, key, _ := strings.Cut(value, ":")
+	db.raw = append(db.raw, KeyEntry{KID: kid, Key: key, Raw: value})
+}
+
+// Lookup returns the key for kid in either UUID or hex form.
+func (db *KeyDatabase) Lookup(kid string) (KeyEntry, bool) {
+	entry, ok := db.entries[utils.NormalizeKID(kid)]
+	return entry, ok
+}
+
+// All returns every key in insertion order, followed by the passed-through ones.
+func (db *KeyDatabase) All() []KeyEntry {
+	entries := make([]KeyEntry, 0, db.Len())
+	for _, kid := range db.order {
+		entries = append(entries, db.entries[kid])
+	}
+	return append(entries, db.raw...)
+}
+
+func (db *KeyDatabase) Len() int {
+	return len(db.order) + len(db.raw)
+}
+
+// ResolveKeys returns the keys for kids plus any passed-through keys. When kids
+// is empty every key is returned, leaving it to the decryptor to pick. A KID
+// without a key is an error unless there are passed-through keys that may hold it.
+func (db *KeyDatabase) ResolveKeys(kids []string) ([]KeyEntry, error) {
+	if len(kids) == 0 {
+		return db.All(), nil
+	}
+	var keys []KeyEntry
+	var missing []string
+	for _, kid := range kids {
+		if entry, ok := db.Lookup(kid); ok {
+			keys = append(keys, entry)
+		} else {
+			missing = append(missing, utils.NormalizeKID(kid))
+		}
+	}
+	if len(missing) > 0 && len(db.raw) == 0 {
+		return nil, fmt.Errorf("no key found for KID %s", strings.Join(missing, ", "))
+	}
+	return append(keys, db.raw...), nil
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testKID1 = "eb676abbcb345e96bbcf616630f1a3da"
+	testKey1 = "100b6c20940f779a4589152b57d2dacb"
+	testKID2 = "0123456789abcdef0123456789abcdef"
+	testKey2 = "fedcba9876543210fedcba9876543210"
+)
+
+func TestLoadKeyTextFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []KeyEntry
+		err     string
+	}{
+		{
+			"pairs, labels and comments",
+			"\ufeff# keys for the show\r\n" +
+				"// exported from the license server\n" +
+				"\n" +
+				"EB676ABBCB345E96BBCF616630F1A3DA:100B6C20940F779A4589152B57D2DACB Episode 1 video\n" +
+				"  0123-4567-89ab-cdef-0123456789abcdef:" + testKey2 + "  # audio\n",
+			[]KeyEntry{
+				{KID: testKID1, Key: testKey1, Label: "Episode 1 video"},
+				{KID: testKID2, Key: testKey2},
+			},
+			"",
+		},
+		{
+			"the first key for a KID wins",
+			testKID1 + ":" + testKey1 + "\n" +
+				"eb676abb-cb34-5e96-bbcf-616630f1a3da:" + testKey2 + "\n",
+			[]KeyEntry{{KID: testKID1, Key: testKey1}},
+			"",
+		},
+		{"missing key", testKID1 + "\n", nil, ":1: invalid key"},
+		{"short KID", "# ok\neb676abb:" + testKey1 + "\n", nil, ":2: invalid key"},
+		{"not hex", testKID1 + ":" + strings.Repeat("g", 32) + "\n", nil, ":1: invalid key"},
+		{"track id form is not accepted in a file", "1:" + testKey1 + "\n", nil, ":1: invalid key"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "keys.txt")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		db := NewKeyDatabase()
+		err := db.LoadKeyTextFile(path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := db.All(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if err := NewKeyDatabase().LoadKeyTextFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("missing file: got no error")
+	}
+}
+
+func TestAddKeyArg(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   KeyEntry
+		string string
+	}{
+		{testKID1 + ":" + testKey1, KeyEntry{KID: testKID1, Key: testKey1}, testKID1 + ":" + testKey1},
+		{"EB676ABB-CB34-5E96-BBCF-616630F1A3DA:" + strings.ToUpper(testKey1), KeyEntry{KID: testKID1, Key: testKey1}, testKID1 + ":" + testKey1},
+		{"1:" + testKey1, KeyEntry{KID: "1", Key: testKey1, Raw: "1:" + testKey1}, "1:" + testKey1},
+		{" 2:abc ", KeyEntry{KID: "2", Key: "abc", Raw: "2:abc"}, "2:abc"},
+		{"opaque", KeyEntry{KID: "opaque", Raw: "opaque"}, "opaque"},
+	}
+	for _, tt := range tests {
+		db := NewKeyDatabase()
+		db.AddKeyArg(tt.value)
+		got := db.All()
+		if len(got) != 1 || got[0] != tt.want || got[0].String() != tt.string {
+			t.Errorf("AddKeyArg(%q): got %+v, want %+v (%s)", tt.value, got, tt.want, tt.string)
+		}
+		if _, ok := db.Lookup(tt.want.KID); ok != (tt.want.Raw == "") {
+			t.Errorf("AddKeyArg(%q): Lookup found %v", tt.value, ok)
+		}
+	}
+}
+
+func TestResolveKeys(t *testing.T) {
+	key1 := KeyEntry{KID: testKID1, Key: testKey1}
+	key2 := KeyEntry{KID: testKID2, Key: testKey2}
+	raw := KeyEntry{KID: "1", Key: testKey1, Raw: "1:" + testKey1}
+	tests := []struct {
+		name string
+		args []string
+		kids []string
+		want []KeyEntry
+		err  string
+	}{
+		{"hex KID", []string{testKID1 + ":" + testKey1, testKID2 + ":" + testKey2}, []string{testKID2}, []KeyEntry{key2}, ""},
+		{"UUID KID", []string{testKID1 + ":" + testKey1, testKID2 + ":" + testKey2}, []string{"EB676ABB-CB34-5E96-BBCF-616630F1A3DA"}, []KeyEntry{key1}, ""},
+		{"key given as a UUID", []string{"eb676abb-cb34-5e96-bbcf-616630f1a3da:" + testKey1}, []string{" EB676ABBCB345E96BBCF616630F1A3DA "}, []KeyEntry{key1}, ""},
+		{"several KIDs in request order", []string{testKID1 + ":" + testKey1, testKID2 + ":" + testKey2}, []string{testKID2, testKID1}, []KeyEntry{key2, key1}, ""},
+		{"no KIDs gives every key", []string{testKID1 + ":" + testKey1, "1:" + testKey1}, nil, []KeyEntry{key1, raw}, ""},
+		{"missing KID", []string{testKID1 + ":" + testKey1}, []string{testKID1, "0123-4567-89AB-CDEF-0123456789ABCDEF"}, nil, "no key found for KID " + testKID2},
+		{"missing KID with passed-through keys", []string{"1:" + testKey1}, []string{testKID1}, []KeyEntry{raw}, ""},
+		{"matched and passed-through keys", []string{"1:" + testKey1, testKID2 + ":" + testKey2}, []string{testKID2}, []KeyEntry{key2, raw}, ""},
+	}
+	for _, tt := range tests {
+		db := NewKeyDatabase()
+		for _, arg := range tt.args {
+			db.AddKeyArg(arg)
+		}
+		got, err := db.ResolveKeys(tt.kids)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
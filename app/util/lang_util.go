@@ -0,0 +1,27 @@
+package util
+
+import "strings"
+
+var iso639_1To2 = map[string]string{
+	"ar": "ara", "bg": "bul", "ca": "cat", "cs": "ces", "da": "dan", "de": "deu",
+	"el": "ell", "en": "eng", "es": "spa", "et": "est", "fa": "fas", "fi": "fin",
+	"fr": "fra", "he": "heb", "hi": "hin", "hr": "hrv", "hu": "hun", "id": "ind",
+	"is": "isl", "it": "ita", "ja": "jpn", "ko": "kor", "lt": "lit", "lv": "lav",
+	"ms": "msa", "nb": "nob", "nl": "nld", "no": "nor", "pl": "pol", "pt": "por",
+	"ro": "ron", "ru": "rus", "sk": "slk", "sl": "slv", "sr": "srp", "sv": "swe",
+	"ta": "tam", "te": "tel", "th": "tha", "tl": "tgl", "tr": "tur", "uk": "ukr",
+	"vi": "vie", "yue": "yue", "zh": "zho",
+}
+
+// ToISO639_2 turns a manifest language tag such as "en-US" or "zh-Hans" into the
+// three-letter code MP4 and Matroska expect. Unknown tags are returned as given.
+func ToISO639_2(lang string) string {
+	primary := strings.ToLower(strings.SplitN(strings.ReplaceAll(lang, "_", "-"), "-", 2)[0])
+	if code, ok := iso639_1To2[primary]; ok {
+		return code
+	}
+	if len(primary) == 3 {
+		return primary
+	}
+	return lang
+}
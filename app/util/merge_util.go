@@ -0,0 +1,168 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const tsPacketSize = 188
+
+// CombineMultipleFilesIntoSingleFile concatenates files into output as they are.
+func CombineMultipleFilesIntoSingleFile(files []string, output string) error {
+	if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	for _, file := range files {
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// TSMerger concatenates MPEG-TS segments while rewriting continuity counters
+// so they run on across segment boundaries, and drops the PAT/PMT tables a
+// segment starts with when they merely repeat the previous ones. Tables
+// repeated within a segment are kept, players rely on them for seeking.
+type TSMerger struct {
+	w          *bufio.Writer
+	cc         map[uint16]byte
+	pmtPIDs    map[uint16]bool
+	lastTables map[uint16][]byte
+}
+
+// MergeTS merges parts, each an ordered list of segments. Tables are always
+// kept at the start of a part, since a discontinuity may change them.
+func MergeTS(parts [][]string, output string) error {
+	if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	m := &TSMerger{
+		w:       bufio.NewWriterSize(out, 1<<20),
+		cc:      make(map[uint16]byte),
+		pmtPIDs: make(map[uint16]bool),
+	}
+	for _, part := range parts {
+		m.lastTables = make(map[uint16][]byte)
+		for _, file := range part {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if err := m.writeSegment(data); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+	return m.w.Flush()
+}
+
+func (m *TSMerger) writeSegment(data []byte) error {
+	start := findTSSync(data)
+	if start < 0 {
+		return fmt.Errorf("no MPEG-TS sync byte found")
+	}
+	// leading is true until the segment's first packet that isn't a PAT/PMT.
+	leading := true
+	for pos := start; pos+tsPacketSize <= len(data); pos += tsPacketSize {
+		packet := data[pos : pos+tsPacketSize]
+		if packet[0] != 0x47 {
+			continue
+		}
+		pid := binary.BigEndian.Uint16(packet[1:3]) & 0x1fff
+		hasPayload := packet[3]&0x10 != 0
+
+		if pid == 0 || m.pmtPIDs[pid] {
+			if pid == 0 {
+				m.readPAT(packet)
+			}
+			if m.isRepeatedTable(pid, packet) && leading {
+				continue
+			}
+		} else {
+			leading = false
+		}
+
+		if hasPayload {
+			if last, ok := m.cc[pid]; ok {
+				packet[3] = packet[3]&0xf0 | (last+1)&0x0f
+			}
+			m.cc[pid] = packet[3] & 0x0f
+		}
+		if _, err := m.w.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRepeatedTable reports whether the section in packet equals the last one
+// seen for pid, ignoring the header byte that holds the continuity counter.
+func (m *TSMerger) isRepeatedTable(pid uint16, packet []byte) bool {
+	section := packet[4:]
+	if last, ok := m.lastTables[pid]; ok && bytes.Equal(last, section) {
+		return true
+	}
+	m.lastTables[pid] = append([]byte(nil), section...)
+	return false
+}
+
+func (m *TSMerger) readPAT(packet []byte) {
+	if packet[1]&0x40 == 0 || packet[3]&0x10 == 0 {
+		return
+	}
+	payload := packet[4:]
+	if packet[3]&0x20 != 0 {
+		// a corrupt adaptation_field_length may run past the packet
+		if 1+int(payload[0]) > len(payload) {
+			return
+		}
+		payload = payload[1+int(payload[0]):]
+	}
+	if len(payload) < 1 || len(payload) < 1+int(payload[0])+8 {
+		return
+	}
+	section := payload[1+int(payload[0]):]
+	length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0fff)
+	end := min(3+length-4, len(section))
+	for i := 8; i+4 <= end; i += 4 {
+		program := binary.BigEndian.Uint16(section[i:])
+		if program != 0 {
+			m.pmtPIDs[binary.BigEndian.Uint16(section[i+2:])&0x1fff] = true
+		}
+	}
+}
+
+// findTSSync skips anything (e.g. a fake image header) before the first packet.
+func findTSSync(data []byte) int {
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0x47 && (i+tsPacketSize >= len(data) || data[i+tsPacketSize] == 0x47) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tsPacket builds a packet with a payload and a continuity counter of cc.
+func tsPacket(pid uint16, cc byte, payload []byte) []byte {
+	p := make([]byte, tsPacketSize)
+	p[0], p[1], p[2], p[3] = 0x47, byte(pid>>8)&0x1f|0x40, byte(pid), 0x10|cc&0x0f
+	copy(p[4:], payload)
+	return p
+}
+
+var (
+	// PAT listing program 1 with its PMT on PID 0x100
+	testPAT = tsPacket(0, 0, []byte{0, 0x00, 0xb0, 0x0d, 0, 1, 0xc1, 0, 0, 0, 1, 0xe1, 0x00})
+	testPMT = tsPacket(0x100, 0, []byte{0, 0x02, 0xb0, 0x12, 0, 1, 0xc1, 0, 0, 0xe1, 0x01})
+)
+
+func TestMergeTSKeepsRepeatedTablesWithinSegments(t *testing.T) {
+	dir := t.TempDir()
+	var segments []string
+	for i := 0; i < 2; i++ {
+		segment := bytes.Join([][]byte{
+			testPAT, testPMT, tsPacket(0x101, 0, []byte("a")),
+			testPAT, testPMT, tsPacket(0x101, 1, []byte("b")),
+		}, nil)
+		path := filepath.Join(dir, filepath.Base(t.Name())+string(rune('0'+i))+".ts")
+		if err := os.WriteFile(path, segment, 0644); err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, path)
+	}
+	output := filepath.Join(dir, "out.ts")
+	if err := MergeTS([][]string{segments}, output); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second segment's leading PAT and PMT repeat the first segment's.
+	if got := len(data) / tsPacketSize; got != 10 {
+		t.Fatalf("got %d packets, want 10", got)
+	}
+	for i, pid := range []uint16{0, 0x100, 0x101, 0, 0x100, 0x101, 0x101, 0, 0x100, 0x101} {
+		packet := data[i*tsPacketSize:]
+		if got := uint16(packet[1]&0x1f)<<8 | uint16(packet[2]); got != pid {
+			t.Errorf("packet %d has PID %#x, want %#x", i, got, pid)
+		}
+	}
+	// continuity counters of the video PID run on across the join
+	var ccs []byte
+	for i := 0; i < 10; i++ {
+		if packet := data[i*tsPacketSize:]; packet[2] == 0x01 {
+			ccs = append(ccs, packet[3]&0x0f)
+		}
+	}
+	if !bytes.Equal(ccs, []byte{0, 1, 2, 3}) {
+		t.Errorf("video continuity counters %v, want [0 1 2 3]", ccs)
+	}
+}
+
+func TestReadPATCorruptAdaptationField(t *testing.T) {
+	packet := tsPacket(0, 0, []byte{0xff})
+	packet[3] |= 0x20
+	m := &TSMerger{pmtPIDs: make(map[uint16]bool)}
+	m.readPAT(packet)
+}
@@ -0,0 +1,191 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+)
+
+var (
+	ErrDecryptorNotFound = errors.New("decryption binary not found")
+	ErrInvalidArguments  = errors.New("decryptor rejected its arguments")
+	ErrDecryptFailed     = errors.New("decryption failed")
+	ErrDecryptorInternal = errors.New("decryptor internal error")
+)
+
+// DecryptError wraps one of the Err* values with the tool's exit code and stderr.
+type DecryptError struct {
+	Tool     string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("%s exited with code %d: %v: %s", e.Tool, e.ExitCode, e.Err, strings.TrimSpace(e.Stderr))
+}
+
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// Mp4Decryptor drives mp4decrypt or shaka-packager.
+type Mp4Decryptor struct {
+	BinaryPath       string
+	UseShakaPackager bool
+	Logger           *log.Logger
+}
+
+// NewMp4Decryptor locates mp4decrypt, or shaka-packager when useShaka is set, in
+// binaryPath (a file or a directory), tmpDir, next to this program or on PATH.
+func NewMp4Decryptor(useShaka bool, binaryPath, tmpDir string, logger *log.Logger) (*Mp4Decryptor, error) {
+	names := []string{"mp4decrypt"}
+	if useShaka {
+		names = []string{"shaka-packager", "packager", "packager-linux-x64", "packager-osx-x64", "packager-win-x64"}
+	}
+	path := FindExecutable(names, binaryPath, tmpDir)
+	if path == "" {
+		return nil, fmt.Errorf("%w: %s", ErrDecryptorNotFound, names[0])
+	}
+	return &Mp4Decryptor{BinaryPath: path, UseShakaPackager: useShaka, Logger: logger}, nil
+}
+
+// BuildArgs returns the command line for decrypting source into dest. For
+// mp4decrypt a separate init is passed with --fragments-info; shaka-packager
+// cannot take one, so Decrypt prepends it to the source instead.
+// shaka-packager only takes keys by KID, so passed-through keys are rejected.
+func (d *Mp4Decryptor) BuildArgs(keys []KeyEntry, source, dest, init string) ([]string, error) {
+	var args []string
+	if d.UseShakaPackager {
+		keyArgs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if key.Raw != "" {
+				return nil, fmt.Errorf("%w: shaka-packager needs KID:KEY keys, got %q", ErrInvalidArguments, key.Raw)
+			}
+			keyArgs = append(keyArgs, fmt.Sprintf("key_id=%s:key=%s", key.KID, key.Key))
+		}
+		args = append(args,
+			"--quiet",
+			"--enable_raw_key_decryption",
+			fmt.Sprintf("input=%s,stream=0,output=%s", source, dest),
+			"--keys", strings.Join(keyArgs, ","))
+		return args, nil
+	}
+
+	for _, key := range keys {
+		args = append(args, "--key", key.String())
+	}
+	if init != "" {
+		args = append(args, "--fragments-info", init)
+	}
+	return append(args, source, dest), nil
+}
+
+// Decrypt decrypts source into dest. init is only needed when source is a bare
+// media segment without its own moov. dest only appears once the tool has
+// succeeded, so an interrupted run never leaves a partial file behind.
+func (d *Mp4Decryptor) Decrypt(keys []KeyEntry, source, dest, init string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("%w: no keys", ErrInvalidArguments)
+	}
+	// keep the extension, shaka-packager picks the output format from it
+	ext := filepath.Ext(dest)
+	tmpDest := strings.TrimSuffix(dest, ext) + ".tmp" + ext
+	defer os.Remove(tmpDest)
+	if d.UseShakaPackager && init != "" {
+		combined, err := combineInit(init, source, dest+".tmp_input.mp4")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(combined)
+		source, init = combined, ""
+	}
+
+	args, err := d.BuildArgs(keys, source, tmpDest, init)
+	if err != nil {
+		return err
+	}
+	d.Logger.Debug("%s %s", d.BinaryPath, strings.Join(args, " "))
+	cmd := exec.Command(d.BinaryPath, args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var captured strings.Builder
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		captured.WriteString(line + "\n")
+		d.Logger.Debug("%s", line)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &DecryptError{
+				Tool:     d.toolName(),
+				ExitCode: exitErr.ExitCode(),
+				Stderr:   captured.String(),
+				Err:      d.mapExitCode(exitErr.ExitCode()),
+			}
+		}
+		return err
+	}
+	if info, err := os.Stat(tmpDest); err != nil || info.Size() == 0 {
+		return &DecryptError{Tool: d.toolName(), Stderr: captured.String(), Err: ErrDecryptFailed}
+	}
+	return os.Rename(tmpDest, dest)
+}
+
+func (d *Mp4Decryptor) toolName() string {
+	if d.UseShakaPackager {
+		return "shaka-packager"
+	}
+	return "mp4decrypt"
+}
+
+// mapExitCode follows shaka-packager's ExitStatus enum; mp4decrypt only ever returns 1.
+func (d *Mp4Decryptor) mapExitCode(code int) error {
+	if !d.UseShakaPackager {
+		return ErrDecryptFailed
+	}
+	switch code {
+	case 1:
+		return ErrInvalidArguments
+	case 2:
+		return ErrDecryptFailed
+	default:
+		return ErrDecryptorInternal
+	}
+}
+
+func combineInit(init, source, dest string) (string, error) {
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	for _, path := range []string{init, source} {
+		in, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return dest, nil
+}
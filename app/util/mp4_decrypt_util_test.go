@@ -0,0 +1,49 @@
+package util
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMp4DecryptorBuildArgs(t *testing.T) {
+	kid1 := KeyEntry{KID: testKID1, Key: testKey1}
+	kid2 := KeyEntry{KID: testKID2, Key: testKey2}
+	raw := KeyEntry{KID: "1", Key: testKey1, Raw: "1:" + testKey1}
+	tests := []struct {
+		name  string
+		shaka bool
+		keys  []KeyEntry
+		init  string
+		want  []string
+	}{
+		{
+			"mp4decrypt",
+			false, []KeyEntry{kid1, kid2}, "",
+			[]string{"--key", testKID1 + ":" + testKey1, "--key", testKID2 + ":" + testKey2, "in.mp4", "out.mp4"},
+		},
+		{
+			"mp4decrypt with a separate init and a track id key",
+			false, []KeyEntry{raw}, "init.mp4",
+			[]string{"--key", "1:" + testKey1, "--fragments-info", "init.mp4", "in.mp4", "out.mp4"},
+		},
+		{
+			"shaka-packager",
+			true, []KeyEntry{kid1, kid2}, "",
+			[]string{"--quiet", "--enable_raw_key_decryption", "input=in.mp4,stream=0,output=out.mp4",
+				"--keys", "key_id=" + testKID1 + ":key=" + testKey1 + ",key_id=" + testKID2 + ":key=" + testKey2},
+		},
+	}
+	for _, tt := range tests {
+		d := &Mp4Decryptor{UseShakaPackager: tt.shaka}
+		got, err := d.BuildArgs(tt.keys, "in.mp4", "out.mp4", tt.init)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %q, %v\nwant %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	d := &Mp4Decryptor{UseShakaPackager: true}
+	if args, err := d.BuildArgs([]KeyEntry{kid1, raw}, "in.mp4", "out.mp4", ""); !errors.Is(err, ErrInvalidArguments) {
+		t.Errorf("shaka-packager with a track id key: got %q, %v", args, err)
+	}
+}
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+)
+
+// roleDispositions maps DASH roles onto ffmpeg stream dispositions.
+var roleDispositions = map[enums.RoleType]string{
+	enums.Commentary:  "comment",
+	enums.Dub:         "dub",
+	enums.Description: "visual_impaired+descriptions",
+	enums.Metadata:    "metadata",
+}
+
+// streamLineRegex matches the "Stream #0:1[0x101](eng): Audio: ..." lines of ffmpeg's input summary.
+var streamLineRegex = regexp.MustCompile(`(?m)^\s*Stream #0:(\d+)`)
+
+// BuildFFmpegMuxArgs returns the ffmpeg arguments that combine files into one
+// container, carrying each file's language, title, default flag and role.
+// streamCounts holds the number of streams in each file: a merged TS may
+// carry audio and video, and every one of them gets the file's metadata.
+// chaptersFile, when set, is an FFMETADATA file whose chapters are embedded.
+func BuildFFmpegMuxArgs(files []entity.OutputFile, streamCounts []int, output, format, chaptersFile string, noDateInfo bool) []string {
+	args := []string{"-loglevel", "warning", "-nostats", "-progress", "pipe:1", "-y"}
+	for _, f := range files {
+		args = append(args, "-i", f.FilePath)
+	}
+	if chaptersFile != "" {
+		args = append(args, "-f", "ffmetadata", "-i", chaptersFile)
+	}
+	for i := range files {
+		args = append(args, "-map", fmt.Sprint(i))
+	}
+	if chaptersFile != "" {
+		args = append(args, "-map_chapters", fmt.Sprint(len(files)))
+	}
+	args = append(args, "-c", "copy")
+
+	if format == "mp4" {
+		args = append(args, "-c:s", "mov_text")
+	}
+
+	// output stream index of the first stream of each file
+	first := 0
+	for i, f := range files {
+		count := 1
+		if i < len(streamCounts) && streamCounts[i] > 0 {
+			count = streamCounts[i]
+		}
+		streams := make([]int, count)
+		for j := range streams {
+			streams[j] = first + j
+		}
+		first += count
+
+		var disposition []string
+		if f.Default {
+			disposition = append(disposition, "default")
+		}
+		if f.Forced {
+			disposition = append(disposition, "forced")
+		}
+		if f.Role != nil {
+			if d, ok := roleDispositions[*f.Role]; ok {
+				disposition = append(disposition, d)
+			}
+		}
+		value := "0"
+		if len(disposition) > 0 {
+			value = strings.Join(disposition, "+")
+		}
+		for _, s := range streams {
+			if f.LangCode != "" {
+				args = append(args, fmt.Sprintf("-metadata:s:%d", s), "language="+ToISO639_2(f.LangCode))
+			}
+			if f.Title != "" {
+				args = append(args, fmt.Sprintf("-metadata:s:%d", s), "title="+f.Title)
+			}
+			args = append(args, fmt.Sprintf("-disposition:%d", s), value)
+		}
+	}
+
+	switch format {
+	case "mkv":
+		args = append(args, "-f", "matroska")
+	case "ts":
+		args = append(args, "-f", "mpegts")
+	default:
+		args = append(args, "-movflags", "+faststart", "-f", "mp4")
+	}
+	if !noDateInfo {
+		args = append(args, "-metadata", "creation_time="+time.Now().UTC().Format(time.RFC3339))
+	}
+	return append(args, output)
+}
+
+// Mux combines files into output with ffmpeg.
+func (f *FFmpegMerger) Mux(files []entity.OutputFile, output, format, chaptersFile string, noDateInfo bool) error {
+	streamCounts := make([]int, len(files))
+	for i, file := range files {
+		streamCounts[i] = f.CountStreams(file.FilePath)
+	}
+	return f.run(BuildFFmpegMuxArgs(files, streamCounts, output, format, chaptersFile, noDateInfo))
+}
+
+// CountStreams returns the number of streams ffmpeg finds in path, read from
+// the input summary it prints when given no output. It returns 0 when ffmpeg
+// cannot read the file.
+func (f *FFmpegMerger) CountStreams(path string) int {
+	// ffmpeg exits with an error without an output file, the summary is printed anyway
+	out, _ := exec.Command(f.BinaryPath, "-hide_banner", "-i", path).CombinedOutput()
+	streams := make(map[string]bool)
+	for _, m := range streamLineRegex.FindAllSubmatch(out, -1) {
+		streams[string(m[1])] = true
+	}
+	return len(streams)
+}
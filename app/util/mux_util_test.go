@@ -0,0 +1,56 @@
+package util
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+)
+
+func TestBuildFFmpegMuxArgsIndexesOutputStreams(t *testing.T) {
+	commentary := enums.Commentary
+	files := []entity.OutputFile{
+		{FilePath: "video.ts", MediaType: enums.VIDEO, LangCode: "en", Default: true},
+		{FilePath: "audio.m4a", MediaType: enums.AUDIO, LangCode: "ja", Title: "Commentary", Role: &commentary},
+		{FilePath: "sub.srt", MediaType: enums.SUBTITLES, LangCode: "fr"},
+	}
+	// the video file is a muxed TS holding video and audio
+	got := BuildFFmpegMuxArgs(files, []int{2, 1, 1}, "out.mkv", "mkv", "", true)
+	want := []string{
+		"-loglevel", "warning", "-nostats", "-progress", "pipe:1", "-y",
+		"-i", "video.ts", "-i", "audio.m4a", "-i", "sub.srt",
+		"-map", "0", "-map", "1", "-map", "2",
+		"-c", "copy",
+		"-metadata:s:0", "language=eng", "-disposition:0", "default",
+		"-metadata:s:1", "language=eng", "-disposition:1", "default",
+		"-metadata:s:2", "language=jpn", "-metadata:s:2", "title=Commentary", "-disposition:2", "comment",
+		"-metadata:s:3", "language=fra", "-disposition:3", "0",
+		"-f", "matroska", "out.mkv",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestCountStreams(t *testing.T) {
+	merger, _ := fakeFFmpeg(t)
+	script := `#!/bin/sh
+cat >&2 <<'EOF'
+Input #0, mpegts, from 'video.ts':
+  Duration: 00:00:10.00, start: 1.400000, bitrate: 1000 kb/s
+  Program 1
+    Stream #0:0[0x100]: Video: h264 (High) ([27][0][0][0] / 0x001B), yuv420p, 1920x1080
+    Stream #0:1[0x101](eng): Audio: aac (LC) ([15][0][0][0] / 0x000F), 48000 Hz, stereo
+At least one output file must be specified
+EOF
+exit 1
+`
+	if err := os.WriteFile(merger.BinaryPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := merger.CountStreams("video.ts"); got != 2 {
+		t.Errorf("got %d streams, want 2", got)
+	}
+}
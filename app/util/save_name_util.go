@@ -0,0 +1,61 @@
+package util
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// genericManifestNames say nothing about the content, so the directory above is used instead.
+var genericManifestNames = map[string]bool{
+	"master": true, "index": true, "playlist": true, "manifest": true, "main": true,
+	"prog_index": true, "stream": true, "video": true, "dash": true, "hls": true,
+}
+
+// SaveNameFromUrl takes a name from a manifest URL's path: the file name
+// without its extension, or its directory when the file name is generic.
+func SaveNameFromUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	dir, file := path.Split(strings.TrimSuffix(u.Path, "/"))
+	name := strings.TrimSuffix(file, path.Ext(file))
+	if genericManifestNames[strings.ToLower(name)] {
+		name = path.Base(strings.TrimSuffix(dir, "/"))
+	}
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// DefaultSaveName picks the save name when --save-name is not given: a name
+// from the manifest URL followed by the start time unless noDateInfo is set.
+// Characters that can't appear in a file name are replaced.
+func DefaultSaveName(manifestUrl string, noDateInfo bool, start time.Time) string {
+	name := SaveNameFromUrl(manifestUrl)
+	stamp := start.Format("2006-01-02_15-04-05")
+	switch {
+	case name == "":
+		name = stamp
+	case !noDateInfo:
+		name += "_" + stamp
+	}
+	return SanitizeFileName(name)
+}
+
+// TmpDirName names the temporary directory of a download without --save-name.
+// It leaves out the start time, so rerunning the same manifest resumes in the
+// same directory; a URL without a usable name gets a hash of the URL instead.
+func TmpDirName(manifestUrl string) string {
+	if name := SaveNameFromUrl(manifestUrl); name != "" {
+		return SanitizeFileName(name)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(manifestUrl))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
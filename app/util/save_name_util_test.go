@@ -0,0 +1,62 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveNameFromUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://cdn.example.com/shows/episode-1.m3u8?token=abc", "episode-1"},
+		{"https://cdn.example.com/shows/episode-1/master.m3u8", "episode-1"},
+		{"https://cdn.example.com/movie/Manifest.mpd", "movie"},
+		{"https://cdn.example.com/movie/stream.ism/manifest", "stream.ism"},
+		{"https://cdn.example.com/live/", "live"},
+		{"https://cdn.example.com/index.m3u8", ""},
+		{"https://cdn.example.com/", ""},
+		{"https://cdn.example.com", ""},
+		{"/local/path/show.m3u8", "show"},
+		{"https://cdn.example.com/a%20b.m3u8", "a b"},
+		{"://bad", ""},
+	}
+	for _, tt := range tests {
+		if got := SaveNameFromUrl(tt.url); got != tt.want {
+			t.Errorf("SaveNameFromUrl(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultSaveName(t *testing.T) {
+	start := time.Date(2024, 3, 5, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		url        string
+		noDateInfo bool
+		want       string
+	}{
+		{"https://cdn.example.com/shows/episode-1.m3u8", false, "episode-1_2024-03-05_07-08-09"},
+		{"https://cdn.example.com/shows/episode-1.m3u8", true, "episode-1"},
+		// without a name the start time is all there is
+		{"https://cdn.example.com/index.m3u8", false, "2024-03-05_07-08-09"},
+		{"https://cdn.example.com/index.m3u8", true, "2024-03-05_07-08-09"},
+		{"https://cdn.example.com/a:b%3F.m3u8", true, "a_b_"},
+		{"https://cdn.example.com/CON.m3u8", true, "CON_"},
+	}
+	for _, tt := range tests {
+		if got := DefaultSaveName(tt.url, tt.noDateInfo, start); got != tt.want {
+			t.Errorf("DefaultSaveName(%q, %v) = %q, want %q", tt.url, tt.noDateInfo, got, tt.want)
+		}
+	}
+}
+
+func TestTmpDirName(t *testing.T) {
+	if got := TmpDirName("https://cdn.example.com/shows/episode-1/master.m3u8"); got != "episode-1" {
+		t.Errorf("named URL: got %q", got)
+	}
+	a, b := TmpDirName("https://a.example.com/index.m3u8"), TmpDirName("https://b.example.com/index.m3u8")
+	if len(a) != 8 || a == b || a != TmpDirName("https://a.example.com/index.m3u8") {
+		t.Errorf("unnamed URLs: got %q and %q, want distinct stable hashes", a, b)
+	}
+}
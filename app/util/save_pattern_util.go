@@ -0,0 +1,184 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+var (
+	savePatternRegex  = regexp.MustCompile(`<(\w+)(?::([^>]*))?>`)
+	reservedNameRegex = regexp.MustCompile(`(?i)^(CON|PRN|AUX|NUL|COM[0-9]|LPT[0-9])(\..*)?$`)
+)
+
+// separatorChars may sit between pattern variables and are dropped along with an empty neighbour.
+const separatorChars = "._- "
+
+// SavePatternVars are the values a --save-pattern can refer to.
+type SavePatternVars struct {
+	SaveName string
+	// Id is the stream's position among the selected streams.
+	Id   int
+	Spec *entity.StreamSpec
+	// Ext is the output extension without the dot.
+	Ext  string
+	Time time.Time
+}
+
+func (v *SavePatternVars) lookup(name, arg string) string {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	spec := v.Spec
+	if spec == nil {
+		spec = &entity.StreamSpec{}
+	}
+	switch name {
+	case "SaveName":
+		return v.SaveName
+	case "Id":
+		return strconv.Itoa(v.Id)
+	case "GroupId":
+		return deref(spec.GroupId)
+	case "Codecs":
+		return deref(spec.Codecs)
+	case "Language":
+		return deref(spec.Language)
+	case "Resolution":
+		return deref(spec.Resolution)
+	case "Bandwidth":
+		if spec.Bandwidth == nil {
+			return ""
+		}
+		return strconv.Itoa(*spec.Bandwidth)
+	case "MediaType":
+		if spec.MediaType == nil {
+			return ""
+		}
+		return spec.MediaType.String()
+	case "Ext":
+		return v.Ext
+	case "Date":
+		if arg == "" {
+			arg = "2006-01-02_15-04-05"
+		}
+		return v.Time.Format(arg)
+	}
+	return ""
+}
+
+// FormatSavePattern fills in a --save-pattern such as
+// "<SaveName>.<Language>.<Resolution>.<Ext>". <Date:layout> takes a Go time
+// layout. Literal runs made only of separators ("._- ") are kept only when
+// there is text on both sides, so "<SaveName>.<Language>" with no language
+// gives just the save name. Unknown variables are empty. The result
+// is sanitized with SanitizeFileName.
+func FormatSavePattern(pattern string, vars SavePatternVars) string {
+	type piece struct {
+		text string
+		sep  bool
+	}
+	var pieces []piece
+	last := 0
+	for _, loc := range savePatternRegex.FindAllStringSubmatchIndex(pattern, -1) {
+		if loc[0] > last {
+			literal := pattern[last:loc[0]]
+			pieces = append(pieces, piece{text: literal, sep: strings.Trim(literal, separatorChars) == ""})
+		}
+		last = loc[1]
+		arg := ""
+		if loc[4] >= 0 {
+			arg = pattern[loc[4]:loc[5]]
+		}
+		value := vars.lookup(pattern[loc[2]:loc[3]], arg)
+		pieces = append(pieces, piece{text: replaceReserved(value)})
+	}
+	if last < len(pattern) {
+		literal := pattern[last:]
+		pieces = append(pieces, piece{text: literal, sep: strings.Trim(literal, separatorChars) == ""})
+	}
+
+	var sb strings.Builder
+	for i, p := range pieces {
+		if !p.sep {
+			sb.WriteString(p.text)
+			continue
+		}
+		// a separator needs non-empty text on both sides
+		before := sb.Len() > 0 && !strings.ContainsAny(sb.String()[sb.Len()-1:], separatorChars)
+		after := false
+		for _, next := range pieces[i+1:] {
+			if next.text != "" && !next.sep {
+				after = true
+				break
+			}
+		}
+		if before && after {
+			sb.WriteString(p.text)
+		}
+	}
+	return SanitizeFileName(sb.String())
+}
+
+// replaceReserved turns characters Linux or NTFS refuse in a file name into "_".
+func replaceReserved(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`\/:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// SanitizeFileName makes name safe to create on Linux and NTFS: reserved and
+// control characters become "_", trailing dots and spaces are dropped, device
+// names such as CON or NUL get a "_" before any extension and the length is capped at 200
+// bytes. Capping shortens the part before the extension, so "name.mp4" keeps
+// its ".mp4".
+func SanitizeFileName(name string) string {
+	name = strings.TrimRight(strings.TrimSpace(replaceReserved(name)), ". ")
+	if m := reservedNameRegex.FindStringSubmatch(name); m != nil {
+		// "NUL.txt" becomes "NUL_.txt", keeping the extension last
+		name = m[1] + "_" + m[2]
+	}
+	const maxLen, maxExtLen = 200, 16
+	if len(name) > maxLen {
+		ext := filepath.Ext(name)
+		if len(ext) > maxExtLen || len(ext) == len(name) {
+			ext = ""
+		}
+		stem := []rune(strings.TrimSuffix(name, ext))
+		for len(string(stem))+len(ext) > maxLen {
+			stem = stem[:len(stem)-1]
+		}
+		name = strings.TrimRight(string(stem), ". ") + ext
+	}
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
+
+// UniquePath returns path, or path with "-1", "-2"... before the extension
+// when it already exists on disk or taken reports it in use.
+func UniquePath(path string, taken func(string) bool) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for index := 1; ; index++ {
+		_, err := os.Stat(candidate)
+		if os.IsNotExist(err) && (taken == nil || !taken(candidate)) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, index, ext)
+	}
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/enums"
+)
+
+func TestFormatSavePattern(t *testing.T) {
+	lang, res, video, con := "en", "1920x1080", enums.VIDEO, "con"
+	bandwidth := 5000000
+	full := SavePatternVars{
+		SaveName: "show",
+		Id:       2,
+		Spec:     &entity.StreamSpec{Language: &lang, Resolution: &res, Bandwidth: &bandwidth, MediaType: &video},
+		Ext:      "mp4",
+		Time:     time.Date(2024, 3, 5, 7, 8, 9, 0, time.UTC),
+	}
+	empty := SavePatternVars{SaveName: "show", Ext: "mp4"}
+	long := full
+	long.SaveName = strings.Repeat("a", 300)
+
+	tests := []struct {
+		name    string
+		pattern string
+		vars    SavePatternVars
+		want    string
+	}{
+		{"all set", "<SaveName>.<Language>.<Resolution>.<Ext>", full, "show.en.1920x1080.mp4"},
+		{"empty variables drop their separators", "<SaveName>.<Language>.<Resolution>.<Ext>", empty, "show.mp4"},
+		{"leading empty variable", "<Language>_<SaveName>", empty, "show"},
+		{"id, bandwidth and media type", "<Id>-<MediaType>-<Bandwidth>", full, "2-VIDEO-5000000"},
+		{"literal text is kept", "[<SaveName>] part <Id>", empty, "[show] part 0"},
+		{"default date layout", "<SaveName>_<Date>", full, "show_2024-03-05_07-08-09"},
+		{"date layout", "<Date:20060102>", full, "20240305"},
+		{"unknown variables are empty", "<SaveName>.<Nope>.<Ext>", full, "show.mp4"},
+		{"reserved characters", "<SaveName>", SavePatternVars{SaveName: `a/b:c*d?"e"<f>|g`}, "a_b_c_d__e__f__g"},
+		{"device names", "<SaveName>", SavePatternVars{SaveName: "con"}, "con_"},
+		{"device names before the extension", "<Language>.<Ext>", SavePatternVars{Spec: &entity.StreamSpec{Language: &con}, Ext: "mp4"}, "con_.mp4"},
+		{"trailing dots and spaces", "<SaveName>. ", SavePatternVars{SaveName: "show. "}, "show"},
+		{"long names keep the extension", "<SaveName>.<Ext>", long, strings.Repeat("a", 196) + ".mp4"},
+	}
+	for _, tt := range tests {
+		if got := FormatSavePattern(tt.pattern, tt.vars); got != tt.want {
+			t.Errorf("%s: FormatSavePattern(%q) = %q, want %q", tt.name, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"plain.mp4", "plain.mp4"},
+		{"  spaced  ", "spaced"},
+		{"tab\there", "tab_here"},
+		{"NUL.txt", "NUL_.txt"},
+		{"aux.en.mp4", "aux_.en.mp4"},
+		{"COM1", "COM1_"},
+		{"console", "console"},
+		{"...", "_"},
+		{"", "_"},
+		{strings.Repeat("b", 250), strings.Repeat("b", 200)},
+		{strings.Repeat("c", 250) + ".m4a", strings.Repeat("c", 196) + ".m4a"},
+		// the cut lands on a rune boundary and drops the dots it exposes
+		{"a" + strings.Repeat("é", 150) + ".srt", "a" + strings.Repeat("é", 97) + ".srt"},
+		{strings.Repeat("f", 195) + "....." + strings.Repeat("g", 10) + ".ts", strings.Repeat("f", 195) + ".ts"},
+		// an extension that long is part of the name
+		{"x." + strings.Repeat("h", 250), "x." + strings.Repeat("h", 198)},
+	}
+	for _, tt := range tests {
+		got := SanitizeFileName(tt.name)
+		if got != tt.want {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(got) > 200 {
+			t.Errorf("SanitizeFileName(%q) is %d bytes", tt.name, len(got))
+		}
+	}
+}
+
+func TestUniquePath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	taken := map[string]bool{filepath.Join(dir, "a-1.mp4"): true, filepath.Join(dir, "b.mp4"): true}
+	tests := []struct {
+		path, want string
+	}{
+		{"new.mp4", "new.mp4"},
+		{"a.mp4", "a-2.mp4"},
+		{"b.mp4", "b-1.mp4"},
+		{"noext", "noext"},
+	}
+	for _, tt := range tests {
+		got := UniquePath(filepath.Join(dir, tt.path), func(p string) bool { return taken[p] })
+		if want := filepath.Join(dir, tt.want); got != want {
+			t.Errorf("UniquePath(%q) = %q, want %q", tt.path, got, want)
+		}
+	}
+}
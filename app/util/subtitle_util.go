@@ -0,0 +1,60 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/entity"
+	commonentity "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+)
+
+// EditSubtitle applies the `subtitle` subcommand's edits to a WebVTT, TTML or
+// SRT file and writes the result in opts.Format. It returns the output path.
+func EditSubtitle(opts *entity.SubtitleOptions, logger *log.Logger) (string, error) {
+	patterns := make([]*regexp.Regexp, 0, len(opts.Drop))
+	for _, expr := range opts.Drop {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", fmt.Errorf("invalid --drop regex %q: %w", expr, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	data, err := os.ReadFile(opts.Input)
+	if err != nil {
+		return "", err
+	}
+	sub, err := commonentity.ParseSubtitle(string(data))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", filepath.Base(opts.Input), err)
+	}
+	logger.Info("Loaded %d cues from %s", len(sub.Cues), opts.Input)
+
+	if removed := sub.RemoveMatching(patterns); removed > 0 {
+		logger.Info("Dropped %d cues", removed)
+	}
+	sub.Retime(opts.FromFps, opts.ToFps)
+	sub.ShiftTime(opts.Shift)
+	if merged := sub.MergeShortCues(opts.MergeShorter, opts.MergeGap); merged > 0 {
+		logger.Info("Merged %d short cues", merged)
+	}
+	if opts.AutoFix {
+		sub.FixTimestamps()
+	}
+
+	output := opts.Output
+	if output == "" {
+		output = strings.TrimSuffix(opts.Input, filepath.Ext(opts.Input)) + commonentity.SubtitleExt(opts.Format)
+		if output == opts.Input {
+			output = strings.TrimSuffix(opts.Input, filepath.Ext(opts.Input)) + ".edited" + commonentity.SubtitleExt(opts.Format)
+		}
+	}
+	if err := os.WriteFile(output, []byte(sub.ToFormat(opts.Format, opts.AssStyle)), 0644); err != nil {
+		return "", err
+	}
+	return output, nil
+}
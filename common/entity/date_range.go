@@ -0,0 +1,24 @@
+package entity
+
+import "time"
+
+// DateRange is an HLS EXT-X-DATERANGE tag. Attributes keeps every attribute,
+// including client-defined X- ones, as written in the playlist.
+type DateRange struct {
+	Id         string
+	Class      string
+	StartDate  time.Time
+	EndDate    *time.Time
+	Duration   *float64
+	Attributes map[string]string
+}
+
+// Title picks a human-readable name for the range, falling back to its id.
+func (d *DateRange) Title() string {
+	for _, key := range []string{"X-TITLE", "X-COM-TITLE", "X-NAME"} {
+		if v := d.Attributes[key]; v != "" {
+			return v
+		}
+	}
+	return d.Id
+}
@@ -0,0 +1,32 @@
+package entity
+
+// PsshInfo is one decoded pssh box, from the init segment or the manifest.
+type PsshInfo struct {
+	SystemID  string
+	System    string
+	KIDs      []string
+	Provider  string
+	ContentID string
+	// Data is the base64 of the complete pssh box, ready to hand to a licence server.
+	Data string
+}
+
+// DrmInfo describes what a stream needs to be decrypted. KIDs are lower-case hex.
+type DrmInfo struct {
+	Scheme string
+	KIDs   []string
+	Pssh   []PsshInfo
+}
+
+// AddKID appends kid unless it is already known.
+func (d *DrmInfo) AddKID(kid string) {
+	if kid == "" {
+		return
+	}
+	for _, k := range d.KIDs {
+		if k == kid {
+			return
+		}
+	}
+	d.KIDs = append(d.KIDs, kid)
+}
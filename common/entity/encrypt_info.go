@@ -10,6 +10,9 @@ type EncryptInfo struct {
 	Method enums.EncryptMethod
 	Key    []byte
 	IV     []byte
+	// KID is the default key id declared by the manifest (e.g. cenc:default_KID),
+	// stored as lower-case hex without dashes.
+	KID string
 }
 
 func NewEncryptInfo() *EncryptInfo {
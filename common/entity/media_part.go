@@ -2,6 +2,8 @@ package entity
 
 type MediaPart struct {
 	MediaSegments []MediaSegment // Slice of MediaSegment
+	// PeriodId is the DASH Period this part came from, when periods are joined.
+	PeriodId *string
 }
 
 func NewMediaPart() *MediaPart {
@@ -8,6 +8,8 @@ type Playlist struct {
 	TargetDuration    *float64
 	MediaInit         *MediaSegment
 	MediaParts        []MediaPart
+	// DateRanges holds the EXT-X-DATERANGE tags of an HLS playlist.
+	DateRanges []DateRange
 }
 
 func (p *Playlist) GetTotalDuration() *float64 {
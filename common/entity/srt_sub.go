@@ -0,0 +1,104 @@
+package entity
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	srtTimingRegex = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{1,2}(?:[,.]\d+)?)\s*-->\s*((?:\d+:)?\d{1,2}:\d{1,2}(?:[,.]\d+)?)`)
+	srtIndexRegex  = regexp.MustCompile(`^\s*\d+\s*$`)
+	srtTagRegex    = regexp.MustCompile(`(?i)</?(i|b|u|font)(?:\s[^>]*)?>`)
+	srtAssTagRegex = regexp.MustCompile(`\{\\[^}]*\}`)
+)
+
+// ParseSrt reads a SubRip file into a WebVttSub. Milliseconds may follow a
+// comma or a dot, cue indexes may be missing and a leading BOM is ignored.
+// <i>, <b> and <u> are kept, other markup is dropped and text is escaped for WebVTT.
+func ParseSrt(text string) (*WebVttSub, error) {
+	text = strings.TrimPrefix(strings.ReplaceAll(text, "\r\n", "\n"), "\ufeff")
+	sub := &WebVttSub{}
+	for _, block := range splitBlocks(strings.ReplaceAll(text, "\r", "\n")) {
+		timing := -1
+		for j := 0; j < len(block) && j < 2; j++ {
+			if srtTimingRegex.MatchString(block[j]) {
+				timing = j
+				break
+			}
+		}
+		if timing < 0 || timing == 1 && !srtIndexRegex.MatchString(block[0]) {
+			// a blank line inside the previous cue's text
+			if n := len(sub.Cues); n > 0 {
+				sub.Cues[n-1].Payload += "\n" + srtPayload(block)
+			}
+			continue
+		}
+		m := srtTimingRegex.FindStringSubmatch(block[timing])
+		payload := srtPayload(block[timing+1:])
+		if strings.TrimSpace(payload) == "" {
+			continue
+		}
+		sub.Cues = append(sub.Cues, SubCue{
+			StartTime: srtTime(m[1]),
+			EndTime:   srtTime(m[2]),
+			Payload:   payload,
+		})
+	}
+	if len(sub.Cues) == 0 && strings.TrimSpace(text) != "" {
+		return nil, fmt.Errorf("bad srt")
+	}
+	return sub, nil
+}
+
+// srtTime parses h:mm:ss,fff, where the fraction may be any number of digits.
+func srtTime(s string) time.Duration {
+	s = strings.ReplaceAll(s, ",", ".")
+	clock, frac, _ := strings.Cut(s, ".")
+	var seconds int64
+	for _, part := range strings.Split(clock, ":") {
+		v, _ := strconv.ParseInt(part, 10, 64)
+		seconds = seconds*60 + v
+	}
+	d := time.Duration(seconds) * time.Second
+	if frac != "" {
+		f, _ := strconv.ParseFloat("0."+frac, 64)
+		d += time.Duration(f * float64(time.Second)).Round(time.Millisecond)
+	}
+	return d
+}
+
+func srtPayload(lines []string) string {
+	var out []string
+	for _, line := range lines {
+		out = append(out, escapeVttText(srtAssTagRegex.ReplaceAllString(strings.TrimSpace(line), "")))
+	}
+	return strings.Join(out, "\n")
+}
+
+// escapeVttText escapes &, < and > outside the tags WebVTT shares with SubRip.
+func escapeVttText(text string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range srtTagRegex.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(escapeVttChars(text[last:loc[0]]))
+		last = loc[1]
+		name := strings.ToLower(text[loc[2]:loc[3]])
+		if name == "font" {
+			continue
+		}
+		if text[loc[0]+1] == '/' {
+			sb.WriteString("</" + name + ">")
+		} else {
+			sb.WriteString("<" + name + ">")
+		}
+	}
+	sb.WriteString(escapeVttChars(text[last:]))
+	return sb.String()
+}
+
+func escapeVttChars(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,114 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSrt(t *testing.T) {
+	ms := func(v int64) time.Duration { return time.Duration(v) * time.Millisecond }
+	tests := []struct {
+		name string
+		srt  string
+		want []SubCue
+	}{
+		{
+			"comma separator",
+			"1\n00:00:01,500 --> 00:00:03,000\nHello\n\n2\n00:01:02,003 --> 01:00:00,000\nworld\n",
+			[]SubCue{
+				{StartTime: ms(1500), EndTime: ms(3000), Payload: "Hello"},
+				{StartTime: ms(62003), EndTime: ms(3600000), Payload: "world"},
+			},
+		},
+		{
+			"dot separator and short fractions",
+			"1\n00:00:01.5 --> 00:00:02.25\nDots\n",
+			[]SubCue{{StartTime: ms(1500), EndTime: ms(2250), Payload: "Dots"}},
+		},
+		{
+			"no hours",
+			"1\n01:02,000 --> 01:03,000\nShort\n",
+			[]SubCue{{StartTime: ms(62000), EndTime: ms(63000), Payload: "Short"}},
+		},
+		{
+			"missing indexes",
+			"00:00:01,000 --> 00:00:02,000\nOne\n\n00:00:03,000 --> 00:00:04,000\nTwo\n",
+			[]SubCue{
+				{StartTime: ms(1000), EndTime: ms(2000), Payload: "One"},
+				{StartTime: ms(3000), EndTime: ms(4000), Payload: "Two"},
+			},
+		},
+		{
+			"BOM and CRLF",
+			"\ufeff1\r\n00:00:01,000 --> 00:00:02,000\r\nLine one\r\nLine two\r\n\r\n",
+			[]SubCue{{StartTime: ms(1000), EndTime: ms(2000), Payload: "Line one\nLine two"}},
+		},
+		{
+			"blank line inside a cue",
+			"1\n00:00:01,000 --> 00:00:02,000\nFirst\n\nstill first\n\n2\n00:00:03,000 --> 00:00:04,000\nSecond\n",
+			[]SubCue{
+				{StartTime: ms(1000), EndTime: ms(2000), Payload: "First\nstill first"},
+				{StartTime: ms(3000), EndTime: ms(4000), Payload: "Second"},
+			},
+		},
+		{
+			"markup",
+			"1\n00:00:01,000 --> 00:00:02,000\n<I>Tilt</I> <font color=\"red\">red</font> {\\an8}<b>top</b> & 1 < 2\n",
+			[]SubCue{{StartTime: ms(1000), EndTime: ms(2000), Payload: "<i>Tilt</i> red <b>top</b> &amp; 1 &lt; 2"}},
+		},
+		{
+			"empty cues are dropped",
+			"1\n00:00:01,000 --> 00:00:02,000\n\n2\n00:00:03,000 --> 00:00:04,000\nKept\n",
+			[]SubCue{{StartTime: ms(3000), EndTime: ms(4000), Payload: "Kept"}},
+		},
+		{"empty file", "", nil},
+	}
+	for _, tt := range tests {
+		sub, err := ParseSrt(tt.srt)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sub.Cues, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, sub.Cues, tt.want)
+		}
+	}
+	if _, err := ParseSrt("not a subtitle"); err == nil {
+		t.Error("got no error for text without cues")
+	}
+}
+
+func TestSrtRoundTrip(t *testing.T) {
+	const srt = "1\n00:00:01,500 --> 00:00:03,000\n<i>Hello</i> & <bye>\n\n2\n01:02:03,004 --> 01:02:05,000\nTwo\nlines\n\n"
+	sub, err := ParseSrt(srt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sub.ToSrt(); got != srt {
+		t.Errorf("got  %q\nwant %q", got, srt)
+	}
+}
+
+func TestParseSubtitleDetectsFormat(t *testing.T) {
+	tests := []struct {
+		name, text, payload string
+	}{
+		{"webvtt", "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nvtt\n", "vtt"},
+		{"srt", "1\n00:00:01,000 --> 00:00:02,000\nsrt\n", "srt"},
+		{"ttml", `<tt xmlns="http://www.w3.org/ns/ttml"><body><div><p begin="1s" end="2s">ttml</p></div></body></tt>`, "ttml"},
+	}
+	for _, tt := range tests {
+		sub, err := ParseSubtitle(tt.text)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(sub.Cues) != 1 || sub.Cues[0].Payload != tt.payload {
+			t.Errorf("%s: got %+v", tt.name, sub.Cues)
+		}
+	}
+	if _, err := ParseSubtitle("just text"); err == nil {
+		t.Error("got no error for an unknown format")
+	}
+}
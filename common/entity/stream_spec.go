@@ -15,6 +15,7 @@ type StreamSpec struct {
 	Language        *string
 	Name            *string
 	Default         *enums.Choice
+	Forced          *enums.Choice // HLS FORCED attribute of a subtitle rendition
 	SkippedDuration *float64
 	MSSData         *MSSData
 	Bandwidth       *int
@@ -35,6 +36,11 @@ type StreamSpec struct {
 	OriginalUrl     string
 	Playlist        *Playlist
 	SegmentsCount   int
+	// DrmInfo holds PSSH data from the manifest and, once inspected, from the init segment.
+	DrmInfo *DrmInfo
+	// Headers are the request headers the stream was parsed with, recorded in
+	// meta.json so it can be downloaded elsewhere or later.
+	Headers map[string]string
 }
 
 func (s *StreamSpec) GetSegmentsCount() *int {
@@ -379,3 +385,29 @@ func safeDeref(s *string, defaultValue string) string {
 	}
 	return *s
 }
+
+// WithoutKeys returns a copy of the spec whose segments carry no key material,
+// for writing to meta.json. The original playlist is left untouched.
+func (s StreamSpec) WithoutKeys() StreamSpec {
+	if s.Playlist == nil {
+		return s
+	}
+	playlist := *s.Playlist
+	if playlist.MediaInit != nil {
+		init := *playlist.MediaInit
+		init.EncryptInfo.Key = nil
+		playlist.MediaInit = &init
+	}
+	playlist.MediaParts = make([]MediaPart, len(s.Playlist.MediaParts))
+	for i, part := range s.Playlist.MediaParts {
+		segments := make([]MediaSegment, len(part.MediaSegments))
+		for j, seg := range part.MediaSegments {
+			seg.EncryptInfo.Key = nil
+			segments[j] = seg
+		}
+		part.MediaSegments = segments
+		playlist.MediaParts[i] = part
+	}
+	s.Playlist = &playlist
+	return s
+}
@@ -3,12 +3,22 @@ package entity
 import (
 	"hash"
 	"hash/fnv"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/utils"
 )
 
+var (
+	cueTagRegex   = regexp.MustCompile(`<(/?)([^\s.>]*)([^\s>]*)(?:\s+([^>]*))?>`)
+	cueEntityText = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", "\u00a0", "&lrm;", "\u200e", "&rlm;", "\u200f")
+)
+
+// SubCue is one WebVTT cue. Payload is the cue text with its WebVTT markup and
+// line breaks; use PlainText or SrtText where markup isn't understood.
 type SubCue struct {
+	Id        string
 	StartTime time.Duration
 	EndTime   time.Duration
 	Payload   string
@@ -51,3 +61,41 @@ func localHashInt64(h hash.Hash64, i int64) {
 	}
 	h.Write(buf[:])
 }
+
+// Voice returns the speaker named by the cue's first <v> span, if any.
+func (s *SubCue) Voice() string {
+	for _, m := range cueTagRegex.FindAllStringSubmatch(s.Payload, -1) {
+		if m[1] == "" && m[2] == "v" {
+			return strings.TrimSpace(m[4])
+		}
+	}
+	return ""
+}
+
+// PlainText is the payload without markup. Speakers are kept as a "Name: " prefix.
+func (s *SubCue) PlainText() string {
+	return s.renderText(false)
+}
+
+// SrtText is PlainText keeping the italic, bold and underline tags SubRip supports.
+func (s *SubCue) SrtText() string {
+	return s.renderText(true)
+}
+
+func (s *SubCue) renderText(keepStyle bool) string {
+	text := cueTagRegex.ReplaceAllStringFunc(s.Payload, func(tag string) string {
+		m := cueTagRegex.FindStringSubmatch(tag)
+		switch m[2] {
+		case "v":
+			if m[1] == "" && strings.TrimSpace(m[4]) != "" {
+				return strings.TrimSpace(m[4]) + ": "
+			}
+		case "i", "b", "u":
+			if keepStyle {
+				return "<" + m[1] + m[2] + ">"
+			}
+		}
+		return ""
+	})
+	return cueEntityText.Replace(text)
+}
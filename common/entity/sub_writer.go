@@ -0,0 +1,391 @@
+package entity
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubtitleExt is the file extension for a --sub-format value.
+func SubtitleExt(format string) string {
+	switch strings.ToUpper(format) {
+	case "VTT":
+		return ".vtt"
+	case "ASS":
+		return ".ass"
+	case "TTML":
+		return ".ttml"
+	}
+	return ".srt"
+}
+
+// ToFormat renders the cues as SRT, VTT, ASS or TTML; anything else gives SRT.
+// assStyle is only used for ASS and may be nil.
+func (w *WebVttSub) ToFormat(format string, assStyle *AssStyle) string {
+	switch strings.ToUpper(format) {
+	case "VTT":
+		return w.ToVtt()
+	case "ASS":
+		return w.ToAss(assStyle)
+	case "TTML":
+		return w.ToTtml()
+	}
+	return w.ToSrt()
+}
+
+// AssStyle is the Default style written into the [V4+ Styles] section by ToAss.
+// Colours use the ASS &HAABBGGRR notation.
+type AssStyle struct {
+	FontName      string
+	FontSize      int
+	PrimaryColour string
+	OutlineColour string
+	BackColour    string
+	Bold          bool
+	Italic        bool
+	Outline       float64
+	Shadow        float64
+	// Alignment is the numpad position used when a cue has no settings (2 = bottom centre).
+	Alignment int
+	MarginL   int
+	MarginR   int
+	MarginV   int
+	PlayResX  int
+	PlayResY  int
+}
+
+func NewAssStyle() *AssStyle {
+	return &AssStyle{
+		FontName:      "Arial",
+		FontSize:      54,
+		PrimaryColour: "&H00FFFFFF",
+		OutlineColour: "&H00000000",
+		BackColour:    "&H80000000",
+		Outline:       2.5,
+		Alignment:     2,
+		MarginL:       60,
+		MarginR:       60,
+		MarginV:       50,
+		PlayResX:      1920,
+		PlayResY:      1080,
+	}
+}
+
+var assColourRegex = regexp.MustCompile(`^&[Hh](?:[0-9A-Fa-f]{2})?[0-9A-Fa-f]{6}$`)
+
+// ParseAssStyle reads colon-separated key=value pairs over NewAssStyle(), e.g.
+// "font=Noto Sans:size=48:primary=&H0000FFFF:align=8". Keys are font, size,
+// primary, outline_colour, back, bold, italic, outline, shadow, align,
+// margin_l, margin_r, margin_v, res_x and res_y.
+func ParseAssStyle(value string) (*AssStyle, error) {
+	s := NewAssStyle()
+	for _, pair := range strings.Split(value, ":") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid ass style option: %s, expecting key=value", pair)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "font":
+			if s.FontName = val; val == "" || strings.Contains(val, ",") {
+				err = fmt.Errorf("bad font name")
+			}
+		case "size":
+			s.FontSize, err = strconv.Atoi(val)
+		case "primary", "outline_colour", "back":
+			if !assColourRegex.MatchString(val) {
+				err = fmt.Errorf("bad colour")
+				break
+			}
+			colour := "&H" + strings.ToUpper(val[2:])
+			switch strings.ToLower(key) {
+			case "primary":
+				s.PrimaryColour = colour
+			case "outline_colour":
+				s.OutlineColour = colour
+			default:
+				s.BackColour = colour
+			}
+		case "bold":
+			s.Bold, err = strconv.ParseBool(val)
+		case "italic":
+			s.Italic, err = strconv.ParseBool(val)
+		case "outline":
+			s.Outline, err = strconv.ParseFloat(val, 64)
+		case "shadow":
+			s.Shadow, err = strconv.ParseFloat(val, 64)
+		case "align":
+			if s.Alignment, err = strconv.Atoi(val); err == nil && (s.Alignment < 1 || s.Alignment > 9) {
+				err = fmt.Errorf("out of range")
+			}
+		case "margin_l":
+			s.MarginL, err = strconv.Atoi(val)
+		case "margin_r":
+			s.MarginR, err = strconv.Atoi(val)
+		case "margin_v":
+			s.MarginV, err = strconv.Atoi(val)
+		case "res_x":
+			s.PlayResX, err = strconv.Atoi(val)
+		case "res_y":
+			s.PlayResY, err = strconv.Atoi(val)
+		default:
+			return nil, fmt.Errorf("unknown ass style option: %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", key, val)
+		}
+	}
+	if s.FontSize <= 0 || s.PlayResX <= 0 || s.PlayResY <= 0 {
+		return nil, fmt.Errorf("ass style size, res_x and res_y must be positive")
+	}
+	return s, nil
+}
+
+func (s *AssStyle) String() string {
+	return fmt.Sprintf("font=%s:size=%d:primary=%s:outline_colour=%s:back=%s:bold=%t:italic=%t:outline=%s:shadow=%s:align=%d:margin_l=%d:margin_r=%d:margin_v=%d:res_x=%d:res_y=%d",
+		s.FontName, s.FontSize, s.PrimaryColour, s.OutlineColour, s.BackColour, s.Bold, s.Italic,
+		strconv.FormatFloat(s.Outline, 'f', -1, 64), strconv.FormatFloat(s.Shadow, 'f', -1, 64),
+		s.Alignment, s.MarginL, s.MarginR, s.MarginV, s.PlayResX, s.PlayResY)
+}
+
+func (s *AssStyle) header() string {
+	assBool := func(b bool) int {
+		if b {
+			return -1
+		}
+		return 0
+	}
+	var sb strings.Builder
+	sb.WriteString("[Script Info]\nScriptType: v4.00+\n")
+	fmt.Fprintf(&sb, "PlayResX: %d\nPlayResY: %d\nWrapStyle: 0\nScaledBorderAndShadow: yes\n\n", s.PlayResX, s.PlayResY)
+	sb.WriteString("[V4+ Styles]\n")
+	sb.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	fmt.Fprintf(&sb, "Style: Default,%s,%d,%s,&H000000FF,%s,%s,%d,%d,0,0,100,100,0,0,1,%s,%s,%d,%d,%d,%d,1\n\n",
+		s.FontName, s.FontSize, s.PrimaryColour, s.OutlineColour, s.BackColour, assBool(s.Bold), assBool(s.Italic),
+		strconv.FormatFloat(s.Outline, 'f', -1, 64), strconv.FormatFloat(s.Shadow, 'f', -1, 64),
+		s.Alignment, s.MarginL, s.MarginR, s.MarginV)
+	sb.WriteString("[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	return sb.String()
+}
+
+// cueSettings is the part of a WebVTT cue's settings that affects placement.
+type cueSettings struct {
+	line       *float64 // percent from the top
+	lineNumber *int     // line index, negative counts from the bottom
+	position   *float64 // percent from the left
+	align      string
+}
+
+func parseCueSettings(settings string) cueSettings {
+	var cs cueSettings
+	for _, field := range strings.Fields(settings) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		value, _, _ = strings.Cut(value, ",")
+		switch key {
+		case "line":
+			if strings.HasSuffix(value, "%") {
+				if v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64); err == nil {
+					cs.line = &v
+				}
+			} else if v, err := strconv.Atoi(value); err == nil {
+				cs.lineNumber = &v
+			}
+		case "position":
+			if v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64); err == nil {
+				cs.position = &v
+			}
+		case "align":
+			cs.align = value
+		}
+	}
+	return cs
+}
+
+// column maps the align setting onto ASS numpad columns 1 (left), 2 or 3 (right).
+func (cs cueSettings) column() int {
+	switch cs.align {
+	case "start", "left":
+		return 1
+	case "end", "right":
+		return 3
+	}
+	return 2
+}
+
+// assOverride returns the {\an..\pos(..)} tag placing a cue as its settings describe.
+func (cs cueSettings) assOverride(style *AssStyle) string {
+	column := cs.column()
+	if cs.line == nil && cs.lineNumber == nil && cs.position == nil {
+		if cs.align == "" || column == 2 {
+			return ""
+		}
+		return fmt.Sprintf(`{\an%d}`, column)
+	}
+
+	x := float64(style.PlayResX) / 2
+	switch {
+	case cs.position != nil:
+		x = *cs.position / 100 * float64(style.PlayResX)
+	case column == 1:
+		x = float64(style.MarginL)
+	case column == 3:
+		x = float64(style.PlayResX - style.MarginR)
+	}
+	// A cue's line is where its top edge goes, unless counted from the bottom.
+	an := column + 6
+	y := float64(style.PlayResY - style.MarginV)
+	lineHeight := float64(style.FontSize) * 1.2
+	switch {
+	case cs.line != nil:
+		y = *cs.line / 100 * float64(style.PlayResY)
+	case cs.lineNumber != nil && *cs.lineNumber >= 0:
+		y = float64(*cs.lineNumber) * lineHeight
+	case cs.lineNumber != nil:
+		an = column
+		y = float64(style.PlayResY) - float64(-*cs.lineNumber-1)*lineHeight - float64(style.MarginV)
+	default:
+		an = column
+	}
+	return fmt.Sprintf(`{\an%d\pos(%d,%d)}`, an, int(x), int(y))
+}
+
+// ToAss renders the cues as an ASS script using style, or NewAssStyle() when nil.
+// Cue placement is taken from the line, position and align settings.
+func (w *WebVttSub) ToAss(style *AssStyle) string {
+	if style == nil {
+		style = NewAssStyle()
+	}
+	var sb strings.Builder
+	sb.WriteString(style.header())
+	for _, cue := range w.getCues() {
+		text := parseCueSettings(cue.Settings).assOverride(style) + vttToAssText(cue.Payload)
+		fmt.Fprintf(&sb, "Dialogue: 0,%s,%s,Default,%s,0,0,0,,%s\n", assTime(cue.StartTime), assTime(cue.EndTime), strings.ReplaceAll(cue.Voice(), ",", " "), text)
+	}
+	return sb.String()
+}
+
+func assTime(d time.Duration) string {
+	cs := d.Round(10*time.Millisecond).Milliseconds() / 10
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// assTextEscaper keeps braces in cue text from opening override blocks.
+var assTextEscaper = strings.NewReplacer("{", `\{`, "}", `\}`)
+
+func vttToAssText(payload string) string {
+	text := cueTagRegex.ReplaceAllStringFunc(assTextEscaper.Replace(payload), func(tag string) string {
+		m := cueTagRegex.FindStringSubmatch(tag)
+		state := "1"
+		if m[1] == "/" {
+			state = "0"
+		}
+		switch m[2] {
+		case "i", "b", "u", "s":
+			return `{\` + m[2] + state + "}"
+		}
+		return ""
+	})
+	text = cueEntityText.Replace(text)
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r", ""), "\n", `\N`)
+}
+
+// ToTtml renders the cues as a TTML document on the media timeline. Cue
+// settings with a line or position become regions; italic, bold and underline
+// tags become styled spans.
+func (w *WebVttSub) ToTtml() string {
+	var body strings.Builder
+	regions := map[string]string{}
+	var regionDefs strings.Builder
+	for _, cue := range w.getCues() {
+		region := "bottom"
+		cs := parseCueSettings(cue.Settings)
+		if cs.line != nil || cs.position != nil || cs.align != "" {
+			key := cue.Settings
+			if id, ok := regions[key]; ok {
+				region = id
+			} else {
+				region = fmt.Sprintf("r%d", len(regions)+1)
+				regions[key] = region
+				regionDefs.WriteString(cs.ttmlRegion(region))
+			}
+		}
+		fmt.Fprintf(&body, "      <p begin=\"%s\" end=\"%s\" region=\"%s\">%s</p>\n",
+			ttmlTime(cue.StartTime), ttmlTime(cue.EndTime), region, vttToTtmlText(cue.Payload))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling" xmlns:ttp="http://www.w3.org/ns/ttml#parameter" ttp:timeBase="media">` + "\n")
+	sb.WriteString("  <head>\n    <layout>\n")
+	sb.WriteString(`      <region xml:id="bottom" tts:origin="10% 10%" tts:extent="80% 80%" tts:displayAlign="after" tts:textAlign="center"/>` + "\n")
+	sb.WriteString(regionDefs.String())
+	sb.WriteString("    </layout>\n  </head>\n  <body>\n    <div>\n")
+	sb.WriteString(body.String())
+	sb.WriteString("    </div>\n  </body>\n</tt>\n")
+	return sb.String()
+}
+
+func (cs cueSettings) ttmlRegion(id string) string {
+	textAlign := []string{"", "left", "center", "right"}[cs.column()]
+	top := 80.0
+	displayAlign := "after"
+	if cs.line != nil {
+		top = min(*cs.line, 90)
+		displayAlign = "before"
+	}
+	left := 10.0
+	if cs.position != nil {
+		left = max(0, min(*cs.position-40, 20))
+	}
+	return fmt.Sprintf("      <region xml:id=\"%s\" tts:origin=\"%s%% %s%%\" tts:extent=\"80%% %s%%\" tts:displayAlign=\"%s\" tts:textAlign=\"%s\"/>\n",
+		id, formatPercent(left), formatPercent(top), formatPercent(100-top), displayAlign, textAlign)
+}
+
+func ttmlTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func vttToTtmlText(payload string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range cueTagRegex.FindAllStringSubmatchIndex(payload, -1) {
+		sb.WriteString(escapeXml(cueEntityText.Replace(payload[last:loc[0]])))
+		last = loc[1]
+		name := payload[loc[4]:loc[5]]
+		if loc[3] > loc[2] {
+			if name == "i" || name == "b" || name == "u" {
+				sb.WriteString("</span>")
+			}
+			continue
+		}
+		switch name {
+		case "v":
+			// keep the speaker, as SrtText does
+			if loc[8] >= 0 {
+				sb.WriteString(escapeXml(strings.TrimSpace(payload[loc[8]:loc[9]])) + ": ")
+			}
+		case "i":
+			sb.WriteString(`<span tts:fontStyle="italic">`)
+		case "b":
+			sb.WriteString(`<span tts:fontWeight="bold">`)
+		case "u":
+			sb.WriteString(`<span tts:textDecoration="underline">`)
+		}
+	}
+	sb.WriteString(escapeXml(cueEntityText.Replace(payload[last:])))
+	return strings.ReplaceAll(sb.String(), "\n", "<br/>")
+}
+
+func escapeXml(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
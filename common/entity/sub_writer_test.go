@@ -0,0 +1,137 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssOverride(t *testing.T) {
+	tests := []struct {
+		settings string
+		want     string
+	}{
+		{"", ""},
+		{"align:center", ""},
+		{"align:start", `{\an1}`},
+		{"align:end", `{\an3}`},
+		{"line:10%", `{\an8\pos(960,108)}`},
+		{"line:50%,center align:left position:50%,line-left", `{\an7\pos(960,540)}`},
+		{"position:25% align:start line:0", `{\an7\pos(480,0)}`},
+		{"line:2", `{\an8\pos(960,129)}`},
+		{"line:-1", `{\an2\pos(960,1030)}`},
+		{"line:-2 align:end", `{\an3\pos(1860,965)}`},
+		{"position:10%", `{\an2\pos(192,1030)}`},
+		{"align:right line:90%", `{\an9\pos(1860,972)}`},
+	}
+	style := NewAssStyle()
+	for _, tt := range tests {
+		if got := parseCueSettings(tt.settings).assOverride(style); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.settings, got, tt.want)
+		}
+	}
+
+	small := &AssStyle{FontSize: 20, MarginL: 10, MarginR: 10, MarginV: 10, PlayResX: 640, PlayResY: 360}
+	if got, want := parseCueSettings("line:-2 align:start").assOverride(small), `{\an1\pos(10,326)}`; got != want {
+		t.Errorf("custom style: got %s, want %s", got, want)
+	}
+}
+
+func TestAssTime(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0:00:00.00"},
+		{1230 * time.Millisecond, "0:00:01.23"},
+		{1234 * time.Millisecond, "0:00:01.23"},
+		{1235 * time.Millisecond, "0:00:01.24"},
+		{1999 * time.Millisecond, "0:00:02.00"},
+		{time.Hour + 2*time.Minute + 3456*time.Millisecond, "1:02:03.46"},
+		{10*time.Hour - 4*time.Millisecond, "10:00:00.00"},
+	}
+	for _, tt := range tests {
+		if got := assTime(tt.d); got != tt.want {
+			t.Errorf("assTime(%v) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestVttToAssText(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{"<i>Hello</i>\r\n<b>world</b>", `{\i1}Hello{\i0}\N{\b1}world{\b0}`},
+		{"<v Bob>Hi</v> <c.yellow>there</c>", "Hi there"},
+		{"&lt;tag&gt; &amp; more", "<tag> & more"},
+		{`{\an8}not a tag {x}`, `\{\an8\}not a tag \{x\}`},
+		{"<u>{</u>", `{\u1}\{{\u0}`},
+	}
+	for _, tt := range tests {
+		if got := vttToAssText(tt.payload); got != tt.want {
+			t.Errorf("vttToAssText(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestToAss(t *testing.T) {
+	sub := &WebVttSub{Cues: []SubCue{
+		{StartTime: 1005 * time.Millisecond, EndTime: 2500 * time.Millisecond, Payload: "<v Ann, Jr>Hi {there}</v>"},
+		{StartTime: 3 * time.Second, EndTime: 4 * time.Second, Payload: "Top", Settings: "line:0 align:start"},
+	}}
+	style, err := ParseAssStyle("font=Noto Sans:size=40:primary=&h0000ffff:bold=true:align=8:margin_v=20:res_x=1280:res_y=720")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sub.ToFormat("ass", style)
+	for _, want := range []string{
+		"PlayResX: 1280\nPlayResY: 720\n",
+		"Style: Default,Noto Sans,40,&H0000FFFF,&H000000FF,&H00000000,&H80000000,-1,0,0,0,100,100,0,0,1,2.5,0,8,60,60,20,1\n",
+		"Dialogue: 0,0:00:01.01,0:00:02.50,Default,Ann  Jr,0,0,0,,Hi \\{there\\}\n",
+		"Dialogue: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,{\\an7\\pos(60,0)}Top\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("ToAss: missing %q in\n%s", want, got)
+		}
+	}
+	if def := sub.ToFormat("ASS", nil); !strings.Contains(def, "Style: Default,Arial,54,") {
+		t.Errorf("ToAss(nil): got\n%s", def)
+	}
+}
+
+func TestParseAssStyle(t *testing.T) {
+	style, err := ParseAssStyle("")
+	if err != nil || *style != *NewAssStyle() {
+		t.Errorf("empty: got %+v, %v", style, err)
+	}
+	style, err = ParseAssStyle("italic=true:outline=1.5:shadow=2:back=&H40000000:margin_l=10:margin_r=20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !style.Italic || style.Outline != 1.5 || style.Shadow != 2 || style.BackColour != "&H40000000" || style.MarginL != 10 || style.MarginR != 20 {
+		t.Errorf("got %+v", style)
+	}
+	if again, err := ParseAssStyle(style.String()); err != nil || *again != *style {
+		t.Errorf("String() round trip: got %+v, %v, want %+v", again, err, style)
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"bold", "expecting key=value"},
+		{"colour=&H00FFFFFF", "unknown ass style option: colour"},
+		{"primary=white", "invalid value for primary"},
+		{"size=big", "invalid value for size"},
+		{"align=0", "invalid value for align"},
+		{"font=a,b", "invalid value for font"},
+		{"res_y=0", "must be positive"},
+	}
+	for _, tt := range tests {
+		_, err := ParseAssStyle(tt.value)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want %q", tt.value, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package entity
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ttmlClockRegex  = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})(?:(\.\d+)|:(\d+)(?:\.(\d+))?)?$`)
+	ttmlOffsetRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)(h|ms|m|s|f|t)$`)
+	ttmlSpaceRegex  = regexp.MustCompile(`\s+`)
+)
+
+// ttmlNode is an element or, when name is empty, a text node.
+type ttmlNode struct {
+	name     string
+	attrs    map[string]string
+	children []*ttmlNode
+	text     string
+}
+
+// ttmlTiming holds the ttp: parameters that time expressions depend on.
+type ttmlTiming struct {
+	frameRate    float64
+	subFrameRate float64
+	tickRate     float64
+}
+
+type ttmlStyle struct {
+	italic, bold, underline bool
+	textAlign               string
+	origin                  string
+	extent                  string
+	displayAlign            string
+}
+
+// ttmlFragment is a run of text with its own active interval inside a <p>.
+type ttmlFragment struct {
+	text       string
+	begin, end time.Duration
+}
+
+type ttmlParser struct {
+	timing  ttmlTiming
+	styles  map[string]map[string]string
+	regions map[string]map[string]string
+	cues    []SubCue
+}
+
+// ParseTtml reads a TTML, DFXP or IMSC1 text document into SubCues. Times are
+// resolved against the document's tickRate and frameRate, nested begin/end/dur
+// are accumulated, <br/> becomes a line break, and italic/bold/underline styles
+// become WebVTT tags. Regions are mapped onto WebVTT line/position/align settings.
+func ParseTtml(text string) (*WebVttSub, error) {
+	root, err := parseTtmlTree(text)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil || root.name != "tt" {
+		return nil, fmt.Errorf("bad ttml")
+	}
+
+	p := &ttmlParser{
+		timing:  ttmlTiming{frameRate: 30, subFrameRate: 1, tickRate: 1},
+		styles:  make(map[string]map[string]string),
+		regions: make(map[string]map[string]string),
+	}
+	if v, err := strconv.ParseFloat(root.attrs["frameRate"], 64); err == nil && v > 0 {
+		p.timing.frameRate = v
+		p.timing.tickRate = v
+	}
+	if parts := strings.Fields(root.attrs["frameRateMultiplier"]); len(parts) == 2 {
+		num, _ := strconv.ParseFloat(parts[0], 64)
+		den, _ := strconv.ParseFloat(parts[1], 64)
+		if num > 0 && den > 0 {
+			p.timing.frameRate *= num / den
+		}
+	}
+	if v, err := strconv.ParseFloat(root.attrs["subFrameRate"], 64); err == nil && v > 0 {
+		p.timing.subFrameRate = v
+		if _, ok := root.attrs["frameRate"]; ok {
+			p.timing.tickRate = p.timing.frameRate * v
+		}
+	}
+	if v, err := strconv.ParseFloat(root.attrs["tickRate"], 64); err == nil && v > 0 {
+		p.timing.tickRate = v
+	}
+
+	for _, head := range root.find("head") {
+		for _, style := range head.findDeep("style") {
+			if id := style.attrs["id"]; id != "" {
+				p.styles[id] = style.attrs
+			}
+		}
+		for _, region := range head.findDeep("region") {
+			if id := region.attrs["id"]; id != "" {
+				attrs := map[string]string{}
+				for _, ref := range strings.Fields(region.attrs["style"]) {
+					for k, v := range p.styles[ref] {
+						attrs[k] = v
+					}
+				}
+				for _, style := range region.find("style") {
+					for k, v := range style.attrs {
+						attrs[k] = v
+					}
+				}
+				for k, v := range region.attrs {
+					attrs[k] = v
+				}
+				p.regions[id] = attrs
+			}
+		}
+	}
+
+	for _, body := range root.find("body") {
+		p.walk(body, 0, -1, ttmlStyle{})
+	}
+	sort.SliceStable(p.cues, func(i, j int) bool { return p.cues[i].StartTime < p.cues[j].StartTime })
+	return &WebVttSub{Cues: p.cues}, nil
+}
+
+func parseTtmlTree(text string) (*ttmlNode, error) {
+	decoder := xml.NewDecoder(strings.NewReader(text))
+	decoder.Strict = false
+	var stack []*ttmlNode
+	var root *ttmlNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad ttml: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &ttmlNode{name: t.Name.Local, attrs: make(map[string]string)}
+			for _, a := range t.Attr {
+				node.attrs[a.Name.Local] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, &ttmlNode{text: string(t)})
+			}
+		}
+	}
+	return root, nil
+}
+
+func (n *ttmlNode) find(name string) []*ttmlNode {
+	var found []*ttmlNode
+	for _, c := range n.children {
+		if c.name == name {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+func (n *ttmlNode) findDeep(name string) []*ttmlNode {
+	var found []*ttmlNode
+	for _, c := range n.children {
+		if c.name == name {
+			found = append(found, c)
+		}
+		found = append(found, c.findDeep(name)...)
+	}
+	return found
+}
+
+// interval resolves a node's begin/end/dur against its parent's active
+// interval. end < 0 means open-ended.
+func (p *ttmlParser) interval(n *ttmlNode, parentBegin, parentEnd time.Duration) (time.Duration, time.Duration) {
+	begin := parentBegin
+	if v, ok := p.parseTime(n.attrs["begin"]); ok {
+		begin = parentBegin + v
+	}
+	end := parentEnd
+	if v, ok := p.parseTime(n.attrs["end"]); ok {
+		end = parentBegin + v
+	} else if v, ok := p.parseTime(n.attrs["dur"]); ok {
+		end = begin + v
+	}
+	if parentEnd >= 0 && (end < 0 || end > parentEnd) {
+		end = parentEnd
+	}
+	return begin, end
+}
+
+// applyStyle layers the node's referenced and inline styles over the inherited ones.
+func (p *ttmlParser) applyStyle(n *ttmlNode, style ttmlStyle) ttmlStyle {
+	apply := func(attrs map[string]string) {
+		if v, ok := attrs["fontStyle"]; ok {
+			style.italic = v == "italic" || v == "oblique"
+		}
+		if v, ok := attrs["fontWeight"]; ok {
+			style.bold = v == "bold"
+		}
+		if v, ok := attrs["textDecoration"]; ok {
+			style.underline = strings.Contains(v, "underline") && !strings.Contains(v, "noUnderline")
+		}
+		if v, ok := attrs["textAlign"]; ok {
+			style.textAlign = v
+		}
+		if v, ok := attrs["origin"]; ok {
+			style.origin = v
+		}
+		if v, ok := attrs["extent"]; ok {
+			style.extent = v
+		}
+		if v, ok := attrs["displayAlign"]; ok {
+			style.displayAlign = v
+		}
+	}
+	if region, ok := p.regions[n.attrs["region"]]; ok {
+		apply(region)
+	}
+	for _, ref := range strings.Fields(n.attrs["style"]) {
+		if s, ok := p.styles[ref]; ok {
+			for _, parent := range strings.Fields(s["style"]) {
+				apply(p.styles[parent])
+			}
+			apply(s)
+		}
+	}
+	if n.name != "style" && n.name != "region" {
+		apply(n.attrs)
+	}
+	return style
+}
+
+func (p *ttmlParser) walk(n *ttmlNode, parentBegin, parentEnd time.Duration, style ttmlStyle) {
+	begin, end := p.interval(n, parentBegin, parentEnd)
+	style = p.applyStyle(n, style)
+	if n.name == "p" {
+		p.addParagraph(n, begin, end, style)
+		return
+	}
+	for _, c := range n.children {
+		if c.name != "" {
+			p.walk(c, begin, end, style)
+		}
+	}
+}
+
+func (p *ttmlParser) addParagraph(n *ttmlNode, begin, end time.Duration, style ttmlStyle) {
+	var fragments []ttmlFragment
+	p.collect(n, begin, end, style, &fragments, true)
+	if len(fragments) == 0 {
+		return
+	}
+
+	// Spans may carry their own timing, so split the paragraph at every
+	// boundary and emit one cue per distinct stretch of visible text.
+	bounds := []time.Duration{}
+	for _, f := range fragments {
+		bounds = append(bounds, f.begin, f.end)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	settings := style.cueSettings()
+	for i := 0; i+1 < len(bounds); i++ {
+		from, to := bounds[i], bounds[i+1]
+		if to <= from {
+			continue
+		}
+		var sb strings.Builder
+		for _, f := range fragments {
+			if f.begin <= from && f.end >= to {
+				sb.WriteString(f.text)
+			}
+		}
+		payload := cleanTtmlPayload(sb.String())
+		if payload == "" {
+			continue
+		}
+		if last := len(p.cues) - 1; last >= 0 && p.cues[last].EndTime == from && p.cues[last].Payload == payload {
+			p.cues[last].EndTime = to
+			continue
+		}
+		p.cues = append(p.cues, SubCue{StartTime: from, EndTime: to, Payload: payload, Settings: settings})
+	}
+}
+
+// collect flattens a paragraph into timed text runs, wrapping styled runs in tags.
+func (p *ttmlParser) collect(n *ttmlNode, begin, end time.Duration, style ttmlStyle, out *[]ttmlFragment, isRoot bool) {
+	if !isRoot {
+		begin, end = p.interval(n, begin, end)
+		style = p.applyStyle(n, style)
+	}
+	if end < 0 || end <= begin {
+		return
+	}
+	preserve := n.attrs["space"] == "preserve"
+	for _, c := range n.children {
+		switch c.name {
+		case "":
+			text := c.text
+			if !preserve {
+				text = ttmlSpaceRegex.ReplaceAllString(text, " ")
+			}
+			if strings.TrimSpace(text) == "" && text != " " {
+				continue
+			}
+			*out = append(*out, ttmlFragment{text: style.wrap(text), begin: begin, end: end})
+		case "br":
+			*out = append(*out, ttmlFragment{text: "\n", begin: begin, end: end})
+		case "span":
+			p.collect(c, begin, end, style, out, false)
+		}
+	}
+}
+
+func (s ttmlStyle) wrap(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return text
+	}
+	if s.underline {
+		text = "<u>" + text + "</u>"
+	}
+	if s.bold {
+		text = "<b>" + text + "</b>"
+	}
+	if s.italic {
+		text = "<i>" + text + "</i>"
+	}
+	return text
+}
+
+// cueSettings maps the region's origin/extent and text alignment onto WebVTT settings.
+func (s ttmlStyle) cueSettings() string {
+	var settings []string
+	originX, originY, okOrigin := parsePercentPair(s.origin)
+	extentX, extentY, okExtent := parsePercentPair(s.extent)
+	if okOrigin {
+		line := originY
+		if okExtent && s.displayAlign == "after" {
+			line = originY + extentY
+		} else if okExtent && s.displayAlign == "center" {
+			line = originY + extentY/2
+		}
+		settings = append(settings, fmt.Sprintf("line:%s%%", formatPercent(math.Min(line, 100))))
+		if okExtent {
+			settings = append(settings, fmt.Sprintf("position:%s%%", formatPercent(originX+extentX/2)),
+				fmt.Sprintf("size:%s%%", formatPercent(extentX)))
+		}
+	}
+	switch s.textAlign {
+	case "left", "start":
+		settings = append(settings, "align:start")
+	case "right", "end":
+		settings = append(settings, "align:end")
+	case "center":
+		if len(settings) > 0 {
+			settings = append(settings, "align:center")
+		}
+	}
+	return strings.Join(settings, " ")
+}
+
+func parsePercentPair(v string) (float64, float64, bool) {
+	parts := strings.Fields(v)
+	if len(parts) != 2 || !strings.HasSuffix(parts[0], "%") || !strings.HasSuffix(parts[1], "%") {
+		return 0, 0, false
+	}
+	x, err1 := strconv.ParseFloat(strings.TrimSuffix(parts[0], "%"), 64)
+	y, err2 := strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64)
+	return x, y, err1 == nil && err2 == nil
+}
+
+func formatPercent(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func cleanTtmlPayload(payload string) string {
+	lines := strings.Split(payload, "\n")
+	var kept []string
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// parseTime reads a TTML clock-time (HH:MM:SS.fff or HH:MM:SS:FF.sub) or
+// offset-time (12.5s, 400ms, 25f, 900000t, ...).
+func (p *ttmlParser) parseTime(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	toDuration := func(seconds float64) time.Duration {
+		return time.Duration(math.Round(seconds * float64(time.Second)))
+	}
+	if m := ttmlClockRegex.FindStringSubmatch(v); m != nil {
+		h, _ := strconv.ParseFloat(m[1], 64)
+		min, _ := strconv.ParseFloat(m[2], 64)
+		sec, _ := strconv.ParseFloat(m[3], 64)
+		seconds := h*3600 + min*60 + sec
+		if m[4] != "" {
+			frac, _ := strconv.ParseFloat("0"+m[4], 64)
+			seconds += frac
+		}
+		if m[5] != "" {
+			frames, _ := strconv.ParseFloat(m[5], 64)
+			if m[6] != "" {
+				sub, _ := strconv.ParseFloat(m[6], 64)
+				frames += sub / p.timing.subFrameRate
+			}
+			seconds += frames / p.timing.frameRate
+		}
+		return toDuration(seconds), true
+	}
+	if m := ttmlOffsetRegex.FindStringSubmatch(v); m != nil {
+		value, _ := strconv.ParseFloat(m[1], 64)
+		switch m[2] {
+		case "h":
+			value *= 3600
+		case "m":
+			value *= 60
+		case "ms":
+			value /= 1000
+		case "f":
+			value /= p.timing.frameRate
+		case "t":
+			value /= p.timing.tickRate
+		}
+		return toDuration(value), true
+	}
+	return 0, false
+}
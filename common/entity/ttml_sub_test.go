@@ -0,0 +1,120 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTtml(t *testing.T) {
+	const head = `<head><styling>` +
+		`<style xml:id="it" tts:fontStyle="italic"/>` +
+		`<style xml:id="strong" style="it" tts:fontWeight="bold"/>` +
+		`</styling><layout>` +
+		`<region xml:id="top" tts:origin="10% 5%" tts:extent="80% 20%" tts:textAlign="center"/>` +
+		`</layout></head>`
+	tt := func(attrs, body string) string {
+		return `<?xml version="1.0" encoding="UTF-8"?>` +
+			`<tt xmlns="http://www.w3.org/ns/ttml" xmlns:tts="http://www.w3.org/ns/ttml#styling" xmlns:ttp="http://www.w3.org/ns/ttml#parameter" ` +
+			attrs + `>` + head + `<body><div>` + body + `</div></body></tt>`
+	}
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	tests := []struct {
+		name string
+		doc  string
+		want []SubCue
+	}{
+		{
+			"clock times",
+			tt("", `<p begin="00:00:01.500" end="00:00:03.000">Hello</p>`),
+			[]SubCue{{StartTime: sec(1.5), EndTime: sec(3), Payload: "Hello"}},
+		},
+		{
+			"tick rate",
+			tt(`ttp:tickRate="10000000"`, `<p begin="15000000t" end="30000000t">Ticks</p>`),
+			[]SubCue{{StartTime: sec(1.5), EndTime: sec(3), Payload: "Ticks"}},
+		},
+		{
+			"frames",
+			tt(`ttp:frameRate="25"`, `<p begin="00:00:01:05" end="50f">Frames</p>`),
+			[]SubCue{{StartTime: sec(1.2), EndTime: sec(2), Payload: "Frames"}},
+		},
+		{
+			"offset units and dur",
+			tt("", `<p begin="1.5s" dur="500ms">Short</p><p begin="0.05m" end="0.002h">Long</p>`),
+			[]SubCue{
+				{StartTime: sec(1.5), EndTime: sec(2), Payload: "Short"},
+				{StartTime: sec(3), EndTime: sec(7.2), Payload: "Long"},
+			},
+		},
+		{
+			"times nest in the parent",
+			tt("", `<div begin="10s"><p begin="1s" end="2s">Nested</p></div>`),
+			[]SubCue{{StartTime: sec(11), EndTime: sec(12), Payload: "Nested"}},
+		},
+		{
+			"line breaks",
+			tt("", `<p begin="1s" end="2s">First line<br/>  second   line </p>`),
+			[]SubCue{{StartTime: sec(1), EndTime: sec(2), Payload: "First line\nsecond line"}},
+		},
+		{
+			"styles",
+			tt("", `<p begin="1s" end="2s"><span style="it">lean</span> <span style="strong">heavy</span> <span tts:textDecoration="underline">under</span></p>`),
+			[]SubCue{{StartTime: sec(1), EndTime: sec(2), Payload: "<i>lean</i> <i><b>heavy</b></i> <u>under</u>"}},
+		},
+		{
+			"timed spans split the paragraph",
+			tt("", `<p begin="1s" end="3s"><span>always</span> <span begin="1s">later</span></p>`),
+			[]SubCue{
+				{StartTime: sec(1), EndTime: sec(2), Payload: "always"},
+				{StartTime: sec(2), EndTime: sec(3), Payload: "always later"},
+			},
+		},
+		{
+			"region",
+			tt("", `<p region="top" begin="1s" end="2s">Up here</p>`),
+			[]SubCue{{StartTime: sec(1), EndTime: sec(2), Payload: "Up here", Settings: "line:5% position:50% size:80% align:center"}},
+		},
+		{
+			"open-ended paragraphs are dropped",
+			tt("", `<p begin="1s">Forever</p><p begin="2s" end="3s">Kept</p>`),
+			[]SubCue{{StartTime: sec(2), EndTime: sec(3), Payload: "Kept"}},
+		},
+	}
+	for _, tt := range tests {
+		sub, err := ParseTtml(tt.doc)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sub.Cues, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, sub.Cues, tt.want)
+		}
+	}
+}
+
+func TestParseTtmlRejectsOtherDocuments(t *testing.T) {
+	for _, doc := range []string{"", "WEBVTT\n\n00:01.000 --> 00:02.000\nHi", `<html><body/></html>`} {
+		if _, err := ParseTtml(doc); err == nil {
+			t.Errorf("%q: got no error", doc)
+		}
+	}
+}
+
+func TestTtmlRoundTrip(t *testing.T) {
+	want := []SubCue{
+		{StartTime: 1500 * time.Millisecond, EndTime: 3 * time.Second, Payload: "<i>Hello</i>\nworld & co"},
+		{StartTime: 61 * time.Second, EndTime: 62 * time.Second, Payload: "<b>Bold</b>"},
+	}
+	sub, err := ParseTtml((&WebVttSub{Cues: want}).ToTtml())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ToTtml puts cues without settings in its default bottom region
+	for i := range sub.Cues {
+		sub.Cues[i].Settings = ""
+	}
+	if !reflect.DeepEqual(sub.Cues, want) {
+		t.Errorf("got  %+v\nwant %+v", sub.Cues, want)
+	}
+}
@@ -0,0 +1,89 @@
+package entity
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retime rescales every cue from one frame rate to another, e.g. 25 to
+// 23.976 to undo a PAL speed-up.
+func (w *WebVttSub) Retime(fromFps, toFps float64) {
+	if fromFps <= 0 || toFps <= 0 || fromFps == toFps {
+		return
+	}
+	scale := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) * fromFps / toFps).Round(time.Millisecond)
+	}
+	for i := range w.Cues {
+		w.Cues[i].StartTime = scale(w.Cues[i].StartTime)
+		w.Cues[i].EndTime = scale(w.Cues[i].EndTime)
+	}
+}
+
+// RemoveMatching drops the lines of each cue's plain text that match any of
+// patterns, and then the cues left empty. It returns the number of cues removed.
+func (w *WebVttSub) RemoveMatching(patterns []*regexp.Regexp) int {
+	if len(patterns) == 0 {
+		return 0
+	}
+	matches := func(line string) bool {
+		for _, p := range patterns {
+			if p.MatchString(line) {
+				return true
+			}
+		}
+		return false
+	}
+	cues := w.Cues[:0]
+	removed := 0
+	for _, cue := range w.Cues {
+		plain := strings.Split(cue.PlainText(), "\n")
+		lines := strings.Split(cue.Payload, "\n")
+		if len(plain) == len(lines) {
+			kept := lines[:0]
+			for i, line := range lines {
+				if !matches(plain[i]) {
+					kept = append(kept, line)
+				}
+			}
+			cue.Payload = strings.Join(kept, "\n")
+		} else if matches(cue.PlainText()) {
+			cue.Payload = ""
+		}
+		if strings.TrimSpace(cue.PlainText()) == "" {
+			removed++
+			continue
+		}
+		cues = append(cues, cue)
+	}
+	w.Cues = cues
+	return removed
+}
+
+// MergeShortCues joins each cue shorter than minDuration with the cues that
+// follow it at the same position within maxGap. The cues are sorted by start
+// time first. It returns the number of cues merged away.
+func (w *WebVttSub) MergeShortCues(minDuration, maxGap time.Duration) int {
+	if minDuration <= 0 || len(w.Cues) == 0 {
+		return 0
+	}
+	sort.SliceStable(w.Cues, func(i, j int) bool { return w.Cues[i].StartTime < w.Cues[j].StartTime })
+	cues := []SubCue{w.Cues[0]}
+	for _, next := range w.Cues[1:] {
+		cur := &cues[len(cues)-1]
+		gap := next.StartTime - cur.EndTime
+		if cur.EndTime-cur.StartTime >= minDuration || gap < 0 || gap > maxGap || cur.Settings != next.Settings {
+			cues = append(cues, next)
+			continue
+		}
+		if next.Payload != cur.Payload {
+			cur.Payload += "\n" + next.Payload
+		}
+		cur.EndTime = max(cur.EndTime, next.EndTime)
+	}
+	merged := len(w.Cues) - len(cues)
+	w.Cues = cues
+	return merged
+}
@@ -0,0 +1,121 @@
+package entity
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRetime(t *testing.T) {
+	ms := func(v int64) time.Duration { return time.Duration(v) * time.Millisecond }
+	tests := []struct {
+		name           string
+		fromFps, toFps float64
+		want           []SubCue
+	}{
+		// subtitles timed for a PAL speed-up run longer at film speed
+		{"25 to 23.976", 25, 24000.0 / 1001, []SubCue{{StartTime: ms(62562), EndTime: ms(125125), Payload: "a"}}},
+		{"23.976 to 25", 24000.0 / 1001, 25, []SubCue{{StartTime: ms(57542), EndTime: ms(115085), Payload: "a"}}},
+		{"same rate", 25, 25, []SubCue{{StartTime: ms(60000), EndTime: ms(120000), Payload: "a"}}},
+		{"missing rate", 0, 25, []SubCue{{StartTime: ms(60000), EndTime: ms(120000), Payload: "a"}}},
+	}
+	for _, tt := range tests {
+		sub := &WebVttSub{Cues: []SubCue{{StartTime: ms(60000), EndTime: ms(120000), Payload: "a"}}}
+		sub.Retime(tt.fromFps, tt.toFps)
+		if !reflect.DeepEqual(sub.Cues, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, sub.Cues, tt.want)
+		}
+	}
+}
+
+func TestRemoveMatching(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		payloads []string
+		want     []string
+		removed  int
+	}{
+		{"whole cues", []string{`^\[.*\]$`}, []string{"[music]", "Hello", "[door slams]"}, []string{"Hello"}, 2},
+		{"single lines", []string{`^\[.*\]$`}, []string{"[music]\nHello\n[laughs]", "World"}, []string{"Hello", "World"}, 0},
+		{"plain text is matched, markup kept", []string{`^JOHN:`}, []string{"<i>JOHN: hi</i>\n<b>there</b>"}, []string{"<b>there</b>"}, 0},
+		{"any of several patterns", []string{`^♪`, `^-$`}, []string{"♪ la la", "-", "Kept"}, []string{"Kept"}, 2},
+		{"no patterns", nil, []string{"[music]"}, []string{"[music]"}, 0},
+	}
+	for _, tt := range tests {
+		sub := &WebVttSub{}
+		for i, p := range tt.payloads {
+			sub.Cues = append(sub.Cues, SubCue{StartTime: time.Duration(i) * time.Second, EndTime: time.Duration(i+1) * time.Second, Payload: p})
+		}
+		var patterns []*regexp.Regexp
+		for _, p := range tt.patterns {
+			patterns = append(patterns, regexp.MustCompile(p))
+		}
+		removed := sub.RemoveMatching(patterns)
+		var got []string
+		for _, cue := range sub.Cues {
+			got = append(got, cue.Payload)
+		}
+		if !reflect.DeepEqual(got, tt.want) || removed != tt.removed {
+			t.Errorf("%s: got %q (%d removed), want %q (%d removed)", tt.name, got, removed, tt.want, tt.removed)
+		}
+	}
+}
+
+func TestMergeShortCues(t *testing.T) {
+	ms := func(v int64) time.Duration { return time.Duration(v) * time.Millisecond }
+	cue := func(start, end int64, payload string) SubCue {
+		return SubCue{StartTime: ms(start), EndTime: ms(end), Payload: payload}
+	}
+	tests := []struct {
+		name   string
+		cues   []SubCue
+		want   []SubCue
+		merged int
+	}{
+		{
+			"short cues joined across a small gap",
+			[]SubCue{cue(0, 300, "One"), cue(400, 700, "two"), cue(800, 3000, "three")},
+			[]SubCue{cue(0, 3000, "One\ntwo\nthree")},
+			2,
+		},
+		{
+			"gap too large",
+			[]SubCue{cue(0, 300, "One"), cue(600, 3000, "Two")},
+			[]SubCue{cue(0, 300, "One"), cue(600, 3000, "Two")},
+			0,
+		},
+		{
+			"long cues stay",
+			[]SubCue{cue(0, 2000, "One"), cue(2100, 2200, "Two")},
+			[]SubCue{cue(0, 2000, "One"), cue(2100, 2200, "Two")},
+			0,
+		},
+		{
+			"repeated text is not doubled",
+			[]SubCue{cue(0, 300, "Same"), cue(300, 2000, "Same")},
+			[]SubCue{cue(0, 2000, "Same")},
+			1,
+		},
+		{
+			"unsorted input",
+			[]SubCue{cue(400, 2000, "two"), cue(0, 300, "One")},
+			[]SubCue{cue(0, 2000, "One\ntwo")},
+			1,
+		},
+		{
+			"different positions",
+			[]SubCue{cue(0, 300, "One"), {StartTime: ms(300), EndTime: ms(2000), Payload: "Two", Settings: "line:0"}},
+			[]SubCue{cue(0, 300, "One"), {StartTime: ms(300), EndTime: ms(2000), Payload: "Two", Settings: "line:0"}},
+			0,
+		},
+	}
+	for _, tt := range tests {
+		sub := &WebVttSub{Cues: tt.cues}
+		merged := sub.MergeShortCues(time.Second, 200*time.Millisecond)
+		if !reflect.DeepEqual(sub.Cues, tt.want) || merged != tt.merged {
+			t.Errorf("%s:\ngot  %+v (%d merged)\nwant %+v (%d merged)", tt.name, sub.Cues, merged, tt.want, tt.merged)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package entity
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+const mpegtsWrap = int64(1) << 33
+
+// joinTolerance is the gap under which a cue continuing into the next segment
+// is treated as one cue rather than two.
+const joinTolerance = 50 * time.Millisecond
+
+// MergeWebVttSegments joins the WEBVTT files of an HLS subtitle playlist. Each
+// segment's X-TIMESTAMP-MAP is resolved against basePts, the 90kHz PTS of the
+// first video segment, so the cues share the video's timeline. When basePts is
+// negative the MPEGTS value of the first segment with a map is used instead.
+// autoFix repairs overlapping and zero-length cues afterwards.
+func MergeWebVttSegments(segments []string, basePts int64, autoFix bool) (*WebVttSub, error) {
+	merged := &WebVttSub{}
+	for i, text := range segments {
+		sub, err := Parse(text+"\n\n", 0)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		if basePts < 0 && sub.hasTimestampMap() {
+			basePts = sub.MpegtsTimestamp
+		}
+		sub.ShiftTime(sub.timelineOffset(basePts))
+		merged.AddCuesFromOne(sub)
+	}
+	merged.MpegtsTimestamp = max(basePts, 0)
+	if autoFix {
+		merged.FixTimestamps()
+	}
+	return merged, nil
+}
+
+// hasTimestampMap reports whether the segment had an X-TIMESTAMP-MAP header.
+func (w *WebVttSub) hasTimestampMap() bool {
+	return w.MpegtsTimestamp != 0 || w.LocalTimestamp != 0
+}
+
+// timelineOffset is what moves this segment's cue times onto a timeline that
+// starts at basePts.
+func (w *WebVttSub) timelineOffset(basePts int64) time.Duration {
+	if !w.hasTimestampMap() {
+		return 0
+	}
+	delta := w.MpegtsTimestamp - basePts
+	// Either side may have rolled over the 33-bit PTS counter.
+	if delta < -mpegtsWrap/2 {
+		delta += mpegtsWrap
+	} else if delta > mpegtsWrap/2 {
+		delta -= mpegtsWrap
+	}
+	return time.Duration(delta)*time.Second/90000 - w.LocalTimestamp
+}
+
+// ShiftTime moves every cue by d, dropping cues that would end before zero.
+func (w *WebVttSub) ShiftTime(d time.Duration) {
+	if d == 0 {
+		return
+	}
+	cues := w.Cues[:0]
+	for _, cue := range w.Cues {
+		cue.StartTime += d
+		cue.EndTime += d
+		if cue.EndTime <= 0 {
+			continue
+		}
+		cue.StartTime = max(cue.StartTime, 0)
+		cues = append(cues, cue)
+	}
+	w.Cues = cues
+}
+
+// AddCuesFromOne appends the cues of a following segment. Cues already present
+// are skipped, and a cue that carries on from where an identical one ended is
+// joined to it. STYLE and REGION blocks the document doesn't have yet are added.
+func (w *WebVttSub) AddCuesFromOne(other *WebVttSub) {
+	w.Styles = appendMissing(w.Styles, other.Styles)
+	w.Regions = appendMissing(w.Regions, other.Regions)
+	seen := make(map[int]bool, len(w.Cues))
+	for i := range w.Cues {
+		seen[w.Cues[i].GetHashCode()] = true
+	}
+	for _, cue := range other.Cues {
+		if seen[cue.GetHashCode()] {
+			continue
+		}
+		if prev := w.findSplitCue(&cue); prev != nil {
+			prev.EndTime = max(prev.EndTime, cue.EndTime)
+			continue
+		}
+		seen[cue.GetHashCode()] = true
+		w.Cues = append(w.Cues, cue)
+	}
+}
+
+// appendMissing appends the blocks of more that blocks doesn't already hold.
+func appendMissing(blocks, more []string) []string {
+	for _, block := range more {
+		if !slices.Contains(blocks, block) {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// findSplitCue returns the earlier cue that cue continues, if any.
+func (w *WebVttSub) findSplitCue(cue *SubCue) *SubCue {
+	for i := len(w.Cues) - 1; i >= 0; i-- {
+		prev := &w.Cues[i]
+		if prev.Payload == cue.Payload && prev.Settings == cue.Settings &&
+			cue.StartTime >= prev.StartTime && cue.StartTime <= prev.EndTime+joinTolerance {
+			return prev
+		}
+	}
+	return nil
+}
+
+// FixTimestamps sorts the cues, gives zero-length cues a duration reaching to
+// the next cue and trims cues that run into the next one at the same place.
+func (w *WebVttSub) FixTimestamps() {
+	sort.SliceStable(w.Cues, func(i, j int) bool { return w.Cues[i].StartTime < w.Cues[j].StartTime })
+	for i := range w.Cues {
+		cue := &w.Cues[i]
+		var next *SubCue
+		for j := i + 1; j < len(w.Cues); j++ {
+			if w.Cues[j].Settings == cue.Settings && w.Cues[j].StartTime > cue.StartTime {
+				next = &w.Cues[j]
+				break
+			}
+		}
+		if cue.EndTime <= cue.StartTime {
+			cue.EndTime = cue.StartTime + time.Second
+			if next != nil {
+				cue.EndTime = min(cue.EndTime, next.StartTime)
+			}
+		}
+		if next != nil && cue.EndTime > next.StartTime {
+			cue.EndTime = next.StartTime
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package entity
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// vttSegment builds a WEBVTT segment; mpegts < 0 leaves out the X-TIMESTAMP-MAP.
+func vttSegment(mpegts int64, local string, cues ...string) string {
+	text := "WEBVTT\n"
+	if mpegts >= 0 {
+		text += fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:%s\n", mpegts, local)
+	}
+	for _, cue := range cues {
+		text += "\n" + cue + "\n"
+	}
+	return text
+}
+
+func TestMergeWebVttSegments(t *testing.T) {
+	ms := func(v int64) time.Duration { return time.Duration(v) * time.Millisecond }
+	tests := []struct {
+		name     string
+		segments []string
+		basePts  int64
+		want     []SubCue
+	}{
+		{
+			"offsets against the video's base PTS",
+			[]string{
+				vttSegment(900000, "00:00:00.000", "00:00:01.000 --> 00:00:02.000\nOne"),
+				vttSegment(1800000, "00:00:00.000", "00:00:00.500 --> 00:00:01.000\nTwo"),
+				vttSegment(900000, "00:00:10.000", "00:00:23.000 --> 00:00:24.000\nThree"),
+			},
+			900000,
+			[]SubCue{
+				{StartTime: ms(1000), EndTime: ms(2000), Payload: "One"},
+				{StartTime: ms(10500), EndTime: ms(11000), Payload: "Two"},
+				{StartTime: ms(13000), EndTime: ms(14000), Payload: "Three"},
+			},
+		},
+		{
+			"subtitles starting after the video",
+			[]string{vttSegment(990000, "00:00:00.000", "00:00:00.000 --> 00:00:01.000\nLate")},
+			900000,
+			[]SubCue{{StartTime: ms(1000), EndTime: ms(2000), Payload: "Late"}},
+		},
+		{
+			"33-bit rollover",
+			[]string{
+				vttSegment(1<<33-90000, "00:00:00.000", "00:00:00.500 --> 00:00:01.000\nBefore"),
+				vttSegment(90000, "00:00:00.000", "00:00:00.500 --> 00:00:01.000\nAfter"),
+			},
+			1<<33 - 90000,
+			[]SubCue{
+				{StartTime: ms(500), EndTime: ms(1000), Payload: "Before"},
+				{StartTime: ms(2500), EndTime: ms(3000), Payload: "After"},
+			},
+		},
+		{
+			"base from the first segment with a map",
+			[]string{
+				vttSegment(-1, "", "00:00:00.000 --> 00:00:00.500\nUnmapped"),
+				vttSegment(900000, "00:00:00.000", "00:00:01.000 --> 00:00:02.000\nMapped"),
+				vttSegment(1800000, "00:00:00.000", "00:00:01.000 --> 00:00:02.000\nLater"),
+			},
+			-1,
+			[]SubCue{
+				{StartTime: 0, EndTime: ms(500), Payload: "Unmapped"},
+				{StartTime: ms(1000), EndTime: ms(2000), Payload: "Mapped"},
+				{StartTime: ms(11000), EndTime: ms(12000), Payload: "Later"},
+			},
+		},
+		{
+			"duplicates across segment boundaries",
+			[]string{
+				vttSegment(900000, "00:00:00.000", "00:00:05.000 --> 00:00:07.000\nBoth", "00:00:01.000 --> 00:00:02.000\nFirst"),
+				vttSegment(900000, "00:00:00.000", "00:00:05.000 --> 00:00:07.000\nBoth", "00:00:08.000 --> 00:00:09.000\nSecond"),
+			},
+			900000,
+			[]SubCue{
+				{StartTime: ms(1000), EndTime: ms(2000), Payload: "First"},
+				{StartTime: ms(5000), EndTime: ms(7000), Payload: "Both"},
+				{StartTime: ms(8000), EndTime: ms(9000), Payload: "Second"},
+			},
+		},
+		{
+			"split cues joined",
+			[]string{
+				vttSegment(900000, "00:00:00.000", "00:00:04.000 --> 00:00:06.000\nAcross"),
+				vttSegment(1440000, "00:00:00.000", "00:00:00.000 --> 00:00:01.500\nAcross"),
+				vttSegment(1440000, "00:00:00.000", "00:00:01.530 --> 00:00:02.000\nAcross"),
+			},
+			900000,
+			[]SubCue{{StartTime: ms(4000), EndTime: ms(8000), Payload: "Across"}},
+		},
+	}
+	for _, tt := range tests {
+		merged, err := MergeWebVttSegments(tt.segments, tt.basePts, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		merged.FixTimestamps()
+		if !reflect.DeepEqual(merged.Cues, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, merged.Cues, tt.want)
+		}
+	}
+}
+
+func TestMergeWebVttSegmentsAutoFix(t *testing.T) {
+	ms := func(v int64) time.Duration { return time.Duration(v) * time.Millisecond }
+	segments := []string{vttSegment(-1, "",
+		"00:00:01.000 --> 00:00:05.000\nOverlaps",
+		"00:00:03.000 --> 00:00:04.000\nNext",
+		"00:00:06.000 --> 00:00:06.000\nZero length",
+		"00:00:06.400 --> 00:00:07.000\nAfter",
+		"00:00:09.000 --> 00:00:09.000\nLast",
+		"00:00:02.000 --> 00:00:08.000 line:0\nElsewhere",
+	)}
+	tests := []struct {
+		autoFix bool
+		want    []SubCue
+	}{
+		{false, []SubCue{
+			{StartTime: ms(1000), EndTime: ms(5000), Payload: "Overlaps"},
+			{StartTime: ms(3000), EndTime: ms(4000), Payload: "Next"},
+			{StartTime: ms(6000), EndTime: ms(6000), Payload: "Zero length"},
+			{StartTime: ms(6400), EndTime: ms(7000), Payload: "After"},
+			{StartTime: ms(9000), EndTime: ms(9000), Payload: "Last"},
+			{StartTime: ms(2000), EndTime: ms(8000), Payload: "Elsewhere", Settings: "line:0"},
+		}},
+		{true, []SubCue{
+			{StartTime: ms(1000), EndTime: ms(3000), Payload: "Overlaps"},
+			// a cue at another position may overlap
+			{StartTime: ms(2000), EndTime: ms(8000), Payload: "Elsewhere", Settings: "line:0"},
+			{StartTime: ms(3000), EndTime: ms(4000), Payload: "Next"},
+			{StartTime: ms(6000), EndTime: ms(6400), Payload: "Zero length"},
+			{StartTime: ms(6400), EndTime: ms(7000), Payload: "After"},
+			{StartTime: ms(9000), EndTime: ms(10000), Payload: "Last"},
+		}},
+	}
+	for _, tt := range tests {
+		merged, err := MergeWebVttSegments(segments, -1, tt.autoFix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(merged.Cues, tt.want) {
+			t.Errorf("autoFix %v:\ngot  %+v\nwant %+v", tt.autoFix, merged.Cues, tt.want)
+		}
+	}
+}
@@ -9,86 +9,99 @@ import (
 )
 
 var (
-	tsMapRegex    = regexp.MustCompile(`X-TIMESTAMP-MAP.*`)
-	tsValueRegex  = regexp.MustCompile(`MPEGTS:(\d+)`)
-	splitRegex    = regexp.MustCompile(`\s`)
-	vttClassRegex = regexp.MustCompile(`<c\..*?>([\\s\\S]*?)</c>`)
+	tsMapRegex   = regexp.MustCompile(`X-TIMESTAMP-MAP.*`)
+	tsValueRegex = regexp.MustCompile(`MPEGTS:(\d+)`)
+	tsLocalRegex = regexp.MustCompile(`LOCAL:([\d:.]+)`)
+	splitRegex   = regexp.MustCompile(`\s`)
 )
 
+// WebVttSub is a WebVTT document. Cue payloads keep their markup (voice,
+// class, italic, bold and ruby spans) and line breaks, so ToVtt round-trips.
 type WebVttSub struct {
 	Cues            []SubCue
 	MpegtsTimestamp int64
+	// LocalTimestamp is the cue time X-TIMESTAMP-MAP pairs with MpegtsTimestamp.
+	LocalTimestamp time.Duration
+	// Styles and Regions hold the bodies of the STYLE and REGION blocks, in order.
+	Styles  []string
+	Regions []string
 }
 
 func Parse(text string, baseTimestamp int64) (*WebVttSub, error) {
-	trimmedText := strings.TrimSpace(text)
+	trimmedText := strings.TrimSpace(strings.TrimPrefix(text, "\ufeff"))
 	if !strings.HasPrefix(trimmedText, "WEBVTT") {
 		return nil, fmt.Errorf("bad vtt")
 	}
 
 	webSub := &WebVttSub{}
-	lines := strings.Split(text, "\n")
 
 	// Handle timestamp map
 	if tsMapRegex.MatchString(text) {
-		matches := tsValueRegex.FindStringSubmatch(tsMapRegex.FindString(text))
+		tsMap := tsMapRegex.FindString(text)
+		matches := tsValueRegex.FindStringSubmatch(tsMap)
 		if len(matches) > 1 {
 			timestamp, err := strconv.ParseInt(matches[1], 10, 64)
 			if err == nil {
 				webSub.MpegtsTimestamp = timestamp
 			}
 		}
+		if local := tsLocalRegex.FindStringSubmatch(tsMap); len(local) > 1 {
+			webSub.LocalTimestamp = convertToTS(local[1])
+		}
 	}
 
-	var needPayload bool
-	var timeLine string
-	var payloads []string
-
-	for _, line := range lines {
-		if strings.Contains(line, " --> ") {
-			needPayload = true
-			timeLine = strings.TrimSpace(line)
+	for i, block := range splitBlocks(trimmedText) {
+		timing := -1
+		for j, line := range block {
+			if strings.Contains(line, "-->") {
+				timing = j
+				break
+			}
+		}
+		if i == 0 {
+			// the WEBVTT header, which some files run straight into the first cue
+			if timing < 0 {
+				continue
+			}
+			block, timing = block[timing:], 0
+		}
+		if timing < 0 {
+			keyword, body, _ := strings.Cut(strings.Join(block, "\n"), "\n")
+			switch strings.TrimSpace(keyword) {
+			case "STYLE":
+				webSub.Styles = append(webSub.Styles, body)
+			case "REGION":
+				webSub.Regions = append(webSub.Regions, body)
+			}
+			// NOTE blocks and anything unrecognised are dropped
+			continue
+		}
+		if timing > 1 {
+			// not a cue: only an id may precede the timing line
 			continue
 		}
 
-		if needPayload {
-			if strings.TrimSpace(line) == "" {
-				payload := strings.Join(payloads, "\n")
-				if strings.TrimSpace(payload) == "" {
-					payloads = nil
-					continue
-				}
-
-				timeComponents := splitRegex.Split(strings.ReplaceAll(timeLine, "-->", ""), -1)
-				var nonEmptyComponents []string
-				for _, comp := range timeComponents {
-					if comp != "" {
-						nonEmptyComponents = append(nonEmptyComponents, comp)
-					}
-				}
-
-				if len(nonEmptyComponents) >= 2 {
-					startTime := convertToTS(nonEmptyComponents[0])
-					endTime := convertToTS(nonEmptyComponents[1])
-					style := ""
-					if len(nonEmptyComponents) > 2 {
-						style = strings.Join(nonEmptyComponents[2:], " ")
-					}
-
-					webSub.Cues = append(webSub.Cues, SubCue{
-						StartTime: startTime,
-						EndTime:   endTime,
-						Payload:   removeClassTag(strings.Join(payloads, "")),
-						Settings:  style,
-					})
-				}
-
-				payloads = nil
-				needPayload = false
-			} else {
-				payloads = append(payloads, strings.TrimSpace(line))
+		timeComponents := splitRegex.Split(strings.TrimSpace(strings.Replace(block[timing], "-->", " ", 1)), -1)
+		var nonEmptyComponents []string
+		for _, comp := range timeComponents {
+			if comp != "" {
+				nonEmptyComponents = append(nonEmptyComponents, comp)
 			}
 		}
+		payload := strings.Join(block[timing+1:], "\n")
+		if len(nonEmptyComponents) < 2 || strings.TrimSpace(payload) == "" {
+			continue
+		}
+		cue := SubCue{
+			StartTime: convertToTS(nonEmptyComponents[0]),
+			EndTime:   convertToTS(nonEmptyComponents[1]),
+			Payload:   payload,
+			Settings:  strings.Join(nonEmptyComponents[2:], " "),
+		}
+		if timing == 1 {
+			cue.Id = strings.TrimSpace(block[0])
+		}
+		webSub.Cues = append(webSub.Cues, cue)
 	}
 
 	if baseTimestamp != 0 {
@@ -106,19 +119,39 @@ func Parse(text string, baseTimestamp int64) (*WebVttSub, error) {
 	return webSub, nil
 }
 
-func removeClassTag(text string) string {
-	if vttClassRegex.MatchString(text) {
-		matches := vttClassRegex.FindAllStringSubmatch(text, -1)
-		var result strings.Builder
-		for _, match := range matches {
-			if len(match) > 1 {
-				result.WriteString(match[1])
-				result.WriteString(" ")
+// ParseSubtitle reads a WebVTT, TTML or SRT document, telling them apart by content.
+func ParseSubtitle(text string) (*WebVttSub, error) {
+	text = strings.TrimPrefix(strings.ReplaceAll(text, "\r\n", "\n"), "\ufeff")
+	switch trimmed := strings.TrimSpace(text); {
+	case strings.HasPrefix(trimmed, "WEBVTT"):
+		return Parse(text+"\n\n", 0)
+	case strings.Contains(trimmed, "<tt"):
+		return ParseTtml(text)
+	case strings.Contains(trimmed, "-->"):
+		return ParseSrt(text)
+	}
+	return nil, fmt.Errorf("unrecognised subtitle format")
+}
+
+// splitBlocks splits a document into its blank-line separated blocks of lines.
+func splitBlocks(text string) [][]string {
+	var blocks [][]string
+	var block []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			if block != nil {
+				blocks = append(blocks, block)
+				block = nil
 			}
+			continue
 		}
-		return strings.TrimSpace(result.String())
+		block = append(block, line)
 	}
-	return text
+	if block != nil {
+		blocks = append(blocks, block)
+	}
+	return blocks
 }
 
 func convertToTS(str string) time.Duration {
@@ -163,16 +196,25 @@ func pow(x, y int) int64 {
 func (w *WebVttSub) String() string {
 	var sb strings.Builder
 	for _, cue := range w.getCues() {
-		fmt.Fprintf(&sb, "%02d:%02d:%02d.%03d --> %02d:%02d:%02d.%03d %s\n",
-			int(cue.StartTime.Hours()), int(cue.StartTime.Minutes())%60, int(cue.StartTime.Seconds())%60, cue.StartTime.Milliseconds()%1000,
-			int(cue.EndTime.Hours()), int(cue.EndTime.Minutes())%60, int(cue.EndTime.Seconds())%60, cue.EndTime.Milliseconds()%1000,
-			cue.Settings)
+		if cue.Id != "" {
+			sb.WriteString(cue.Id)
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s --> %s", vttTime(cue.StartTime), vttTime(cue.EndTime))
+		if cue.Settings != "" {
+			sb.WriteString(" " + cue.Settings)
+		}
+		sb.WriteString("\n")
 		sb.WriteString(cue.Payload)
 		sb.WriteString("\n\n")
 	}
 	return sb.String()
 }
 
+func vttTime(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
+
 func (w *WebVttSub) getCues() []SubCue {
 	var result []SubCue
 	for _, cue := range w.Cues {
@@ -183,10 +225,20 @@ func (w *WebVttSub) getCues() []SubCue {
 	return result
 }
 
+// ToVtt writes the document back out with its STYLE and REGION blocks and cue ids.
 func (w *WebVttSub) ToVtt() string {
-	return "WEBVTT\n\n" + w.String()
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	for _, region := range w.Regions {
+		sb.WriteString("REGION\n" + region + "\n\n")
+	}
+	for _, style := range w.Styles {
+		sb.WriteString("STYLE\n" + style + "\n\n")
+	}
+	return sb.String() + w.String()
 }
 
+// ToSrt writes the cues as SubRip, using each cue's SrtText.
 func (w *WebVttSub) ToSrt() string {
 	var sb strings.Builder
 	index := 1
@@ -196,7 +248,7 @@ func (w *WebVttSub) ToSrt() string {
 		fmt.Fprintf(&sb, "%02d:%02d:%02d,%03d --> %02d:%02d:%02d,%03d\n",
 			int(cue.StartTime.Hours()), int(cue.StartTime.Minutes())%60, int(cue.StartTime.Seconds())%60, cue.StartTime.Milliseconds()%1000,
 			int(cue.EndTime.Hours()), int(cue.EndTime.Minutes())%60, int(cue.EndTime.Seconds())%60, cue.EndTime.Milliseconds()%1000)
-		sb.WriteString(cue.Payload)
+		sb.WriteString(cue.SrtText())
 		sb.WriteString("\n\n")
 		index++
 	}
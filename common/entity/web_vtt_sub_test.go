@@ -0,0 +1,121 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWebVttRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		vtt  string
+	}{
+		{"plain", "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\n\n"},
+		{"id and settings", "WEBVTT\n\nintro\n00:00:01.000 --> 00:00:02.000 line:10% align:start\nHello\n\n"},
+		{"multi-line payload", "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nFirst line\nsecond line\n\n"},
+		{"voices and styles", "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n<v Roger Bingham>We are in <i>New York</i> &amp; <b>live</b>\n<v.loud Neil>Hi</v>\n\n"},
+		{
+			"style and region blocks",
+			"WEBVTT\n\n" +
+				"REGION\nid:fred\nwidth:40%\nlines:3\n\n" +
+				"STYLE\n::cue {\n  color: yellow;\n}\n\n" +
+				"00:00:01.000 --> 00:00:02.000 region:fred\nHello\n\n",
+		},
+		{"hours", "WEBVTT\n\n01:02:03.004 --> 01:02:05.000\nLate\n\n"},
+	}
+	for _, tt := range tests {
+		sub, err := Parse(tt.vtt, 0)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := sub.ToVtt(); got != tt.vtt {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.name, got, tt.vtt)
+		}
+	}
+}
+
+func TestParseWebVtt(t *testing.T) {
+	tests := []struct {
+		name string
+		vtt  string
+		want []SubCue
+	}{
+		{
+			"short times, BOM and CRLF",
+			"\ufeffWEBVTT\r\n\r\n01.500 --> 02:03.250\r\nHi\r\n",
+			[]SubCue{{StartTime: 1500 * time.Millisecond, EndTime: 2*time.Minute + 3250*time.Millisecond, Payload: "Hi"}},
+		},
+		{
+			"header running into the first cue",
+			"WEBVTT\n00:00:01.000 --> 00:00:02.000\nHi\n",
+			[]SubCue{{StartTime: time.Second, EndTime: 2 * time.Second, Payload: "Hi"}},
+		},
+		{
+			"notes and empty cues are dropped",
+			"WEBVTT\n\nNOTE a comment\n\n00:00:01.000 --> 00:00:02.000\n\n00:00:03.000 --> 00:00:04.000\nKept\n",
+			[]SubCue{{StartTime: 3 * time.Second, EndTime: 4 * time.Second, Payload: "Kept"}},
+		},
+	}
+	for _, tt := range tests {
+		sub, err := Parse(tt.vtt, 0)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sub.Cues, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, sub.Cues, tt.want)
+		}
+	}
+	if _, err := Parse("1\n00:00:01,000 --> 00:00:02,000\nHi\n", 0); err == nil {
+		t.Error("SRT parsed as WebVTT")
+	}
+}
+
+func TestSubCueText(t *testing.T) {
+	tests := []struct {
+		payload, plain, srt, voice string
+	}{
+		{"Hello", "Hello", "Hello", ""},
+		{"<v Roger>Hi <i>there</i></v>", "Roger: Hi there", "Roger: Hi <i>there</i>", "Roger"},
+		{"<c.yellow>Fish</c> &amp; <ruby>chips<rt>x</rt></ruby>", "Fish & chipsx", "Fish & chipsx", ""},
+		{"<b>Bold</b>\n<u>under</u>", "Bold\nunder", "<b>Bold</b>\n<u>under</u>", ""},
+	}
+	for _, tt := range tests {
+		cue := SubCue{Payload: tt.payload}
+		if got := cue.PlainText(); got != tt.plain {
+			t.Errorf("PlainText(%q) = %q, want %q", tt.payload, got, tt.plain)
+		}
+		if got := cue.SrtText(); got != tt.srt {
+			t.Errorf("SrtText(%q) = %q, want %q", tt.payload, got, tt.srt)
+		}
+		if got := cue.Voice(); got != tt.voice {
+			t.Errorf("Voice(%q) = %q, want %q", tt.payload, got, tt.voice)
+		}
+	}
+}
+
+func TestMergeWebVttSegmentsKeepsStylesAndRegions(t *testing.T) {
+	const header = "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n" +
+		"REGION\nid:top\nlines:2\n\n" +
+		"STYLE\n::cue(.yellow) { color: yellow; }\n\n"
+	segments := []string{
+		header + "00:00:01.000 --> 00:00:02.000 region:top\n<c.yellow>One</c>\n",
+		header + "STYLE\n::cue(.red) { color: red; }\n\n" +
+			"00:00:11.000 --> 00:00:12.000\n<c.red>Two</c>\n",
+	}
+	merged, err := MergeWebVttSegments(segments, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"id:top\nlines:2"}; !reflect.DeepEqual(merged.Regions, want) {
+		t.Errorf("regions %q, want %q", merged.Regions, want)
+	}
+	if want := []string{"::cue(.yellow) { color: yellow; }", "::cue(.red) { color: red; }"}; !reflect.DeepEqual(merged.Styles, want) {
+		t.Errorf("styles %q, want %q", merged.Styles, want)
+	}
+	if len(merged.Cues) != 2 {
+		t.Errorf("got %d cues, want 2", len(merged.Cues))
+	}
+}
@@ -1,8 +1,44 @@
 package enums
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type Choice int
 
 const (
 	YES Choice = iota
 	NO
 )
+
+var ChoiceStrings = map[Choice]string{
+	YES: "YES",
+	NO:  "NO",
+}
+
+func (c Choice) String() string {
+	if str, exists := ChoiceStrings[c]; exists {
+		return str
+	}
+	return "Unknown Choice"
+}
+
+// MarshalJSON marshals the Choice to JSON
+func (c Choice) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *Choice) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	for choice, str := range ChoiceStrings {
+		if str == s {
+			*c = choice
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown Choice: %s", s)
+}
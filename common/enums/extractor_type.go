@@ -1,5 +1,10 @@
 package enums
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type ExtractorType int
 
 // Define enum values using iota
@@ -9,3 +14,36 @@ const (
 	HTTP_LIVE
 	MSS
 )
+
+var ExtractorTypeStrings = map[ExtractorType]string{
+	MPEG_DASH: "MPEG_DASH",
+	HLS:       "HLS",
+	HTTP_LIVE: "HTTP_LIVE",
+	MSS:       "MSS",
+}
+
+func (e ExtractorType) String() string {
+	if str, exists := ExtractorTypeStrings[e]; exists {
+		return str
+	}
+	return "Unknown ExtractorType"
+}
+
+// MarshalJSON marshals the ExtractorType to JSON
+func (e ExtractorType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+func (e *ExtractorType) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	for extractorType, str := range ExtractorTypeStrings {
+		if str == s {
+			*e = extractorType
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ExtractorType: %s", s)
+}
@@ -1,5 +1,10 @@
 package enums
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type RoleType int
 
 const (
@@ -12,18 +17,21 @@ const (
 	Description
 	Sign
 	Metadata
+	// ForcedSubtitle is DASH's "forced-subtitle" role.
+	ForcedSubtitle
 )
 
 var roleTypeToString = map[RoleType]string{
-	Subtitle:      "Subtitle",
-	Main:          "Main",
-	Alternate:     "Alternate",
-	Supplementary: "Supplementary",
-	Commentary:    "Commentary",
-	Dub:           "Dub",
-	Description:   "Description",
-	Sign:          "Sign",
-	Metadata:      "Metadata",
+	Subtitle:       "Subtitle",
+	Main:           "Main",
+	Alternate:      "Alternate",
+	Supplementary:  "Supplementary",
+	Commentary:     "Commentary",
+	Dub:            "Dub",
+	Description:    "Description",
+	Sign:           "Sign",
+	Metadata:       "Metadata",
+	ForcedSubtitle: "ForcedSubtitle",
 }
 
 // String returns the string representation of the RoleType.
@@ -34,3 +42,22 @@ func (r RoleType) String() *string {
 	unknown := "Unknown RoleType"
 	return &unknown // Return pointer to "Unknown RoleType" if not found
 }
+
+// MarshalJSON marshals the RoleType to JSON
+func (r RoleType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*r.String())
+}
+
+func (r *RoleType) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	for roleType, str := range roleTypeToString {
+		if str == s {
+			*r = roleType
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown RoleType: %s", s)
+}
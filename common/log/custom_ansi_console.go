@@ -3,7 +3,6 @@ package log
 import (
 	"fmt"
 	"io"
-	"os"
 	"regexp"
 	"strings"
 
@@ -35,7 +34,7 @@ func (w *NonAnsiWriter) Write(p []byte) (n int, err error) {
 	if strings.TrimSpace(output) == "" {
 		return len(p), nil
 	}
-	return fmt.Print(output)
+	return fmt.Fprint(ConsoleOutput, output)
 }
 
 func (w *NonAnsiWriter) removeAnsiEscapeSequences(input string) string {
@@ -51,7 +50,7 @@ type CustomAnsiConsole struct {
 }
 
 func NewCustomAnsiConsole(forceAnsi, noAnsiColor bool) *CustomAnsiConsole {
-	var writer io.Writer = os.Stdout
+	writer := ConsoleOutput
 	if noAnsiColor {
 		writer = &NonAnsiWriter{}
 		color.NoColor = true // Disable fatih/color output globally.
@@ -1,5 +1,7 @@
 package log
 
+import "strings"
+
 type LogLevel int
 
 const (
@@ -9,3 +11,19 @@ const (
 	INFO
 	DEBUG
 )
+
+var logLevelStrings = map[string]LogLevel{
+	"OFF":   OFF,
+	"ERROR": ERROR,
+	"WARN":  WARN,
+	"INFO":  INFO,
+	"DEBUG": DEBUG,
+}
+
+// ParseLogLevel maps the --log-level value to a LogLevel, falling back to INFO.
+func ParseLogLevel(level string) LogLevel {
+	if l, ok := logLevelStrings[strings.ToUpper(strings.TrimSpace(level))]; ok {
+		return l
+	}
+	return INFO
+}
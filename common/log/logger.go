@@ -16,6 +16,8 @@ type Logger struct {
 	LogFilePath  *string
 	VarsRepRegex *regexp.Regexp
 	LogWriteLock sync.RWMutex
+	// Progress receives warning and error events for --progress-json, when set.
+	Progress *ProgressReporter
 }
 
 func (l *Logger) InitLogFile() {
@@ -24,7 +26,7 @@ func (l *Logger) InitLogFile() {
 	}
 	exePath, err := os.Executable()
 	if err != nil {
-		fmt.Println("Error:", err)
+		fmt.Fprintln(ConsoleOutput, "Error:", err)
 		return
 	}
 
@@ -34,7 +36,7 @@ func (l *Logger) InitLogFile() {
 
 	// Create the directory if it doesn't exist
 	if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
-		fmt.Println("Error creating Logs directory:", err)
+		fmt.Fprintln(ConsoleOutput, "Error creating Logs directory:", err)
 		return
 	}
 
@@ -58,9 +60,10 @@ func (l *Logger) InitLogFile() {
 		index++
 	}
 	if err := os.WriteFile(logFilePath, []byte(init), 0644); err != nil {
-		fmt.Println("Error writing to file:", err)
+		fmt.Fprintln(ConsoleOutput, "Error writing to file:", err)
 		return
 	}
+	l.LogFilePath = &logFilePath
 }
 
 func (l *Logger) GetCurrTime() string {
@@ -70,12 +73,12 @@ func (l *Logger) GetCurrTime() string {
 func (l *Logger) HandleLog(write string, subWrite string) {
 	console := NewCustomAnsiConsole(true, false)
 
-	if write == "" {
+	if subWrite == "" {
 		console.MarkupLine(write)
 
 	} else {
 		console.MarkupLine(write)
-		fmt.Println(subWrite)
+		fmt.Fprintln(ConsoleOutput, subWrite)
 	}
 	if l.IsWriteFile && fileExists(l.LogFilePath) {
 		plain := fmt.Sprintf("%s%s", html.EscapeString(write), html.EscapeString(subWrite))
@@ -84,20 +87,49 @@ func (l *Logger) HandleLog(write string, subWrite string) {
 		// Open the file in append mode. If it doesn't exist, create it with 0644 permissions.
 		file, err := os.OpenFile(*l.LogFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			fmt.Println("Error opening or creating file:", err)
+			fmt.Fprintln(ConsoleOutput, "Error opening or creating file:", err)
 			return
 		}
 		defer file.Close() // Ensure the file is closed properly
 
 		// Write the content to the file with a newline at the end
 		if _, err := file.WriteString(plain + "\n"); err != nil {
-			fmt.Println("Error writing to file:", err)
+			fmt.Fprintln(ConsoleOutput, "Error writing to file:", err)
 			return
 		}
 
 	}
 }
 
+func (l *Logger) log(level LogLevel, tag string, format string, a ...interface{}) {
+	if l == nil || l.LogLevel < level {
+		return
+	}
+	l.HandleLog(fmt.Sprintf("%s %s : %s", l.GetCurrTime(), tag, fmt.Sprintf(format, a...)), "")
+}
+
+func (l *Logger) Error(format string, a ...interface{}) {
+	if l != nil {
+		l.Progress.Emit("error", map[string]interface{}{"message": fmt.Sprintf(format, a...)})
+	}
+	l.log(ERROR, "ERROR", format, a...)
+}
+
+func (l *Logger) Warn(format string, a ...interface{}) {
+	if l != nil {
+		l.Progress.Emit("warning", map[string]interface{}{"message": fmt.Sprintf(format, a...)})
+	}
+	l.log(WARN, "WARN", format, a...)
+}
+
+func (l *Logger) Info(format string, a ...interface{}) {
+	l.log(INFO, "INFO", format, a...)
+}
+
+func (l *Logger) Debug(format string, a ...interface{}) {
+	l.log(DEBUG, "DEBUG", format, a...)
+}
+
 func (l *Logger) ReplaceVars(data string, ps []interface{}) string {
 	for _, p := range ps {
 
@@ -112,7 +144,7 @@ func GetCommandLine() string {
 
 func fileExists(filePath *string) bool {
 	if filePath == nil || *filePath == "" {
-		fmt.Println("Invalid or nil file path.")
+		fmt.Fprintln(ConsoleOutput, "Invalid or nil file path.")
 		return false
 	}
 
@@ -0,0 +1,45 @@
+package log
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConsoleOutput is where human-readable output goes. It is switched to stderr
+// when --progress-json writes to stdout, so the two never interleave.
+var ConsoleOutput io.Writer = os.Stdout
+
+// ProgressReporter writes --progress-json events, one JSON object per line.
+// Every event has "event" and "time" keys next to its own fields. A nil
+// reporter discards events, so callers needn't check whether it is enabled.
+type ProgressReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewProgressReporter(w io.Writer) *ProgressReporter {
+	return &ProgressReporter{w: w}
+}
+
+// Emit writes one event. fields may be nil.
+func (p *ProgressReporter) Emit(event string, fields map[string]interface{}) {
+	if p == nil {
+		return
+	}
+	line := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		line[k] = v
+	}
+	line["event"] = event
+	line["time"] = time.Now().Format(time.RFC3339Nano)
+	data, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w.Write(append(data, '\n'))
+}
@@ -0,0 +1,100 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// decodeEvents parses NDJSON output, failing on a line that is not one JSON object.
+func decodeEvents(t *testing.T, data []byte) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %d: %v: %s", len(events)+1, err, scanner.Text())
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestProgressReporterEmit(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgressReporter(&buf)
+	before := time.Now()
+	p.Emit("segment_finished", map[string]interface{}{"stream": 1, "index": 7, "bytes": int64(1024)})
+	p.Emit("phase", map[string]interface{}{"phase": "merge", "event": "overridden", "time": "overridden"})
+	p.Emit("parse_done", nil)
+
+	events := decodeEvents(t, buf.Bytes())
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3:\n%s", len(events), buf.String())
+	}
+	tests := []struct {
+		event  string
+		fields map[string]interface{}
+	}{
+		// JSON numbers decode as float64
+		{"segment_finished", map[string]interface{}{"stream": 1.0, "index": 7.0, "bytes": 1024.0}},
+		{"phase", map[string]interface{}{"phase": "merge"}},
+		{"parse_done", map[string]interface{}{}},
+	}
+	for i, tt := range tests {
+		got := events[i]
+		if got["event"] != tt.event {
+			t.Errorf("event %d: got event %v, want %s", i, got["event"], tt.event)
+		}
+		stamp, err := time.Parse(time.RFC3339Nano, fmt.Sprint(got["time"]))
+		if err != nil || stamp.Before(before.Truncate(time.Second)) || stamp.After(time.Now()) {
+			t.Errorf("event %d: bad time %v: %v", i, got["time"], err)
+		}
+		if len(got) != len(tt.fields)+2 {
+			t.Errorf("event %d: got keys %v, want %v plus event and time", i, got, tt.fields)
+		}
+		for k, v := range tt.fields {
+			if got[k] != v {
+				t.Errorf("event %d: %s = %v, want %v", i, k, got[k], v)
+			}
+		}
+	}
+
+	fields := map[string]interface{}{"stream": 0}
+	p.Emit("speed", fields)
+	if len(fields) != 1 {
+		t.Errorf("Emit modified the caller's fields: %v", fields)
+	}
+}
+
+func TestProgressReporterConcurrentLines(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgressReporter(&buf)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Emit("segment_started", map[string]interface{}{"index": i, "url": "https://example.com/segment.ts"})
+		}()
+	}
+	wg.Wait()
+	seen := make(map[float64]bool)
+	for _, event := range decodeEvents(t, buf.Bytes()) {
+		seen[event["index"].(float64)] = true
+	}
+	if len(seen) != 50 {
+		t.Errorf("got %d distinct events, want 50", len(seen))
+	}
+}
+
+func TestNilProgressReporter(t *testing.T) {
+	var p *ProgressReporter
+	// must not panic
+	p.Emit("summary", map[string]interface{}{"exit_status": 0})
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseKeyBytes accepts a hex string, a base64 string or a path to a raw key file.
+func ParseKeyBytes(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		return os.ReadFile(value)
+	}
+	hexStr := strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	if b, err := hex.DecodeString(hexStr); err == nil {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return b, nil
+	}
+	return nil, fmt.Errorf("cannot parse key value: %s", value)
+}
+
+// NormalizeKID converts a KID in UUID or hex form to lower-case hex without dashes.
+func NormalizeKID(kid string) string {
+	kid = strings.TrimSpace(kid)
+	kid = strings.ReplaceAll(kid, "-", "")
+	return strings.ToLower(kid)
+}
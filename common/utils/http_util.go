@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"net/http"
+	"time"
+)
+
+// AppHttpClient is shared by the parser and the downloader so connections are reused.
+var AppHttpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: 32,
+	},
+	Timeout: 100 * time.Second,
+}
+
+// SetUseSystemProxy toggles whether AppHttpClient honours the HTTP(S)_PROXY environment.
+func SetUseSystemProxy(use bool) {
+	if t, ok := AppHttpClient.Transport.(*http.Transport); ok {
+		if use {
+			t.Proxy = http.ProxyFromEnvironment
+		} else {
+			t.Proxy = nil
+		}
+	}
+}
+
+// NewRequest builds a GET request with the given headers applied.
+func NewRequest(url string, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
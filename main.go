@@ -2,14 +2,137 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	commandline "github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/command_line"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/config"
+	downloadmanager "github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/download_manager"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/app/util"
 	log "github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/log"
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/utils"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "subtitle" {
+		os.Exit(runSubtitleCommand(os.Args[2:]))
+	}
 	options := commandline.CommandInvoker()
-	fmt.Printf("Keys: %v\n", *options.Keys)
-	console := log.NewCustomAnsiConsole(false, true)
-	console.SuccessMessage("Hear me subjects of Ymir")
+	progress, err := openProgressJson(options.ProgressJson)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	utils.SetUseSystemProxy(options.UseSystemProxy)
+	logger := &log.Logger{LogLevel: log.ParseLogLevel(options.LogLevel), IsWriteFile: !options.NoLog, Progress: progress}
+	logger.InitLogFile()
+
+	var outputs []string
+	if !downloadmanager.IsMetaJson(options.Input) {
+		err = fmt.Errorf("manifest parsing is not available, pass a meta_selected.json as --input")
+	} else {
+		outputs, err = downloadFromMetaJson(options, logger)
+	}
+	if err != nil {
+		logger.Error("%v", err)
+	}
+	progress.Emit("summary", downloadmanager.SummaryEventInfo(outputs, err))
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// openProgressJson returns the --progress-json reporter: nil when the option
+// is empty, stdout for "-", otherwise a new file. Human-readable output moves
+// to stderr while events go to stdout.
+func openProgressJson(target string) (*log.ProgressReporter, error) {
+	switch target {
+	case "":
+		return nil, nil
+	case "-":
+		log.ConsoleOutput = os.Stderr
+		return log.NewProgressReporter(os.Stdout), nil
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open --progress-json file: %w", err)
+	}
+	// left open for the life of the process
+	return log.NewProgressReporter(f), nil
+}
+
+// downloadFromMetaJson downloads the streams recorded in a meta_selected.json,
+// skipping manifest parsing. Each stream is fetched with its recorded headers,
+// overridden by those given on the command line.
+func downloadFromMetaJson(options commandline.Options, logger *log.Logger) ([]string, error) {
+	specs, err := downloadmanager.LoadMetaJson(options.Input)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Loaded %d streams from %s", len(specs), options.Input)
+	streams := make([]map[string]interface{}, len(specs))
+	for i := range specs {
+		streams[i] = downloadmanager.StreamEventInfo(i, &specs[i])
+	}
+	logger.Progress.Emit("parse_done", map[string]interface{}{"streams": streams})
+
+	tmpName := util.SanitizeFileName(*options.SaveName)
+	if *options.SaveName == "" {
+		manifestUrl := specs[0].OriginalUrl
+		if manifestUrl == "" {
+			manifestUrl = specs[0].Url
+		}
+		*options.SaveName = util.DefaultSaveName(manifestUrl, options.NoDateInfo, time.Now())
+		tmpName = util.TmpDirName(manifestUrl)
+	}
+	// Writing into the directory the meta json came from would only replace it.
+	if sameDir(filepath.Dir(options.Input), *options.SaveDir) {
+		options.WriteMetaJson = false
+	}
+	cfg := &config.DownloaderConfig{
+		MyOptions: &options,
+		DirPrefix: filepath.Join(*options.TmpDir, tmpName),
+		Headers:   *options.Headers,
+		Logger:    logger,
+	}
+	manager, err := downloadmanager.NewSimpleDownloadManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.WriteMetaJson(specs, specs); err != nil {
+		logger.Warn("Failed to write meta json: %v", err)
+	}
+	if err := manager.StartDownload(specs); err != nil {
+		return manager.Outputs(), err
+	}
+	if options.DelAfterDone {
+		// only succeeds once every stream directory inside it is gone
+		os.Remove(cfg.DirPrefix)
+	}
+	return manager.Outputs(), nil
+}
+
+// sameDir reports whether a and b name the same directory.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// runSubtitleCommand edits a subtitle file, see commandline.SubtitleCommand.
+func runSubtitleCommand(args []string) int {
+	opts, err := commandline.SubtitleCommand(args)
+	if err != nil {
+		return 2
+	}
+	logger := &log.Logger{LogLevel: log.INFO}
+	output, err := util.EditSubtitle(opts, logger)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	logger.Info("Saved to %s", output)
+	return 0
 }
@@ -0,0 +1,622 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// Sample locates one sample in the file. Times are in the track's timescale.
+type Sample struct {
+	Offset int64
+	Size   uint32
+	DTS    int64
+	CTS    int64
+	Sync   bool
+}
+
+// TrackInfo describes one track of a progressive or fragmented MP4.
+type TrackInfo struct {
+	ID        uint32
+	Handler   string
+	Timescale uint32
+	// Format is the sample entry type, e.g. avc1, hvc1, av01, mp4a, Opus, ac-3, ec-3.
+	Format     string
+	Config     []byte // avcC/hvcC/av1C/dOps payload, or the AudioSpecificConfig for mp4a
+	Width      int
+	Height     int
+	Channels   int
+	SampleRate int
+	// MediaTime is the edit list's media start, subtracted from presentation times.
+	MediaTime int64
+	Samples   []Sample
+}
+
+type trexDefaults struct {
+	duration, size, flags uint32
+}
+
+// ReadTracks reads the sample tables of every track in an MP4 file, following
+// moof/traf fragments as well as moov/stbl. Only moov and moof are loaded into
+// memory; sample data stays on disk.
+func ReadTracks(path string) ([]*TrackInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	state := newDemuxState()
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= stat.Size(); {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = stat.Size() - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > stat.Size() {
+			return nil, fmt.Errorf("%s: invalid box %q size %d at %d", path, boxType, size, offset)
+		}
+		if boxType == "moov" || boxType == "moof" {
+			payload := make([]byte, size-headerSize)
+			if _, err := f.ReadAt(payload, offset+headerSize); err != nil {
+				return nil, err
+			}
+			if err := state.readBox(boxType, payload, offset); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		offset += size
+	}
+	if len(state.tracks) == 0 {
+		return nil, fmt.Errorf("%s: no tracks found", path)
+	}
+	return state.tracks, nil
+}
+
+// demuxState collects tracks from moov and samples from the moofs that follow.
+type demuxState struct {
+	tracks  []*TrackInfo
+	byID    map[uint32]*TrackInfo
+	trex    map[uint32]trexDefaults
+	nextDTS map[uint32]int64
+}
+
+func newDemuxState() *demuxState {
+	return &demuxState{
+		byID:    make(map[uint32]*TrackInfo),
+		trex:    make(map[uint32]trexDefaults),
+		nextDTS: make(map[uint32]int64),
+	}
+}
+
+// readBox handles a top-level moov or moof; offset is where the box starts,
+// against which sample offsets are resolved.
+func (d *demuxState) readBox(boxType string, payload []byte, offset int64) error {
+	children, _ := ReadBoxes(payload)
+	for _, c := range children {
+		switch {
+		case boxType == "moov" && c.Type == "trak":
+			t, err := readTrak(c.Payload)
+			if err != nil {
+				return err
+			}
+			if t != nil {
+				d.tracks = append(d.tracks, t)
+				d.byID[t.ID] = t
+			}
+		case boxType == "moov" && c.Type == "mvex":
+			for _, x := range FindBoxes(c.Payload, "trex") {
+				p := x.Payload
+				if len(p) >= 24 {
+					d.trex[binary.BigEndian.Uint32(p[4:])] = trexDefaults{
+						duration: binary.BigEndian.Uint32(p[12:]),
+						size:     binary.BigEndian.Uint32(p[16:]),
+						flags:    binary.BigEndian.Uint32(p[20:]),
+					}
+				}
+			}
+		case boxType == "moof" && c.Type == "traf":
+			if err := readTraf(c.Payload, offset, d.byID, d.trex, d.nextDTS); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FragmentReader reads the samples of one track from standalone fragments,
+// using the track description and defaults of an init segment.
+type FragmentReader struct {
+	state *demuxState
+	Track *TrackInfo
+}
+
+// NewFragmentReader picks the first track of the init segment accepted by match.
+func NewFragmentReader(init []byte, match func(t *TrackInfo) bool) (*FragmentReader, error) {
+	state := newDemuxState()
+	boxes, err := ReadBoxes(init)
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+	for _, b := range boxes {
+		if b.Type == "moov" {
+			if err := state.readBox(b.Type, b.Payload, int64(b.Offset)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, t := range state.tracks {
+		if match(t) {
+			if t.Timescale == 0 {
+				t.Timescale = 1000
+			}
+			return &FragmentReader{state: state, Track: t}, nil
+		}
+	}
+	return nil, fmt.Errorf("no matching track in init segment")
+}
+
+// Samples returns the track's samples in fragment, with offsets into fragment.
+// A fragment without tfdt continues where the previous one ended.
+func (r *FragmentReader) Samples(fragment []byte) ([]Sample, error) {
+	boxes, err := ReadBoxes(fragment)
+	if err != nil && len(boxes) == 0 {
+		return nil, err
+	}
+	r.Track.Samples = nil
+	for _, b := range boxes {
+		if b.Type == "moof" {
+			if err := r.state.readBox(b.Type, b.Payload, int64(b.Offset)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, sample := range r.Track.Samples {
+		if sample.Offset < 0 || sample.Offset+int64(sample.Size) > int64(len(fragment)) {
+			return nil, fmt.Errorf("sample %d lies outside the fragment", i)
+		}
+	}
+	return r.Track.Samples, nil
+}
+
+// TicksToDuration converts v in units of timescale to a Duration, splitting
+// off whole seconds first so 64-bit timestamps don't overflow.
+func TicksToDuration(v int64, timescale uint32) time.Duration {
+	ts := int64(timescale)
+	return time.Duration(v/ts)*time.Second + time.Duration(v%ts)*time.Second/time.Duration(ts)
+}
+
+// NextDTS is the decode time following the last sample read.
+func (r *FragmentReader) NextDTS() int64 {
+	return r.state.nextDTS[r.Track.ID]
+}
+
+// errTruncated reports a box too short for the fields it declares.
+func errTruncated(boxType string) error {
+	return fmt.Errorf("%s box is truncated", boxType)
+}
+
+// versionedUint32 reads the field at v0Offset of a version 0 full box, or at
+// v1Offset of a version 1 box whose times are 64-bit.
+func versionedUint32(p []byte, v0Offset, v1Offset int) (uint32, bool) {
+	pos := v0Offset
+	if len(p) > 0 && p[0] == 1 {
+		pos = v1Offset
+	}
+	if len(p) < pos+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(p[pos:]), true
+}
+
+func readTrak(trak []byte) (*TrackInfo, error) {
+	t := &TrackInfo{}
+	var stts, ctts, stss, stsz, stsc, stco []byte
+	co64 := false
+	var err error
+	Walk(trak, func(box *Box, parents []string) bool {
+		p := box.Payload
+		ok := true
+		switch box.Type {
+		case "tkhd":
+			t.ID, ok = versionedUint32(p, 12, 20)
+		case "mdhd":
+			t.Timescale, ok = versionedUint32(p, 12, 20)
+		case "hdlr":
+			if ok = len(p) >= 12; ok {
+				t.Handler = string(p[8:12])
+			}
+		case "elst":
+			if ok = len(p) >= 8; ok {
+				t.MediaTime = editMediaTime(p)
+			}
+		case "stsd":
+			if ok = len(p) >= 8; ok {
+				if entries, _ := ReadBoxes(p[8:]); len(entries) > 0 {
+					readSampleEntry(t, entries[0])
+				}
+				return false
+			}
+		case "stts":
+			stts = p
+		case "ctts":
+			ctts = p
+		case "stss":
+			stss = p
+		case "stsz":
+			stsz = p
+		case "stsc":
+			stsc = p
+		case "stco":
+			stco = p
+		case "co64":
+			stco, co64 = p, true
+		}
+		if !ok && err == nil {
+			err = errTruncated(box.Type)
+		}
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.ID == 0 {
+		return nil, nil
+	}
+	if stsz != nil && stco != nil && stsc != nil && stts != nil {
+		if t.Samples, err = buildSamples(stts, ctts, stss, stsz, stsc, stco, co64); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func readSampleEntry(t *TrackInfo, entry Box) {
+	t.Format = entry.Type
+	p := entry.Payload
+	var children []Box
+	switch t.Handler {
+	case "vide":
+		if len(p) < 78 {
+			return
+		}
+		t.Width = int(binary.BigEndian.Uint16(p[24:]))
+		t.Height = int(binary.BigEndian.Uint16(p[26:]))
+		children, _ = ReadBoxes(p[78:])
+	case "soun":
+		if len(p) < 28 {
+			return
+		}
+		t.Channels = int(binary.BigEndian.Uint16(p[16:]))
+		t.SampleRate = int(binary.BigEndian.Uint32(p[24:]) >> 16)
+		children, _ = ReadBoxes(p[28:])
+	default:
+		return
+	}
+	for _, c := range children {
+		switch c.Type {
+		case "avcC", "hvcC", "av1C", "dOps", "dac3", "dec3":
+			t.Config = c.Payload
+		case "esds":
+			t.Config = audioSpecificConfig(c.Payload)
+		case "sinf":
+			if frma := FindBoxes(c.Payload, "frma"); len(frma) > 0 && len(frma[0].Payload) >= 4 {
+				t.Format = string(frma[0].Payload[:4])
+			}
+		}
+	}
+}
+
+// editMediaTime returns the media_time of the first non-empty edit.
+func editMediaTime(elst []byte) int64 {
+	count := int(binary.BigEndian.Uint32(elst[4:]))
+	for i, pos := 0, 8; i < count; i++ {
+		var mediaTime int64
+		if elst[0] == 1 {
+			if pos+20 > len(elst) {
+				break
+			}
+			mediaTime = int64(binary.BigEndian.Uint64(elst[pos+8:]))
+			pos += 20
+		} else {
+			if pos+12 > len(elst) {
+				break
+			}
+			mediaTime = int64(int32(binary.BigEndian.Uint32(elst[pos+4:])))
+			pos += 12
+		}
+		if mediaTime >= 0 {
+			return mediaTime
+		}
+	}
+	return 0
+}
+
+// audioSpecificConfig pulls DecoderSpecificInfo (tag 5) out of an esds payload.
+func audioSpecificConfig(esds []byte) []byte {
+	if len(esds) < 4 {
+		return nil
+	}
+	data := esds[4:]
+	for pos := 0; pos < len(data); {
+		tag := data[pos]
+		pos++
+		size := 0
+		for i := 0; i < 4 && pos < len(data); i++ {
+			b := data[pos]
+			pos++
+			size = size<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		switch tag {
+		case 0x03:
+			pos += 3 // ES_ID, flags; optional fields are rare in practice
+		case 0x04:
+			pos += 13
+		case 0x05:
+			if pos+size <= len(data) && pos <= len(data) {
+				return data[pos : pos+size]
+			}
+			return nil
+		default:
+			pos += size
+		}
+	}
+	return nil
+}
+
+// tableEntries returns the entry count of a sample table whose entries of
+// entrySize bytes follow the count at offset 4, or an error when they don't fit.
+func tableEntries(boxType string, p []byte, entrySize int) (int, error) {
+	if len(p) < 8 {
+		return 0, errTruncated(boxType)
+	}
+	count := int(binary.BigEndian.Uint32(p[4:]))
+	if count > (len(p)-8)/entrySize {
+		return 0, errTruncated(boxType)
+	}
+	return count, nil
+}
+
+func buildSamples(stts, ctts, stss, stsz, stsc, stco []byte, co64 bool) ([]Sample, error) {
+	if len(stsz) < 12 {
+		return nil, errTruncated("stsz")
+	}
+	count := int(binary.BigEndian.Uint32(stsz[8:]))
+	fixedSize := binary.BigEndian.Uint32(stsz[4:])
+	if fixedSize == 0 && count > (len(stsz)-12)/4 {
+		return nil, errTruncated("stsz")
+	}
+	sttsEntries, err := tableEntries("stts", stts, 8)
+	if err != nil {
+		return nil, err
+	}
+	cttsEntries := 0
+	if ctts != nil {
+		if cttsEntries, err = tableEntries("ctts", ctts, 8); err != nil {
+			return nil, err
+		}
+	}
+	stssEntries := 0
+	if stss != nil {
+		if stssEntries, err = tableEntries("stss", stss, 4); err != nil {
+			return nil, err
+		}
+	}
+	chunkSize := 4
+	if co64 {
+		chunkSize = 8
+	}
+	chunkCount, err := tableEntries("stco", stco, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := tableEntries("stsc", stsc, 12)
+	if err != nil {
+		return nil, err
+	}
+	// Every sample sits in a chunk, so a count beyond what the chunks can hold is corrupt.
+	var capacity int64
+	for e := 0; e < entries; e++ {
+		capacity += int64(binary.BigEndian.Uint32(stsc[12+e*12:])) * int64(chunkCount)
+	}
+	if int64(count) > capacity {
+		return nil, fmt.Errorf("stsz declares %d samples but the chunks hold at most %d", count, capacity)
+	}
+	samples := make([]Sample, count)
+	for i := range samples {
+		if fixedSize != 0 {
+			samples[i].Size = fixedSize
+		} else if 12+i*4+4 <= len(stsz) {
+			samples[i].Size = binary.BigEndian.Uint32(stsz[12+i*4:])
+		}
+		samples[i].Sync = stss == nil
+	}
+
+	i, dts := 0, int64(0)
+	for e := 0; e < sttsEntries; e++ {
+		n := int(binary.BigEndian.Uint32(stts[8+e*8:]))
+		delta := int64(binary.BigEndian.Uint32(stts[12+e*8:]))
+		for ; n > 0 && i < count; n-- {
+			samples[i].DTS = dts
+			dts += delta
+			i++
+		}
+	}
+	if ctts != nil {
+		i = 0
+		for e := 0; e < cttsEntries; e++ {
+			n := int(binary.BigEndian.Uint32(ctts[8+e*8:]))
+			offset := int64(int32(binary.BigEndian.Uint32(ctts[12+e*8:])))
+			if ctts[0] == 0 {
+				offset = int64(binary.BigEndian.Uint32(ctts[12+e*8:]))
+			}
+			for ; n > 0 && i < count; n-- {
+				samples[i].CTS = offset
+				i++
+			}
+		}
+	}
+	if stss != nil {
+		for e := 0; e < stssEntries; e++ {
+			if idx := int(binary.BigEndian.Uint32(stss[8+e*4:])) - 1; idx >= 0 && idx < count {
+				samples[idx].Sync = true
+			}
+		}
+	}
+
+	chunkOffset := func(c int) int64 {
+		if co64 {
+			return int64(binary.BigEndian.Uint64(stco[8+c*8:]))
+		}
+		return int64(binary.BigEndian.Uint32(stco[8+c*4:]))
+	}
+	i = 0
+	for e := 0; e < entries; e++ {
+		first := int(binary.BigEndian.Uint32(stsc[8+e*12:])) - 1
+		perChunk := int(binary.BigEndian.Uint32(stsc[12+e*12:]))
+		last := chunkCount
+		if e+1 < entries {
+			last = int(binary.BigEndian.Uint32(stsc[8+(e+1)*12:])) - 1
+		}
+		for c := first; c < last && c < chunkCount; c++ {
+			offset := chunkOffset(c)
+			for s := 0; s < perChunk && i < count; s++ {
+				samples[i].Offset = offset
+				offset += int64(samples[i].Size)
+				i++
+			}
+		}
+	}
+	return samples, nil
+}
+
+func readTraf(traf []byte, moofOffset int64, byID map[uint32]*TrackInfo, trex map[uint32]trexDefaults, nextDTS map[uint32]int64) error {
+	children, _ := ReadBoxes(traf)
+	var t *TrackInfo
+	var def trexDefaults
+	base := moofOffset
+	for _, c := range children {
+		p := c.Payload
+		switch c.Type {
+		case "tfhd":
+			if len(p) < 8 {
+				return errTruncated(c.Type)
+			}
+			flags := binary.BigEndian.Uint32(p) & 0xffffff
+			id := binary.BigEndian.Uint32(p[4:])
+			t, def = byID[id], trex[id]
+			// base_data_offset, then description index, duration, size and flags
+			need := 8 + 8*int(flags&0x01) + 4*bits.OnesCount32(flags&0x3a)
+			if len(p) < need {
+				return errTruncated(c.Type)
+			}
+			pos := 8
+			if flags&0x01 != 0 {
+				base = int64(binary.BigEndian.Uint64(p[pos:]))
+				pos += 8
+			}
+			if flags&0x02 != 0 {
+				pos += 4
+			}
+			if flags&0x08 != 0 {
+				def.duration = binary.BigEndian.Uint32(p[pos:])
+				pos += 4
+			}
+			if flags&0x10 != 0 {
+				def.size = binary.BigEndian.Uint32(p[pos:])
+				pos += 4
+			}
+			if flags&0x20 != 0 {
+				def.flags = binary.BigEndian.Uint32(p[pos:])
+			}
+		case "tfdt":
+			if t != nil {
+				if len(p) < 8 || p[0] == 1 && len(p) < 12 {
+					return errTruncated(c.Type)
+				}
+				if p[0] == 1 {
+					nextDTS[t.ID] = int64(binary.BigEndian.Uint64(p[4:]))
+				} else {
+					nextDTS[t.ID] = int64(binary.BigEndian.Uint32(p[4:]))
+				}
+			}
+		case "trun":
+			if t == nil {
+				continue
+			}
+			if len(p) < 8 {
+				return errTruncated(c.Type)
+			}
+			flags := binary.BigEndian.Uint32(p) & 0xffffff
+			count := int(binary.BigEndian.Uint32(p[4:]))
+			pos := 8 + 4*bits.OnesCount32(flags&0x05)
+			entrySize := 4 * bits.OnesCount32(flags&0xf00)
+			if len(p) < pos || entrySize > 0 && count > (len(p)-pos)/entrySize {
+				return errTruncated(c.Type)
+			}
+			pos = 8
+			offset := base
+			if flags&0x01 != 0 {
+				offset += int64(int32(binary.BigEndian.Uint32(p[pos:])))
+				pos += 4
+			}
+			firstFlags, hasFirst := uint32(0), flags&0x04 != 0
+			if hasFirst {
+				firstFlags = binary.BigEndian.Uint32(p[pos:])
+				pos += 4
+			}
+			dts := nextDTS[t.ID]
+			for i := 0; i < count; i++ {
+				s := Sample{Offset: offset, DTS: dts}
+				duration, size, sampleFlags := def.duration, def.size, def.flags
+				if flags&0x100 != 0 {
+					duration = binary.BigEndian.Uint32(p[pos:])
+					pos += 4
+				}
+				if flags&0x200 != 0 {
+					size = binary.BigEndian.Uint32(p[pos:])
+					pos += 4
+				}
+				if flags&0x400 != 0 {
+					sampleFlags = binary.BigEndian.Uint32(p[pos:])
+					pos += 4
+				} else if i == 0 && hasFirst {
+					sampleFlags = firstFlags
+				}
+				if flags&0x800 != 0 {
+					s.CTS = int64(int32(binary.BigEndian.Uint32(p[pos:])))
+					pos += 4
+				}
+				s.Size = size
+				// sample_is_non_sync_sample
+				s.Sync = sampleFlags&0x00010000 == 0
+				t.Samples = append(t.Samples, s)
+				offset += int64(size)
+				dts += int64(duration)
+			}
+			nextDTS[t.ID] = dts
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testBox(boxType string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], boxType)
+	return append(b, payload...)
+}
+
+func TestReadTracksTruncatedBoxes(t *testing.T) {
+	tkhd := make([]byte, 84)
+	tkhd[15] = 1
+	mdhd := make([]byte, 24)
+	mdhd[15] = 90
+	hdlr := append(make([]byte, 8), "vide"...)
+	tests := []struct {
+		name string
+		trak []byte
+	}{
+		{"tkhd", testBox("trak", testBox("tkhd", tkhd[:10]))},
+		{"tkhd v1", testBox("trak", testBox("tkhd", append([]byte{1}, tkhd[1:16]...)))},
+		{"mdhd", testBox("trak", testBox("tkhd", tkhd), testBox("mdia", testBox("mdhd", mdhd[:12])))},
+		{"hdlr", testBox("trak", testBox("tkhd", tkhd), testBox("mdia", testBox("hdlr", hdlr[:9])))},
+		{"stsz", testBox("trak", testBox("tkhd", tkhd), testBox("mdia", testBox("mdhd", mdhd), testBox("hdlr", hdlr),
+			testBox("minf", testBox("stbl",
+				testBox("stts", make([]byte, 8)),
+				testBox("stsc", make([]byte, 8)),
+				testBox("stco", make([]byte, 8)),
+				// declares 1000 samples of their own size but lists none
+				testBox("stsz", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x03, 0xe8}))))),
+		},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "in.mp4")
+		if err := os.WriteFile(path, testBox("moov", tt.trak), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadTracks(path); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
+
+func TestReadTracksFragmentTruncatedTrun(t *testing.T) {
+	tkhd := make([]byte, 84)
+	tkhd[15] = 1
+	mdhd := make([]byte, 24)
+	mdhd[15] = 90
+	moov := testBox("moov", testBox("trak", testBox("tkhd", tkhd),
+		testBox("mdia", testBox("mdhd", mdhd), testBox("hdlr", append(make([]byte, 8), "vide"...)))))
+	// a trun with per-sample sizes that claims more samples than it holds
+	trun := []byte{0, 0, 0x02, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1}
+	moof := testBox("moof", testBox("traf", testBox("tfhd", []byte{0, 0, 0, 0, 0, 0, 0, 1}), testBox("trun", trun)))
+	path := filepath.Join(t.TempDir(), "in.mp4")
+	if err := os.WriteFile(path, append(moov, moof...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTracks(path); err == nil {
+		t.Error("got no error")
+	}
+}
@@ -0,0 +1,96 @@
+package mp4
+
+import (
+	"encoding/hex"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// ParsedMP4Info is what ReadInit extracts from an init segment.
+type ParsedMP4Info struct {
+	KID    string
+	Scheme string
+	PSSH   []entity.PsshInfo
+	Tenc   *TencInfo
+}
+
+// TencInfo is the track encryption box, which is all that is needed to decrypt fragments.
+type TencInfo struct {
+	IsProtected     bool
+	PerSampleIVSize int
+	ConstantIV      []byte
+	CryptByteBlock  int
+	SkipByteBlock   int
+	KID             []byte
+}
+
+// ReadInit reads the protection scheme (schm), the default KID (tenc) and the pssh
+// boxes of an init segment. KID is lower-case hex without dashes and is empty
+// when the init carries no tenc box.
+func ReadInit(data []byte) *ParsedMP4Info {
+	info := &ParsedMP4Info{}
+	Walk(data, func(box *Box, parents []string) bool {
+		switch box.Type {
+		case "schm":
+			// version/flags(4) scheme_type(4) scheme_version(4)
+			if len(box.Payload) >= 8 {
+				info.Scheme = string(box.Payload[4:8])
+			}
+		case "tenc":
+			// version/flags(4) reserved(1) pattern(1) isProtected(1) perSampleIVSize(1) KID(16)
+			if len(box.Payload) >= 24 && info.KID == "" {
+				info.Tenc = parseTenc(box.Payload)
+				info.KID = hex.EncodeToString(info.Tenc.KID)
+			}
+		case "pssh":
+			if pssh, err := parsePsshPayload(box.Payload, box.Raw); err == nil {
+				info.PSSH = append(info.PSSH, *pssh)
+			}
+		}
+		return true
+	})
+	return info
+}
+
+func parseTenc(payload []byte) *TencInfo {
+	tenc := &TencInfo{
+		IsProtected:     payload[6] == 1,
+		PerSampleIVSize: int(payload[7]),
+		KID:             payload[8:24],
+	}
+	if payload[0] > 0 {
+		tenc.CryptByteBlock = int(payload[5] >> 4)
+		tenc.SkipByteBlock = int(payload[5] & 0x0f)
+	}
+	if tenc.IsProtected && tenc.PerSampleIVSize == 0 && len(payload) > 24 {
+		size := int(payload[24])
+		if len(payload) >= 25+size {
+			tenc.ConstantIV = payload[25 : 25+size]
+		}
+	}
+	return tenc
+}
+
+// RemoveProtection returns a copy of an init segment that players treat as clear:
+// encv/enca entries get their original format back from frma, and sinf and pssh
+// boxes are turned into free boxes. Box sizes are unchanged.
+func RemoveProtection(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	var entry []byte
+	Walk(out, func(box *Box, parents []string) bool {
+		switch box.Type {
+		case "encv", "enca", "encs":
+			entry = box.Raw
+		case "frma":
+			if entry != nil && len(box.Payload) >= 4 {
+				copy(entry[4:8], box.Payload[:4])
+			}
+		case "sinf", "pssh":
+			copy(box.Raw[4:8], "free")
+		}
+		return true
+	})
+	return out
+}
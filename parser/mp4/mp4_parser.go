@@ -0,0 +1,103 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Box is one ISO-BMFF box. Raw is the whole box, Payload excludes the size/type header,
+// and Offset is relative to the buffer the box was read from.
+type Box struct {
+	Type    string
+	Offset  int
+	Size    int
+	Raw     []byte
+	Payload []byte
+}
+
+// ReadBoxes splits data into its top-level boxes.
+func ReadBoxes(data []byte) ([]Box, error) {
+	var boxes []Box
+	for offset := 0; offset+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		header := 8
+		switch size {
+		case 0:
+			size = len(data) - offset
+		case 1:
+			if offset+16 > len(data) {
+				return boxes, fmt.Errorf("truncated largesize box %q at %d", boxType, offset)
+			}
+			size = int(binary.BigEndian.Uint64(data[offset+8:]))
+			header = 16
+		}
+		if size < header || offset+size > len(data) {
+			return boxes, fmt.Errorf("invalid box %q size %d at %d", boxType, size, offset)
+		}
+		boxes = append(boxes, Box{
+			Type:    boxType,
+			Offset:  offset,
+			Size:    size,
+			Raw:     data[offset : offset+size],
+			Payload: data[offset+header : offset+size],
+		})
+		offset += size
+	}
+	return boxes, nil
+}
+
+// childOffset returns where child boxes start inside the payload of a container,
+// or -1 when the box type is not a container.
+func childOffset(boxType string) int {
+	switch boxType {
+	case "moov", "trak", "mdia", "minf", "stbl", "dinf", "edts", "mvex",
+		"moof", "traf", "mfra", "sinf", "schi", "udta":
+		return 0
+	case "meta":
+		return 4
+	case "stsd":
+		return 8
+	case "encv", "avc1", "avc3", "hvc1", "hev1", "av01", "vp09", "dvh1", "dvhe":
+		return 78
+	case "enca", "mp4a", "ac-3", "ec-3", "Opus", "fLaC":
+		return 28
+	case "wvtt", "encs":
+		return 8
+	}
+	return -1
+}
+
+// Walk visits every box in data depth-first. parents holds the types of the
+// enclosing boxes. Returning false from visit skips the box's children.
+func Walk(data []byte, visit func(box *Box, parents []string) bool) error {
+	return walk(data, nil, visit)
+}
+
+func walk(data []byte, parents []string, visit func(box *Box, parents []string) bool) error {
+	boxes, err := ReadBoxes(data)
+	for i := range boxes {
+		box := &boxes[i]
+		if !visit(box, parents) {
+			continue
+		}
+		if off := childOffset(box.Type); off >= 0 && off <= len(box.Payload) {
+			if cerr := walk(box.Payload[off:], append(parents, box.Type), visit); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// FindBoxes returns every box of the given type anywhere in data.
+func FindBoxes(data []byte, boxType string) []Box {
+	var found []Box
+	Walk(data, func(box *Box, parents []string) bool {
+		if box.Type == boxType {
+			found = append(found, *box)
+		}
+		return true
+	})
+	return found
+}
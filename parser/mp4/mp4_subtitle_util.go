@@ -0,0 +1,161 @@
+package mp4
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+// SubtitleExtractor turns fMP4 subtitle fragments (wvtt or stpp) into SubCues,
+// using the init segment's timescale and each fragment's tfdt/trun timing.
+type SubtitleExtractor struct {
+	reader *FragmentReader
+}
+
+// NewSubtitleExtractor reads the subtitle track from an init segment.
+func NewSubtitleExtractor(init []byte) (*SubtitleExtractor, error) {
+	reader, err := NewFragmentReader(init, func(t *TrackInfo) bool {
+		return t.Format == "wvtt" || t.Format == "stpp"
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no wvtt or stpp track in init segment")
+	}
+	return &SubtitleExtractor{reader: reader}, nil
+}
+
+// Format is the sample entry type, wvtt or stpp.
+func (e *SubtitleExtractor) Format() string {
+	return e.reader.Track.Format
+}
+
+// Extract returns the cues of one fragment on the track's absolute timeline.
+func (e *SubtitleExtractor) Extract(fragment []byte) ([]entity.SubCue, error) {
+	samples, err := e.reader.Samples(fragment)
+	if err != nil {
+		return nil, err
+	}
+	toTime := func(v int64) time.Duration {
+		return TicksToDuration(v, e.reader.Track.Timescale)
+	}
+	var cues []entity.SubCue
+	for i, sample := range samples {
+		data := fragment[sample.Offset : sample.Offset+int64(sample.Size)]
+		start := toTime(sample.DTS + sample.CTS)
+		var end time.Duration
+		if i+1 < len(samples) {
+			end = toTime(samples[i+1].DTS + samples[i+1].CTS)
+		} else {
+			end = toTime(e.reader.NextDTS() + sample.CTS)
+		}
+		switch e.Format() {
+		case "wvtt":
+			cues = appendVttSample(cues, data, start, end)
+		case "stpp":
+			cues = appendTtmlSample(cues, data, start)
+		}
+	}
+	return cues, nil
+}
+
+// appendVttSample reads the vttc boxes of a wvtt sample. vtte marks a gap and
+// carries no cue. A cue that continues from the previous sample is extended.
+func appendVttSample(cues []entity.SubCue, data []byte, start, end time.Duration) []entity.SubCue {
+	boxes, _ := ReadBoxes(data)
+	for _, b := range boxes {
+		if b.Type != "vttc" {
+			continue
+		}
+		cue := entity.SubCue{StartTime: start, EndTime: end}
+		children, _ := ReadBoxes(b.Payload)
+		for _, c := range children {
+			switch c.Type {
+			case "payl":
+				cue.Payload = strings.TrimSpace(string(c.Payload))
+			case "sttg":
+				cue.Settings = strings.TrimSpace(string(c.Payload))
+			case "iden":
+				cue.Id = strings.TrimSpace(string(c.Payload))
+			}
+		}
+		if cue.Payload == "" {
+			continue
+		}
+		if last := findContinuedCue(cues, cue); last >= 0 {
+			cues[last].EndTime = end
+			continue
+		}
+		cues = append(cues, cue)
+	}
+	return cues
+}
+
+func findContinuedCue(cues []entity.SubCue, cue entity.SubCue) int {
+	for i := len(cues) - 1; i >= 0 && cues[i].EndTime >= cue.StartTime; i-- {
+		if cues[i].EndTime == cue.StartTime && cues[i].Payload == cue.Payload && cues[i].Settings == cue.Settings {
+			return i
+		}
+	}
+	return -1
+}
+
+// appendTtmlSample parses the TTML document of an stpp sample. Most packagers
+// write times on the media timeline; documents whose cues all end before the
+// sample starts are taken as relative to the sample instead.
+func appendTtmlSample(cues []entity.SubCue, data []byte, start time.Duration) []entity.SubCue {
+	text := string(data)
+	if i := strings.Index(text, "<?xml"); i > 0 {
+		text = text[i:]
+	} else if i := strings.Index(text, "<tt"); i > 0 {
+		text = text[i:]
+	}
+	sub, err := entity.ParseTtml(text)
+	if err != nil || len(sub.Cues) == 0 {
+		return cues
+	}
+	relative := start > 0
+	for _, cue := range sub.Cues {
+		if cue.EndTime > start {
+			relative = false
+			break
+		}
+	}
+	for _, cue := range sub.Cues {
+		if relative {
+			cue.StartTime += start
+			cue.EndTime += start
+		}
+		if last := findContinuedCue(cues, cue); last >= 0 {
+			cues[last].EndTime = cue.EndTime
+			continue
+		}
+		cues = append(cues, cue)
+	}
+	return cues
+}
+
+// ExtractSubtitles joins the cues of every fragment into one WebVttSub.
+func ExtractSubtitles(init []byte, fragments [][]byte) (*entity.WebVttSub, error) {
+	extractor, err := NewSubtitleExtractor(init)
+	if err != nil {
+		return nil, err
+	}
+	sub := &entity.WebVttSub{}
+	for i, fragment := range fragments {
+		cues, err := extractor.Extract(fragment)
+		if err != nil {
+			return nil, fmt.Errorf("fragment %d: %w", i, err)
+		}
+		for _, cue := range cues {
+			if last := findContinuedCue(sub.Cues, cue); last >= 0 {
+				sub.Cues[last].EndTime = cue.EndTime
+				continue
+			}
+			sub.Cues = append(sub.Cues, cue)
+		}
+	}
+	sort.SliceStable(sub.Cues, func(i, j int) bool { return sub.Cues[i].StartTime < sub.Cues[j].StartTime })
+	return sub, nil
+}
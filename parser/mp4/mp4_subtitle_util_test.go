@@ -0,0 +1,80 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+func TestTicksToDuration(t *testing.T) {
+	tests := []struct {
+		v         int64
+		timescale uint32
+		want      time.Duration
+	}{
+		{90000, 90000, time.Second},
+		{135000, 90000, 1500 * time.Millisecond},
+		{-45000, 90000, -500 * time.Millisecond},
+		{1, 3, 333333333},
+		// about 54 years since the epoch at 10MHz, where v*time.Second overflows
+		{17_000_000_000_000_000, 10_000_000, 1_700_000_000 * time.Second},
+		{17_000_000_000_000_001, 10_000_000, 1_700_000_000*time.Second + 100},
+	}
+	for _, tt := range tests {
+		if got := TicksToDuration(tt.v, tt.timescale); got != tt.want {
+			t.Errorf("TicksToDuration(%d, %d) = %v, want %v", tt.v, tt.timescale, got, tt.want)
+		}
+	}
+}
+
+func fullBox(boxType string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, flags)
+	header[0] = version
+	return testBox(boxType, append([][]byte{header}, payload...)...)
+}
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func TestSubtitleExtractorLargeTimestamps(t *testing.T) {
+	const timescale = 10_000_000
+	tkhd := make([]byte, 80)
+	binary.BigEndian.PutUint32(tkhd[8:], 1)
+	mdhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mdhd[8:], timescale)
+	init := testBox("moov",
+		testBox("trak", fullBox("tkhd", 0, 0, tkhd),
+			testBox("mdia", fullBox("mdhd", 0, 0, mdhd), fullBox("hdlr", 0, 0, make([]byte, 4), []byte("text")),
+				testBox("minf", testBox("stbl", fullBox("stsd", 0, 0, u32(1), testBox("wvtt", make([]byte, 8))))))),
+		testBox("mvex", fullBox("trex", 0, 0, u32(1), u32(1), make([]byte, 12))))
+
+	cue := testBox("vttc", testBox("payl", []byte("Hello")))
+	gap := testBox("vtte")
+	tfdt := binary.BigEndian.AppendUint64(nil, 17_000_000_000_000_000)
+	// two samples of 2s each, the second an empty gap; data offsets are from the moof
+	trun := func(dataOffset uint32) []byte {
+		return fullBox("trun", 0, 0x301, u32(2), u32(dataOffset),
+			u32(2*timescale), u32(uint32(len(cue))), u32(2*timescale), u32(uint32(len(gap))))
+	}
+	traf := func(dataOffset uint32) []byte {
+		return testBox("traf", fullBox("tfhd", 0, 0x020000, u32(1)), fullBox("tfdt", 1, 0, tfdt), trun(dataOffset))
+	}
+	moofSize := len(testBox("moof", traf(0)))
+	fragment := append(testBox("moof", traf(uint32(moofSize+8))), testBox("mdat", cue, gap)...)
+
+	e, err := NewSubtitleExtractor(init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cues, err := e.Extract(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := 1_700_000_000 * time.Second
+	want := []entity.SubCue{{StartTime: start, EndTime: start + 2*time.Second, Payload: "Hello"}}
+	if !reflect.DeepEqual(cues, want) {
+		t.Errorf("got  %+v\nwant %+v", cues, want)
+	}
+}
@@ -0,0 +1,225 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/michaelchristwin/N_M3U8DL-RE-go.git/common/entity"
+)
+
+const (
+	WidevineSystemID  = "edef8ba979d64acea3c827dcd51d21ed"
+	PlayReadySystemID = "9a04f07998404286ab92e65be0885f95"
+	FairPlaySystemID  = "94ce86fb07ff4f43adb893d2fa968ca2"
+	ClearKeySystemID  = "e2719d58a985b3c9781ab030af78d30e"
+	CommonSystemID    = "1077efecc0b24d02ace33c1e52e2fb4b"
+)
+
+var systemNames = map[string]string{
+	WidevineSystemID:  "Widevine",
+	PlayReadySystemID: "PlayReady",
+	FairPlaySystemID:  "FairPlay",
+	ClearKeySystemID:  "ClearKey",
+	CommonSystemID:    "Common",
+}
+
+var (
+	prKidRegex      = regexp.MustCompile(`<KID[^>]*?VALUE="([^"]+)"|<KID[^>]*>([^<]+)</KID>`)
+	prLaUrlRegex    = regexp.MustCompile(`<LA_URL>([^<]+)</LA_URL>`)
+	prContentRegex  = regexp.MustCompile(`<CID>([^<]+)</CID>|<CONTENTID>([^<]+)</CONTENTID>`)
+	prDsIdRegex     = regexp.MustCompile(`<DS_ID>([^<]+)</DS_ID>`)
+	prCustomIdRegex = regexp.MustCompile(`<CUSTOMATTRIBUTES[^>]*>(.*?)</CUSTOMATTRIBUTES>`)
+)
+
+// ParsePsshBox decodes a complete pssh box (header included).
+func ParsePsshBox(box []byte) (*entity.PsshInfo, error) {
+	boxes, err := ReadBoxes(box)
+	if err != nil || len(boxes) == 0 || boxes[0].Type != "pssh" {
+		return nil, fmt.Errorf("not a pssh box")
+	}
+	return parsePsshPayload(boxes[0].Payload, boxes[0].Raw)
+}
+
+// ParsePsshBase64 decodes the base64 pssh found in DASH cenc:pssh or HLS URI=data: attributes.
+func ParsePsshBase64(value string) (*entity.PsshInfo, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+	return ParsePsshBox(data)
+}
+
+func parsePsshPayload(payload, raw []byte) (*entity.PsshInfo, error) {
+	if len(payload) < 20 {
+		return nil, fmt.Errorf("pssh box too short")
+	}
+	version := payload[0]
+	info := &entity.PsshInfo{
+		SystemID: hex.EncodeToString(payload[4:20]),
+		Data:     base64.StdEncoding.EncodeToString(raw),
+	}
+	info.System = systemNames[info.SystemID]
+	if info.System == "" {
+		info.System = "Unknown"
+	}
+
+	pos := 20
+	if version > 0 {
+		if pos+4 > len(payload) {
+			return nil, fmt.Errorf("pssh box truncated")
+		}
+		count := int(binary.BigEndian.Uint32(payload[pos:]))
+		pos += 4
+		for i := 0; i < count && pos+16 <= len(payload); i++ {
+			info.KIDs = appendUnique(info.KIDs, hex.EncodeToString(payload[pos:pos+16]))
+			pos += 16
+		}
+	}
+	if pos+4 > len(payload) {
+		return info, nil
+	}
+	size := int(binary.BigEndian.Uint32(payload[pos:]))
+	pos += 4
+	if pos+size > len(payload) {
+		return nil, fmt.Errorf("pssh data truncated")
+	}
+	data := payload[pos : pos+size]
+
+	switch info.SystemID {
+	case WidevineSystemID:
+		parseWidevineData(data, info)
+	case PlayReadySystemID:
+		parsePlayReadyData(data, info)
+	}
+	return info, nil
+}
+
+// parseWidevineData reads the WidevinePsshData protobuf: 2=key_id, 3=provider, 4=content_id.
+func parseWidevineData(data []byte, info *entity.PsshInfo) {
+	for pos := 0; pos < len(data); {
+		tag, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return
+		}
+		pos += n
+		field, wireType := tag>>3, tag&7
+		switch wireType {
+		case 0:
+			_, n := binary.Uvarint(data[pos:])
+			if n <= 0 {
+				return
+			}
+			pos += n
+		case 2:
+			length, n := binary.Uvarint(data[pos:])
+			if n <= 0 || length > uint64(len(data)-pos-n) {
+				return
+			}
+			pos += n
+			value := data[pos : pos+int(length)]
+			pos += int(length)
+			switch field {
+			case 2:
+				if len(value) == 16 {
+					info.KIDs = appendUnique(info.KIDs, hex.EncodeToString(value))
+				}
+			case 3:
+				info.Provider = string(value)
+			case 4:
+				info.ContentID = printable(value)
+			}
+		case 5:
+			pos += 4
+		case 1:
+			pos += 8
+		default:
+			return
+		}
+	}
+}
+
+// parsePlayReadyData reads the WRMHEADER record of a PlayReady Object.
+func parsePlayReadyData(data []byte, info *entity.PsshInfo) {
+	if len(data) < 6 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	pos := 6
+	for i := 0; i < count && pos+4 <= len(data); i++ {
+		recordType := binary.LittleEndian.Uint16(data[pos:])
+		length := int(binary.LittleEndian.Uint16(data[pos+2:]))
+		pos += 4
+		if pos+length > len(data) {
+			return
+		}
+		if recordType == 1 {
+			parseWrmHeader(decodeUTF16LE(data[pos:pos+length]), info)
+		}
+		pos += length
+	}
+}
+
+func parseWrmHeader(xml string, info *entity.PsshInfo) {
+	for _, m := range prKidRegex.FindAllStringSubmatch(xml, -1) {
+		value := m[1]
+		if value == "" {
+			value = m[2]
+		}
+		guid, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil || len(guid) != 16 {
+			continue
+		}
+		info.KIDs = appendUnique(info.KIDs, hex.EncodeToString(guidToUUID(guid)))
+	}
+	if m := prLaUrlRegex.FindStringSubmatch(xml); m != nil {
+		info.Provider = m[1]
+	} else if m := prDsIdRegex.FindStringSubmatch(xml); m != nil {
+		info.Provider = m[1]
+	}
+	if m := prContentRegex.FindStringSubmatch(xml); m != nil {
+		info.ContentID = m[1] + m[2]
+	} else if m := prCustomIdRegex.FindStringSubmatch(xml); m != nil {
+		info.ContentID = m[1]
+	}
+}
+
+// guidToUUID converts PlayReady's little-endian GUID layout to the big-endian KID used by CENC.
+func guidToUUID(guid []byte) []byte {
+	b := make([]byte, 16)
+	b[0], b[1], b[2], b[3] = guid[3], guid[2], guid[1], guid[0]
+	b[4], b[5] = guid[5], guid[4]
+	b[6], b[7] = guid[7], guid[6]
+	copy(b[8:], guid[8:])
+	return b
+}
+
+func decodeUTF16LE(data []byte) string {
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func printable(value []byte) string {
+	if utf8.Valid(value) && !bytes.ContainsFunc(value, func(r rune) bool { return r < 0x20 }) {
+		return string(value)
+	}
+	return hex.EncodeToString(value)
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
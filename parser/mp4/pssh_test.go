@@ -0,0 +1,145 @@
+package mp4
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// testPssh builds a pssh box for systemID; a version 1 box lists kids.
+func testPssh(systemID string, kids []string, data []byte) []byte {
+	id, _ := hex.DecodeString(systemID)
+	version := byte(0)
+	payload := id
+	if len(kids) > 0 {
+		version = 1
+		payload = append(payload, u32(uint32(len(kids)))...)
+		for _, kid := range kids {
+			b, _ := hex.DecodeString(kid)
+			payload = append(payload, b...)
+		}
+	}
+	return fullBox("pssh", version, 0, payload, u32(uint32(len(data))), data)
+}
+
+// testWidevineData encodes WidevinePsshData with an algorithm, key ids,
+// provider and content id.
+func testWidevineData(provider string, contentID []byte, kids ...string) []byte {
+	data := []byte{0x08, 0x01} // algorithm: AESCTR
+	for _, kid := range kids {
+		b, _ := hex.DecodeString(kid)
+		data = append(data, 0x12, byte(len(b)))
+		data = append(data, b...)
+	}
+	data = append(data, 0x1a, byte(len(provider)))
+	data = append(data, provider...)
+	data = append(data, 0x22, byte(len(contentID)))
+	return append(data, contentID...)
+}
+
+// testPlayReadyObject wraps a WRMHEADER in a PlayReady Object of one record.
+func testPlayReadyObject(wrmHeader string) []byte {
+	var record []byte
+	for _, u := range utf16.Encode([]rune(wrmHeader)) {
+		record = binary.LittleEndian.AppendUint16(record, u)
+	}
+	pro := binary.LittleEndian.AppendUint32(nil, uint32(10+len(record)))
+	pro = binary.LittleEndian.AppendUint16(pro, 1)
+	pro = binary.LittleEndian.AppendUint16(pro, 1)
+	pro = binary.LittleEndian.AppendUint16(pro, uint16(len(record)))
+	return append(pro, record...)
+}
+
+// psshSummary is the part of a PsshInfo the tests compare.
+type psshSummary struct {
+	System    string
+	KIDs      []string
+	Provider  string
+	ContentID string
+}
+
+func TestParsePsshBox(t *testing.T) {
+	const kid = "00112233445566778899aabbccddeeff"
+	const kid2 = "ffeeddccbbaa99887766554433221100"
+	// PlayReady stores the KID as a little-endian GUID
+	guid := base64.StdEncoding.EncodeToString([]byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+
+	tests := []struct {
+		name string
+		box  []byte
+		want psshSummary
+	}{
+		{
+			"widevine",
+			testPssh(WidevineSystemID, nil, testWidevineData("widevine_test", []byte("movie-1"), kid, kid2)),
+			psshSummary{System: "Widevine", KIDs: []string{kid, kid2}, Provider: "widevine_test", ContentID: "movie-1"},
+		},
+		{
+			"widevine binary content id",
+			testPssh(WidevineSystemID, nil, testWidevineData("p", []byte{0x01, 0xff}, kid)),
+			psshSummary{System: "Widevine", KIDs: []string{kid}, Provider: "p", ContentID: "01ff"},
+		},
+		{
+			"version 1 key ids",
+			testPssh(WidevineSystemID, []string{kid2}, testWidevineData("p", nil, kid)),
+			psshSummary{System: "Widevine", KIDs: []string{kid2, kid}, Provider: "p"},
+		},
+		{
+			"playready 4.0",
+			testPssh(PlayReadySystemID, nil, testPlayReadyObject(
+				`<WRMHEADER version="4.0.0.0"><DATA><KID>`+guid+`</KID><LA_URL>https://pr.example/license</LA_URL><CID>content-1</CID></DATA></WRMHEADER>`)),
+			psshSummary{System: "PlayReady", KIDs: []string{kid}, Provider: "https://pr.example/license", ContentID: "content-1"},
+		},
+		{
+			"playready 4.3",
+			testPssh(PlayReadySystemID, nil, testPlayReadyObject(
+				`<WRMHEADER version="4.3.0.0"><DATA><PROTECTINFO><KIDS><KID ALGID="AESCBC" VALUE="`+guid+`"></KID></KIDS></PROTECTINFO><DS_ID>service</DS_ID></DATA></WRMHEADER>`)),
+			psshSummary{System: "PlayReady", KIDs: []string{kid}, Provider: "service"},
+		},
+		{
+			"unknown system",
+			testPssh("000102030405060708090a0b0c0d0e0f", nil, []byte("opaque")),
+			psshSummary{System: "Unknown"},
+		},
+	}
+	for _, tt := range tests {
+		info, err := ParsePsshBox(tt.box)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := psshSummary{System: info.System, KIDs: info.KIDs, Provider: info.Provider, ContentID: info.ContentID}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+		if info.Data != base64.StdEncoding.EncodeToString(tt.box) {
+			t.Errorf("%s: Data is not the whole box", tt.name)
+		}
+		if decoded, err := ParsePsshBase64(info.Data); err != nil || !reflect.DeepEqual(decoded, info) {
+			t.Errorf("%s: base64 round trip gave %+v, %v", tt.name, decoded, err)
+		}
+	}
+}
+
+func TestParsePsshBoxErrors(t *testing.T) {
+	box := testPssh(WidevineSystemID, nil, testWidevineData("p", nil))
+	// the data size claims more than the box holds
+	binary.BigEndian.PutUint32(box[len(box)-len(testWidevineData("p", nil))-4:], 1000)
+	tests := []struct {
+		name string
+		box  []byte
+	}{
+		{"not a pssh", testBox("free", make([]byte, 24))},
+		{"too short", fullBox("pssh", 0, 0, make([]byte, 8))},
+		{"truncated data", box},
+		{"truncated key id count", fullBox("pssh", 1, 0, make([]byte, 16))},
+	}
+	for _, tt := range tests {
+		if _, err := ParsePsshBox(tt.box); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
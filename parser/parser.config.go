@@ -6,7 +6,3 @@ type ParserConfig struct {
 	BaseUrl     string
 	Headers     map[string]string
 }
-
-func Loko() {
-
-}